@@ -1,5 +1,6 @@
 import os
 import pickle
+import hashlib
 import numpy as np
 from flask import Flask, request, jsonify
 from PIL import Image
@@ -15,10 +16,28 @@ class ChickenDiseaseDetector:
         self.model = None
         self.label_encoder = None
         self.input_shape = (224, 224)  # Default input shape, can be updated based on model
+        self.model_name = os.path.splitext(os.path.basename(model_path))[0]
+        self.model_version = os.environ.get('MODEL_VERSION') or self.file_version(model_path)
         
         self.load_model(model_path)
         self.load_label_encoder(label_encoder_path)
     
+    def file_version(self, path):
+        """Version a model by the hash of its weights, so retrained models are told apart"""
+        digest = hashlib.sha256()
+        with open(path, 'rb') as f:
+            for chunk in iter(lambda: f.read(1 << 20), b''):
+                digest.update(chunk)
+        return digest.hexdigest()[:12]
+    
+    def model_info(self):
+        """Name, version and classes of the loaded model"""
+        return {
+            'name': self.model_name,
+            'version': self.model_version,
+            'classes': [str(c) for c in self.label_encoder.classes_],
+        }
+    
     def load_model(self, model_path):
         """Load the trained Keras model"""
         try:
@@ -166,7 +185,8 @@ def health_check():
     return jsonify({
         'status': 'healthy' if detector else 'model_not_loaded',
         'model_loaded': detector is not None,
-        'detector_type': str(type(detector)) if detector else 'None'
+        'detector_type': str(type(detector)) if detector else 'None',
+        'model': detector.model_info() if detector else None
     })
 
 @app.route('/predict', methods=['POST'])
@@ -226,6 +246,7 @@ def predict_disease():
             return jsonify({
                 'success': True,
                 'prediction': result,
+                'model': {'name': detector.model_name, 'version': detector.model_version},
                 'timestamp': str(np.datetime64('now'))
             })
         except Exception as pred_error:
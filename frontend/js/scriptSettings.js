@@ -18,7 +18,7 @@ document.addEventListener("DOMContentLoaded", () => {
     // Attach event listeners for toggles
     autoModeToggle.addEventListener("change", async () => {
         const state = autoModeToggle.checked;
-        await handleModeToggle("/api/toggle-auto", state);
+        await handleModeToggle("auto", state);
     });
 
     //scheduleModeToggle.addEventListener("change", () => {
@@ -33,19 +33,19 @@ document.addEventListener("DOMContentLoaded", () => {
 
     // Attach event listeners for immediate toggles
     conveyerToggle.addEventListener("change", () =>
-        handleImmediateToggle("/api/toggle-belt", conveyerToggle.checked),
+        handleImmediateToggle("belt", conveyerToggle),
     );
     fanToggle.addEventListener("change", () =>
-        handleImmediateToggle("/api/toggle-fan", fanToggle.checked),
+        handleImmediateToggle("fan", fanToggle),
     );
     lightToggle.addEventListener("change", () =>
-        handleImmediateToggle("/api/toggle-bulb", lightToggle.checked),
+        handleImmediateToggle("bulb", lightToggle),
     );
     feederToggle.addEventListener("change", () =>
-        handleImmediateToggle("/api/toggle-feeder", feederToggle.checked),
+        handleImmediateToggle("feeder", feederToggle),
     );
     pumpToggle.addEventListener("change", () =>
-        handleImmediateToggle("/api/toggle-pump", pumpToggle.checked),
+        handleImmediateToggle("pump", pumpToggle),
     );
 
     // Attach event listener for saving schedule settings
@@ -116,20 +116,29 @@ function updateUI(data) {
         data.schedule.humThreshold.max || 60; */
 }
 
-// Handle toggling Auto Mode
-async function handleModeToggle(endpoint, state) {
-    try {
-        const response = await fetch(endpoint, {
-            method: "GET",
-        });
+// Switch an actuator on or off and return its new state
+async function controlActuator(id, on) {
+    const response = await fetch(`/api/actuators/${id}/control`, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ action: on ? "on" : "off" }),
+    });
 
-        if (!response.ok) {
-            throw new Error(`Failed to toggle ${endpoint}.`);
-        }
+    if (!response.ok) {
+        throw new Error(`Failed to switch ${id}.`);
+    }
+
+    const result = await response.json();
+    console.log(`Switched ${id}: `, result.on);
+    return result.on;
+}
 
-        let result = await response.json();
-        result = JSON.parse(result.state);
-        console.log(`Toggled ${endpoint}: `, result);
+// Handle toggling Auto Mode
+async function handleModeToggle(id, state) {
+    try {
+        const result = await controlActuator(id, state);
 
         if (!result) {
             conveyerToggle.checked = false;
@@ -139,7 +148,7 @@ async function handleModeToggle(endpoint, state) {
             pumpToggle.checked = false;
         }
     } catch (error) {
-        console.error(`Error toggling ${endpoint}:`, error);
+        console.error(`Error toggling ${id}:`, error);
         showNotification("Failed to update Auto Mode toggle.", "error");
 
         // Revert the toggle state on error
@@ -148,26 +157,16 @@ async function handleModeToggle(endpoint, state) {
 }
 
 // Handle immediate toggles (like belt, fan, light, feeder, and water)
-async function handleImmediateToggle(endpoint, state) {
+async function handleImmediateToggle(id, toggle) {
+    const state = toggle.checked;
     try {
-        const response = await fetch(endpoint, {
-            method: "GET",
-        });
-
-        if (!response.ok) {
-            throw new Error(`Failed to toggle ${endpoint}.`);
-        }
-
-        let result = await response.json();
-        result = JSON.parse(result.state);
-        console.log(`Toggled ${endpoint}: `, result);
+        toggle.checked = await controlActuator(id, state);
     } catch (error) {
-        console.error(`Error toggling ${endpoint}:`, error);
+        console.error(`Error toggling ${id}:`, error);
         showNotification("Failed to update device state.", "error");
 
         // Revert the toggle state on error
-        const device = endpoint.split("-")[1];
-        document.getElementById(`${device}Toggle`).checked = !state;
+        toggle.checked = !state;
     }
 }
 
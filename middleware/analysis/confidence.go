@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// Prediction outcomes
+const (
+	OutcomeConfident = "confident"
+	OutcomeUncertain = "uncertain" // The photo should be retaken
+)
+
+// Reasons a prediction is uncertain
+const (
+	ReasonLowConfidence = "low_confidence" // Top class below its threshold
+	ReasonLowMargin     = "low_margin"     // Top two classes too close
+	ReasonHighEntropy   = "high_entropy"   // Probability spread over many classes
+)
+
+// ConfidencePolicy decides when a prediction is trusted. Zero limits are
+// not checked.
+type ConfidencePolicy struct {
+	MinConfidence      float64            // Default threshold for the top class
+	ClassMinConfidence map[string]float64 // Per class thresholds, override the default
+	MinMargin          float64            // Least difference between the top two classes
+	MaxEntropy         float64            // Largest normalized entropy, between 0 and 1
+}
+
+// Assessment is how much a prediction can be trusted
+type Assessment struct {
+	Outcome    string   `json:"outcome"`
+	Reasons    []string `json:"reasons,omitempty"`
+	Threshold  float64  `json:"threshold"` // Confidence threshold of the predicted class
+	Margin     float64  `json:"margin"`    // Top class minus the runner-up
+	RunnerUp   string   `json:"runner_up,omitempty"`
+	Entropy    float64  `json:"entropy"` // Normalized Shannon entropy, 0 is certain and 1 uniform
+	Confidence float64  `json:"confidence"`
+}
+
+// Assess a prediction given the probability of every class
+func (p ConfidencePolicy) Assess(predicted string, confidence float64, probabilities map[string]float64) Assessment {
+	a := Assessment{Outcome: OutcomeConfident, Threshold: p.MinConfidence, Confidence: confidence, Margin: confidence}
+	if t, ok := p.ClassMinConfidence[predicted]; ok && t > 0 {
+		a.Threshold = t
+	}
+
+	if confidence < a.Threshold {
+		a.Reasons = append(a.Reasons, ReasonLowConfidence)
+	}
+
+	// Backends that only return the top class skip the distribution checks
+	if len(probabilities) > 1 {
+		a.RunnerUp, a.Margin = runnerUp(predicted, confidence, probabilities)
+		a.Entropy = NormalizedEntropy(probabilities)
+		if p.MinMargin > 0 && a.Margin < p.MinMargin {
+			a.Reasons = append(a.Reasons, ReasonLowMargin)
+		}
+		if p.MaxEntropy > 0 && a.Entropy > p.MaxEntropy {
+			a.Reasons = append(a.Reasons, ReasonHighEntropy)
+		}
+	}
+
+	if len(a.Reasons) > 0 {
+		a.Outcome = OutcomeUncertain
+	}
+	return a
+}
+
+// Most probable class after the predicted one, and the margin between them
+func runnerUp(predicted string, confidence float64, probabilities map[string]float64) (string, float64) {
+	classes := make([]string, 0, len(probabilities))
+	for class := range probabilities {
+		if class != predicted {
+			classes = append(classes, class)
+		}
+	}
+	sort.Strings(classes)
+
+	best := ""
+	for _, class := range classes {
+		if best == "" || probabilities[class] > probabilities[best] {
+			best = class
+		}
+	}
+	return best, confidence - probabilities[best]
+}
+
+// NormalizedEntropy returns the Shannon entropy of a distribution divided by
+// its maximum, so it is comparable between models with different class counts
+func NormalizedEntropy(probabilities map[string]float64) float64 {
+	if len(probabilities) < 2 {
+		return 0
+	}
+
+	var total float64
+	for _, p := range probabilities {
+		if p > 0 {
+			total += p
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	var entropy float64
+	for _, p := range probabilities {
+		if p > 0 {
+			q := p / total
+			entropy -= q * math.Log(q)
+		}
+	}
+	return math.Min(entropy/math.Log(float64(len(probabilities))), 1)
+}
@@ -0,0 +1,80 @@
+package analysis
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Probabilities of coccidiosis, healthy, newcastle and salmonella
+func probabilities(c, h, n, s float64) map[string]float64 {
+	return map[string]float64{"coccidiosis": c, "healthy": h, "newcastle": n, "salmonella": s}
+}
+
+func TestConfidencePolicyAssess(t *testing.T) {
+	policy := ConfidencePolicy{
+		MinConfidence:      0.5,
+		ClassMinConfidence: map[string]float64{"newcastle": 0.8, "healthy": 0},
+		MinMargin:          0.2,
+		MaxEntropy:         0.85,
+	}
+
+	tests := []struct {
+		name          string
+		predicted     string
+		probabilities map[string]float64
+		wantThreshold float64
+		wantReasons   []string
+	}{
+		{"confident", "coccidiosis", probabilities(0.61, 0.2, 0.1, 0.09), 0.5, nil},
+		{"at the default threshold", "coccidiosis", probabilities(0.5, 0.3, 0.1, 0.1), 0.5, nil},
+		{"below the class threshold", "newcastle", probabilities(0.05, 0.15, 0.75, 0.05), 0.8, []string{ReasonLowConfidence}},
+		{"above the class threshold", "newcastle", probabilities(0.05, 0.05, 0.85, 0.05), 0.8, nil},
+		{"zero class threshold falls back", "healthy", probabilities(0.2, 0.65, 0.1, 0.05), 0.5, nil},
+		{"close runner-up", "coccidiosis", probabilities(0.52, 0.03, 0.05, 0.4), 0.5, []string{ReasonLowMargin}},
+		{"wide enough margin", "coccidiosis", probabilities(0.55, 0.05, 0.06, 0.34), 0.5, nil},
+		{"spread out", "coccidiosis", probabilities(0.5, 0.2, 0.15, 0.15), 0.5, []string{ReasonHighEntropy}},
+		{"spread out below the cutoff", "coccidiosis", probabilities(0.6, 0.15, 0.15, 0.1), 0.5, nil},
+		{"every reason", "coccidiosis", probabilities(0.49, 0.1, 0.11, 0.3), 0.5, []string{ReasonLowConfidence, ReasonLowMargin, ReasonHighEntropy}},
+		{"top class only", "coccidiosis", map[string]float64{"coccidiosis": 0.4}, 0.5, []string{ReasonLowConfidence}},
+	}
+	for _, tt := range tests {
+		confidence := tt.probabilities[tt.predicted]
+		a := policy.Assess(tt.predicted, confidence, tt.probabilities)
+		wantOutcome := OutcomeConfident
+		if len(tt.wantReasons) > 0 {
+			wantOutcome = OutcomeUncertain
+		}
+		if a.Outcome != wantOutcome || !reflect.DeepEqual(a.Reasons, tt.wantReasons) || a.Threshold != tt.wantThreshold {
+			t.Errorf("%s: Assess() = %s %v threshold %v (margin %.2f, entropy %.4f), want %s %v threshold %v",
+				tt.name, a.Outcome, a.Reasons, a.Threshold, a.Margin, a.Entropy, wantOutcome, tt.wantReasons, tt.wantThreshold)
+		}
+	}
+
+	// The runner-up and margin are reported, and zero limits are not checked
+	a := ConfidencePolicy{}.Assess("coccidiosis", 0.52, probabilities(0.52, 0.03, 0.05, 0.4))
+	if a.Outcome != OutcomeConfident || a.RunnerUp != "salmonella" || math.Abs(a.Margin-0.12) > 1e-9 {
+		t.Errorf("Assess() without limits = %+v", a)
+	}
+}
+
+func TestNormalizedEntropy(t *testing.T) {
+	tests := []struct {
+		name          string
+		probabilities map[string]float64
+		want          float64
+	}{
+		{"uniform", probabilities(0.25, 0.25, 0.25, 0.25), 1},
+		{"certain", probabilities(1, 0, 0, 0), 0},
+		{"two classes", map[string]float64{"healthy": 0.5, "sick": 0.5}, 1},
+		{"unnormalized", map[string]float64{"healthy": 2, "sick": 2}, 1},
+		{"skewed", probabilities(0.61, 0.2, 0.1, 0.09), 0.7721},
+		{"single class", map[string]float64{"healthy": 0.9}, 0},
+		{"all zero", probabilities(0, 0, 0, 0), 0},
+	}
+	for _, tt := range tests {
+		if got := NormalizedEntropy(tt.probabilities); math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%s: NormalizedEntropy() = %.4f, want %v", tt.name, got, tt.want)
+		}
+	}
+}
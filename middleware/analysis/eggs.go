@@ -0,0 +1,115 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+)
+
+// DailyEggs is a day of egg collection in a flock
+type DailyEggs struct {
+	Day     time.Time
+	Eggs    int
+	Cracked int
+	Dirty   int
+	Hens    int // Live hens at the start of the day
+}
+
+// LayingDay is a day of a flock's laying curve
+type LayingDay struct {
+	Day      time.Time `json:"day"`
+	Eggs     int       `json:"eggs"`
+	Cracked  int       `json:"cracked"`
+	Dirty    int       `json:"dirty"`
+	Hens     int       `json:"hens"`
+	HenDay   float64   `json:"hen_day_percent"`  // Eggs per hen alive
+	Baseline *float64  `json:"baseline_percent"` // Mean hen-day of the previous days
+	Drop     *float64  `json:"drop_percent"`     // Relative fall below the baseline
+	Alert    bool      `json:"alert"`
+}
+
+// DropConfig tunes production drop detection
+type DropConfig struct {
+	BaselineDays    int     // Days before a day that form its baseline
+	MinBaselineDays int     // Fewer recorded days give no baseline
+	DropPercent     float64 // Relative fall below the baseline that raises an alert
+}
+
+// LayingRates computes the hen-day production of each day and compares it
+// with a rolling baseline of the days before
+func LayingRates(days []DailyEggs, cfg DropConfig) []LayingDay {
+	sorted := append([]DailyEggs{}, days...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Day.Before(sorted[j].Day) })
+
+	rates := make([]LayingDay, 0, len(sorted))
+	for _, d := range sorted {
+		l := LayingDay{Day: d.Day, Eggs: d.Eggs, Cracked: d.Cracked, Dirty: d.Dirty, Hens: d.Hens}
+		if d.Hens > 0 {
+			l.HenDay = float64(d.Eggs) / float64(d.Hens) * 100
+		}
+
+		var previous []float64
+		from := d.Day.AddDate(0, 0, -cfg.BaselineDays)
+		for _, p := range rates {
+			if !p.Day.Before(from) {
+				previous = append(previous, p.HenDay)
+			}
+		}
+		if len(previous) > 0 && len(previous) >= cfg.MinBaselineDays {
+			baseline := Mean(previous)
+			l.Baseline = &baseline
+			if baseline > 0 {
+				drop := (baseline - l.HenDay) / baseline * 100
+				l.Drop = &drop
+				l.Alert = drop >= cfg.DropPercent
+			}
+		}
+		rates = append(rates, l)
+	}
+	return rates
+}
+
+// LayingWeek sums a week of a flock's laying curve
+type LayingWeek struct {
+	Week   int      `json:"week"` // Week of age of the birds
+	From   string   `json:"from"` // First recorded day
+	Days   int      `json:"days"` // Recorded days
+	Eggs   int      `json:"eggs"`
+	HenDay float64  `json:"hen_day_percent"` // Mean of the recorded days
+	Change *float64 `json:"change"`          // Percentage points against the previous week
+}
+
+// WeeklyLaying groups a laying curve by week of age
+func WeeklyLaying(days []LayingDay, weekOf func(time.Time) int) []LayingWeek {
+	var weeks []LayingWeek
+	var henDays []float64
+	flush := func() {
+		if len(weeks) == 0 {
+			return
+		}
+		w := &weeks[len(weeks)-1]
+		w.HenDay = Mean(henDays)
+		if len(weeks) > 1 {
+			change := w.HenDay - weeks[len(weeks)-2].HenDay
+			w.Change = &change
+		}
+		henDays = nil
+	}
+
+	for _, d := range days {
+		week := weekOf(d.Day)
+		if len(weeks) == 0 || weeks[len(weeks)-1].Week != week {
+			flush()
+			weeks = append(weeks, LayingWeek{Week: week, From: d.Day.Format("2006-01-02")})
+		}
+		w := &weeks[len(weeks)-1]
+		w.Days++
+		w.Eggs += d.Eggs
+		henDays = append(henDays, d.HenDay)
+	}
+	flush()
+
+	if weeks == nil {
+		return []LayingWeek{}
+	}
+	return weeks
+}
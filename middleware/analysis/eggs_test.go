@@ -0,0 +1,106 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLayingRates(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 5, d, 0, 0, 0, 0, time.Local) }
+	cfg := DropConfig{BaselineDays: 7, MinBaselineDays: 3, DropPercent: 10}
+	days := []DailyEggs{
+		{Day: day(5), Eggs: 800, Hens: 1000}, // Recorded out of order
+		{Day: day(1), Eggs: 900, Hens: 1000},
+		{Day: day(2), Eggs: 900, Hens: 1000},
+		{Day: day(3), Eggs: 900, Hens: 1000},
+		{Day: day(4), Eggs: 900, Hens: 1000},
+		{Day: day(9), Eggs: 850, Hens: 1000},
+		{Day: day(20), Eggs: 500, Hens: 1000},
+		{Day: day(21), Eggs: 0, Hens: 0},
+	}
+
+	tests := []struct {
+		day          int
+		wantHenDay   float64
+		wantBaseline float64 // 0 when there is none
+		wantAlert    bool
+	}{
+		{1, 90, 0, false},
+		{2, 90, 0, false},
+		{3, 90, 0, false}, // Two days are not enough for a baseline
+		{4, 90, 90, false},
+		{5, 80, 90, true}, // 11% below the baseline
+		{9, 85, 87.5, false},
+		{20, 50, 0, false}, // Nothing recorded in the week before
+		{21, 0, 0, false},
+	}
+	rates := LayingRates(days, cfg)
+	if len(rates) != len(tests) {
+		t.Fatalf("%d laying days, want %d", len(rates), len(tests))
+	}
+	for i, tt := range tests {
+		r := rates[i]
+		if !r.Day.Equal(day(tt.day)) || r.HenDay != tt.wantHenDay || r.Alert != tt.wantAlert {
+			t.Errorf("day %d: %v hen-day %v alert %v, want day %d hen-day %v alert %v",
+				i, r.Day.Format("2006-01-02"), r.HenDay, r.Alert, tt.day, tt.wantHenDay, tt.wantAlert)
+		}
+		switch {
+		case tt.wantBaseline == 0 && r.Baseline != nil:
+			t.Errorf("May %d: baseline %v, want none", tt.day, *r.Baseline)
+		case tt.wantBaseline != 0 && (r.Baseline == nil || *r.Baseline != tt.wantBaseline):
+			t.Errorf("May %d: baseline %v, want %v", tt.day, r.Baseline, tt.wantBaseline)
+		}
+	}
+	if drop := rates[4].Drop; drop == nil || *drop < 11.1 || *drop > 11.2 {
+		t.Errorf("drop on May 5 = %v, want 11.1%%", drop)
+	}
+
+	// A single recorded day is enough when no minimum is set
+	rates = LayingRates(days[1:3], DropConfig{BaselineDays: 7, DropPercent: 10})
+	if rates[1].Baseline == nil || *rates[1].Baseline != 90 {
+		t.Errorf("baseline without a minimum = %v, want 90", rates[1].Baseline)
+	}
+}
+
+func TestWeeklyLaying(t *testing.T) {
+	placed := time.Date(2025, 5, 1, 0, 0, 0, 0, time.Local)
+	weekOf := func(d time.Time) int { return 20 + int(d.Sub(placed).Hours()/24)/7 }
+	day := func(d int, eggs int) LayingDay {
+		return LayingDay{Day: placed.AddDate(0, 0, d), Eggs: eggs, HenDay: float64(eggs) / 10}
+	}
+
+	weeks := WeeklyLaying([]LayingDay{
+		day(0, 900), day(1, 880), day(6, 920),
+		day(7, 850), day(8, 870),
+		day(21, 800),
+	}, weekOf)
+	want := []struct {
+		week, days, eggs int
+		from             string
+		henDay           float64
+		change           float64
+	}{
+		{20, 3, 2700, "2025-05-01", 90, 0},
+		{21, 2, 1720, "2025-05-08", 86, -4},
+		{23, 1, 800, "2025-05-22", 80, -6},
+	}
+	if len(weeks) != len(want) {
+		t.Fatalf("WeeklyLaying() = %+v", weeks)
+	}
+	for i, w := range want {
+		got := weeks[i]
+		if got.Week != w.week || got.Days != w.days || got.Eggs != w.eggs || got.From != w.from || got.HenDay != w.henDay {
+			t.Errorf("week %d = %+v, want %+v", i, got, w)
+		}
+		if i == 0 && got.Change != nil {
+			t.Errorf("first week has a change of %v", *got.Change)
+		}
+		if i > 0 && (got.Change == nil || *got.Change != w.change) {
+			t.Errorf("week %d change = %v, want %v", w.week, got.Change, w.change)
+		}
+	}
+
+	if weeks := WeeklyLaying(nil, weekOf); weeks == nil || len(weeks) != 0 {
+		t.Errorf("WeeklyLaying(nil) = %#v, want an empty list", weeks)
+	}
+}
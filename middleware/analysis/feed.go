@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+)
+
+// StateChange is a device switching on or off
+type StateChange struct {
+	At time.Time
+	On bool
+}
+
+// OnDuration is how long a device was on in [from, to). The state before the
+// first change in the window comes from the last change before from, so it
+// should be included.
+func OnDuration(changes []StateChange, from, to time.Time) time.Duration {
+	sorted := append([]StateChange{}, changes...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].At.Before(sorted[j].At) })
+
+	var total time.Duration
+	on := false
+	since := from
+	for _, c := range sorted {
+		if !c.At.After(from) {
+			on = c.On
+			continue
+		}
+		if !c.At.Before(to) {
+			break
+		}
+		if on && !c.On {
+			total += c.At.Sub(since)
+		}
+		if !on && c.On {
+			since = c.At
+		}
+		on = c.On
+	}
+	if on {
+		total += to.Sub(since)
+	}
+	return total
+}
+
+// FeedConversion is the feed a flock ate against the weight it gained
+type FeedConversion struct {
+	FeedKg         float64  `json:"feed_kg"`
+	StartBiomassKg float64  `json:"start_biomass_kg"`
+	EndBiomassKg   float64  `json:"end_biomass_kg"`
+	GainKg         float64  `json:"gain_kg"`
+	FCR            *float64 `json:"fcr"` // Feed per kg of gain, nil without gain
+}
+
+// FeedConversionRatio compares the feed eaten with the live weight gained
+// between two weighings
+func FeedConversionRatio(feedKg, startBiomassKg, endBiomassKg float64) FeedConversion {
+	fc := FeedConversion{
+		FeedKg:         feedKg,
+		StartBiomassKg: startBiomassKg,
+		EndBiomassKg:   endBiomassKg,
+		GainKg:         endBiomassKg - startBiomassKg,
+	}
+	if fc.GainKg > 0 && feedKg > 0 {
+		fcr := feedKg / fc.GainKg
+		fc.FCR = &fcr
+	}
+	return fc
+}
@@ -0,0 +1,64 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnDuration(t *testing.T) {
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	at := func(hours float64) time.Time { return from.Add(time.Duration(hours * float64(time.Hour))) }
+
+	tests := []struct {
+		name    string
+		changes []StateChange
+		want    time.Duration
+	}{
+		{"no changes", nil, 0},
+		{"on and off inside", []StateChange{{at(2), true}, {at(5), false}}, 3 * time.Hour},
+		{"on before from", []StateChange{{at(-3), true}, {at(4), false}}, 4 * time.Hour},
+		{"off before from", []StateChange{{at(-3), true}, {at(-1), false}, {at(6), true}, {at(7), false}}, time.Hour},
+		{"on at from", []StateChange{{at(0), true}, {at(1), false}}, time.Hour},
+		{"still on at to", []StateChange{{at(20), true}}, 4 * time.Hour},
+		{"on all day", []StateChange{{at(-48), true}}, 24 * time.Hour},
+		{"off at to", []StateChange{{at(22), true}, {at(24), false}}, 2 * time.Hour},
+		{"after to", []StateChange{{at(25), true}}, 0},
+		{"repeated on", []StateChange{{at(1), true}, {at(2), true}, {at(3), true}, {at(4), false}}, 3 * time.Hour},
+		{"repeated off", []StateChange{{at(1), true}, {at(2), false}, {at(3), false}, {at(4), true}, {at(5), false}}, 2 * time.Hour},
+		{"unsorted", []StateChange{{at(5), false}, {at(2), true}, {at(-1), false}}, 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := OnDuration(tt.changes, from, to); got != tt.want {
+			t.Errorf("%s: OnDuration() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFeedConversionRatio(t *testing.T) {
+	tests := []struct {
+		name             string
+		feed, start, end float64
+		wantGain         float64
+		wantFCR          float64 // 0 when there is no ratio
+	}{
+		{"broilers", 3400, 40, 2040, 2000, 1.7},
+		{"weighed twice", 900, 1200, 1800, 600, 1.5},
+		{"no gain", 500, 1000, 1000, 0, 0},
+		{"weight loss", 500, 1000, 900, -100, 0},
+		{"no feed", 0, 40, 2040, 2000, 0},
+	}
+	for _, tt := range tests {
+		fc := FeedConversionRatio(tt.feed, tt.start, tt.end)
+		if fc.GainKg != tt.wantGain {
+			t.Errorf("%s: gain = %v, want %v", tt.name, fc.GainKg, tt.wantGain)
+		}
+		if tt.wantFCR == 0 {
+			if fc.FCR != nil {
+				t.Errorf("%s: FCR = %v, want none", tt.name, *fc.FCR)
+			}
+		} else if fc.FCR == nil || *fc.FCR != tt.wantFCR {
+			t.Errorf("%s: FCR = %v, want %v", tt.name, fc.FCR, tt.wantFCR)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// GrowthPoint is a body weight at an age
+type GrowthPoint struct {
+	AgeDays int     `json:"age_days"`
+	Weight  float64 `json:"weight_g"`
+}
+
+// WeightStats summarizes the individual weights of a sample
+type WeightStats struct {
+	Count      int     `json:"count"`
+	Mean       float64 `json:"mean_g"`
+	StdDev     float64 `json:"std_dev_g"`
+	CV         float64 `json:"cv_percent"`         // Coefficient of variation, lower is more uniform
+	Uniformity float64 `json:"uniformity_percent"` // Birds within 10% of the mean
+}
+
+// SummarizeWeights computes the mean and uniformity of a weight sample
+func SummarizeWeights(weights []float64) WeightStats {
+	s := WeightStats{Count: len(weights)}
+	if s.Count == 0 {
+		return s
+	}
+
+	for _, w := range weights {
+		s.Mean += w
+	}
+	s.Mean /= float64(s.Count)
+
+	within := 0
+	var squares float64
+	for _, w := range weights {
+		squares += (w - s.Mean) * (w - s.Mean)
+		if math.Abs(w-s.Mean) <= 0.1*s.Mean {
+			within++
+		}
+	}
+	if s.Count > 1 {
+		s.StdDev = math.Sqrt(squares / float64(s.Count-1))
+	}
+	if s.Mean > 0 {
+		s.CV = s.StdDev / s.Mean * 100
+	}
+	s.Uniformity = float64(within) / float64(s.Count) * 100
+	return s
+}
+
+func sortedCurve(curve []GrowthPoint) []GrowthPoint {
+	sorted := append([]GrowthPoint{}, curve...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].AgeDays < sorted[j].AgeDays })
+	return sorted
+}
+
+// TargetWeight interpolates a growth curve at an age. ok is false outside
+// the curve.
+func TargetWeight(curve []GrowthPoint, age float64) (weight float64, ok bool) {
+	c := sortedCurve(curve)
+	if len(c) == 0 || age < float64(c[0].AgeDays) || age > float64(c[len(c)-1].AgeDays) {
+		return 0, false
+	}
+	for i := 1; i < len(c); i++ {
+		if age <= float64(c[i].AgeDays) {
+			a, b := c[i-1], c[i]
+			t := (age - float64(a.AgeDays)) / float64(b.AgeDays-a.AgeDays)
+			return a.Weight + t*(b.Weight-a.Weight), true
+		}
+	}
+	return c[0].Weight, true
+}
+
+// ProjectMarketAge estimates the age a flock reaches the market weight,
+// assuming it keeps growing at the same share of the standard as on its last
+// weighing. Past the end of the curve the last segment is extended. ok is
+// false when the curve cannot be used.
+func ProjectMarketAge(curve []GrowthPoint, age int, weight, marketWeight float64) (float64, bool) {
+	if weight >= marketWeight {
+		return float64(age), true
+	}
+	c := sortedCurve(curve)
+	if len(c) < 2 {
+		return 0, false
+	}
+	target, ok := TargetWeight(c, float64(age))
+	if !ok || target <= 0 {
+		return 0, false
+	}
+	// Standard weight the flock must reach on the curve
+	needed := marketWeight * target / weight
+
+	for i := 1; i < len(c); i++ {
+		a, b := c[i-1], c[i]
+		if b.AgeDays < age || b.Weight < needed {
+			continue
+		}
+		if b.Weight == a.Weight {
+			return float64(b.AgeDays), true
+		}
+		t := (needed - a.Weight) / (b.Weight - a.Weight)
+		return math.Max(float64(a.AgeDays)+t*float64(b.AgeDays-a.AgeDays), float64(age)), true
+	}
+
+	a, b := c[len(c)-2], c[len(c)-1]
+	slope := (b.Weight - a.Weight) / float64(b.AgeDays-a.AgeDays)
+	if slope <= 0 {
+		return 0, false
+	}
+	return float64(b.AgeDays) + (needed-b.Weight)/slope, true
+}
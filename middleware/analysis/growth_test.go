@@ -0,0 +1,115 @@
+package analysis
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+)
+
+// The Cobb 500 curve the breed standards are seeded with
+func cobb500(t *testing.T) []GrowthPoint {
+	t.Helper()
+	data, err := os.ReadFile("../database/breeds_seed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var breeds []struct {
+		Key   string        `json:"key"`
+		Curve []GrowthPoint `json:"curve"`
+	}
+	if err := json.Unmarshal(data, &breeds); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range breeds {
+		if b.Key == "cobb_500" {
+			return b.Curve
+		}
+	}
+	t.Fatal("cobb_500 is not seeded")
+	return nil
+}
+
+func TestSummarizeWeights(t *testing.T) {
+	tests := []struct {
+		name           string
+		weights        []float64
+		wantMean       float64
+		wantCV         float64
+		wantUniformity float64
+	}{
+		{"uniform flock", []float64{1800, 2000, 2200, 2000}, 2000, 8.1650, 100},
+		{"uneven flock", []float64{1000, 2000, 3000}, 2000, 50, 100.0 / 3},
+		{"just outside 10%", []float64{1790, 2000, 2000, 2210}, 2000, 8.5732, 50},
+		{"single bird", []float64{2000}, 2000, 0, 100},
+		{"empty", nil, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		s := SummarizeWeights(tt.weights)
+		if s.Count != len(tt.weights) || s.Mean != tt.wantMean || math.Abs(s.CV-tt.wantCV) > 1e-4 || math.Abs(s.Uniformity-tt.wantUniformity) > 1e-9 {
+			t.Errorf("%s: SummarizeWeights() = %+v, want mean %v, CV %v%%, uniformity %v%%",
+				tt.name, s, tt.wantMean, tt.wantCV, tt.wantUniformity)
+		}
+	}
+}
+
+func TestTargetWeight(t *testing.T) {
+	curve := cobb500(t)
+	tests := []struct {
+		age    float64
+		want   float64
+		wantOK bool
+	}{
+		{0, 42, true},
+		{7, 185, true},
+		{10, 305, true},
+		{35.5, 2238.5714, true},
+		{56, 4049, true},
+		{56.5, 0, false},
+		{-1, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := TargetWeight(curve, tt.age)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("TargetWeight(%v) = %v, %v, want %v, %v", tt.age, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	// The curve does not need to be sorted
+	reversed := append([]GrowthPoint{}, curve...)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	if got, ok := TargetWeight(reversed, 10); !ok || got != 305 {
+		t.Errorf("TargetWeight() on a reversed curve = %v, %v, want 305", got, ok)
+	}
+}
+
+func TestProjectMarketAge(t *testing.T) {
+	curve := cobb500(t)
+	tests := []struct {
+		name         string
+		age          int
+		weight       float64
+		marketWeight float64
+		want         float64
+		wantOK       bool
+	}{
+		{"on the standard", 21, 943, 2200, 35.0946, true},
+		{"10% behind", 21, 848.7, 2200, 37.6638, true},
+		{"10% ahead", 35, 2410.1, 2500, 35.8590, true},
+		{"already at market weight", 30, 2300, 2200, 30, true},
+		{"past the end of the curve", 49, 1743, 2200, 60.3641, true},
+		{"older than the curve", 60, 2000, 2200, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ProjectMarketAge(curve, tt.age, tt.weight, tt.marketWeight)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-4 {
+			t.Errorf("%s: ProjectMarketAge() = %.4f, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if _, ok := ProjectMarketAge(curve[:1], 0, 40, 2200); ok {
+		t.Error("ProjectMarketAge() with a single point curve succeeded")
+	}
+}
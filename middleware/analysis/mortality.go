@@ -0,0 +1,65 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+)
+
+// DailyLoss is the birds a flock lost on a day
+type DailyLoss struct {
+	Day    time.Time
+	Deaths int
+	Culls  int
+}
+
+// DailyMortality is a day of a flock's mortality curve
+type DailyMortality struct {
+	Day                 time.Time `json:"day"`
+	Deaths              int       `json:"deaths"`
+	Culls               int       `json:"culls"`
+	OpeningCount        int       `json:"opening_count"` // Live birds at the start of the day
+	ClosingCount        int       `json:"closing_count"`
+	DeathRate           float64   `json:"death_rate_percent"`
+	MortalityRate       float64   `json:"mortality_rate_percent"`       // Deaths and culls
+	CumulativeMortality float64   `json:"cumulative_mortality_percent"` // Deaths and culls since placement
+}
+
+// MortalityCurve turns the losses of a flock into daily and cumulative
+// rates. Days without losses are left out.
+func MortalityCurve(initialCount int, losses []DailyLoss) []DailyMortality {
+	byDay := map[time.Time]*DailyLoss{}
+	for _, l := range losses {
+		day := StartOfDay(l.Day)
+		d, ok := byDay[day]
+		if !ok {
+			d = &DailyLoss{Day: day}
+			byDay[day] = d
+		}
+		d.Deaths += l.Deaths
+		d.Culls += l.Culls
+	}
+
+	days := make([]*DailyLoss, 0, len(byDay))
+	for _, d := range byDay {
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day.Before(days[j].Day) })
+
+	curve := make([]DailyMortality, 0, len(days))
+	live, lost := initialCount, 0
+	for _, d := range days {
+		m := DailyMortality{Day: d.Day, Deaths: d.Deaths, Culls: d.Culls, OpeningCount: live}
+		live -= d.Deaths + d.Culls
+		lost += d.Deaths + d.Culls
+		m.ClosingCount = live
+		if m.OpeningCount > 0 {
+			m.DeathRate = float64(d.Deaths) / float64(m.OpeningCount) * 100
+			m.MortalityRate = float64(d.Deaths+d.Culls) / float64(m.OpeningCount) * 100
+		}
+		if initialCount > 0 {
+			m.CumulativeMortality = float64(lost) / float64(initialCount) * 100
+		}
+		curve = append(curve, m)
+	}
+	return curve
+}
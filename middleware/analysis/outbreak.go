@@ -0,0 +1,130 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+)
+
+// Observation is one disease prediction for a house
+type Observation struct {
+	Time       time.Time
+	Disease    string
+	Healthy    bool
+	Confidence float64
+}
+
+// DailyRate is the share of a day's predictions that found a disease
+type DailyRate struct {
+	Day   time.Time `json:"day"`
+	Cases int       `json:"cases"`
+	Total int       `json:"total"`
+	Rate  float64   `json:"rate"`
+}
+
+// SurgeConfig tunes outbreak detection
+type SurgeConfig struct {
+	Window        time.Duration // Recent period that is tested
+	Baseline      time.Duration // Period before the window used as reference
+	MinCases      int           // Fewer cases are never flagged
+	MinConfidence float64       // Predictions below this confidence are ignored
+	Alpha         float64       // Significance level
+	PriorRate     float64       // Rate assumed when there is little baseline data
+	PriorWeight   float64       // How many predictions the prior is worth
+}
+
+// DefaultSurgeConfig flags a disease when at least three confident cases in
+// the last two days are unlikely given the previous four weeks.
+var DefaultSurgeConfig = SurgeConfig{
+	Window:        48 * time.Hour,
+	Baseline:      28 * 24 * time.Hour,
+	MinCases:      3,
+	MinConfidence: 0.5,
+	Alpha:         0.01,
+	PriorRate:     0.1,
+	PriorWeight:   10,
+}
+
+// Surge is the result of testing one disease in one house
+type Surge struct {
+	Disease       string  `json:"disease"`
+	WindowCases   int     `json:"window_cases"`
+	WindowTotal   int     `json:"window_total"`
+	WindowRate    float64 `json:"window_rate"`
+	BaselineCases int     `json:"baseline_cases"`
+	BaselineTotal int     `json:"baseline_total"`
+	BaselineRate  float64 `json:"baseline_rate"`
+	PValue        float64 `json:"p_value"`
+	Unusual       bool    `json:"unusual"`
+}
+
+// DetectSurge tests whether the rate of disease in the window ending at now is
+// significantly higher than in the baseline period before it. Under the null
+// hypothesis window cases follow a binomial distribution with the smoothed
+// baseline rate.
+func DetectSurge(observations []Observation, disease string, now time.Time, cfg SurgeConfig) Surge {
+	windowStart := now.Add(-cfg.Window)
+	baselineStart := windowStart.Add(-cfg.Baseline)
+
+	s := Surge{Disease: disease}
+	for _, o := range observations {
+		if o.Confidence < cfg.MinConfidence || o.Time.Before(baselineStart) || !o.Time.Before(now) {
+			continue
+		}
+		isCase := !o.Healthy && o.Disease == disease
+		if o.Time.Before(windowStart) {
+			s.BaselineTotal++
+			if isCase {
+				s.BaselineCases++
+			}
+		} else {
+			s.WindowTotal++
+			if isCase {
+				s.WindowCases++
+			}
+		}
+	}
+
+	if s.WindowTotal > 0 {
+		s.WindowRate = float64(s.WindowCases) / float64(s.WindowTotal)
+	}
+	s.BaselineRate = (float64(s.BaselineCases) + cfg.PriorRate*cfg.PriorWeight) /
+		(float64(s.BaselineTotal) + cfg.PriorWeight)
+	s.PValue = BinomialUpperTail(s.WindowCases, s.WindowTotal, s.BaselineRate)
+	s.Unusual = s.WindowCases >= cfg.MinCases && s.WindowRate > s.BaselineRate && s.PValue < cfg.Alpha
+	return s
+}
+
+// DailyRates buckets observations by local day between from and to and
+// returns the share of confident predictions that found the disease
+func DailyRates(observations []Observation, disease string, from, to time.Time, minConfidence float64) []DailyRate {
+	days := map[time.Time]*DailyRate{}
+	for _, o := range observations {
+		if o.Confidence < minConfidence || o.Time.Before(from) || !o.Time.Before(to) {
+			continue
+		}
+		day := StartOfDay(o.Time)
+		d, ok := days[day]
+		if !ok {
+			d = &DailyRate{Day: day}
+			days[day] = d
+		}
+		d.Total++
+		if !o.Healthy && o.Disease == disease {
+			d.Cases++
+		}
+	}
+
+	rates := make([]DailyRate, 0, len(days))
+	for _, d := range days {
+		d.Rate = float64(d.Cases) / float64(d.Total)
+		rates = append(rates, *d)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Day.Before(rates[j].Day) })
+	return rates
+}
+
+// StartOfDay truncates a time to local midnight
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.Local().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,100 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+// Predictions spread evenly over the hours before end, the first cases of
+// them found coccidiosis
+func predictions(end time.Time, hours, total, cases int) []Observation {
+	observations := make([]Observation, total)
+	for i := range observations {
+		observations[i] = Observation{
+			Time:       end.Add(-time.Duration((i+1)*hours) * time.Hour / time.Duration(total+1)),
+			Disease:    "healthy",
+			Healthy:    true,
+			Confidence: 0.9,
+		}
+		if i < cases {
+			observations[i].Disease, observations[i].Healthy = "coccidiosis", false
+		}
+	}
+	return observations
+}
+
+func TestDetectSurge(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	windowStart := now.Add(-DefaultSurgeConfig.Window)
+	// 5 cases in 100 predictions over the four weeks before the window
+	baseline := predictions(windowStart, 28*24, 100, 5)
+
+	tests := []struct {
+		name        string
+		window      []Observation
+		wantCases   int
+		wantUnusual bool
+	}{
+		{"surge", predictions(now, 48, 20, 8), 8, true},
+		{"usual rate", predictions(now, 48, 20, 1), 1, false},
+		{"too few cases", predictions(now, 48, 2, 2), 2, false},
+		{
+			"unconfident cases are ignored",
+			append(predictions(now, 48, 20, 1), func() []Observation {
+				unsure := predictions(now, 48, 8, 8)
+				for i := range unsure {
+					unsure[i].Confidence = 0.3
+				}
+				return unsure
+			}()...),
+			1, false,
+		},
+	}
+	for _, tt := range tests {
+		s := DetectSurge(append(tt.window, baseline...), "coccidiosis", now, DefaultSurgeConfig)
+		if s.BaselineCases != 5 || s.BaselineTotal != 100 {
+			t.Errorf("%s: baseline = %d/%d, want 5/100", tt.name, s.BaselineCases, s.BaselineTotal)
+		}
+		// Smoothed with 10 predictions at the 10% prior
+		if want := 6.0 / 110; s.BaselineRate != want {
+			t.Errorf("%s: baseline rate = %v, want %v", tt.name, s.BaselineRate, want)
+		}
+		if s.WindowCases != tt.wantCases || s.Unusual != tt.wantUnusual {
+			t.Errorf("%s: %d window cases, unusual %v (p = %.4g), want %d, %v",
+				tt.name, s.WindowCases, s.Unusual, s.PValue, tt.wantCases, tt.wantUnusual)
+		}
+	}
+
+	// Another disease is not a case of this one
+	if s := DetectSurge(predictions(now, 48, 20, 8), "newcastle", now, DefaultSurgeConfig); s.WindowCases != 0 || s.Unusual {
+		t.Errorf("other disease: %+v", s)
+	}
+}
+
+func TestDailyRates(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2025, 6, day, hour, 0, 0, 0, time.Local) }
+	observations := []Observation{
+		{Time: at(1, 8), Disease: "coccidiosis", Confidence: 0.9},
+		{Time: at(1, 20), Disease: "healthy", Healthy: true, Confidence: 0.9},
+		{Time: at(1, 21), Disease: "coccidiosis", Confidence: 0.2}, // Unconfident
+		{Time: at(3, 0), Disease: "salmonella", Confidence: 0.8},
+		{Time: at(3, 23), Disease: "coccidiosis", Confidence: 0.8},
+		{Time: at(3, 23), Disease: "coccidiosis", Confidence: 0.7},
+		{Time: at(4, 0), Disease: "coccidiosis", Confidence: 0.9}, // At to
+		{Time: at(1, 6), Disease: "coccidiosis", Confidence: 0.9}, // Before from
+	}
+
+	got := DailyRates(observations, "coccidiosis", at(1, 7), at(4, 0), 0.5)
+	want := []DailyRate{
+		{Day: at(1, 0), Cases: 1, Total: 2, Rate: 0.5},
+		{Day: at(3, 0), Cases: 2, Total: 3, Rate: 2.0 / 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DailyRates() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if !got[i].Day.Equal(want[i].Day) || got[i].Cases != want[i].Cases || got[i].Total != want[i].Total || got[i].Rate != want[i].Rate {
+			t.Errorf("day %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBinomialUpperTail(t *testing.T) {
+	// Reference values from a cumulative binomial table
+	tests := []struct {
+		k, n int
+		p    float64
+		want float64
+	}{
+		{1, 10, 0.1, 0.6513},
+		{3, 10, 0.5, 0.9453},
+		{8, 10, 0.5, 0.0547},
+		{2, 20, 0.05, 0.2642},
+		{5, 50, 0.02, 0.0032},
+		{0, 10, 0.3, 1},
+		{11, 10, 0.3, 0},
+		{1, 10, 0, 0},
+		{10, 10, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := BinomialUpperTail(tt.k, tt.n, tt.p); math.Abs(got-tt.want) > 5e-5 {
+			t.Errorf("BinomialUpperTail(%d, %d, %v) = %.5f, want %.4f", tt.k, tt.n, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestPearson(t *testing.T) {
+	tests := []struct {
+		name   string
+		x, y   []float64
+		want   float64
+		wantOK bool
+	}{
+		{"perfect positive", []float64{1, 2, 3, 4}, []float64{10, 20, 30, 40}, 1, true},
+		{"perfect negative", []float64{1, 2, 3, 4}, []float64{8, 6, 4, 2}, -1, true},
+		{"uncorrelated", []float64{1, 2, 3, 4}, []float64{1, 3, 3, 1}, 0, true},
+		{"constant series", []float64{1, 2, 3, 4}, []float64{5, 5, 5, 5}, 0, false},
+		{"too few pairs", []float64{1, 2}, []float64{1, 2}, 0, false},
+		{"unequal lengths", []float64{1, 2, 3}, []float64{1, 2, 3, 4}, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := Pearson(tt.x, tt.y)
+		if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: Pearson() = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Actuators are the outputs of the controllers. Each is registered with its
+// type, what it drives and the address of the output in the driver of its
+// controller, so a second fan is a registry entry instead of a new route.
+
+var (
+	actuatorTypes     = []string{database.ActuatorRelay, database.ActuatorServo, database.ActuatorDimmer}
+	actuatorFunctions = []string{"fan", "light", "heater", "belt", "feeder", "pump", "curtain", "mode", "other"}
+)
+
+var (
+	actuatorIDPattern   = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+	controllerClientsMu sync.Mutex
+	controllerClients   = map[string]*ControllerClient{}
+)
+
+// Client of an ESP32 controller by address. Actuators without one belong to
+// the default controller.
+func controllerFor(address string) *ControllerClient {
+	if address == "" || address == controller.Address {
+		return controller
+	}
+	controllerClientsMu.Lock()
+	defer controllerClientsMu.Unlock()
+	cc, ok := controllerClients[address]
+	if !ok {
+		cc = NewControllerClient("https://" + address)
+		controllerClients[address] = cc
+	}
+	return cc
+}
+
+// Address of the controller an actuator belongs to
+func actuatorController(a database.Actuator) string {
+	if a.Controller == "" {
+		return controller.Address
+	}
+	return a.Controller
+}
+
+func actuatorOutput(a database.Actuator) ControllerOutput {
+	return ControllerOutput{Type: a.Type, Address: a.Address}
+}
+
+// Store the state an actuator was seen in, and meter the run time of
+// feeders and pumps
+func recordActuatorState(a database.Actuator, state float64, source string) {
+	if err := database.SetActuatorState(DB, a.ID, state); err != nil {
+		log.Println("Failed to store actuator state:", err)
+	}
+	if isMetered(a.Function) {
+		recordDeviceState(actuatorController(a), a.ID, state != 0, source)
+	}
+}
+
+// Poll the state of the active actuators of each controller, controllers
+// switch some of them themselves in auto mode
+func recordActuatorStatesOnce() error {
+	actuators, err := database.ListActuators(DB, database.ActuatorFilter{ActiveOnly: true})
+	if err != nil {
+		return err
+	}
+	byController := map[string][]database.Actuator{}
+	for _, a := range actuators {
+		byController[a.Controller] = append(byController[a.Controller], a)
+	}
+
+	var firstErr error
+	for address, actuators := range byController {
+		outputs := make([]ControllerOutput, len(actuators))
+		for i, a := range actuators {
+			outputs[i] = actuatorOutput(a)
+		}
+
+		driver, err := driverFor(address)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), controllerRequestTimeout)
+			var states map[ControllerOutput]float64
+			states, err = driver.ReadState(ctx, outputs)
+			cancel()
+			for _, a := range actuators {
+				if state, ok := states[actuatorOutput(a)]; ok {
+					recordActuatorState(a, state, deviceSourcePoll)
+				}
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ====== ACTUATOR HANDLERS ====== //
+
+type actuatorRequest struct {
+	ID         string `json:"id"` // Only read on create
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Function   string `json:"function"`
+	Controller string `json:"controller"` // Defaults to the controller of the house
+	Address    string `json:"address"`
+	HouseID    *int   `json:"house_id"`
+	Active     *bool  `json:"active"` // Defaults to true
+}
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate a request into an actuator. On failure the returned map is the
+// body of a 400 response.
+func (req actuatorRequest) actuator(id string) (database.Actuator, fiber.Map) {
+	a := database.Actuator{
+		ID:         id,
+		Name:       strings.TrimSpace(req.Name),
+		Type:       strings.ToLower(strings.TrimSpace(req.Type)),
+		Function:   strings.ToLower(strings.TrimSpace(req.Function)),
+		Controller: strings.TrimRight(strings.TrimSpace(req.Controller), "/"),
+		Address:    strings.TrimSpace(req.Address),
+		HouseID:    req.HouseID,
+		Active:     req.Active == nil || *req.Active,
+	}
+	if a.Type == "" {
+		a.Type = database.ActuatorRelay
+	}
+	if !oneOf(a.Type, actuatorTypes) {
+		return a, fiber.Map{"error": "Unknown actuator type", "hint": "One of " + strings.Join(actuatorTypes, ", ")}
+	}
+	if !oneOf(a.Function, actuatorFunctions) {
+		return a, fiber.Map{"error": "Unknown actuator function", "hint": "One of " + strings.Join(actuatorFunctions, ", ")}
+	}
+	if a.HouseID != nil {
+		house, err := database.GetHouse(DB, *a.HouseID)
+		if err != nil {
+			return a, fiber.Map{"error": "Unknown house"}
+		}
+		if a.Controller == "" {
+			a.Controller = house.Controller
+		}
+	}
+
+	driver, err := driverFor(a.Controller)
+	if err != nil {
+		return a, fiber.Map{"error": "Failed to load controller driver", "details": err.Error()}
+	}
+	if err := driver.CheckOutput(actuatorOutput(a)); err != nil {
+		return a, fiber.Map{"error": "Invalid actuator address", "hint": err.Error()}
+	}
+	return a, nil
+}
+
+func actuatorFromParams(c *fiber.Ctx) (database.Actuator, int, fiber.Map) {
+	actuator, err := database.GetActuator(DB, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return actuator, fiber.StatusNotFound, fiber.Map{"error": "Actuator not found"}
+	}
+	if err != nil {
+		return actuator, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch actuator"}
+	}
+	return actuator, 0, nil
+}
+
+// List actuators: ?house_id=&function=&active=true
+func ListActuatorsHandler(c *fiber.Ctx) error {
+	actuators, err := database.ListActuators(DB, database.ActuatorFilter{
+		HouseID:    c.QueryInt("house_id"),
+		Function:   c.Query("function"),
+		ActiveOnly: c.Query("active") == "true",
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch actuators"})
+	}
+	return c.JSON(actuators)
+}
+
+func GetActuatorHandler(c *fiber.Ctx) error {
+	actuator, status, body := actuatorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	return c.JSON(actuator)
+}
+
+func CreateActuatorHandler(c *fiber.Ctx) error {
+	var req actuatorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !actuatorIDPattern.MatchString(req.ID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid actuator ID",
+			"hint":  "Up to 64 letters, digits and _ . : -",
+		})
+	}
+	actuator, body := req.actuator(req.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+	if _, err := database.GetActuator(DB, actuator.ID); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "An actuator with this ID already exists"})
+	}
+
+	if err := database.CreateActuator(DB, actuator); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create actuator"})
+	}
+	created, err := database.GetActuator(DB, actuator.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch actuator"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Actuator registered successfully", "actuator": created})
+}
+
+// Replace an actuator. Its last state is kept.
+func UpdateActuatorHandler(c *fiber.Ctx) error {
+	existing, status, body := actuatorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	var req actuatorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	actuator, body := req.actuator(existing.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	if err := database.UpdateActuator(DB, actuator); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update actuator"})
+	}
+	updated, err := database.GetActuator(DB, actuator.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch actuator"})
+	}
+	return c.JSON(fiber.Map{"message": "Actuator updated successfully", "actuator": updated})
+}
+
+func DeleteActuatorHandler(c *fiber.Ctx) error {
+	err := database.DeleteActuator(DB, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Actuator not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete actuator"})
+	}
+	return c.JSON(fiber.Map{"message": "Actuator deleted successfully"})
+}
+
+// ====== CONTROL ====== //
+
+type actuatorControlRequest struct {
+	Action string   `json:"action"` // toggle, on, off or set. Defaults to toggle.
+	Value  *float64 `json:"value"`  // State for set
+}
+
+// Drive an actuator: {"action": "toggle"} or {"action": "set", "value": 40}.
+// Relays are switched with toggle, on and off, servos and dimmers are set.
+// Dimmers can also be switched off. Changed compares the new state to the
+// last known one.
+func ControlActuatorHandler(c *fiber.Ctx) error {
+	actuator, status, body := actuatorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if !actuator.Active {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Actuator is inactive"})
+	}
+	var req actuatorControlRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	if req.Action == "" {
+		req.Action = "toggle"
+	}
+
+	relay := actuator.Type == database.ActuatorRelay
+	min, max := outputRange(actuator.Type)
+	var value float64
+	switch {
+	case req.Action == "on" && relay:
+		value = 1
+	case req.Action == "off" && (relay || actuator.Type == database.ActuatorDimmer):
+		value = 0
+	case req.Action == "toggle" && relay:
+	case req.Action == "set" && !relay:
+		if req.Value == nil || *req.Value < min || *req.Value > max {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("value must be between %g and %g", min, max),
+			})
+		}
+		value = *req.Value
+	default:
+		hint := "Relays take toggle, on or off"
+		if !relay {
+			hint = fmt.Sprintf("A %s takes set with a value", actuator.Type)
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid action", "hint": hint})
+	}
+
+	driver, err := driverFor(actuator.Controller)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load controller driver"})
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), controllerRequestTimeout)
+	defer cancel()
+
+	var state float64
+	if req.Action == "toggle" {
+		state, err = driver.Toggle(ctx, actuatorOutput(actuator))
+	} else {
+		state, err = driver.SetOutput(ctx, actuatorOutput(actuator), value)
+	}
+	if errors.Is(err, errUnsupportedOutput) {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": "The controller firmware does not drive this type of actuator"})
+	}
+	if err != nil {
+		log.Printf("Failed to drive actuator %s: %v", actuator.ID, err)
+		return controllerErrorResponse(c, err, "Failed to drive actuator")
+	}
+
+	changed := actuator.LastState == nil || *actuator.LastState != state
+	recordActuatorState(actuator, state, deviceSourceToggle)
+	return c.JSON(fiber.Map{"actuator": actuator.ID, "state": state, "on": state != 0, "changed": changed})
+}
@@ -0,0 +1,12 @@
+package api
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Stop the AI service when the middleware dies, otherwise the orphan keeps the
+// port and every restart fails
+func configureChildProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+}
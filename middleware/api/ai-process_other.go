@@ -0,0 +1,7 @@
+//go:build !linux
+
+package api
+
+import "os/exec"
+
+func configureChildProcess(cmd *exec.Cmd) {}
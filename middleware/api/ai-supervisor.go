@@ -0,0 +1,539 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The supervisor probes every classifier backend, records which model each
+// serves and keeps an aggregated status. When AI_SERVICE_COMMAND is set, the
+// Flask AI service runs as a child process that is restarted with backoff
+// when it exits or stops answering.
+
+const (
+	aiProbeTimeout     = 5 * time.Second
+	aiFailureThreshold = 2                // Failed probes before a backend counts as down
+	aiHungThreshold    = 3                // Failed probes before a managed process is killed
+	aiMinBackoff       = time.Second      // First restart delay
+	aiMaxBackoff       = time.Minute      // Longest restart delay
+	aiStableUptime     = 5 * time.Minute  // Uptime after which the backoff is reset
+	aiStartupGrace     = 3 * time.Minute  // Loading TensorFlow and the model is slow
+	aiStatusStale      = 10 * time.Minute // Probe on demand when the last probe is older
+)
+
+// AI backend states
+const (
+	aiHealthy        = "healthy"
+	aiModelNotLoaded = "model_not_loaded"
+	aiUnreachable    = "unreachable"
+	aiUnknown        = "unknown"
+)
+
+// AIModelRef identifies the model that made a prediction
+type AIModelRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// AIModelInfo is the model a backend currently serves
+type AIModelInfo struct {
+	AIModelRef
+	Classes []string `json:"classes"`
+}
+
+// AIBackendStatus is the state of one classifier backend
+type AIBackendStatus struct {
+	Name                string       `json:"name"`
+	Type                string       `json:"type"`
+	Status              string       `json:"status"`
+	ModelLoaded         bool         `json:"model_loaded"`
+	Available           bool         `json:"available"`
+	Model               *AIModelInfo `json:"model,omitempty"`
+	LastCheck           time.Time    `json:"last_check"`
+	LastHealthy         *time.Time   `json:"last_healthy,omitempty"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+}
+
+// AIStatus is the aggregated state of the AI backends. The top level fields
+// describe the primary backend, except Available which is true when any
+// backend can take predictions.
+type AIStatus struct {
+	AIBackendStatus
+	Backends []AIBackendStatus `json:"backends,omitempty"` // Only when there are several
+	Routing  *AIRouting        `json:"routing,omitempty"`
+	Process  *AIProcessStatus  `json:"process,omitempty"`
+	Queue    map[string]int    `json:"queue,omitempty"`
+}
+
+// AIProcessStatus describes the managed AI service process
+type AIProcessStatus struct {
+	State     string     `json:"state"` // starting, running, backoff
+	PID       int        `json:"pid,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	Restarts  int        `json:"restarts"`
+	LastExit  string     `json:"last_exit,omitempty"`
+	NextStart *time.Time `json:"next_start,omitempty"`
+}
+
+// aiBackend is a configured classifier with its health
+type aiBackend struct {
+	name       string
+	classifier DiseaseClassifier
+
+	mu     sync.Mutex
+	status AIBackendStatus
+}
+
+type aiSupervisor struct {
+	backends []*aiBackend
+	router   *aiRouter
+	process  *aiProcess // nil when the AI service is not managed
+	managed  *aiBackend // Backend served by the managed process
+}
+
+var aiService = &aiSupervisor{}
+
+// StartAISupervisor loads the backend configuration, starts the managed AI
+// service process if configured and probes the backends every interval
+func StartAISupervisor(interval time.Duration) error {
+	config, err := loadAIConfig()
+	if err != nil {
+		return err
+	}
+
+	s := &aiSupervisor{}
+	for _, bc := range config.Backends {
+		classifier, err := newClassifier(bc)
+		if err != nil {
+			return fmt.Errorf("AI backend %q: %w", bc.Name, err)
+		}
+		for _, b := range s.backends {
+			if b.name == bc.Name {
+				return fmt.Errorf("duplicate AI backend %q", bc.Name)
+			}
+		}
+		s.backends = append(s.backends, &aiBackend{
+			name:       bc.Name,
+			classifier: classifier,
+			status:     AIBackendStatus{Name: bc.Name, Type: bc.Type, Status: aiUnknown},
+		})
+		if bc.Type == backendFlask && s.managed == nil {
+			s.managed = s.backends[len(s.backends)-1]
+		}
+	}
+	if s.router, err = newAIRouter(config.Routing, s.backends); err != nil {
+		return fmt.Errorf("AI routing: %w", err)
+	}
+
+	if command := strings.Fields(os.Getenv("AI_SERVICE_COMMAND")); len(command) > 0 {
+		if s.managed == nil {
+			return errors.New("AI_SERVICE_COMMAND needs a flask backend")
+		}
+		s.process = newAIProcess(command, getAIServiceDir())
+		go s.process.run()
+	}
+
+	routing := s.router.describe()
+	if routing.Candidate != "" {
+		log.Printf("AI routing: %d%% of predictions to %s, the rest to %s, split by %s",
+			routing.CandidatePercent, routing.Candidate, routing.Primary, routing.SplitBy)
+	}
+
+	aiService = s
+	go func() {
+		s.probe()
+		for range time.Tick(interval) {
+			s.probe()
+		}
+	}()
+	return nil
+}
+
+// Working directory of the managed AI service
+func getAIServiceDir() string {
+	dir := os.Getenv("AI_SERVICE_DIR")
+	if dir == "" {
+		return filepath.Join("..", "ai-service")
+	}
+	return dir
+}
+
+// Whether predictions can be sent to any backend. Before the first probe a
+// backend is assumed to be up.
+func aiAvailable() bool {
+	for _, b := range aiService.backends {
+		if b.available() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *aiBackend) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.availableLocked()
+}
+
+func (b *aiBackend) availableLocked() bool {
+	switch b.status.Status {
+	case aiUnknown, aiHealthy:
+		return true
+	case aiModelNotLoaded:
+		return false
+	}
+	return b.status.ConsecutiveFailures < aiFailureThreshold
+}
+
+// Model the backend serves, nil when unknown
+func (b *aiBackend) model() *AIModelRef {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.status.Model == nil {
+		return nil
+	}
+	ref := b.status.Model.AIModelRef
+	return &ref
+}
+
+// Model served by the primary backend, nil when unknown
+func currentAIModel() *AIModelRef {
+	if aiService.router == nil {
+		return nil
+	}
+	return aiService.router.primary.model()
+}
+
+// Check every backend once and restart the managed process if it hangs
+func (s *aiSupervisor) probe() {
+	for _, b := range s.backends {
+		failures := b.probe()
+		if b == s.managed && s.process != nil && failures >= aiHungThreshold {
+			s.process.restartIfHung()
+		}
+	}
+}
+
+// Check the backend once, update its status and return the consecutive
+// failures
+func (b *aiBackend) probe() int {
+	ctx, cancel := context.WithTimeout(context.Background(), aiProbeTimeout)
+	health, err := b.classifier.Health(ctx)
+	cancel()
+	now := time.Now()
+
+	model := health.Model
+	if err == nil && model != nil {
+		isNew, err := database.RecordAIModel(DB, database.AIModel{Name: model.Name, Version: model.Version, Classes: model.Classes})
+		if err != nil {
+			log.Println("Failed to record AI model:", err)
+		} else if isNew {
+			log.Printf("AI backend %s is serving a new model: %s version %s (%d classes)",
+				b.name, model.Name, model.Version, len(model.Classes))
+		}
+	}
+
+	b.mu.Lock()
+	wasAvailable := b.availableLocked()
+	b.status.LastCheck = now
+	switch {
+	case err != nil:
+		b.status.Status = aiUnreachable
+		b.status.ModelLoaded = false
+		b.status.ConsecutiveFailures++
+		b.status.LastError = err.Error()
+	case !health.ModelLoaded:
+		b.status.Status = aiModelNotLoaded
+		b.status.ModelLoaded = false
+		b.status.ConsecutiveFailures++
+		b.status.LastError = "AI backend is running but the model is not loaded"
+	default:
+		b.status.Status = aiHealthy
+		b.status.ModelLoaded = true
+		b.status.ConsecutiveFailures = 0
+		b.status.LastError = ""
+		b.status.LastHealthy = &now
+	}
+	if model != nil {
+		b.status.Model = model
+	}
+	available := b.availableLocked()
+	failures := b.status.ConsecutiveFailures
+	lastError := b.status.LastError
+	b.mu.Unlock()
+
+	if wasAvailable && !available {
+		log.Printf("AI backend %s is down: %v", b.name, lastError)
+	} else if !wasAvailable && available {
+		log.Printf("AI backend %s is back up", b.name)
+		predictionJobs.signal()
+	}
+	return failures
+}
+
+func (b *aiBackend) snapshot() AIBackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := b.status
+	status.Available = b.availableLocked()
+	return status
+}
+
+// Snapshot of the status with the process and queue state
+func (s *aiSupervisor) snapshot() AIStatus {
+	if s.router == nil {
+		return AIStatus{AIBackendStatus: AIBackendStatus{Status: aiUnknown}}
+	}
+
+	primary := s.router.primary.snapshot()
+	if time.Since(primary.LastCheck) > aiStatusStale {
+		s.probe()
+	}
+
+	status := AIStatus{AIBackendStatus: s.router.primary.snapshot()}
+	status.Available = aiAvailable()
+	if len(s.backends) > 1 {
+		for _, b := range s.backends {
+			status.Backends = append(status.Backends, b.snapshot())
+		}
+		routing := s.router.describe()
+		status.Routing = &routing
+	}
+
+	if s.process != nil {
+		process := s.process.status()
+		status.Process = &process
+	}
+	if queue, err := database.CountPendingJobs(DB); err == nil {
+		status.Queue = queue
+	}
+	return status
+}
+
+// Classify the image of a job on the backend it is routed to. When that
+// backend is down or unreachable the job falls back to the other one, so the
+// A/B split never costs a farmer a result.
+func classifyJob(ctx context.Context, job database.PredictionJob, imageData []byte) (PredictionResponse, *aiBackend, error) {
+	var resp PredictionResponse
+	err := errors.New("no AI backend is available")
+	for _, b := range aiService.router.route(job) {
+		if !b.available() {
+			continue
+		}
+		resp, err = b.classifier.Classify(ctx, imageData, job.Filename)
+		var aiErr *aiServiceError
+		if err == nil || errors.As(err, &aiErr) || ctx.Err() != nil {
+			return resp, b, err
+		}
+		log.Printf("AI backend %s failed for job %s: %v", b.name, job.ID, err)
+	}
+	return resp, nil, err
+}
+
+// ====== MANAGED PROCESS ====== //
+
+type aiProcess struct {
+	command []string
+	dir     string
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	state     string
+	startedAt time.Time
+	restarts  int
+	lastExit  string
+	nextStart time.Time
+}
+
+func newAIProcess(command []string, dir string) *aiProcess {
+	return &aiProcess{command: command, dir: dir, state: "starting"}
+}
+
+// Run the AI service and restart it whenever it exits. It never returns.
+func (p *aiProcess) run() {
+	backoff := aiMinBackoff
+	for {
+		started := time.Now()
+		err := p.runOnce()
+		uptime := time.Since(started)
+
+		if uptime > aiStableUptime {
+			backoff = aiMinBackoff
+		}
+
+		exit := "exited"
+		if err != nil {
+			exit = err.Error()
+		}
+		log.Printf("AI service process %s after %s, restarting in %s", exit, uptime.Round(time.Second), backoff)
+
+		p.mu.Lock()
+		p.cmd = nil
+		p.state = "backoff"
+		p.lastExit = exit
+		p.nextStart = time.Now().Add(backoff)
+		p.restarts++
+		p.mu.Unlock()
+
+		time.Sleep(backoff)
+		backoff = min(backoff*2, aiMaxBackoff)
+	}
+}
+
+func (p *aiProcess) runOnce() error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Dir = p.dir
+	configureChildProcess(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.state = "running"
+	p.startedAt = time.Now()
+	p.nextStart = time.Time{}
+	p.mu.Unlock()
+	log.Printf("Started AI service process %d: %s", cmd.Process.Pid, strings.Join(p.command, " "))
+
+	// Pipes must be drained before Wait
+	var wg sync.WaitGroup
+	for _, pipe := range []io.Reader{stdout, stderr} {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				log.Println("[ai-service]", scanner.Text())
+			}
+		}(pipe)
+	}
+	wg.Wait()
+
+	return cmd.Wait()
+}
+
+// Kill the process when it has had time to start and still does not answer
+func (p *aiProcess) restartIfHung() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil || time.Since(p.startedAt) < aiStartupGrace {
+		return
+	}
+	log.Printf("AI service process %d is not answering, killing it", p.cmd.Process.Pid)
+	p.cmd.Process.Kill()
+}
+
+var errAINotManaged = errors.New("AI service is not managed by the middleware")
+
+// Kill the process so the supervisor restarts it
+func (p *aiProcess) restart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return errors.New("AI service process is not running")
+	}
+	return p.cmd.Process.Kill()
+}
+
+func (p *aiProcess) status() AIProcessStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := AIProcessStatus{State: p.state, Restarts: p.restarts, LastExit: p.lastExit}
+	if p.cmd != nil {
+		status.PID = p.cmd.Process.Pid
+		startedAt := p.startedAt
+		status.StartedAt = &startedAt
+	}
+	if !p.nextStart.IsZero() {
+		nextStart := p.nextStart
+		status.NextStart = &nextStart
+	}
+	return status
+}
+
+// ====== STATUS HANDLERS ====== //
+
+// Aggregated status of the AI service
+func AIHealthCheckHandler(c *fiber.Ctx) error {
+	// Validate user authentication
+	if err := ValidateCookie(c); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required"})
+	}
+
+	status := aiService.snapshot()
+	if !status.Available {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(status)
+	}
+	return c.JSON(status)
+}
+
+// Model versions the AI service has served
+func ListAIModelsHandler(c *fiber.Ctx) error {
+	models, err := database.ListAIModels(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch AI models"})
+	}
+	return c.JSON(fiber.Map{"models": models, "current": currentAIModel()})
+}
+
+// Compare the backends and model versions over the last ?days=30, against
+// the vet reviews
+func CompareAIModelsHandler(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 30)
+	if days < 1 || days > 365 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	comparisons, err := database.CompareModels(DB, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to compare AI models"})
+	}
+
+	result := fiber.Map{"days": days, "models": comparisons}
+	if aiService.router != nil {
+		result["routing"] = aiService.router.describe()
+	}
+	return c.JSON(result)
+}
+
+// Restart the managed AI service process
+func RestartAIServiceHandler(c *fiber.Ctx) error {
+	if aiService.process == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": errAINotManaged.Error(),
+			"hint":  "Set AI_SERVICE_COMMAND to let the middleware run the AI service",
+		})
+	}
+	if err := aiService.process.restart(); err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"message": "AI service is restarting"})
+}
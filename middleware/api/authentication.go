@@ -9,6 +9,8 @@ import (
 	"regexp"
 	"time"
 
+	"middleware/database"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
@@ -109,6 +111,49 @@ func ValidateCookie(c *fiber.Ctx) error {
 	return nil
 }
 
+// Get the ID of the logged in user
+func CurrentUserID(c *fiber.Ctx) (int, error) {
+	username := ValidateToken(c.Cookies("token"))
+	if username == "" {
+		return 0, errors.New("Token is not set or invalid")
+	}
+
+	var userID int
+	err := DB.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	return userID, err
+}
+
+// Get the role of the logged in user
+func CurrentUserRole(c *fiber.Ctx) (string, error) {
+	username := ValidateToken(c.Cookies("token"))
+	if username == "" {
+		return "", errors.New("Token is not set or invalid")
+	}
+	return database.GetUserRole(DB, username)
+}
+
+// Restrict a route to users with one of the given roles
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := ValidateToken(c.Cookies("token"))
+		if username == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized access"})
+		}
+
+		role, err := database.GetUserRole(DB, username)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Insufficient permissions"})
+	}
+}
+
 // ====== REGISTER USER ====== //
 func RegisterHandler(c *fiber.Ctx) error {
 	if ValidateCookie(c) == nil {
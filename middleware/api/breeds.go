@@ -0,0 +1,100 @@
+package api
+
+import (
+	"database/sql"
+	"strings"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ====== BREED STANDARD HANDLERS ====== //
+
+func ListBreedStandardsHandler(c *fiber.Ctx) error {
+	breeds, err := database.ListBreedStandards(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch breed standards"})
+	}
+	return c.JSON(breeds)
+}
+
+func GetBreedStandardHandler(c *fiber.Ctx) error {
+	breed, err := database.GetBreedStandard(DB, c.Params("key"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Breed standard not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch breed standard"})
+	}
+	return c.JSON(breed)
+}
+
+// Create or replace a breed standard
+func PutBreedStandardHandler(c *fiber.Ctx) error {
+	var req struct {
+		Name         string                 `json:"name"`
+		Type         string                 `json:"type"`
+		MarketWeight float64                `json:"market_weight_g"`
+		Notes        string                 `json:"notes"`
+		Curve        []analysis.GrowthPoint `json:"curve"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	key := c.Params("key")
+	if !datasetLabel.MatchString(key) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid breed key"})
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name is required"})
+	}
+	if !database.ValidBreedType(req.Type) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be broiler, layer or local"})
+	}
+	if req.MarketWeight < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "market_weight_g cannot be negative"})
+	}
+	if len(req.Curve) < 2 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The curve needs at least two points"})
+	}
+	for i, p := range req.Curve {
+		if p.AgeDays < 0 || p.Weight <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Curve ages cannot be negative and weights must be positive"})
+		}
+		if i > 0 && p.AgeDays <= req.Curve[i-1].AgeDays {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Curve points must be in increasing age"})
+		}
+	}
+
+	breed := database.BreedStandard{
+		Key:          key,
+		Name:         strings.TrimSpace(req.Name),
+		Type:         req.Type,
+		MarketWeight: req.MarketWeight,
+		Notes:        req.Notes,
+		Curve:        req.Curve,
+	}
+	if err := database.UpsertBreedStandard(DB, breed); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save breed standard"})
+	}
+
+	saved, err := database.GetBreedStandard(DB, key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch breed standard"})
+	}
+	return c.JSON(fiber.Map{"message": "Breed standard saved successfully", "breed": saved})
+}
+
+func DeleteBreedStandardHandler(c *fiber.Ctx) error {
+	err := database.DeleteBreedStandard(DB, c.Params("key"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Breed standard not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete breed standard"})
+	}
+	return c.JSON(fiber.Map{"message": "Breed standard deleted successfully"})
+}
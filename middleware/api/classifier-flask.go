@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// flaskClassifier talks to the TensorFlow AI service in ai-service/: the
+// image is posted as a multipart form to /predict
+type flaskClassifier struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Bytes of an unexpected response body kept in the log
+const maxLoggedBody = 200
+
+// Shorten a response body for logging
+func truncateBody(body []byte, n int) string {
+	if len(body) <= n {
+		return string(body)
+	}
+	return string(body[:n]) + "..."
+}
+
+func newFlaskClassifier(baseURL string) *flaskClassifier {
+	return &flaskClassifier{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: aiRequestTimeout},
+	}
+}
+
+func (f *flaskClassifier) Classify(ctx context.Context, imageData []byte, filename string) (PredictionResponse, error) {
+	var predictionResp PredictionResponse
+
+	// Create a buffer to store the file content
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	// Create form file field
+	fw, err := writer.CreateFormFile("image", filename)
+	if err != nil {
+		return predictionResp, err
+	}
+
+	// Copy file content
+	if _, err := fw.Write(imageData); err != nil {
+		return predictionResp, err
+	}
+
+	// Close the writer to finalize the form
+	writer.Close()
+
+	// Create HTTP request to AI service
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.baseURL+"/predict", &buf)
+	if err != nil {
+		return predictionResp, err
+	}
+
+	// Set content type
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return predictionResp, err
+	}
+	defer resp.Body.Close()
+
+	// Read response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return predictionResp, err
+	}
+
+	// Parse response
+	if err := json.Unmarshal(body, &predictionResp); err != nil {
+		log.Printf("Failed to parse AI response. Status: %d, Body: %q", resp.StatusCode, truncateBody(body, maxLoggedBody))
+		return predictionResp, &aiServiceError{status: http.StatusBadGateway, message: "Failed to parse AI service response"}
+	}
+
+	if resp.StatusCode != http.StatusOK || !predictionResp.Success {
+		errorMsg := predictionResp.Error
+		if errorMsg == "" {
+			errorMsg = "Unknown error from AI service"
+		}
+		status := resp.StatusCode
+		if status == http.StatusOK {
+			status = http.StatusBadGateway
+		}
+		return predictionResp, &aiServiceError{status: status, message: errorMsg}
+	}
+
+	return predictionResp, nil
+}
+
+func (f *flaskClassifier) Health(ctx context.Context) (ClassifierHealth, error) {
+	var health struct {
+		Status      string       `json:"status"`
+		ModelLoaded bool         `json:"model_loaded"`
+		Model       *AIModelInfo `json:"model"`
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.baseURL+"/health", nil)
+	if err != nil {
+		return ClassifierHealth{}, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return ClassifierHealth{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ClassifierHealth{}, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return ClassifierHealth{}, err
+	}
+
+	result := ClassifierHealth{ModelLoaded: health.ModelLoaded}
+	if health.Model != nil && health.Model.Version != "" {
+		result.Model = health.Model
+	}
+	return result, nil
+}
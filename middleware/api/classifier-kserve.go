@@ -0,0 +1,213 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// kserveClassifier talks to an inference server implementing the Open
+// Inference (KServe v2) protocol, such as KServe, Triton or Seldon MLServer.
+// These servers only run the model, so the image is preprocessed here the way
+// the Flask service does it: resized to the model input and scaled to [0, 1].
+type kserveClassifier struct {
+	modelURL  string // Base URL of the model, or of its version
+	model     string
+	version   string
+	input     string
+	inputSize int
+	classes   []string
+	client    *http.Client
+}
+
+func newKServeClassifier(config aiBackendConfig) (*kserveClassifier, error) {
+	if config.URL == "" || config.Model == "" {
+		return nil, errors.New("url and model are required")
+	}
+	if len(config.Classes) == 0 {
+		return nil, errors.New("classes are required to read the model output")
+	}
+
+	k := &kserveClassifier{
+		modelURL:  strings.TrimSuffix(config.URL, "/") + "/v2/models/" + url.PathEscape(config.Model),
+		model:     config.Model,
+		version:   config.Version,
+		input:     config.Input,
+		inputSize: config.InputSize,
+		classes:   config.Classes,
+		client:    &http.Client{Timeout: aiRequestTimeout},
+	}
+	if k.version != "" {
+		k.modelURL += "/versions/" + url.PathEscape(k.version)
+	}
+	if k.input == "" {
+		k.input = "input_1" // Default Keras input name
+	}
+	if k.inputSize <= 0 {
+		k.inputSize = 224
+	}
+	return k, nil
+}
+
+type kserveTensor struct {
+	Name     string    `json:"name"`
+	Shape    []int     `json:"shape"`
+	Datatype string    `json:"datatype"`
+	Data     []float64 `json:"data"`
+}
+
+type kserveInferRequest struct {
+	ID     string         `json:"id,omitempty"`
+	Inputs []kserveTensor `json:"inputs"`
+}
+
+type kserveInferResponse struct {
+	ModelName    string         `json:"model_name"`
+	ModelVersion string         `json:"model_version"`
+	Outputs      []kserveTensor `json:"outputs"`
+	Error        string         `json:"error"`
+}
+
+func (k *kserveClassifier) Classify(ctx context.Context, imageData []byte, filename string) (PredictionResponse, error) {
+	var predictionResp PredictionResponse
+
+	tensor, err := k.inputTensor(imageData)
+	if err != nil {
+		return predictionResp, &aiServiceError{status: http.StatusBadRequest, message: "Failed to preprocess image"}
+	}
+
+	body, err := json.Marshal(kserveInferRequest{Inputs: []kserveTensor{tensor}})
+	if err != nil {
+		return predictionResp, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.modelURL+"/infer", bytes.NewReader(body))
+	if err != nil {
+		return predictionResp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return predictionResp, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return predictionResp, err
+	}
+
+	var infer kserveInferResponse
+	if err := json.Unmarshal(respBody, &infer); err != nil {
+		return predictionResp, &aiServiceError{status: http.StatusBadGateway, message: "Failed to parse inference server response"}
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := infer.Error
+		if message == "" {
+			message = "Unknown error from inference server"
+		}
+		return predictionResp, &aiServiceError{status: resp.StatusCode, message: message}
+	}
+	if len(infer.Outputs) == 0 || len(infer.Outputs[0].Data) != len(k.classes) {
+		return predictionResp, &aiServiceError{
+			status:  http.StatusBadGateway,
+			message: fmt.Sprintf("Inference server output does not match the %d configured classes", len(k.classes)),
+		}
+	}
+
+	predictionResp.Success = true
+	predictionResp.Prediction = predictionFromScores(k.classes, infer.Outputs[0].Data)
+	predictionResp.Model = &AIModelRef{Name: infer.ModelName, Version: infer.ModelVersion}
+	if predictionResp.Model.Name == "" {
+		predictionResp.Model.Name = k.model
+	}
+	if predictionResp.Model.Version == "" {
+		predictionResp.Model.Version = k.version
+	}
+	predictionResp.Timestamp = time.Now().Format(time.RFC3339)
+	return predictionResp, nil
+}
+
+// Resize the image to the model input and lay it out as a [1, H, W, 3] FP32
+// tensor
+func (k *kserveClassifier) inputTensor(imageData []byte) (kserveTensor, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageData))
+	if err != nil {
+		return kserveTensor{}, err
+	}
+
+	size := k.inputSize
+	resized := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	data := make([]float64, 0, size*size*3)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			p := resized.RGBAAt(x, y)
+			data = append(data, float64(p.R)/255, float64(p.G)/255, float64(p.B)/255)
+		}
+	}
+	return kserveTensor{Name: k.input, Shape: []int{1, size, size, 3}, Datatype: "FP32", Data: data}, nil
+}
+
+func (k *kserveClassifier) Health(ctx context.Context) (ClassifierHealth, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.modelURL+"/ready", nil)
+	if err != nil {
+		return ClassifierHealth{}, err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return ClassifierHealth{}, err
+	}
+	resp.Body.Close()
+
+	// The server answers but the model is not ready
+	if resp.StatusCode != http.StatusOK {
+		return ClassifierHealth{}, nil
+	}
+
+	health := ClassifierHealth{ModelLoaded: true}
+	if version := k.servedVersion(ctx); version != "" {
+		health.Model = &AIModelInfo{AIModelRef{Name: k.model, Version: version}, k.classes}
+	}
+	return health, nil
+}
+
+// Version reported by the model metadata, the configured one if set
+func (k *kserveClassifier) servedVersion(ctx context.Context) string {
+	if k.version != "" {
+		return k.version
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.modelURL, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var metadata struct {
+		Versions []string `json:"versions"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&metadata) != nil {
+		return ""
+	}
+	if len(metadata.Versions) == 0 {
+		return ""
+	}
+	return metadata.Versions[len(metadata.Versions)-1]
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"math"
+	"time"
+)
+
+// mockClassifier derives a prediction from the hash of the image, so the same
+// photo always gets the same result. It lets the middleware and the frontend
+// run without TensorFlow.
+type mockClassifier struct {
+	classes []string
+}
+
+const mockModelVersion = "mock-1"
+
+func newMockClassifier(classes []string) *mockClassifier {
+	if len(classes) == 0 {
+		classes = []string{"coccidiosis", "e_coli", "healthy", "newcastle", "salmonella"}
+	}
+	return &mockClassifier{classes: classes}
+}
+
+func (m *mockClassifier) Classify(ctx context.Context, imageData []byte, filename string) (PredictionResponse, error) {
+	sum := sha256.Sum256(imageData)
+
+	// Softmax over hash bytes, sharpened so most results are confident
+	scores := make([]float64, len(m.classes))
+	var total float64
+	for i := range scores {
+		scores[i] = math.Exp(float64(sum[i%len(sum)]) / 32)
+		total += scores[i]
+	}
+	for i := range scores {
+		scores[i] /= total
+	}
+
+	return PredictionResponse{
+		Success:    true,
+		Prediction: predictionFromScores(m.classes, scores),
+		Model:      &AIModelRef{Name: "mock", Version: mockModelVersion},
+		Timestamp:  time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+func (m *mockClassifier) Health(ctx context.Context) (ClassifierHealth, error) {
+	return ClassifierHealth{
+		ModelLoaded: true,
+		Model:       &AIModelInfo{AIModelRef{Name: "mock", Version: mockModelVersion}, m.classes},
+	}, nil
+}
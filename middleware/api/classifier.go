@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+
+	"middleware/database"
+)
+
+// Predictions are made by a DiseaseClassifier. By default there is a single
+// backend, the Flask AI service at AI_SERVICE_URL. AI_CONFIG points to a JSON
+// file declaring several backends and how jobs are routed between them, for
+// example to send a share of the predictions to a new model version:
+//
+//	{
+//	  "backends": [
+//	    {"name": "flask", "type": "flask", "url": "http://127.0.0.1:5000"},
+//	    {"name": "v2", "type": "kserve", "url": "http://10.0.0.5:8080", "model": "chicken-disease",
+//	     "classes": ["coccidiosis", "healthy", "newcastle", "salmonella"]}
+//	  ],
+//	  "routing": {"primary": "flask", "candidate": "v2", "candidate_percent": 10, "split_by": "user"}
+//	}
+//
+// Every prediction records the backend and model version that made it, so
+// the variants can be compared against the vet reviews.
+
+// DiseaseClassifier predicts the disease from a droppings photo
+type DiseaseClassifier interface {
+	// Classify a normalized JPEG image
+	Classify(ctx context.Context, image []byte, filename string) (PredictionResponse, error)
+	// Whether the model is loaded, and which model is served
+	Health(ctx context.Context) (ClassifierHealth, error)
+}
+
+// ClassifierHealth is the result of a backend health check
+type ClassifierHealth struct {
+	ModelLoaded bool
+	Model       *AIModelInfo
+}
+
+// Backend types
+const (
+	backendFlask  = "flask"
+	backendKServe = "kserve"
+	backendMock   = "mock"
+)
+
+type aiBackendConfig struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	URL       string   `json:"url"`
+	Model     string   `json:"model"`      // KServe model name
+	Version   string   `json:"version"`    // KServe model version, optional
+	Input     string   `json:"input"`      // KServe input tensor name
+	InputSize int      `json:"input_size"` // Side of the square model input
+	Classes   []string `json:"classes"`    // Class names in output order
+}
+
+type aiRoutingConfig struct {
+	Primary          string `json:"primary"`
+	Candidate        string `json:"candidate"`
+	CandidatePercent int    `json:"candidate_percent"`
+	SplitBy          string `json:"split_by"` // job or user
+}
+
+type aiConfig struct {
+	Backends []aiBackendConfig `json:"backends"`
+	Routing  aiRoutingConfig   `json:"routing"`
+}
+
+// Read AI_CONFIG, or build the single backend configuration. AI_BACKEND=mock
+// replaces the Flask service with the mock classifier for development.
+func loadAIConfig() (aiConfig, error) {
+	path := os.Getenv("AI_CONFIG")
+	if path == "" {
+		backend := aiBackendConfig{Name: backendFlask, Type: backendFlask, URL: AIServiceURL}
+		if os.Getenv("AI_BACKEND") == backendMock {
+			backend = aiBackendConfig{Name: backendMock, Type: backendMock}
+		}
+		return aiConfig{Backends: []aiBackendConfig{backend}}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return aiConfig{}, err
+	}
+	var config aiConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return aiConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(config.Backends) == 0 {
+		return aiConfig{}, fmt.Errorf("%s: no backends configured", path)
+	}
+	return config, nil
+}
+
+func newClassifier(config aiBackendConfig) (DiseaseClassifier, error) {
+	switch config.Type {
+	case backendFlask:
+		if config.URL == "" {
+			return nil, errors.New("url is required")
+		}
+		return newFlaskClassifier(config.URL), nil
+	case backendKServe:
+		return newKServeClassifier(config)
+	case backendMock:
+		return newMockClassifier(config.Classes), nil
+	}
+	return nil, fmt.Errorf("unknown backend type %q", config.Type)
+}
+
+// ====== ROUTING ====== //
+
+// aiRouter splits jobs between a primary and an optional candidate backend
+type aiRouter struct {
+	primary   *aiBackend
+	candidate *aiBackend
+	percent   int
+	byUser    bool
+}
+
+// AIRouting describes the routing for the status endpoint
+type AIRouting struct {
+	Primary          string `json:"primary"`
+	Candidate        string `json:"candidate,omitempty"`
+	CandidatePercent int    `json:"candidate_percent"`
+	SplitBy          string `json:"split_by"`
+}
+
+func newAIRouter(config aiRoutingConfig, backends []*aiBackend) (*aiRouter, error) {
+	find := func(name string) *aiBackend {
+		for _, b := range backends {
+			if b.name == name {
+				return b
+			}
+		}
+		return nil
+	}
+
+	router := &aiRouter{primary: backends[0], percent: config.CandidatePercent, byUser: config.SplitBy == "user"}
+	if config.Primary != "" {
+		if router.primary = find(config.Primary); router.primary == nil {
+			return nil, fmt.Errorf("unknown primary backend %q", config.Primary)
+		}
+	}
+	if config.Candidate != "" {
+		if router.candidate = find(config.Candidate); router.candidate == nil {
+			return nil, fmt.Errorf("unknown candidate backend %q", config.Candidate)
+		}
+		if router.candidate == router.primary {
+			return nil, errors.New("candidate backend must differ from the primary")
+		}
+	}
+	if router.percent < 0 || router.percent > 100 {
+		return nil, errors.New("candidate_percent must be between 0 and 100")
+	}
+	if config.SplitBy != "" && config.SplitBy != "job" && config.SplitBy != "user" {
+		return nil, errors.New("split_by must be job or user")
+	}
+	return router, nil
+}
+
+// Backends to try for a job, the one it is routed to first. The split is a
+// hash of the job or user ID, so a job keeps its variant when it is retried
+// and, with split_by user, a farmer always sees the same model.
+func (r *aiRouter) route(job database.PredictionJob) []*aiBackend {
+	if r.candidate == nil || r.percent == 0 {
+		return []*aiBackend{r.primary}
+	}
+
+	key := job.ID
+	if r.byUser {
+		key = fmt.Sprint(job.UserID)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	if int(h.Sum32()%100) < r.percent {
+		return []*aiBackend{r.candidate, r.primary}
+	}
+	return []*aiBackend{r.primary, r.candidate}
+}
+
+func (r *aiRouter) describe() AIRouting {
+	routing := AIRouting{Primary: r.primary.name, CandidatePercent: r.percent, SplitBy: "job"}
+	if r.candidate != nil {
+		routing.Candidate = r.candidate.name
+	} else {
+		routing.CandidatePercent = 0
+	}
+	if r.byUser {
+		routing.SplitBy = "user"
+	}
+	return routing
+}
+
+// ====== SCORES ====== //
+
+// Labels the AI service treats as a healthy result
+var healthyLabels = map[string]bool{"healthy": true, "normal": true, "no_disease": true}
+
+// Build a prediction from the class scores of a backend that only returns
+// raw model output. The recommendation comes from the knowledge base, like the
+// Flask service's own table. Low confidence is reported by the assessment
+// rather than in the text.
+func predictionFromScores(classes []string, scores []float64) DiseasePrediction {
+	prediction := DiseasePrediction{AllProbabilities: make(map[string]float64, len(classes))}
+	best := -1
+	for i, label := range classes {
+		prediction.AllProbabilities[label] = scores[i]
+		if best < 0 || scores[i] > scores[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return prediction
+	}
+
+	prediction.PredictedDisease = classes[best]
+	prediction.Confidence = scores[best]
+	prediction.IsHealthy = healthyLabels[strings.ToLower(classes[best])]
+
+	prediction.Recommendation = "Unknown condition detected. Consult a veterinarian for proper diagnosis."
+	if disease, err := database.GetDisease(DB, classes[best]); err == nil {
+		if t, ok := disease.Translations[fallbackLocale]; ok && t.Recommendation != "" {
+			prediction.Recommendation = t.Recommendation
+		}
+	}
+	return prediction
+}
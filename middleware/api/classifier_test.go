@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"middleware/database"
+)
+
+// Build the backends and router from an AI_CONFIG file
+func testAIRouter(t *testing.T, config string) (*aiRouter, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ai.json")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AI_CONFIG", path)
+
+	loaded, err := loadAIConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backends []*aiBackend
+	for _, bc := range loaded.Backends {
+		classifier, err := newClassifier(bc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, &aiBackend{name: bc.Name, classifier: classifier})
+	}
+	return newAIRouter(loaded.Routing, backends)
+}
+
+func routingConfig(routing string) string {
+	return `{
+		"backends": [
+			{"name": "stable", "type": "mock"},
+			{"name": "v2", "type": "kserve", "url": "http://127.0.0.1:1", "model": "chicken-disease", "classes": ["healthy", "sick"]}
+		],
+		"routing": ` + routing + `
+	}`
+}
+
+func TestAIRouting(t *testing.T) {
+	names := func(backends []*aiBackend) []string {
+		var names []string
+		for _, b := range backends {
+			names = append(names, b.name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		routing     string
+		wantPercent float64
+	}{
+		{`{"primary": "stable", "candidate": "v2", "candidate_percent": 10}`, 10},
+		{`{"primary": "stable", "candidate": "v2", "candidate_percent": 50, "split_by": "job"}`, 50},
+		{`{"primary": "stable", "candidate": "v2", "candidate_percent": 0}`, 0},
+		{`{"primary": "stable", "candidate": "v2", "candidate_percent": 100}`, 100},
+		{`{}`, 0},
+	}
+	for _, tt := range tests {
+		router, err := testAIRouter(t, routingConfig(tt.routing))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.routing, err)
+		}
+
+		const jobs = 2000
+		candidate := 0
+		for i := 0; i < jobs; i++ {
+			job := database.PredictionJob{ID: fmt.Sprintf("job-%d", i), UserID: i % 7}
+			route := names(router.route(job))
+			if !reflect.DeepEqual(route, names(router.route(job))) {
+				t.Fatalf("%s: job %s routed differently on retry", tt.routing, job.ID)
+			}
+			if route[0] == "v2" {
+				candidate++
+				if !reflect.DeepEqual(route, []string{"v2", "stable"}) {
+					t.Errorf("%s: route %q does not fall back to the primary", tt.routing, route)
+				}
+			}
+		}
+		if got := float64(candidate) * 100 / jobs; math.Abs(got-tt.wantPercent) > 3 {
+			t.Errorf("%s: %.1f%% of jobs to the candidate, want %v%%", tt.routing, got, tt.wantPercent)
+		}
+	}
+
+	// Split by user, a farmer keeps the same model whatever the job
+	router, err := testAIRouter(t, routingConfig(`{"primary": "stable", "candidate": "v2", "candidate_percent": 30, "split_by": "user"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := 0
+	for user := 1; user <= 1000; user++ {
+		first := names(router.route(database.PredictionJob{ID: "a", UserID: user}))
+		for _, id := range []string{"b", "c", "d"} {
+			if got := names(router.route(database.PredictionJob{ID: id, UserID: user})); !reflect.DeepEqual(got, first) {
+				t.Fatalf("user %d: job %s routed to %q, job a to %q", user, id, got, first)
+			}
+		}
+		if first[0] == "v2" {
+			users++
+		}
+	}
+	if users < 250 || users > 350 {
+		t.Errorf("%d of 1000 users on the candidate, want about 300", users)
+	}
+	if got := router.describe(); got != (AIRouting{Primary: "stable", Candidate: "v2", CandidatePercent: 30, SplitBy: "user"}) {
+		t.Errorf("describe() = %+v", got)
+	}
+
+	for _, routing := range []string{
+		`{"primary": "missing"}`,
+		`{"candidate": "missing"}`,
+		`{"primary": "stable", "candidate": "stable"}`,
+		`{"candidate": "v2", "candidate_percent": 150}`,
+		`{"candidate": "v2", "split_by": "house"}`,
+	} {
+		if _, err := testAIRouter(t, routingConfig(routing)); err == nil {
+			t.Errorf("routing %s was accepted", routing)
+		}
+	}
+}
+
+func TestMockClassifier(t *testing.T) {
+	mock := newMockClassifier(nil)
+	classify := func(image string) PredictionResponse {
+		resp, err := mock.Classify(context.Background(), []byte(image), "bird.jpg")
+		if err != nil || !resp.Success {
+			t.Fatalf("Classify() = %+v, %v", resp, err)
+		}
+		return resp
+	}
+
+	first := classify("droppings")
+	if again := classify("droppings"); !reflect.DeepEqual(again.Prediction, first.Prediction) {
+		t.Errorf("the same image gave %+v, then %+v", first.Prediction, again.Prediction)
+	}
+	if first.Model == nil || first.Model.Version != mockModelVersion {
+		t.Errorf("model = %+v, want %s", first.Model, mockModelVersion)
+	}
+
+	predicted := map[string]bool{}
+	for i := 0; i < 50; i++ {
+		p := classify(fmt.Sprint("image ", i)).Prediction
+		total := 0.0
+		for _, probability := range p.AllProbabilities {
+			total += probability
+		}
+		if len(p.AllProbabilities) != 5 || math.Abs(total-1) > 1e-9 {
+			t.Errorf("probabilities %v do not cover the 5 classes", p.AllProbabilities)
+		}
+		if p.Confidence != p.AllProbabilities[p.PredictedDisease] || p.IsHealthy != (p.PredictedDisease == "healthy") {
+			t.Errorf("prediction %+v does not match its scores", p)
+		}
+		predicted[p.PredictedDisease] = true
+	}
+	if len(predicted) < 3 {
+		t.Errorf("50 images only predicted %v", predicted)
+	}
+}
+
+func TestKServeClassifier(t *testing.T) {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 30)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply func(w http.ResponseWriter)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/models/chicken-disease/ready":
+		case "/v2/models/chicken-disease":
+			w.Write([]byte(`{"name": "chicken-disease", "versions": ["1", "2"]}`))
+		case "/v2/models/chicken-disease/infer":
+			var req kserveInferRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Inputs) != 1 {
+				t.Errorf("infer request: %+v, %v", req, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			input := req.Inputs[0]
+			if input.Name != "input_1" || input.Datatype != "FP32" || !reflect.DeepEqual(input.Shape, []int{1, 8, 8, 3}) || len(input.Data) != 8*8*3 {
+				t.Errorf("input tensor %s %s %v with %d values", input.Name, input.Datatype, input.Shape, len(input.Data))
+			}
+			for _, v := range input.Data {
+				if v < 0 || v > 1 {
+					t.Errorf("input value %v outside [0, 1]", v)
+					break
+				}
+			}
+			reply(w)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	k, err := newKServeClassifier(aiBackendConfig{
+		URL:       server.URL,
+		Model:     "chicken-disease",
+		InputSize: 8,
+		Classes:   []string{"coccidiosis", "healthy", "newcastle", "salmonella"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply = func(w http.ResponseWriter) {
+		w.Write([]byte(`{"model_name": "chicken-disease", "model_version": "2",
+			"outputs": [{"name": "dense", "shape": [1, 4], "datatype": "FP32", "data": [0.1, 0.05, 0.8, 0.05]}]}`))
+	}
+	resp, err := k.Classify(context.Background(), img.Bytes(), "bird.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := resp.Prediction
+	if !resp.Success || p.PredictedDisease != "newcastle" || p.Confidence != 0.8 || p.IsHealthy || p.AllProbabilities["coccidiosis"] != 0.1 {
+		t.Errorf("Classify() = %+v", resp)
+	}
+	if resp.Model == nil || *resp.Model != (AIModelRef{Name: "chicken-disease", Version: "2"}) {
+		t.Errorf("model = %+v", resp.Model)
+	}
+
+	errorTests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+	}{
+		{"server error", http.StatusInternalServerError, `{"error": "model not loaded"}`, http.StatusInternalServerError},
+		{"fewer outputs than classes", http.StatusOK, `{"outputs": [{"data": [0.5, 0.5]}]}`, http.StatusBadGateway},
+		{"no outputs", http.StatusOK, `{"outputs": []}`, http.StatusBadGateway},
+		{"not JSON", http.StatusOK, `<html>`, http.StatusBadGateway},
+	}
+	for _, tt := range errorTests {
+		reply = func(w http.ResponseWriter) {
+			w.WriteHeader(tt.status)
+			w.Write([]byte(tt.body))
+		}
+		_, err := k.Classify(context.Background(), img.Bytes(), "bird.jpg")
+		var aiErr *aiServiceError
+		if !errors.As(err, &aiErr) || aiErr.status != tt.wantStatus {
+			t.Errorf("%s: error = %v, want status %d", tt.name, err, tt.wantStatus)
+		}
+	}
+
+	// Without a configured version, the latest served one is reported
+	health, err := k.Health(context.Background())
+	if err != nil || !health.ModelLoaded || health.Model == nil || health.Model.Version != "2" {
+		t.Errorf("Health() = %+v, %v", health, err)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every prediction is assessed before it is stored. A prediction below the
+// confidence threshold of its class, too close to the runner-up or spread over
+// many classes is "uncertain": clients should ask for a new photo instead of
+// showing a diagnosis. Default limits come from the environment, per disease
+// thresholds from the knowledge base.
+
+var confidencePolicy = getConfidencePolicy()
+
+func getConfidencePolicy() analysis.ConfidencePolicy {
+	return analysis.ConfidencePolicy{
+		MinConfidence: envFraction("AI_MIN_CONFIDENCE", 0.7),
+		MinMargin:     envFraction("AI_MIN_MARGIN", 0.2),
+		MaxEntropy:    envFraction("AI_MAX_ENTROPY", 0.8),
+	}
+}
+
+// Value between 0 and 1 from the environment, 0 disables the check
+func envFraction(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && v >= 0 && v <= 1 {
+		return v
+	}
+	return fallback
+}
+
+// Assess a prediction with the current thresholds
+func assessPrediction(prediction DiseasePrediction) analysis.Assessment {
+	policy := confidencePolicy
+	thresholds, err := database.DiseaseConfidenceThresholds(DB)
+	if err != nil {
+		log.Println("Failed to fetch confidence thresholds:", err)
+	} else {
+		policy.ClassMinConfidence = thresholds
+	}
+	return policy.Assess(prediction.PredictedDisease, prediction.Confidence, prediction.AllProbabilities)
+}
+
+// RetakeGuidance tells a client to ask the farmer for a better photo
+type RetakeGuidance struct {
+	Reasons []string `json:"reasons"`
+	Tips    []string `json:"tips"`
+	Message string   `json:"message"`
+	Locale  string   `json:"locale"`
+}
+
+// Retake tips, for clients that show their own instructions
+const (
+	tipMoveCloser      = "move_closer"
+	tipImproveLighting = "improve_lighting"
+	tipHoldSteady      = "hold_steady"
+	tipSingleSample    = "single_sample" // One fresh dropping filling the frame
+)
+
+var retakeTips = map[string][]string{
+	analysis.ReasonLowConfidence: {tipMoveCloser, tipImproveLighting, tipHoldSteady},
+	analysis.ReasonLowMargin:     {tipSingleSample, tipMoveCloser},
+	analysis.ReasonHighEntropy:   {tipImproveLighting, tipHoldSteady, tipSingleSample},
+}
+
+var retakeMessages = map[string]string{
+	"en": "We could not tell with confidence what this photo shows. Please take a new photo of a single fresh dropping, close up, in good light and holding the phone steady.",
+	"km": "យើងមិនអាចកំណត់បានច្បាស់ពីរូបថតនេះទេ។ សូមថតរូបលាមកស្រស់តែមួយម្ដងទៀត ឱ្យជិត ក្នុងពន្លឺល្អ និងកាន់ទូរស័ព្ទឱ្យនឹង។",
+}
+
+// Guidance for an uncertain prediction, nil when it can be trusted
+func retakeGuidance(c *fiber.Ctx, stored database.StoredPrediction) *RetakeGuidance {
+	a := stored.Assessment
+	if a == nil || a.Outcome != analysis.OutcomeUncertain {
+		return nil
+	}
+
+	guidance := &RetakeGuidance{Reasons: a.Reasons, Tips: []string{}}
+	seen := map[string]bool{}
+	for _, reason := range a.Reasons {
+		for _, tip := range retakeTips[reason] {
+			if !seen[tip] {
+				seen[tip] = true
+				guidance.Tips = append(guidance.Tips, tip)
+			}
+		}
+	}
+	for _, locale := range requestLocales(c) {
+		if message, ok := retakeMessages[locale]; ok {
+			guidance.Message, guidance.Locale = message, locale
+			break
+		}
+	}
+	return guidance
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ControllerClient talks to one ESP32 controller. Reads are retried with
+// jittered backoff and the last good response is kept so the dashboard still
+// has something to show while the controller reboots. A circuit breaker stops
+// requests piling up on a controller that is known to be down.
+type ControllerClient struct {
+	BaseURL string
+	Address string
+	HTTP    *http.Client
+
+	AttemptTimeout time.Duration // Timeout of a single request
+	MaxRetries     int           // Extra attempts for idempotent reads
+	RetryBackoff   time.Duration // Base delay between attempts
+
+	breaker *circuitBreaker
+
+	mu    sync.Mutex
+	cache map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	body []byte
+	at   time.Time
+}
+
+// ControllerReading is a response body from the controller. Stale readings
+// come from the cache because the controller could not be reached.
+type ControllerReading struct {
+	Body  []byte
+	Stale bool
+	Age   time.Duration
+	Err   error // Why the cached reading was served
+}
+
+var errCircuitOpen = errors.New("controller is unavailable, circuit breaker is open")
+
+// statusError is returned for non-200 responses from the controller
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("data provider returned status %d", e.code)
+}
+
+func NewControllerClient(baseURL string) *ControllerClient {
+	address := controllerAddress(baseURL)
+	return &ControllerClient{
+		BaseURL: baseURL,
+		Address: address,
+		HTTP: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: pinnedTLSConfig(address),
+			},
+		},
+		AttemptTimeout: 3 * time.Second,
+		MaxRetries:     2,
+		RetryBackoff:   250 * time.Millisecond,
+		breaker:        newCircuitBreaker(3, 15*time.Second),
+		cache:          make(map[string]cachedResponse),
+	}
+}
+
+// Read fetches an idempotent endpoint, retrying transient failures. When the
+// controller stays unreachable the last known response is returned as stale.
+func (cc *ControllerClient) Read(ctx context.Context, endpoint string) (ControllerReading, error) {
+	var err error
+	for attempt := 0; attempt <= cc.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if !sleepContext(ctx, cc.backoff(attempt)) {
+				err = ctx.Err()
+				break
+			}
+		}
+
+		var body []byte
+		body, err = cc.do(ctx, endpoint, false)
+		if err == nil {
+			cc.mu.Lock()
+			cc.cache[endpoint] = cachedResponse{body: body, at: time.Now()}
+			cc.mu.Unlock()
+			return ControllerReading{Body: body}, nil
+		}
+		if !retryable(err) {
+			return ControllerReading{}, err
+		}
+		if errors.Is(err, errCircuitOpen) {
+			break
+		}
+	}
+
+	cc.mu.Lock()
+	cached, ok := cc.cache[endpoint]
+	cc.mu.Unlock()
+	if !ok {
+		return ControllerReading{}, err
+	}
+	return ControllerReading{Body: cached.body, Stale: true, Age: time.Since(cached.at), Err: err}, nil
+}
+
+// Command sends a state-changing request. Toggles are not idempotent, so
+// commands are never retried.
+func (cc *ControllerClient) Command(ctx context.Context, endpoint string) ([]byte, error) {
+	return cc.do(ctx, endpoint, true)
+}
+
+// Available reports whether the circuit breaker currently lets requests through
+func (cc *ControllerClient) Available() bool {
+	return cc.breaker.state() != breakerOpen
+}
+
+// Send one request and return the decrypted response body. Commands are sealed
+// in an envelope when the controller has a payload key.
+func (cc *ControllerClient) do(ctx context.Context, endpoint string, command bool) ([]byte, error) {
+	if !cc.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+
+	body, err := cc.roundTrip(ctx, endpoint, command)
+	if err != nil && retryable(err) {
+		cc.breaker.failure()
+	} else {
+		cc.breaker.success()
+	}
+	return body, err
+}
+
+func (cc *ControllerClient) roundTrip(ctx context.Context, endpoint string, command bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cc.AttemptTimeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	if command {
+		sealed, encrypted, err := sealControllerCommand(cc.Address, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if encrypted {
+			req, err = http.NewRequestWithContext(ctx, http.MethodPost, cc.BaseURL+endpoint, bytes.NewReader(sealed))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if req == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, cc.BaseURL+endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := cc.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{code: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return openControllerResponse(cc.Address, body)
+}
+
+// Full jitter exponential backoff
+func (cc *ControllerClient) backoff(attempt int) time.Duration {
+	max := cc.RetryBackoff << (attempt - 1)
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// Only availability problems are worth retrying. Trust and payload errors
+// would fail the same way again.
+func retryable(err error) bool {
+	var mismatch *CertificateMismatchError
+	switch {
+	case errors.As(err, &mismatch),
+		errors.Is(err, errControllerNotEnrolled),
+		errors.Is(err, errEnvelopeRequired),
+		errors.Is(err, errEnvelopeInvalid),
+		errors.Is(err, errEnvelopeReplayed),
+		errors.Is(err, context.Canceled):
+		return false
+	}
+
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500
+	}
+	return true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ====== CIRCUIT BREAKER ====== //
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after a number of consecutive failures and lets a
+// single trial request through once the cooldown has passed.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool // A half-open trial request is in flight
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+func (b *circuitBreaker) state() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked()
+}
+
+func (b *circuitBreaker) stateLocked() breakerState {
+	if b.failures < b.threshold {
+		return breakerClosed
+	}
+	if time.Since(b.openedAt) < b.cooldown {
+		return breakerOpen
+	}
+	return breakerHalfOpen
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked() {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return false
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
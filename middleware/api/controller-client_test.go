@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flappingController stands in for a controller that drops out. While down it
+// answers 503, while flapping it alternates between failing and succeeding.
+type flappingController struct {
+	requests atomic.Int32
+	down     atomic.Bool
+	flapping atomic.Bool
+	hang     atomic.Bool // Hold requests until the client gives up
+}
+
+func (f *flappingController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := f.requests.Add(1)
+	if f.hang.Load() {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		return
+	}
+	if f.down.Load() || (f.flapping.Load() && n%2 == 1) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte(`{"temperature":24.5}`))
+}
+
+func newFlappingController(t *testing.T) (*flappingController, *ControllerClient) {
+	t.Helper()
+	fake := &flappingController{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cc := NewControllerClient(server.URL)
+	cc.HTTP = server.Client()
+	cc.AttemptTimeout = time.Second
+	cc.RetryBackoff = 10 * time.Millisecond
+	cc.breaker = newCircuitBreaker(3, 100*time.Millisecond)
+	return fake, cc
+}
+
+func TestControllerClientRetriesReads(t *testing.T) {
+	fake, cc := newFlappingController(t)
+	cc.breaker = newCircuitBreaker(100, time.Minute)
+
+	// A flapping controller is read on the second attempt
+	fake.flapping.Store(true)
+	reading, err := cc.Read(context.Background(), "/get-current-data")
+	if err != nil || reading.Stale {
+		t.Fatalf("Read() = %+v, %v, want a fresh reading", reading, err)
+	}
+	if got := fake.requests.Load(); got != 2 {
+		t.Errorf("flapping controller got %d requests, want 2", got)
+	}
+
+	// A controller that stays down gets MaxRetries extra attempts
+	fake.flapping.Store(false)
+	fake.down.Store(true)
+	fake.requests.Store(0)
+	if _, err := cc.Read(context.Background(), "/get-initial-state"); err == nil {
+		t.Fatal("Read() succeeded against a controller that is down")
+	}
+	if got, want := fake.requests.Load(), int32(cc.MaxRetries+1); got != want {
+		t.Errorf("controller got %d requests, want %d", got, want)
+	}
+
+	// Commands are never retried
+	fake.requests.Store(0)
+	if _, err := cc.Command(context.Background(), "/toggle-fan"); err == nil {
+		t.Fatal("Command() succeeded against a controller that is down")
+	}
+	if got := fake.requests.Load(); got != 1 {
+		t.Errorf("command was sent %d times, want 1", got)
+	}
+}
+
+func TestControllerClientBackoffJitter(t *testing.T) {
+	cc := &ControllerClient{RetryBackoff: 100 * time.Millisecond}
+	for attempt := 1; attempt <= 3; attempt++ {
+		max := cc.RetryBackoff << (attempt - 1)
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 50; i++ {
+			d := cc.backoff(attempt)
+			if d < 0 || d > max {
+				t.Fatalf("backoff(%d) = %v, want between 0 and %v", attempt, d, max)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("backoff(%d) is not jittered, always %v", attempt, cc.backoff(attempt))
+		}
+	}
+}
+
+func TestControllerClientCircuitBreaker(t *testing.T) {
+	fake, cc := newFlappingController(t)
+	cc.MaxRetries = 0
+	fake.down.Store(true)
+
+	// Opens after the threshold of consecutive failures
+	for i := 0; i < 3; i++ {
+		if cc.breaker.state() != breakerClosed {
+			t.Fatalf("breaker opened after %d failures", i)
+		}
+		cc.Read(context.Background(), "/get-current-data")
+	}
+	if state := cc.breaker.state(); state != breakerOpen || cc.Available() {
+		t.Fatalf("breaker state = %v after 3 failures, want open", state)
+	}
+
+	// An open breaker does not reach the controller
+	fake.requests.Store(0)
+	if _, err := cc.Read(context.Background(), "/get-current-data"); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("Read() error = %v, want %v", err, errCircuitOpen)
+	}
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("open breaker let %d requests through", got)
+	}
+
+	// Half-open after the cooldown, a failed trial opens it again
+	time.Sleep(cc.breaker.cooldown)
+	if state := cc.breaker.state(); state != breakerHalfOpen {
+		t.Fatalf("breaker state = %v after the cooldown, want half-open", state)
+	}
+	cc.Read(context.Background(), "/get-current-data")
+	if state := cc.breaker.state(); state != breakerOpen {
+		t.Fatalf("breaker state = %v after a failed trial, want open", state)
+	}
+
+	// A successful trial closes it
+	time.Sleep(cc.breaker.cooldown)
+	fake.down.Store(false)
+	if _, err := cc.Read(context.Background(), "/get-current-data"); err != nil {
+		t.Fatalf("half-open Read() error = %v", err)
+	}
+	if state := cc.breaker.state(); state != breakerClosed || !cc.Available() {
+		t.Errorf("breaker state = %v after a successful trial, want closed", state)
+	}
+}
+
+func TestControllerClientServesStaleReadings(t *testing.T) {
+	fake, cc := newFlappingController(t)
+	fresh, err := cc.Read(context.Background(), "/get-current-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.down.Store(true)
+	for cc.Available() {
+		cc.Read(context.Background(), "/get-current-data")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	fake.requests.Store(0)
+	reading, err := cc.Read(context.Background(), "/get-current-data")
+	if err != nil {
+		t.Fatalf("Read() error = %v, want a stale reading", err)
+	}
+	if !reading.Stale || reading.Age <= 0 || string(reading.Body) != string(fresh.Body) {
+		t.Errorf("Read() = %+v, want the cached body as stale with an age", reading)
+	}
+	if !errors.Is(reading.Err, errCircuitOpen) {
+		t.Errorf("stale reading error = %v, want %v", reading.Err, errCircuitOpen)
+	}
+	if got := fake.requests.Load(); got != 0 {
+		t.Errorf("open breaker let %d requests through", got)
+	}
+
+	// Endpoints that were never read have nothing to fall back on
+	if _, err := cc.Read(context.Background(), "/get-initial-state"); err == nil {
+		t.Error("Read() of an uncached endpoint succeeded while the breaker is open")
+	}
+}
+
+func TestControllerClientCancelledContext(t *testing.T) {
+	fake, cc := newFlappingController(t)
+	cc.AttemptTimeout = 5 * time.Second
+	cc.RetryBackoff = 5 * time.Second
+
+	// Cancelled during a request
+	fake.hang.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := cc.Read(ctx, "/get-current-data")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Read() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read() returned after %v, want promptly after the cancel", elapsed)
+	}
+	if got := fake.requests.Load(); got != 1 {
+		t.Errorf("cancelled read was attempted %d times, want 1", got)
+	}
+
+	// Cancelled while waiting for a retry
+	fake.hang.Store(false)
+	fake.down.Store(true)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := cc.Read(ctx, "/get-current-data"); err == nil {
+		t.Error("Read() succeeded against a controller that is down")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Read() returned after %v, want promptly after the cancel", elapsed)
+	}
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controllers are read and driven through a ControllerDriver for their
+// firmware. Our ESP32 firmware is the default, third-party relays are
+// registered by address with their driver:
+//
+//	PUT /api/admin/controllers/192.168.1.40 {"driver": "tasmota", "password": "..."}
+//
+// Outputs are addressed the way the firmware numbers them. Their state is 0
+// or 1 for relays, the angle in degrees for servos and the brightness in
+// percent for dimmers.
+
+// ControllerDriver reads the sensors and drives the outputs of a controller
+type ControllerDriver interface {
+	// Current sensor values by field, nested fields joined with dots
+	ReadSensors(ctx context.Context) (map[string]float64, error)
+	// Current state of outputs. Outputs the controller does not report are
+	// left out.
+	ReadState(ctx context.Context, outputs []ControllerOutput) (map[ControllerOutput]float64, error)
+	// Switch or position an output and return its new state
+	SetOutput(ctx context.Context, output ControllerOutput, value float64) (float64, error)
+	// Flip a relay and return its new state
+	Toggle(ctx context.Context, output ControllerOutput) (float64, error)
+	// Whether the firmware has such an output
+	CheckOutput(output ControllerOutput) error
+}
+
+// ControllerOutput is an output of a controller by actuator type and address
+type ControllerOutput struct {
+	Type    string
+	Address string
+}
+
+// Driver names
+const (
+	driverESP32      = "esp32"
+	driverTasmota    = "tasmota"
+	driverShellyGen1 = "shelly-gen1"
+	driverShellyGen2 = "shelly-gen2"
+)
+
+var controllerDrivers = []string{driverESP32, driverTasmota, driverShellyGen1, driverShellyGen2}
+
+var errUnsupportedOutput = errors.New("the controller firmware does not drive this type of output")
+
+// Third-party relays serve plain HTTP on the farm network
+const relayRequestTimeout = 5 * time.Second
+
+var relayHTTPClient = &http.Client{Timeout: relayRequestTimeout}
+
+func newControllerDriver(config database.Controller) (ControllerDriver, error) {
+	switch config.Driver {
+	case driverESP32:
+		return &esp32Driver{client: controllerFor(config.Address)}, nil
+	case driverTasmota:
+		return newTasmotaDriver(config), nil
+	case driverShellyGen1:
+		return newShellyGen1Driver(config), nil
+	case driverShellyGen2:
+		return newShellyGen2Driver(config), nil
+	}
+	return nil, fmt.Errorf("unknown controller driver %q", config.Driver)
+}
+
+// Driver of a controller by address. Controllers that are not registered
+// run our ESP32 firmware.
+func driverFor(address string) (ControllerDriver, error) {
+	if address == "" {
+		return &esp32Driver{client: controller}, nil
+	}
+	config, err := database.GetController(DB, address)
+	if err == sql.ErrNoRows {
+		return &esp32Driver{client: controllerFor(address)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newControllerDriver(config)
+}
+
+// Range of the state of an output type
+func outputRange(actuatorType string) (min, max float64) {
+	switch actuatorType {
+	case database.ActuatorServo:
+		return 0, 180
+	case database.ActuatorDimmer:
+		return 0, 100
+	}
+	return 0, 1
+}
+
+// Parse the channel number of an output address
+func outputChannel(address string, min, max int) (int, error) {
+	n, err := strconv.Atoi(address)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("address must be a channel number from %d to %d", min, max)
+	}
+	return n, nil
+}
+
+func relayState(on bool) float64 {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// Collect the numbers of a JSON document by their dotted path, e.g.
+// "AM2301.Temperature" or "ext_temperature.0.tC"
+func flattenReadings(prefix string, v interface{}, out map[string]float64) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := v.(type) {
+	case float64:
+		out[prefix] = v
+	case map[string]interface{}:
+		for key, child := range v {
+			flattenReadings(join(key), child, out)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenReadings(join(strconv.Itoa(i)), child, out)
+		}
+	}
+}
+
+// Send a request to a third-party controller and decode its JSON response
+func doRelayRequest(req *http.Request, out interface{}) error {
+	resp, err := relayHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return readRelayResponse(resp, out)
+}
+
+// Decode the JSON response of a third-party controller
+func readRelayResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%w: check the credentials of the controller", &statusError{code: resp.StatusCode})
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 0 {
+			return fmt.Errorf("%w: %s", &statusError{code: resp.StatusCode}, strings.TrimSpace(string(body)))
+		}
+		return &statusError{code: resp.StatusCode}
+	}
+	return json.Unmarshal(body, out)
+}
+
+// An actuator the driver of its controller cannot drive
+type invalidActuator struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Actuators registered on a controller that a driver cannot drive
+func invalidActuators(address string, driver ControllerDriver) ([]invalidActuator, error) {
+	actuators, err := database.ListActuators(DB, database.ActuatorFilter{})
+	if err != nil {
+		return nil, err
+	}
+	invalid := []invalidActuator{}
+	for _, a := range actuators {
+		if a.Controller != address {
+			continue
+		}
+		if err := driver.CheckOutput(actuatorOutput(a)); err != nil {
+			invalid = append(invalid, invalidActuator{a.ID, a.Name, err.Error()})
+		}
+	}
+	return invalid, nil
+}
+
+// 409 response listing the actuators a driver change would strand
+func invalidActuatorsResponse(c *fiber.Ctx, invalid []invalidActuator) error {
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+		"error":     "Actuators of this controller are not supported by the driver",
+		"hint":      "Update or delete them first",
+		"actuators": invalid,
+	})
+}
+
+// ====== CONTROLLER HANDLERS ====== //
+
+func ListControllersHandler(c *fiber.Ctx) error {
+	controllers, err := database.ListControllers(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch controllers"})
+	}
+	return c.JSON(fiber.Map{"default": controller.Address, "drivers": controllerDrivers, "controllers": controllers})
+}
+
+type controllerRequest struct {
+	Driver   string  `json:"driver"`
+	Username string  `json:"username"`
+	Password *string `json:"password"` // Kept when omitted
+	Notes    string  `json:"notes"`
+}
+
+// Register the driver of a controller by address
+func PutControllerHandler(c *fiber.Ctx) error {
+	address := strings.TrimSpace(c.Params("address"))
+	var req controllerRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	config := database.Controller{
+		Address:  address,
+		Driver:   strings.ToLower(strings.TrimSpace(req.Driver)),
+		Username: strings.TrimSpace(req.Username),
+		Notes:    strings.TrimSpace(req.Notes),
+	}
+	if !oneOf(config.Driver, controllerDrivers) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unknown controller driver",
+			"hint":  "One of " + strings.Join(controllerDrivers, ", "),
+		})
+	}
+	if address == "" || strings.ContainsAny(address, "/?#@ ") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Address must be a host with an optional port"})
+	}
+
+	existing, err := database.GetController(DB, address)
+	if err != nil && err != sql.ErrNoRows {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch controller"})
+	}
+	config.Password = existing.Password
+	if req.Password != nil {
+		config.Password = *req.Password
+	}
+
+	// The actuators already registered on the controller must stay drivable
+	driver, err := newControllerDriver(config)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load controller driver"})
+	}
+	invalid, err := invalidActuators(address, driver)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch actuators"})
+	}
+	if len(invalid) > 0 {
+		return invalidActuatorsResponse(c, invalid)
+	}
+
+	if err := database.SaveController(DB, config); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save controller"})
+	}
+	saved, err := database.GetController(DB, address)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch controller"})
+	}
+	return c.JSON(fiber.Map{"message": "Controller saved successfully", "controller": saved})
+}
+
+// Unregister a controller, which then runs our ESP32 firmware again
+func DeleteControllerHandler(c *fiber.Ctx) error {
+	address := c.Params("address")
+	invalid, err := invalidActuators(address, &esp32Driver{client: controllerFor(address)})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch actuators"})
+	}
+	if len(invalid) > 0 {
+		return invalidActuatorsResponse(c, invalid)
+	}
+
+	err = database.DeleteController(DB, address)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Controller not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete controller"})
+	}
+	return c.JSON(fiber.Map{"message": "Controller deleted successfully"})
+}
+
+// Read the sensors of a controller through its driver, to check a
+// registration and find the fields sensors can be mapped to
+func GetControllerReadingsHandler(c *fiber.Ctx) error {
+	driver, err := driverFor(c.Params("address"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load controller driver"})
+	}
+	ctx, cancel := context.WithTimeout(c.UserContext(), controllerRequestTimeout)
+	defer cancel()
+
+	readings, err := driver.ReadSensors(ctx)
+	if err != nil {
+		return controllerErrorResponse(c, err, "Failed to read controller sensors")
+	}
+	return c.JSON(readings)
+}
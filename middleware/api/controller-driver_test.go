@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeRelay stands in for a third-party controller. It records the requests
+// it gets, with their query unescaped, e.g. "/cm?cmnd=Power1 ON".
+type fakeRelay struct {
+	mu       sync.Mutex
+	requests []string
+	reply    func(r *http.Request) (status int, body string)
+	header   http.Header // Sent with every response
+}
+
+func newFakeRelay(t *testing.T, reply func(r *http.Request) (int, string)) (*fakeRelay, database.Controller) {
+	t.Helper()
+	fake := &fakeRelay{reply: reply}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, database.Controller{Address: strings.TrimPrefix(server.URL, "http://")}
+}
+
+func (f *fakeRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := r.URL.Path
+	if query, err := url.QueryUnescape(r.URL.RawQuery); err == nil && query != "" {
+		request += "?" + query
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	f.mu.Unlock()
+
+	status, body := f.reply(r)
+	for key, values := range f.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func (f *fakeRelay) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// Reply with a fixed body
+func replyWith(body string) func(r *http.Request) (int, string) {
+	return func(*http.Request) (int, string) { return http.StatusOK, body }
+}
+
+func TestChangingDriverKeepsActuatorsDrivable(t *testing.T) {
+	const address = "10.0.9.40"
+	app := fiber.New()
+	app.Put("/controllers/:address", PutControllerHandler)
+	app.Delete("/controllers/:address", DeleteControllerHandler)
+	send := func(method, body string) (int, []invalidActuator) {
+		req := httptest.NewRequest(method, "/controllers/"+address, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result struct {
+			Actuators []invalidActuator `json:"actuators"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result.Actuators
+	}
+
+	if status, _ := send(http.MethodPut, `{"driver": "tasmota"}`); status != fiber.StatusOK {
+		t.Fatalf("registering tasmota: status %d", status)
+	}
+	defer database.DeleteController(DB, address)
+	err := database.CreateActuator(DB, database.Actuator{
+		ID: "driver-test-dimmer", Name: "Brooder lamp", Type: database.ActuatorDimmer,
+		Function: "light", Controller: address, Address: "1", Active: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.DeleteActuator(DB, "driver-test-dimmer")
+
+	// Our ESP32 firmware only drives relays
+	status, invalid := send(http.MethodPut, `{"driver": "esp32"}`)
+	if status != fiber.StatusConflict || len(invalid) != 1 || invalid[0].ID != "driver-test-dimmer" {
+		t.Errorf("switching to esp32: status %d, invalid %v, want 409 listing the dimmer", status, invalid)
+	}
+	if status, _ := send(http.MethodDelete, ""); status != fiber.StatusConflict {
+		t.Errorf("unregistering: status %d, want 409", status)
+	}
+	if config, _ := database.GetController(DB, address); config.Driver != driverTasmota {
+		t.Errorf("driver = %q after the rejected changes, want %q", config.Driver, driverTasmota)
+	}
+
+	if status, _ := send(http.MethodPut, `{"driver": "shelly-gen2"}`); status != fiber.StatusOK {
+		t.Errorf("switching to shelly-gen2: status %d, want 200", status)
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"middleware/database"
+	"middleware/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Encrypted payload channel. Once a controller has a key, every response it
+// sends must be an AES-GCM envelope and every command is sent as one. The
+// associated data binds the controller address, the direction, the key ID and
+// a strictly increasing counter, so captured messages cannot be replayed or
+// reflected back. The counter is per controller, so the firmware must keep
+// it in flash across reboots and key rotations.
+
+// Retired keys are still accepted for this long after a rotation
+const keyGracePeriod = 7 * 24 * time.Hour
+
+const (
+	directionFromController = "c2m"
+	directionToController   = "m2c"
+)
+
+var (
+	errEnvelopeRequired = errors.New("controller sent an unencrypted payload but envelope mode is enabled")
+	errEnvelopeInvalid  = errors.New("controller payload failed authentication")
+	errEnvelopeReplayed = errors.New("controller payload counter was already used")
+)
+
+// Associated data authenticated with every envelope
+func envelopeAAD(address, direction, keyID string, counter uint64) []byte {
+	return []byte(fmt.Sprintf("tokkatot/v1|%s|%s|%s|%d", address, direction, keyID, counter))
+}
+
+// Keys that are still accepted for a controller, active key first
+func usableControllerKeys(address string) ([]database.ControllerKey, error) {
+	keys, err := database.ListControllerKeys(DB, address)
+	if err != nil {
+		return nil, err
+	}
+
+	usable := []database.ControllerKey{}
+	for _, key := range keys {
+		if key.Status == database.KeyActive {
+			usable = append([]database.ControllerKey{key}, usable...)
+		} else if key.RetiredAt != nil && time.Since(*key.RetiredAt) < keyGracePeriod {
+			usable = append(usable, key)
+		}
+	}
+	return usable, nil
+}
+
+// Decrypt a controller response. Controllers without a key are passed through
+// unchanged.
+func openControllerResponse(address string, body []byte) ([]byte, error) {
+	keys, err := usableControllerKeys(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return body, nil
+	}
+
+	var env utils.Envelope
+	if err := json.Unmarshal(body, &env); err != nil || env.Data == "" || env.IV == "" {
+		return nil, errEnvelopeRequired
+	}
+
+	for _, key := range keys {
+		if key.KeyID != env.KeyID {
+			continue
+		}
+
+		secret, err := hex.DecodeString(key.KeyHex)
+		if err != nil {
+			return nil, err
+		}
+
+		plaintext, err := utils.OpenAESGCM(env, secret, envelopeAAD(address, directionFromController, env.KeyID, env.Counter))
+		if err != nil {
+			return nil, errEnvelopeInvalid
+		}
+
+		fresh, err := database.AdvanceRxCounter(DB, address, env.KeyID, env.Counter)
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, errEnvelopeReplayed
+		}
+		return plaintext, nil
+	}
+	return nil, errEnvelopeInvalid
+}
+
+// Seal a command for a controller. The returned flag is false when the
+// controller has no key and the command should be sent in the clear.
+func sealControllerCommand(address, command string) ([]byte, bool, error) {
+	keys, err := usableControllerKeys(address)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(keys) == 0 {
+		return nil, false, nil
+	}
+
+	// Prefer the key the controller last used so commands keep working while
+	// the firmware still runs with a retired key
+	key := keys[0]
+	lastKeyID, err := database.GetLastControllerKeyID(DB, address)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, k := range keys {
+		if k.KeyID == lastKeyID {
+			key = k
+			break
+		}
+	}
+
+	secret, err := hex.DecodeString(key.KeyHex)
+	if err != nil {
+		return nil, false, err
+	}
+
+	counter, err := database.NextTxCounter(DB, address)
+	if err != nil {
+		return nil, false, err
+	}
+
+	payload, err := json.Marshal(fiber.Map{"command": command, "sent_at": time.Now().Unix()})
+	if err != nil {
+		return nil, false, err
+	}
+
+	env, err := utils.EncryptAESGCM(payload, secret, envelopeAAD(address, directionToController, key.KeyID, counter))
+	if err != nil {
+		return nil, false, err
+	}
+	env.KeyID = key.KeyID
+	env.Counter = counter
+
+	body, err := json.Marshal(env)
+	return body, true, err
+}
+
+// Header naming the controller that pushes data to the middleware
+const controllerHeader = "X-Controller-Address"
+
+// RequireControllerEnvelope authenticates data pushed by a controller. The
+// body must be an envelope sealed with the payload key of the controller
+// named by the X-Controller-Address header, and is replaced by its plaintext.
+// Controllers without a key cannot push.
+func RequireControllerEnvelope(c *fiber.Ctx) error {
+	address := c.Get(controllerHeader)
+	if address == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": controllerHeader + " header is required"})
+	}
+	keys, err := usableControllerKeys(address)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch controller keys"})
+	}
+	if len(keys) == 0 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Controller has no payload key",
+			"hint":  "Rotate a key for the controller and flash it into the firmware",
+		})
+	}
+
+	plaintext, err := openControllerResponse(address, c.Body())
+	if err != nil {
+		log.Printf("Rejected data pushed by controller %s: %v", address, err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Payload failed authentication"})
+	}
+	c.Request().SetBody(plaintext)
+	c.Request().Header.SetContentType(fiber.MIMEApplicationJSON)
+	c.Locals(controllerLocal, address)
+	return c.Next()
+}
+
+const controllerLocal = "controller"
+
+// Controller authenticated by RequireControllerEnvelope
+func pushingController(c *fiber.Ctx) string {
+	address, _ := c.Locals(controllerLocal).(string)
+	return address
+}
+
+// ====== ADMIN HANDLERS ====== //
+func ListControllerKeysHandler(c *fiber.Ctx) error {
+	address := c.Query("address", controllerAddress(dataProvider))
+
+	keys, err := database.ListControllerKeys(DB, address)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list controller keys"})
+	}
+	return c.JSON(fiber.Map{"address": address, "keys": keys})
+}
+
+// Generate a new key for a controller. The key is only returned once, to be
+// flashed into the firmware.
+func RotateControllerKeyHandler(c *fiber.Ctx) error {
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Address == "" {
+		req.Address = controllerAddress(dataProvider)
+	}
+
+	secret := make([]byte, 32)
+	keyID := make([]byte, 4)
+	if _, err := rand.Read(secret); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate key"})
+	}
+	if _, err := rand.Read(keyID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate key"})
+	}
+
+	if err := database.RotateControllerKey(DB, req.Address, hex.EncodeToString(keyID), hex.EncodeToString(secret)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save controller key"})
+	}
+
+	log.Printf("Rotated payload key of controller %s (key id %s)", req.Address, hex.EncodeToString(keyID))
+	return c.JSON(fiber.Map{
+		"message":      "Controller key rotated successfully",
+		"address":      req.Address,
+		"key_id":       hex.EncodeToString(keyID),
+		"key":          hex.EncodeToString(secret),
+		"grace_period": keyGracePeriod.String(),
+	})
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"middleware/database"
+)
+
+// esp32Driver speaks our ESP32 firmware API through a ControllerClient. It
+// only drives relays. An output is addressed as "device" or "device:field":
+// it is switched through /toggle-<device> and its state is the field of
+// /get-initial-state, which defaults to the device.
+type esp32Driver struct {
+	client *ControllerClient
+}
+
+var esp32AddressPattern = regexp.MustCompile(`^[a-z0-9_-]+(:[a-z0-9_]+)?$`)
+
+// Split an ESP32 address into the toggled device and its state field
+func esp32Address(address string) (device, field string) {
+	device, field, found := strings.Cut(address, ":")
+	if !found {
+		field = device
+	}
+	return device, field
+}
+
+// Read an endpoint, failing rather than serving a cached response
+func (d *esp32Driver) read(ctx context.Context, endpoint string) ([]byte, error) {
+	reading, err := d.client.Read(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if reading.Stale {
+		return nil, reading.Err
+	}
+	return reading.Body, nil
+}
+
+func (d *esp32Driver) ReadSensors(ctx context.Context) (map[string]float64, error) {
+	body, err := d.read(ctx, "/get-current-data")
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	readings := map[string]float64{}
+	flattenReadings("", data, readings)
+	return readings, nil
+}
+
+func (d *esp32Driver) ReadState(ctx context.Context, outputs []ControllerOutput) (map[ControllerOutput]float64, error) {
+	body, err := d.read(ctx, "/get-initial-state")
+	if err != nil {
+		return nil, err
+	}
+	// The firmware encodes booleans as numbers
+	var state map[string]float64
+	if err := json.Unmarshal(body, &state); err != nil {
+		return nil, err
+	}
+
+	states := map[ControllerOutput]float64{}
+	for _, output := range outputs {
+		_, field := esp32Address(output.Address)
+		if v, ok := state[field]; ok && output.Type == database.ActuatorRelay {
+			states[output] = relayState(v != 0)
+		}
+	}
+	return states, nil
+}
+
+// The firmware only toggles, so switch from the current state
+func (d *esp32Driver) SetOutput(ctx context.Context, output ControllerOutput, value float64) (float64, error) {
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	states, err := d.ReadState(ctx, []ControllerOutput{output})
+	if err != nil {
+		return 0, err
+	}
+	current, ok := states[output]
+	if !ok {
+		_, field := esp32Address(output.Address)
+		return 0, fmt.Errorf("controller does not report the state of %s", field)
+	}
+	if current == value {
+		return current, nil
+	}
+	return d.Toggle(ctx, output)
+}
+
+func (d *esp32Driver) Toggle(ctx context.Context, output ControllerOutput) (float64, error) {
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	device, _ := esp32Address(output.Address)
+	reply, err := d.client.Command(ctx, "/toggle-"+device)
+	if err != nil {
+		return 0, err
+	}
+	on, err := strconv.ParseBool(strings.TrimSpace(string(reply)))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected %s state %q", device, reply)
+	}
+	return relayState(on), nil
+}
+
+func (d *esp32Driver) CheckOutput(output ControllerOutput) error {
+	if output.Type != database.ActuatorRelay {
+		return errUnsupportedOutput
+	}
+	if !esp32AddressPattern.MatchString(output.Address) {
+		return errors.New(`address must be the device of its toggle route, optionally followed by its state field, e.g. "belt:conveyer"`)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"middleware/database"
+)
+
+// ESP32 driver talking to a fake controller answering by path
+func newFakeESP32(t *testing.T, replies map[string]string) (*fakeRelay, *esp32Driver) {
+	t.Helper()
+	fake, config := newFakeRelay(t, func(r *http.Request) (int, string) {
+		body, ok := replies[r.URL.Path]
+		if !ok {
+			return http.StatusNotFound, ""
+		}
+		return http.StatusOK, body
+	})
+	cc := NewControllerClient("http://" + config.Address)
+	cc.HTTP = http.DefaultClient
+	return fake, &esp32Driver{client: cc}
+}
+
+func TestESP32DriverToggle(t *testing.T) {
+	belt := ControllerOutput{database.ActuatorRelay, "belt:conveyer"}
+	tests := []struct {
+		reply   string
+		want    float64
+		wantErr bool
+	}{
+		{"true", 1, false},
+		{"false\n", 0, false},
+		{"1", 1, false},
+		{" 0 ", 0, false},
+		{"on", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		fake, d := newFakeESP32(t, map[string]string{"/toggle-belt": tt.reply})
+		got, err := d.Toggle(context.Background(), belt)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "unexpected belt state") {
+				t.Errorf("reply %q: error = %v, want an unexpected state", tt.reply, err)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("reply %q: Toggle() = %v, %v, want %v", tt.reply, got, err, tt.want)
+		}
+		if want := []string{"/toggle-belt"}; !reflect.DeepEqual(fake.log(), want) {
+			t.Errorf("reply %q: requests = %q, want %q", tt.reply, fake.log(), want)
+		}
+	}
+}
+
+func TestESP32DriverSetOutput(t *testing.T) {
+	tests := []struct {
+		name         string
+		address      string
+		value        float64
+		wantRequests []string
+		want         float64
+	}{
+		{"already on", "belt:conveyer", 1, []string{"/get-initial-state"}, 1},
+		{"already off", "fan", 0, []string{"/get-initial-state"}, 0},
+		{"switch on", "fan", 1, []string{"/get-initial-state", "/toggle-fan"}, 1},
+		{"switch off", "belt:conveyer", 0, []string{"/get-initial-state", "/toggle-belt"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, d := newFakeESP32(t, map[string]string{
+				"/get-initial-state": `{"conveyer": 1, "fan": 0}`,
+				"/toggle-belt":       "false",
+				"/toggle-fan":        "true",
+			})
+			got, err := d.SetOutput(context.Background(), ControllerOutput{database.ActuatorRelay, tt.address}, tt.value)
+			if err != nil || got != tt.want {
+				t.Errorf("SetOutput() = %v, %v, want %v", got, err, tt.want)
+			}
+			if !reflect.DeepEqual(fake.log(), tt.wantRequests) {
+				t.Errorf("requests = %q, want %q", fake.log(), tt.wantRequests)
+			}
+		})
+	}
+
+	// An output the controller does not report is not toggled blindly
+	fake, d := newFakeESP32(t, map[string]string{"/get-initial-state": `{"fan": 0}`})
+	if _, err := d.SetOutput(context.Background(), ControllerOutput{database.ActuatorRelay, "pump"}, 1); err == nil {
+		t.Error("SetOutput() of an unreported output succeeded")
+	}
+	if n := len(fake.log()); n != 1 {
+		t.Errorf("%d requests, want only the state read", n)
+	}
+}
+
+func TestESP32CheckOutput(t *testing.T) {
+	d := &esp32Driver{}
+	tests := []struct {
+		output ControllerOutput
+		valid  bool
+	}{
+		{ControllerOutput{database.ActuatorRelay, "fan"}, true},
+		{ControllerOutput{database.ActuatorRelay, "auto:auto_mode"}, true},
+		{ControllerOutput{database.ActuatorRelay, "Fan"}, false},
+		{ControllerOutput{database.ActuatorRelay, "fan/1"}, false},
+		{ControllerOutput{database.ActuatorDimmer, "bulb"}, false},
+	}
+	for _, tt := range tests {
+		if err := d.CheckOutput(tt.output); (err == nil) != tt.valid {
+			t.Errorf("CheckOutput(%v) = %v, want valid %v", tt.output, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"middleware/database"
+	"middleware/utils"
+)
+
+// Shelly devices are driven through their local HTTP API. Relays and
+// dimmers are addressed by their channel from 0. Sensor fields are those of
+// the device status, e.g. "ext_temperature.0.tC" on Gen1 and
+// "temperature:100.tC" on Gen2.
+
+const shellyMaxChannel = 15
+
+func checkShellyOutput(output ControllerOutput) error {
+	if output.Type != database.ActuatorRelay && output.Type != database.ActuatorDimmer {
+		return errUnsupportedOutput
+	}
+	_, err := outputChannel(output.Address, 0, shellyMaxChannel)
+	return err
+}
+
+// ====== GEN1 ====== //
+
+// shellyGen1Driver speaks the REST API of Gen1 devices, e.g. GET /relay/0.
+// Credentials are sent with basic authentication.
+type shellyGen1Driver struct {
+	baseURL  string
+	username string
+	password string
+}
+
+func newShellyGen1Driver(config database.Controller) *shellyGen1Driver {
+	return &shellyGen1Driver{baseURL: "http://" + config.Address, username: config.Username, password: config.Password}
+}
+
+func (d *shellyGen1Driver) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	target := d.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if d.password != "" {
+		req.SetBasicAuth(d.username, d.password)
+	}
+	return doRelayRequest(req, out)
+}
+
+// Status of a relay or light channel
+type shellyGen1Channel struct {
+	IsOn       bool     `json:"ison"`
+	Brightness *float64 `json:"brightness"`
+}
+
+func (ch shellyGen1Channel) state(output ControllerOutput) float64 {
+	if output.Type == database.ActuatorDimmer {
+		if !ch.IsOn || ch.Brightness == nil {
+			return 0
+		}
+		return *ch.Brightness
+	}
+	return relayState(ch.IsOn)
+}
+
+// Path of an output: relays are /relay/<n> and dimmers /light/<n>
+func shellyGen1Path(output ControllerOutput) string {
+	if output.Type == database.ActuatorDimmer {
+		return "/light/" + output.Address
+	}
+	return "/relay/" + output.Address
+}
+
+func (d *shellyGen1Driver) ReadSensors(ctx context.Context) (map[string]float64, error) {
+	var status interface{}
+	if err := d.get(ctx, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	readings := map[string]float64{}
+	flattenReadings("", status, readings)
+	return readings, nil
+}
+
+func (d *shellyGen1Driver) ReadState(ctx context.Context, outputs []ControllerOutput) (map[ControllerOutput]float64, error) {
+	var status struct {
+		Relays []shellyGen1Channel `json:"relays"`
+		Lights []shellyGen1Channel `json:"lights"`
+	}
+	if err := d.get(ctx, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+
+	states := map[ControllerOutput]float64{}
+	for _, output := range outputs {
+		channels := status.Relays
+		if output.Type == database.ActuatorDimmer {
+			channels = status.Lights
+		}
+		if n, err := strconv.Atoi(output.Address); err == nil && n >= 0 && n < len(channels) {
+			states[output] = channels[n].state(output)
+		}
+	}
+	return states, nil
+}
+
+func (d *shellyGen1Driver) SetOutput(ctx context.Context, output ControllerOutput, value float64) (float64, error) {
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	query := url.Values{"turn": {"off"}}
+	if value != 0 {
+		query.Set("turn", "on")
+		if output.Type == database.ActuatorDimmer {
+			query.Set("brightness", strconv.Itoa(int(value)))
+		}
+	}
+	var ch shellyGen1Channel
+	if err := d.get(ctx, shellyGen1Path(output), query, &ch); err != nil {
+		return 0, err
+	}
+	return ch.state(output), nil
+}
+
+func (d *shellyGen1Driver) Toggle(ctx context.Context, output ControllerOutput) (float64, error) {
+	if output.Type != database.ActuatorRelay {
+		return 0, errUnsupportedOutput
+	}
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	var ch shellyGen1Channel
+	if err := d.get(ctx, shellyGen1Path(output), url.Values{"turn": {"toggle"}}, &ch); err != nil {
+		return 0, err
+	}
+	return ch.state(output), nil
+}
+
+func (d *shellyGen1Driver) CheckOutput(output ControllerOutput) error {
+	return checkShellyOutput(output)
+}
+
+// ====== GEN2 ====== //
+
+// shellyGen2Driver speaks the RPC API of Gen2 and later devices over HTTP
+// GET, e.g. /rpc/Switch.Set?id=0&on=true. Devices with authentication
+// enabled answer 401 with a digest challenge, which is answered with the
+// credentials of the controller. The user is always "admin".
+type shellyGen2Driver struct {
+	baseURL  string
+	username string
+	password string
+}
+
+var errShellyAuth = errors.New("shelly: the device requires authentication, check the password of the controller")
+
+func newShellyGen2Driver(config database.Controller) *shellyGen2Driver {
+	d := &shellyGen2Driver{baseURL: "http://" + config.Address, username: config.Username, password: config.Password}
+	if d.username == "" {
+		d.username = "admin"
+	}
+	return d
+}
+
+func (d *shellyGen2Driver) call(ctx context.Context, method string, params url.Values, out interface{}) error {
+	target := d.baseURL + "/rpc/" + method
+	if len(params) > 0 {
+		target += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := relayHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// A challenged request was not run, so it is safe to send it again
+	if resp.StatusCode == http.StatusUnauthorized && d.password != "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if resp, err = d.authorized(req, challenge); err != nil {
+			return err
+		}
+	}
+
+	err = readRelayResponse(resp, out)
+	var status *statusError
+	if errors.As(err, &status) && status.code == http.StatusUnauthorized {
+		return errShellyAuth
+	}
+	return err
+}
+
+// Send a request again, answering the digest challenge of the device
+func (d *shellyGen2Driver) authorized(req *http.Request, challenge string) (*http.Response, error) {
+	params, err := utils.ParseDigest(challenge)
+	if err != nil {
+		return nil, fmt.Errorf("shelly: %w", err)
+	}
+	authorization, err := utils.DigestAuthorization(params, d.username, d.password, req.Method, req.URL.RequestURI())
+	if err != nil {
+		return nil, fmt.Errorf("shelly: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", authorization)
+	return relayHTTPClient.Do(req)
+}
+
+// Status of a switch or light component
+type shellyGen2Component struct {
+	Output     bool     `json:"output"`
+	Brightness *float64 `json:"brightness"`
+}
+
+func (c shellyGen2Component) state(output ControllerOutput) float64 {
+	if output.Type == database.ActuatorDimmer {
+		if !c.Output || c.Brightness == nil {
+			return 0
+		}
+		return *c.Brightness
+	}
+	return relayState(c.Output)
+}
+
+// Component of an output: relays are Switch and dimmers Light
+func shellyGen2Namespace(output ControllerOutput) string {
+	if output.Type == database.ActuatorDimmer {
+		return "Light"
+	}
+	return "Switch"
+}
+
+func (d *shellyGen2Driver) ReadSensors(ctx context.Context) (map[string]float64, error) {
+	var status interface{}
+	if err := d.call(ctx, "Shelly.GetStatus", nil, &status); err != nil {
+		return nil, err
+	}
+	readings := map[string]float64{}
+	flattenReadings("", status, readings)
+	return readings, nil
+}
+
+func (d *shellyGen2Driver) ReadState(ctx context.Context, outputs []ControllerOutput) (map[ControllerOutput]float64, error) {
+	// Components are keyed by type and ID, e.g. "switch:0"
+	var status map[string]json.RawMessage
+	if err := d.call(ctx, "Shelly.GetStatus", nil, &status); err != nil {
+		return nil, err
+	}
+
+	states := map[ControllerOutput]float64{}
+	for _, output := range outputs {
+		key := "switch:" + output.Address
+		if output.Type == database.ActuatorDimmer {
+			key = "light:" + output.Address
+		}
+		var c shellyGen2Component
+		if raw, ok := status[key]; ok && json.Unmarshal(raw, &c) == nil {
+			states[output] = c.state(output)
+		}
+	}
+	return states, nil
+}
+
+// Set an output and read back the state it settled in
+func (d *shellyGen2Driver) SetOutput(ctx context.Context, output ControllerOutput, value float64) (float64, error) {
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	component := shellyGen2Namespace(output)
+	params := url.Values{"id": {output.Address}, "on": {strconv.FormatBool(value != 0)}}
+	if output.Type == database.ActuatorDimmer && value != 0 {
+		params.Set("brightness", strconv.Itoa(int(value)))
+	}
+	var result interface{}
+	if err := d.call(ctx, component+".Set", params, &result); err != nil {
+		return 0, err
+	}
+
+	var c shellyGen2Component
+	if err := d.call(ctx, component+".GetStatus", url.Values{"id": {output.Address}}, &c); err != nil {
+		return 0, err
+	}
+	return c.state(output), nil
+}
+
+func (d *shellyGen2Driver) Toggle(ctx context.Context, output ControllerOutput) (float64, error) {
+	if output.Type != database.ActuatorRelay {
+		return 0, errUnsupportedOutput
+	}
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	var result struct {
+		WasOn *bool `json:"was_on"`
+	}
+	if err := d.call(ctx, "Switch.Toggle", url.Values{"id": {output.Address}}, &result); err != nil {
+		return 0, err
+	}
+	if result.WasOn == nil {
+		return 0, fmt.Errorf("shelly: no state in the result of Switch.Toggle")
+	}
+	return relayState(!*result.WasOn), nil
+}
+
+func (d *shellyGen2Driver) CheckOutput(output ControllerOutput) error {
+	return checkShellyOutput(output)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"middleware/database"
+	"middleware/utils"
+)
+
+var (
+	shellyRelay  = func(n string) ControllerOutput { return ControllerOutput{database.ActuatorRelay, n} }
+	shellyDimmer = func(n string) ControllerOutput { return ControllerOutput{database.ActuatorDimmer, n} }
+)
+
+const shellyGen1Status = `{
+	"relays": [{"ison": false, "source": "http"}, {"ison": true, "source": "http"}],
+	"lights": [{"ison": true, "brightness": 70}],
+	"ext_temperature": {"0": {"tC": 24.5}},
+	"ext_humidity": {"0": {"hum": 61}}
+}`
+
+func TestShellyGen1Driver(t *testing.T) {
+	state := func(output ControllerOutput) func(d *shellyGen1Driver) (float64, error) {
+		return func(d *shellyGen1Driver) (float64, error) {
+			states, err := d.ReadState(context.Background(), []ControllerOutput{output})
+			return states[output], err
+		}
+	}
+
+	tests := []struct {
+		name         string
+		reply        string
+		run          func(d *shellyGen1Driver) (float64, error)
+		wantRequests []string
+		want         float64
+	}{
+		{
+			name:  "sensors from /status",
+			reply: shellyGen1Status,
+			run: func(d *shellyGen1Driver) (float64, error) {
+				readings, err := d.ReadSensors(context.Background())
+				return readings["ext_temperature.0.tC"], err
+			},
+			wantRequests: []string{"/status"},
+			want:         24.5,
+		},
+		{
+			name:         "relay state",
+			reply:        shellyGen1Status,
+			run:          state(shellyRelay("1")),
+			wantRequests: []string{"/status"},
+			want:         1,
+		},
+		{
+			name:         "light state is its brightness",
+			reply:        shellyGen1Status,
+			run:          state(shellyDimmer("0")),
+			wantRequests: []string{"/status"},
+			want:         70,
+		},
+		{
+			name:  "switch on",
+			reply: `{"ison": true, "source": "http"}`,
+			run: func(d *shellyGen1Driver) (float64, error) {
+				return d.SetOutput(context.Background(), shellyRelay("0"), 1)
+			},
+			wantRequests: []string{"/relay/0?turn=on"},
+			want:         1,
+		},
+		{
+			name:  "toggle",
+			reply: `{"ison": false, "source": "http"}`,
+			run: func(d *shellyGen1Driver) (float64, error) {
+				return d.Toggle(context.Background(), shellyRelay("1"))
+			},
+			wantRequests: []string{"/relay/1?turn=toggle"},
+			want:         0,
+		},
+		{
+			name:  "dim",
+			reply: `{"ison": true, "brightness": 55}`,
+			run: func(d *shellyGen1Driver) (float64, error) {
+				return d.SetOutput(context.Background(), shellyDimmer("0"), 55)
+			},
+			wantRequests: []string{"/light/0?brightness=55&turn=on"},
+			want:         55,
+		},
+		{
+			name:  "dim to off",
+			reply: `{"ison": false, "brightness": 55}`,
+			run: func(d *shellyGen1Driver) (float64, error) {
+				return d.SetOutput(context.Background(), shellyDimmer("0"), 0)
+			},
+			wantRequests: []string{"/light/0?turn=off"},
+			want:         0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, config := newFakeRelay(t, replyWith(tt.reply))
+			got, err := tt.run(newShellyGen1Driver(config))
+			if err != nil || got != tt.want {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+			if !reflect.DeepEqual(fake.log(), tt.wantRequests) {
+				t.Errorf("requests = %q, want %q", fake.log(), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestShellyGen1DriverBasicAuth(t *testing.T) {
+	_, config := newFakeRelay(t, func(r *http.Request) (int, string) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+			return http.StatusUnauthorized, ""
+		}
+		return http.StatusOK, `{"ison": true}`
+	})
+	config.Username = "admin"
+
+	config.Password = "secret"
+	if got, err := newShellyGen1Driver(config).Toggle(context.Background(), shellyRelay("0")); err != nil || got != 1 {
+		t.Errorf("Toggle() = %v, %v, want 1", got, err)
+	}
+
+	config.Password = "wrong"
+	_, err := newShellyGen1Driver(config).Toggle(context.Background(), shellyRelay("0"))
+	var status *statusError
+	if !errors.As(err, &status) || status.code != http.StatusUnauthorized {
+		t.Errorf("Toggle() error = %v, want a 401 status error", err)
+	}
+}
+
+const shellyGen2Status = `{
+	"sys": {"uptime": 3600},
+	"switch:0": {"id": 0, "output": false, "temperature": {"tC": 41.2}},
+	"switch:1": {"id": 1, "output": true, "temperature": {"tC": 41.2}},
+	"light:0": {"id": 0, "output": true, "brightness": 30},
+	"temperature:100": {"id": 100, "tC": 24.5}
+}`
+
+// Answer Gen2 RPC calls by method
+func shellyGen2Replies(replies map[string]string) func(r *http.Request) (int, string) {
+	return func(r *http.Request) (int, string) {
+		body, ok := replies[strings.TrimPrefix(r.URL.Path, "/rpc/")]
+		if !ok {
+			return http.StatusNotFound, `{"code": 404, "message": "No handler"}`
+		}
+		return http.StatusOK, body
+	}
+}
+
+func TestShellyGen2Driver(t *testing.T) {
+	state := func(output ControllerOutput) func(d *shellyGen2Driver) (float64, error) {
+		return func(d *shellyGen2Driver) (float64, error) {
+			states, err := d.ReadState(context.Background(), []ControllerOutput{output})
+			return states[output], err
+		}
+	}
+
+	tests := []struct {
+		name         string
+		replies      map[string]string
+		run          func(d *shellyGen2Driver) (float64, error)
+		wantRequests []string
+		want         float64
+	}{
+		{
+			name:    "sensors from Shelly.GetStatus",
+			replies: map[string]string{"Shelly.GetStatus": shellyGen2Status},
+			run: func(d *shellyGen2Driver) (float64, error) {
+				readings, err := d.ReadSensors(context.Background())
+				return readings["temperature:100.tC"], err
+			},
+			wantRequests: []string{"/rpc/Shelly.GetStatus"},
+			want:         24.5,
+		},
+		{
+			name:         "switch state",
+			replies:      map[string]string{"Shelly.GetStatus": shellyGen2Status},
+			run:          state(shellyRelay("1")),
+			wantRequests: []string{"/rpc/Shelly.GetStatus"},
+			want:         1,
+		},
+		{
+			name:         "light state is its brightness",
+			replies:      map[string]string{"Shelly.GetStatus": shellyGen2Status},
+			run:          state(shellyDimmer("0")),
+			wantRequests: []string{"/rpc/Shelly.GetStatus"},
+			want:         30,
+		},
+		{
+			name: "set reads back the state",
+			replies: map[string]string{
+				"Switch.Set":       `{"was_on": false}`,
+				"Switch.GetStatus": `{"id": 0, "output": true}`,
+			},
+			run: func(d *shellyGen2Driver) (float64, error) {
+				return d.SetOutput(context.Background(), shellyRelay("0"), 1)
+			},
+			wantRequests: []string{"/rpc/Switch.Set?id=0&on=true", "/rpc/Switch.GetStatus?id=0"},
+			want:         1,
+		},
+		{
+			name: "dim",
+			replies: map[string]string{
+				"Light.Set":       `null`,
+				"Light.GetStatus": `{"id": 0, "output": true, "brightness": 45}`,
+			},
+			run: func(d *shellyGen2Driver) (float64, error) {
+				return d.SetOutput(context.Background(), shellyDimmer("0"), 45)
+			},
+			wantRequests: []string{"/rpc/Light.Set?brightness=45&id=0&on=true", "/rpc/Light.GetStatus?id=0"},
+			want:         45,
+		},
+		{
+			name:    "toggle returns the opposite of was_on",
+			replies: map[string]string{"Switch.Toggle": `{"was_on": true}`},
+			run: func(d *shellyGen2Driver) (float64, error) {
+				return d.Toggle(context.Background(), shellyRelay("1"))
+			},
+			wantRequests: []string{"/rpc/Switch.Toggle?id=1"},
+			want:         0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, config := newFakeRelay(t, shellyGen2Replies(tt.replies))
+			got, err := tt.run(newShellyGen2Driver(config))
+			if err != nil || got != tt.want {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+			if !reflect.DeepEqual(fake.log(), tt.wantRequests) {
+				t.Errorf("requests = %q, want %q", fake.log(), tt.wantRequests)
+			}
+		})
+	}
+
+	// A toggle result without was_on is an error, not a guess
+	_, config := newFakeRelay(t, shellyGen2Replies(map[string]string{"Switch.Toggle": `{}`}))
+	if _, err := newShellyGen2Driver(config).Toggle(context.Background(), shellyRelay("0")); err == nil {
+		t.Error("Toggle() succeeded without was_on in the result")
+	}
+}
+
+func TestShellyGen2DriverDigestAuth(t *testing.T) {
+	answer := shellyGen2Replies(map[string]string{"Switch.Toggle": `{"was_on": false}`})
+	fake, config := newFakeRelay(t, func(r *http.Request) (int, string) {
+		params, err := utils.ParseDigest(r.Header.Get("Authorization"))
+		if err == nil && params["username"] == "admin" && params["uri"] == r.URL.RequestURI() {
+			want, _ := utils.DigestResponse(params, "admin", "secret", r.Method, params["uri"])
+			if params["response"] == want {
+				return answer(r)
+			}
+		}
+		return http.StatusUnauthorized, `{"code": 401, "message": "Unauthorized"}`
+	})
+	fake.header = http.Header{"Www-Authenticate": {`Digest qop="auth", realm="shellyplus1-f008d1d8b8b8", nonce="60dc59c6", algorithm=SHA-256`}}
+
+	tests := []struct {
+		password     string
+		want         error
+		wantRequests int // The challenge is only answered with a password
+	}{
+		{"secret", nil, 2},
+		{"wrong", errShellyAuth, 2},
+		{"", errShellyAuth, 1},
+	}
+	for _, tt := range tests {
+		before := len(fake.log())
+		config.Password = tt.password
+		got, err := newShellyGen2Driver(config).Toggle(context.Background(), shellyRelay("0"))
+		if !errors.Is(err, tt.want) || (tt.want == nil && got != 1) {
+			t.Errorf("password %q: Toggle() = %v, %v, want %v", tt.password, got, err, tt.want)
+		}
+		if n := len(fake.log()) - before; n != tt.wantRequests {
+			t.Errorf("password %q: %d requests, want %d", tt.password, n, tt.wantRequests)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"middleware/database"
+)
+
+// tasmotaDriver speaks the HTTP command API of Tasmota firmware,
+// GET /cm?cmnd=<command>. Relays are addressed by their POWER channel from 1
+// and dimmers by their Dimmer channel. Sensor fields are those of
+// "Status 10", e.g. "AM2301.Temperature".
+type tasmotaDriver struct {
+	baseURL  string
+	username string
+	password string
+}
+
+func newTasmotaDriver(config database.Controller) *tasmotaDriver {
+	d := &tasmotaDriver{baseURL: "http://" + config.Address, username: config.Username, password: config.Password}
+	if d.username == "" {
+		d.username = "admin" // The web user of Tasmota
+	}
+	return d
+}
+
+// Run a command and return its JSON result
+func (d *tasmotaDriver) command(ctx context.Context, cmnd string) (map[string]interface{}, error) {
+	query := url.Values{"cmnd": {cmnd}}
+	if d.password != "" {
+		query.Set("user", d.username)
+		query.Set("password", d.password)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.baseURL+"/cm?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := doRelayRequest(req, &result); err != nil {
+		return nil, err
+	}
+	// Failures are reported in the body, e.g. a missing password
+	if warning, ok := result["WARNING"]; ok {
+		return nil, fmt.Errorf("tasmota: %v", warning)
+	}
+	if result["Command"] == "Unknown" {
+		return nil, fmt.Errorf("tasmota: unknown command %q", cmnd)
+	}
+	return result, nil
+}
+
+// Command names of an output. Devices with one relay report POWER instead
+// of POWER1, and one dimmer Dimmer instead of Dimmer1.
+func tasmotaKeys(output ControllerOutput) (command string, keys []string) {
+	if output.Type == database.ActuatorDimmer {
+		if output.Address == "1" {
+			return "Dimmer", []string{"Dimmer", "Dimmer1"}
+		}
+		return "Dimmer" + output.Address, []string{"Dimmer" + output.Address}
+	}
+	keys = []string{"POWER" + output.Address}
+	if output.Address == "1" {
+		keys = append(keys, "POWER")
+	}
+	return "Power" + output.Address, keys
+}
+
+// State of an output in a command result or status
+func tasmotaState(output ControllerOutput, result map[string]interface{}) (float64, bool) {
+	_, keys := tasmotaKeys(output)
+	for _, key := range keys {
+		switch v := result[key].(type) {
+		case string:
+			return relayState(strings.EqualFold(v, "ON")), true
+		case float64:
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func (d *tasmotaDriver) ReadSensors(ctx context.Context) (map[string]float64, error) {
+	result, err := d.command(ctx, "Status 10")
+	if err != nil {
+		return nil, err
+	}
+	readings := map[string]float64{}
+	flattenReadings("", result["StatusSNS"], readings)
+	return readings, nil
+}
+
+func (d *tasmotaDriver) ReadState(ctx context.Context, outputs []ControllerOutput) (map[ControllerOutput]float64, error) {
+	result, err := d.command(ctx, "Status 11")
+	if err != nil {
+		return nil, err
+	}
+	status, _ := result["StatusSTS"].(map[string]interface{})
+
+	states := map[ControllerOutput]float64{}
+	for _, output := range outputs {
+		if v, ok := tasmotaState(output, status); ok {
+			states[output] = v
+		}
+	}
+	return states, nil
+}
+
+func (d *tasmotaDriver) SetOutput(ctx context.Context, output ControllerOutput, value float64) (float64, error) {
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	command, _ := tasmotaKeys(output)
+	arg := strconv.Itoa(int(value))
+	if output.Type == database.ActuatorRelay {
+		arg = "OFF"
+		if value != 0 {
+			arg = "ON"
+		}
+	}
+	return d.run(ctx, output, command+" "+arg)
+}
+
+func (d *tasmotaDriver) Toggle(ctx context.Context, output ControllerOutput) (float64, error) {
+	if output.Type != database.ActuatorRelay {
+		return 0, errUnsupportedOutput
+	}
+	if err := d.CheckOutput(output); err != nil {
+		return 0, err
+	}
+	command, _ := tasmotaKeys(output)
+	return d.run(ctx, output, command+" TOGGLE")
+}
+
+// Run a command on an output and return the state it reports
+func (d *tasmotaDriver) run(ctx context.Context, output ControllerOutput, cmnd string) (float64, error) {
+	result, err := d.command(ctx, cmnd)
+	if err != nil {
+		return 0, err
+	}
+	state, ok := tasmotaState(output, result)
+	if !ok {
+		return 0, fmt.Errorf("tasmota: no state in the result of %q", cmnd)
+	}
+	return state, nil
+}
+
+func (d *tasmotaDriver) CheckOutput(output ControllerOutput) error {
+	switch output.Type {
+	case database.ActuatorRelay:
+		_, err := outputChannel(output.Address, 1, 32)
+		return err
+	case database.ActuatorDimmer:
+		_, err := outputChannel(output.Address, 1, 4)
+		return err
+	}
+	return errUnsupportedOutput
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"middleware/database"
+)
+
+func TestTasmotaDriver(t *testing.T) {
+	relay := func(n string) ControllerOutput { return ControllerOutput{database.ActuatorRelay, n} }
+	dimmer := func(n string) ControllerOutput { return ControllerOutput{database.ActuatorDimmer, n} }
+	state := func(output ControllerOutput) func(d *tasmotaDriver) (float64, error) {
+		return func(d *tasmotaDriver) (float64, error) {
+			states, err := d.ReadState(context.Background(), []ControllerOutput{output})
+			return states[output], err
+		}
+	}
+
+	tests := []struct {
+		name     string
+		reply    string
+		run      func(d *tasmotaDriver) (float64, error)
+		wantCmnd string
+		want     float64
+		wantErr  string
+	}{
+		{
+			name:  "sensors from Status 10",
+			reply: `{"StatusSNS":{"Time":"2025-01-01T06:00:00","AM2301":{"Temperature":24.5,"Humidity":61},"TempUnit":"C"}}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				readings, err := d.ReadSensors(context.Background())
+				return readings["AM2301.Temperature"], err
+			},
+			wantCmnd: "Status 10",
+			want:     24.5,
+		},
+		{
+			name:     "state from Status 11",
+			reply:    `{"StatusSTS":{"POWER1":"OFF","POWER2":"ON"}}`,
+			run:      state(relay("2")),
+			wantCmnd: "Status 11",
+			want:     1,
+		},
+		{
+			name:     "single relay reports POWER",
+			reply:    `{"StatusSTS":{"POWER":"ON"}}`,
+			run:      state(relay("1")),
+			wantCmnd: "Status 11",
+			want:     1,
+		},
+		{
+			name:     "single dimmer reports Dimmer",
+			reply:    `{"StatusSTS":{"POWER":"ON","Dimmer":40}}`,
+			run:      state(dimmer("1")),
+			wantCmnd: "Status 11",
+			want:     40,
+		},
+		{
+			name:  "switch on",
+			reply: `{"POWER2":"ON"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.SetOutput(context.Background(), relay("2"), 1)
+			},
+			wantCmnd: "Power2 ON",
+			want:     1,
+		},
+		{
+			name:  "switch off a single relay",
+			reply: `{"POWER":"OFF"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.SetOutput(context.Background(), relay("1"), 0)
+			},
+			wantCmnd: "Power1 OFF",
+			want:     0,
+		},
+		{
+			name:  "toggle",
+			reply: `{"POWER3":"ON"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.Toggle(context.Background(), relay("3"))
+			},
+			wantCmnd: "Power3 TOGGLE",
+			want:     1,
+		},
+		{
+			name:  "dim",
+			reply: `{"POWER":"ON","Dimmer":60}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.SetOutput(context.Background(), dimmer("1"), 60)
+			},
+			wantCmnd: "Dimmer 60",
+			want:     60,
+		},
+		{
+			name:  "warning",
+			reply: `{"WARNING":"Need user=<username>&password=<password>"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.Toggle(context.Background(), relay("1"))
+			},
+			wantCmnd: "Power1 TOGGLE",
+			wantErr:  "tasmota: Need user",
+		},
+		{
+			name:  "unknown command",
+			reply: `{"Command":"Unknown"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.Toggle(context.Background(), relay("1"))
+			},
+			wantCmnd: "Power1 TOGGLE",
+			wantErr:  "unknown command",
+		},
+		{
+			name:  "no state in the result",
+			reply: `{"POWER1":"ON"}`,
+			run: func(d *tasmotaDriver) (float64, error) {
+				return d.Toggle(context.Background(), relay("2"))
+			},
+			wantCmnd: "Power2 TOGGLE",
+			wantErr:  "no state",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, config := newFakeRelay(t, replyWith(tt.reply))
+			got, err := tt.run(newTasmotaDriver(config))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+			if want := []string{"/cm?cmnd=" + tt.wantCmnd}; !reflect.DeepEqual(fake.log(), want) {
+				t.Errorf("requests = %q, want %q", fake.log(), want)
+			}
+		})
+	}
+}
+
+func TestTasmotaDriverCredentials(t *testing.T) {
+	fake, config := newFakeRelay(t, func(r *http.Request) (int, string) {
+		if r.URL.Query().Get("user") != "admin" || r.URL.Query().Get("password") != "secret" {
+			return http.StatusOK, `{"WARNING":"Need user=<username>&password=<password>"}`
+		}
+		return http.StatusOK, `{"POWER1":"ON"}`
+	})
+
+	config.Password = "secret"
+	if got, err := newTasmotaDriver(config).SetOutput(context.Background(), ControllerOutput{database.ActuatorRelay, "1"}, 1); err != nil || got != 1 {
+		t.Errorf("SetOutput() = %v, %v, want 1", got, err)
+	}
+	config.Password = "wrong"
+	if _, err := newTasmotaDriver(config).SetOutput(context.Background(), ControllerOutput{database.ActuatorRelay, "1"}, 1); err == nil {
+		t.Error("SetOutput() succeeded with a wrong password")
+	}
+	if n := len(fake.log()); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestTasmotaCheckOutput(t *testing.T) {
+	d := &tasmotaDriver{}
+	valid := []ControllerOutput{{database.ActuatorRelay, "1"}, {database.ActuatorRelay, "32"}, {database.ActuatorDimmer, "4"}}
+	invalid := []ControllerOutput{{database.ActuatorRelay, "0"}, {database.ActuatorRelay, "33"}, {database.ActuatorDimmer, "5"}, {database.ActuatorServo, "1"}}
+	for _, output := range valid {
+		if err := d.CheckOutput(output); err != nil {
+			t.Errorf("CheckOutput(%v) = %v", output, err)
+		}
+	}
+	for _, output := range invalid {
+		if err := d.CheckOutput(output); err == nil {
+			t.Errorf("CheckOutput(%v) accepted an output tasmota does not have", output)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The firmware serves a fixed self-signed certificate, so there is no CA to
+// verify against. Instead each controller's certificate is pinned on first use
+// and every later connection must present the same key.
+
+// CertificateMismatchError is returned when a controller presents a
+// certificate that does not match its pin.
+type CertificateMismatchError struct {
+	Address  string
+	PinType  string
+	Expected string
+	Got      string
+}
+
+func (e *CertificateMismatchError) Error() string {
+	return fmt.Sprintf("controller %s presented %s fingerprint %s, expected %s",
+		e.Address, e.PinType, e.Got, e.Expected)
+}
+
+var errControllerNotEnrolled = errors.New("controller certificate is not pinned and trust on first use is disabled")
+
+// Trust on first use is on unless CONTROLLER_TOFU=false
+func tofuEnabled() bool {
+	return !strings.EqualFold(os.Getenv("CONTROLLER_TOFU"), "false")
+}
+
+// Host part of a controller URL, used as the key of its pin
+func controllerAddress(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
+
+// Compute the fingerprint of a certificate for the given pin type
+func certificateFingerprint(cert *x509.Certificate, pinType string) string {
+	var sum [32]byte
+	if pinType == "cert" {
+		sum = sha256.Sum256(cert.Raw)
+	} else {
+		sum = sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// Build a TLS config that only accepts the pinned certificate of address
+func pinnedTLSConfig(address string) *tls.Config {
+	return &tls.Config{
+		// Chain verification is replaced by the pin check below
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("controller %s presented no certificate", address)
+			}
+			return verifyControllerPin(address, cs.PeerCertificates[0])
+		},
+	}
+}
+
+func verifyControllerPin(address string, cert *x509.Certificate) error {
+	pin, err := database.GetControllerPin(DB, address)
+	if err == sql.ErrNoRows {
+		if !tofuEnabled() {
+			return errControllerNotEnrolled
+		}
+
+		// Trust on first use: remember this certificate
+		pin = database.ControllerPin{
+			Address:     address,
+			PinType:     "spki",
+			Fingerprint: certificateFingerprint(cert, "spki"),
+		}
+		if err := database.SaveControllerPin(DB, pin); err != nil {
+			return fmt.Errorf("failed to pin controller certificate: %v", err)
+		}
+		log.Printf("Pinned certificate of controller %s (spki %s)", address, pin.Fingerprint)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load controller pin: %v", err)
+	}
+
+	got := certificateFingerprint(cert, pin.PinType)
+	if !strings.EqualFold(got, pin.Fingerprint) {
+		return &CertificateMismatchError{
+			Address:  address,
+			PinType:  pin.PinType,
+			Expected: pin.Fingerprint,
+			Got:      got,
+		}
+	}
+	return nil
+}
+
+// Connect to a controller and return the certificate it presents
+func fetchControllerCertificate(address string) (*x509.Certificate, error) {
+	host := address
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("controller %s presented no certificate", address)
+	}
+	return certs[0], nil
+}
+
+// Translate controller connection errors into a client response
+func controllerErrorResponse(c *fiber.Ctx, err error, fallback string) error {
+	var mismatch *CertificateMismatchError
+	if errors.As(err, &mismatch) {
+		log.Println("Controller certificate mismatch:", mismatch)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Controller certificate does not match the pinned certificate",
+			"hint":  "If the controller was reflashed, an administrator must re-pin it",
+		})
+	}
+	if errors.Is(err, errControllerNotEnrolled) {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Controller is not enrolled",
+			"hint":  "An administrator must pin the controller certificate",
+		})
+	}
+	if errors.Is(err, errEnvelopeRequired) || errors.Is(err, errEnvelopeInvalid) || errors.Is(err, errEnvelopeReplayed) {
+		log.Println("Rejected controller payload:", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error":   "Controller payload was rejected",
+			"details": err.Error(),
+		})
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Controller is unavailable, try again shortly",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+}
+
+// ====== ADMIN HANDLERS ====== //
+func ListControllerPinsHandler(c *fiber.Ctx) error {
+	pins, err := database.ListControllerPins(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to list controller pins"})
+	}
+	return c.JSON(fiber.Map{"pins": pins})
+}
+
+// Re-pin a controller after a reflash. Without an explicit fingerprint the
+// certificate currently presented by the controller is trusted.
+func RepinControllerHandler(c *fiber.Ctx) error {
+	var req struct {
+		Address     string `json:"address"`
+		PinType     string `json:"pin_type"`
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := c.BodyParser(&req); err != nil && len(c.Body()) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if req.Address == "" {
+		req.Address = controllerAddress(dataProvider)
+	}
+	if req.PinType == "" {
+		req.PinType = "spki"
+	}
+	if req.PinType != "spki" && req.PinType != "cert" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "pin_type must be 'spki' or 'cert'"})
+	}
+
+	if req.Fingerprint == "" {
+		cert, err := fetchControllerCertificate(req.Address)
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error":   "Failed to reach controller",
+				"details": err.Error(),
+			})
+		}
+		req.Fingerprint = certificateFingerprint(cert, req.PinType)
+	} else if b, err := hex.DecodeString(req.Fingerprint); err != nil || len(b) != sha256.Size {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Fingerprint must be a hex encoded SHA-256 hash"})
+	}
+
+	pin := database.ControllerPin{
+		Address:     req.Address,
+		PinType:     req.PinType,
+		Fingerprint: strings.ToLower(req.Fingerprint),
+	}
+	if err := database.SaveControllerPin(DB, pin); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save controller pin"})
+	}
+
+	// Drop pooled connections so the new pin applies immediately
+	controller.HTTP.CloseIdleConnections()
+
+	log.Printf("Controller %s re-pinned (%s %s)", pin.Address, pin.PinType, pin.Fingerprint)
+	return c.JSON(fiber.Map{
+		"message": "Controller pinned successfully",
+		"pin":     pin,
+	})
+}
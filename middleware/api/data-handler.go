@@ -1,43 +1,55 @@
 package api
 
 import (
-	"crypto/tls"
-	"io"
-	"net/http"
+	"context"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 var (
-	dataProvider = "https://10.0.0.2"
-	httpClient   *http.Client
+	dataProvider = getDataProviderURL()
+	controller   *ControllerClient
 )
 
 func init() {
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Timeout: 10 * time.Second,
+	controller = NewControllerClient(dataProvider)
+}
+
+// The controller address can be overridden, e.g. to point at the simulator
+func getDataProviderURL() string {
+	url := os.Getenv("CONTROLLER_URL")
+	if url == "" {
+		return "https://10.0.0.2" // Static IP of the ESP32 controller
 	}
+	return strings.TrimRight(url, "/")
 }
 
+// Upper bound for a dashboard request including retries
+const controllerRequestTimeout = 10 * time.Second
+
 // ====== DATA HANDLERS ====== //
 func getDataHandler(c **fiber.Ctx, endpoint string) error {
-	resp, err := httpClient.Get(dataProvider + endpoint)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return (*c).Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get data from data provider"})
-	}
-	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout((*c).UserContext(), controllerRequestTimeout)
+	defer cancel()
 
-	body, err := io.ReadAll(resp.Body)
+	reading, err := controller.Read(ctx, endpoint)
 	if err != nil {
-		return (*c).Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return controllerErrorResponse(*c, err, "Failed to get data from data provider")
 	}
 
 	// Forward raw data from the data provider directly to the client
-	return (*c).JSON(fiber.Map{"data": string(body)})
+	if reading.Stale {
+		return (*c).JSON(fiber.Map{
+			"data":        string(reading.Body),
+			"stale":       true,
+			"age_seconds": int(reading.Age.Seconds()),
+			"error":       "Data provider unavailable, showing last known data",
+		})
+	}
+	return (*c).JSON(fiber.Map{"data": string(reading.Body), "stale": false})
 }
 
 func GetInitialStateHandler(c *fiber.Ctx) error {
@@ -52,47 +64,6 @@ func GetHistoricalDataHandler(c *fiber.Ctx) error {
 	return getDataHandler(&c, "/get-historical-data")
 }
 
-// ====== TOGGLE HANDLERS ====== //
-func toggleHandler(c **fiber.Ctx, endpoint string) error {
-	resp, err := httpClient.Get(dataProvider + endpoint)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return (*c).Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to toggle device"})
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return (*c).Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	// Verify step removed: return the provider response as the new state
-	return (*c).Status(fiber.StatusOK).JSON(fiber.Map{"state": string(body)})
-}
-
-func ToggleAutoHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-auto")
-}
-
-func ToggleBeltHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-belt")
-}
-
-func ToggleFanHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-fan")
-}
-
-func ToggleBulbHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-bulb")
-}
-
-func ToggleFeederHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-feeder")
-}
-
-func TogglePumpHandler(c *fiber.Ctx) error {
-	return toggleHandler(&c, "/toggle-pump")
-}
-
 // ====== SCHEDULE HANDLERS ====== //
 /* func SaveScheduleHandler(c *fiber.Ctx) error {
 	var schedule database.Schedule
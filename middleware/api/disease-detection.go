@@ -1,15 +1,16 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
-	"net/http"
 	"os"
 	"time"
 
+	"middleware/database"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -36,44 +37,66 @@ type DiseasePrediction struct {
 type PredictionResponse struct {
 	Success    bool              `json:"success"`
 	Prediction DiseasePrediction `json:"prediction"`
+	Model      *AIModelRef       `json:"model,omitempty"`
 	Timestamp  string            `json:"timestamp"`
 	Error      string            `json:"error,omitempty"`
 }
 
-// Health check for AI service
-func AIHealthCheckHandler(c *fiber.Ctx) error {
-	// Validate user authentication
-	if err := ValidateCookie(c); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Authentication required"})
+// aiServiceError is returned when the AI service answers with an error
+type aiServiceError struct {
+	status  int
+	message string
+}
+
+func (e *aiServiceError) Error() string {
+	return fmt.Sprintf("AI service error: %s (status: %d)", e.message, e.status)
+}
+
+// Largest image accepted for prediction
+const maxImageSize = 10 * 1024 * 1024
+
+// Maximum time for a single call to the AI service
+const aiRequestTimeout = 60 * time.Second
+
+// Read an uploaded image and normalize it. The client's Content-Type is not
+// trusted, the type is sniffed from the data. On failure the returned map is
+// the body of a 400 response.
+func readImageUpload(file *multipart.FileHeader) ([]byte, string, fiber.Map) {
+	// Validate file size (max 10MB)
+	if file.Size > maxImageSize {
+		return nil, "", fiber.Map{
+			"error": "File too large",
+			"hint":  "Please upload an image smaller than 10MB",
+		}
 	}
 
-	// Check AI service health
-	resp, err := http.Get(AIServiceURL + "/health")
+	// Open the uploaded file
+	src, err := file.Open()
 	if err != nil {
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-			"error":   "AI service unavailable",
-			"details": err.Error(),
-		})
+		return nil, "", fiber.Map{"error": "Failed to open uploaded file"}
 	}
-	defer resp.Body.Close()
+	defer src.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(src)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read AI service response"})
+		return nil, "", fiber.Map{"error": "Failed to read uploaded file"}
 	}
 
-	var healthStatus map[string]interface{}
-	if err := json.Unmarshal(body, &healthStatus); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to parse AI service response"})
+	normalized, contentType, err := normalizeImage(data)
+	var imgErr *imageError
+	if errors.As(err, &imgErr) {
+		return nil, "", fiber.Map{"error": imgErr.message, "hint": imgErr.hint}
 	}
-
-	return c.JSON(healthStatus)
+	if err != nil {
+		return nil, "", fiber.Map{"error": "Failed to process image"}
+	}
+	return normalized, contentType, nil
 }
 
-// Disease prediction handler
+// Disease prediction handler. The image goes through the job queue like any
+// other prediction, so concurrency limits apply. The handler waits up to
+// maxJobWait for the result to keep the synchronous API, slower predictions
+// answer 202 with the job to poll like POST /api/ai/jobs.
 func PredictDiseaseHandler(c *fiber.Ctx) error {
 	// Validate user authentication
 	if err := ValidateCookie(c); err != nil {
@@ -90,110 +113,71 @@ func PredictDiseaseHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate file type
-	if !isValidImageType(file.Header.Get("Content-Type")) {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid file type",
-			"hint":  "Please upload a JPEG, PNG, or WebP image",
-		})
+	imageData, contentType, uploadErr := readImageUpload(file)
+	if uploadErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(uploadErr)
 	}
 
-	// Validate file size (max 10MB)
-	if file.Size > 10*1024*1024 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "File too large",
-			"hint":  "Please upload an image smaller than 10MB",
+	// Fail fast instead of waiting for a service that is down. The job API
+	// still accepts images, they are processed when it recovers.
+	if !aiAvailable() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"success": false,
+			"error":   "AI service unavailable",
+			"hint":    "Please try again in a few minutes",
 		})
 	}
 
-	// Open the uploaded file
-	src, err := file.Open()
+	job, err := enqueuePredictionJob(c, imageData, contentType, file.Filename)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to open uploaded file"})
-	}
-	defer src.Close()
-
-	// Create a buffer to store the file content
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
-
-	// Create form file field
-	fw, err := writer.CreateFormFile("image", file.Filename)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create form data"})
-	}
-
-	// Copy file content
-	if _, err := io.Copy(fw, src); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to copy file data"})
-	}
-
-	// Close the writer to finalize the form
-	writer.Close()
-
-	// Create HTTP request to AI service
-	req, err := http.NewRequest("POST", AIServiceURL+"/predict", &buf)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to create AI service request"})
-	}
-
-	// Set content type
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	// Make request with timeout
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
-			"error":   "AI service request failed",
-			"details": err.Error(),
+		return jobErrorResponse(c, err)
+	}
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), maxJobWait)
+	defer cancel()
+	latest, err := predictionJobs.wait(ctx, job.ID)
+	if err != nil && ctx.Err() != nil {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"success":    false,
+			"error":      "Prediction is taking longer than expected",
+			"job_id":     job.ID,
+			"status":     latest.Status,
+			"deadline":   job.Deadline,
+			"status_url": "/api/ai/jobs/" + job.ID,
 		})
 	}
-	defer resp.Body.Close()
-
-	// Read response
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to read AI service response"})
+			"error": "Failed to fetch prediction job"})
 	}
+	job = latest
 
-	// Parse response
-	var predictionResp PredictionResponse
-	if err := json.Unmarshal(body, &predictionResp); err != nil {
-		fmt.Printf("Failed to parse AI response. Status: %d, Body: %s\n", resp.StatusCode, string(body))
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Failed to parse AI service response",
-			"details": string(body),
-		})
-	}
-
-	// Return the prediction result
-	if resp.StatusCode != http.StatusOK || !predictionResp.Success {
-		errorMsg := predictionResp.Error
-		if errorMsg == "" {
-			errorMsg = "Unknown error from AI service"
-		}
-		fmt.Printf("AI service error: %s (status: %d)\n", errorMsg, resp.StatusCode)
-		return c.Status(resp.StatusCode).JSON(fiber.Map{
+	if job.Status != database.JobSucceeded {
+		return c.Status(jobFailureStatus(job)).JSON(fiber.Map{
 			"success": false,
 			"error":   "AI service returned error",
-			"details": errorMsg,
+			"details": job.Error,
+			"job_id":  job.ID,
 		})
 	}
 
-	// Log the prediction for monitoring
-	fmt.Printf("✅ Disease prediction: %s (%.2f%% confidence) at %s\n",
-		predictionResp.Prediction.PredictedDisease,
-		predictionResp.Prediction.Confidence*100,
-		predictionResp.Timestamp)
+	stored, err := database.GetPrediction(DB, *job.PredictionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch prediction"})
+	}
 
-	return c.JSON(predictionResp)
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"prediction":    predictionFromStored(stored),
+		"timestamp":     stored.CreatedAt.Format(time.RFC3339),
+		"prediction_id": stored.ID,
+		"disease_info":  diseaseInfoFor(c, stored.PredictedDisease),
+		"job_id":        job.ID,
+		"outcome":       stored.Outcome,
+		"assessment":    stored.Assessment,
+		"retake":        retakeGuidance(c, stored),
+	})
 }
 
 // Utility function to validate image types
@@ -212,70 +196,3 @@ func isValidImageType(contentType string) bool {
 	}
 	return false
 }
-
-// Get disease information (educational content)
-func GetDiseaseInfoHandler(c *fiber.Ctx) error {
-	// Validate user authentication
-	if err := ValidateCookie(c); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Authentication required"})
-	}
-
-	// Disease information database
-	diseaseInfo := map[string]interface{}{
-		"healthy": map[string]string{
-			"name":        "Healthy",
-			"description": "Normal, healthy chicken droppings",
-			"symptoms":    "Firm, brown droppings with white urates",
-			"treatment":   "Continue good hygiene and regular monitoring",
-			"prevention":  "Maintain clean environment, fresh water, and proper nutrition",
-		},
-		"coccidiosis": map[string]string{
-			"name":        "Coccidiosis",
-			"description": "Parasitic infection affecting the intestinal tract",
-			"symptoms":    "Bloody or watery droppings, lethargy, poor growth",
-			"treatment":   "Anticoccidial medication, supportive care",
-			"prevention":  "Keep environment dry, avoid overcrowding, use medicated feed",
-		},
-		"salmonella": map[string]string{
-			"name":        "Salmonella",
-			"description": "Bacterial infection that can affect digestive system",
-			"symptoms":    "Watery droppings, decreased appetite, lethargy",
-			"treatment":   "Antibiotics (consult veterinarian), supportive care",
-			"prevention":  "Good hygiene, clean water, proper feed storage",
-		},
-		"e_coli": map[string]string{
-			"name":        "E. Coli Infection",
-			"description": "Bacterial infection often secondary to other conditions",
-			"symptoms":    "Watery droppings, depression, poor growth",
-			"treatment":   "Antibiotics, improve environmental conditions",
-			"prevention":  "Clean environment, good ventilation, stress reduction",
-		},
-		"newcastle": map[string]string{
-			"name":        "Newcastle Disease",
-			"description": "Viral disease affecting respiratory and nervous systems",
-			"symptoms":    "Greenish droppings, respiratory distress, neurological signs",
-			"treatment":   "Supportive care (no specific treatment), isolation",
-			"prevention":  "Vaccination, biosecurity measures, quarantine new birds",
-		},
-	}
-
-	disease := c.Query("disease")
-	if disease == "" {
-		return c.JSON(fiber.Map{
-			"diseases": diseaseInfo,
-		})
-	}
-
-	info, exists := diseaseInfo[disease]
-	if !exists {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Disease information not found",
-		})
-	}
-
-	return c.JSON(fiber.Map{
-		"disease": disease,
-		"info":    info,
-	})
-}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Disease information lives in the database so vets and admins can edit it,
+// with a translation per locale. Most farmers read Khmer, English is the
+// fallback because every disease has English content.
+
+var supportedLocales = []string{"km", "en"}
+
+const fallbackLocale = "en"
+
+func supportedLocale(locale string) bool {
+	for _, l := range supportedLocales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Locales to try for a request, most preferred first: ?lang=, then the
+// Accept-Language header by quality, then the fallback
+func requestLocales(c *fiber.Ctx) []string {
+	var locales []string
+	add := func(tag string) {
+		// "km-KH" -> "km"
+		locale := strings.ToLower(strings.TrimSpace(strings.SplitN(tag, "-", 2)[0]))
+		if !supportedLocale(locale) {
+			return
+		}
+		for _, l := range locales {
+			if l == locale {
+				return
+			}
+		}
+		locales = append(locales, locale)
+	}
+
+	add(c.Query("lang"))
+
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		fields := strings.Split(part, ";")
+		w := weighted{tag: fields[0], quality: 1}
+		for _, param := range fields[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil {
+					w.quality = v
+				}
+			}
+		}
+		if w.quality > 0 {
+			accepted = append(accepted, w)
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+	for _, w := range accepted {
+		add(w.tag)
+	}
+
+	add(fallbackLocale)
+	return locales
+}
+
+// Localized information about a predicted disease, nil when the knowledge
+// base has no entry for it
+func diseaseInfoFor(c *fiber.Ctx, key string) *database.LocalizedDisease {
+	disease, err := database.GetDisease(DB, key)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to fetch disease info:", err)
+		}
+		return nil
+	}
+	info, ok := database.Localize(disease, requestLocales(c))
+	if !ok {
+		return nil
+	}
+	return &info
+}
+
+// Localized information about several diseases, keyed by disease
+func diseaseInfoMap(c *fiber.Ctx, keys []string) map[string]database.LocalizedDisease {
+	infos := map[string]database.LocalizedDisease{}
+	diseases, err := database.ListDiseases(DB)
+	if err != nil {
+		log.Println("Failed to fetch disease info:", err)
+		return infos
+	}
+
+	locales := requestLocales(c)
+	for _, d := range diseases {
+		for _, key := range keys {
+			if d.Key != key {
+				continue
+			}
+			if info, ok := database.Localize(d, locales); ok {
+				infos[key] = info
+			}
+		}
+	}
+	return infos
+}
+
+// Get disease information (educational content): ?disease=&lang=
+func GetDiseaseInfoHandler(c *fiber.Ctx) error {
+	// Validate user authentication
+	if err := ValidateCookie(c); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required"})
+	}
+
+	diseases, err := database.ListDiseases(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch disease information"})
+	}
+
+	locales := requestLocales(c)
+	diseaseInfo := map[string]database.LocalizedDisease{}
+	for _, d := range diseases {
+		if info, ok := database.Localize(d, locales); ok {
+			diseaseInfo[d.Key] = info
+		}
+	}
+
+	disease := c.Query("disease")
+	if disease == "" {
+		return c.JSON(fiber.Map{
+			"diseases": diseaseInfo,
+			"locale":   locales[0],
+		})
+	}
+
+	info, exists := diseaseInfo[disease]
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Disease information not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"disease": disease,
+		"info":    info,
+	})
+}
+
+// ====== KNOWLEDGE BASE ADMINISTRATION ====== //
+
+// Every disease with all its translations
+func ListDiseasesHandler(c *fiber.Ctx) error {
+	diseases, err := database.ListDiseases(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch diseases"})
+	}
+	return c.JSON(fiber.Map{"diseases": diseases, "locales": supportedLocales})
+}
+
+func GetDiseaseHandler(c *fiber.Ctx) error {
+	disease, err := database.GetDisease(DB, c.Params("key"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Disease not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch disease"})
+	}
+	return c.JSON(fiber.Map{"disease": disease})
+}
+
+// Create or replace a disease. The key is the class name of the AI model.
+func PutDiseaseHandler(c *fiber.Ctx) error {
+	var req struct {
+		Severity      string                                 `json:"severity"`
+		MinConfidence float64                                `json:"min_confidence"`
+		Translations  map[string]database.DiseaseTranslation `json:"translations"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	key := c.Params("key")
+	if !datasetLabel.MatchString(key) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid disease key"})
+	}
+	if req.Severity == "" {
+		req.Severity = database.SeverityMedium
+	}
+	if !database.ValidSeverity(req.Severity) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "severity must be none, low, medium or high"})
+	}
+	if req.MinConfidence < 0 || req.MinConfidence > 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "min_confidence must be between 0 and 1",
+			"hint":  "Use 0 for the default threshold",
+		})
+	}
+	if _, ok := req.Translations[fallbackLocale]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "An English translation is required",
+			"hint":  "English is shown when no translation exists for the user's language",
+		})
+	}
+	for locale, t := range req.Translations {
+		if !supportedLocale(locale) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":     "Unsupported locale: " + locale,
+				"supported": supportedLocales,
+			})
+		}
+		if strings.TrimSpace(t.Name) == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name is required for locale " + locale})
+		}
+	}
+
+	disease := database.Disease{
+		Key:           key,
+		Severity:      req.Severity,
+		MinConfidence: req.MinConfidence,
+		Translations:  req.Translations,
+	}
+	if err := database.UpsertDisease(DB, disease); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save disease"})
+	}
+
+	saved, err := database.GetDisease(DB, key)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch disease"})
+	}
+	return c.JSON(fiber.Map{"message": "Disease saved successfully", "disease": saved})
+}
+
+func DeleteDiseaseHandler(c *fiber.Ctx) error {
+	err := database.DeleteDisease(DB, c.Params("key"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Disease not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete disease"})
+	}
+	return c.JSON(fiber.Map{"message": "Disease deleted successfully"})
+}
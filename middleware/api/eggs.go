@@ -0,0 +1,370 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Layer flocks record the eggs collected each day. Production is measured as
+// hen-day percentage and compared with a rolling baseline. A drop raises a
+// finding, with the temperature of the house as heat stress is the most
+// common cause.
+
+const findingEggDrop = "egg_production_drop"
+
+// Temperature above which layers are heat stressed
+const heatStressTemperature = 30.0
+
+// Size grades eggs can be sorted into
+var eggGrades = []string{"small", "medium", "large", "extra_large"}
+
+var eggDropConfig = getEggDropConfig()
+
+// Drop alert threshold and baseline length. The baseline needs at least three
+// recorded days.
+func getEggDropConfig() analysis.DropConfig {
+	cfg := analysis.DropConfig{BaselineDays: 7, MinBaselineDays: 3, DropPercent: 10}
+	if v, err := strconv.ParseFloat(os.Getenv("EGG_DROP_ALERT_PERCENT"), 64); err == nil && v > 0 {
+		cfg.DropPercent = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("EGG_BASELINE_DAYS")); err == nil && n >= cfg.MinBaselineDays {
+		cfg.BaselineDays = n
+	}
+	return cfg
+}
+
+func validEggGrade(grade string) bool {
+	for _, g := range eggGrades {
+		if g == grade {
+			return true
+		}
+	}
+	return false
+}
+
+type eggCollectionRequest struct {
+	Day     string         `json:"day"` // Defaults to today
+	Total   int            `json:"total"`
+	Cracked int            `json:"cracked"`
+	Dirty   int            `json:"dirty"`
+	Grades  map[string]int `json:"grades"`
+	Notes   string         `json:"notes"`
+}
+
+// Laying curve of a flock over the last days. The baseline of the first days
+// comes from the collections before them.
+func flockLaying(flock database.Flock, days int) ([]database.EggCollection, []analysis.LayingDay, error) {
+	from := analysis.StartOfDay(time.Now()).AddDate(0, 0, -days+1)
+	collections, err := database.ListEggCollections(DB, flock.ID,
+		from.AddDate(0, 0, -eggDropConfig.BaselineDays).Format(dayFormat))
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := database.ListMortalityRecords(DB, flock.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byDay := map[string]*analysis.DailyEggs{}
+	for _, e := range collections {
+		d, ok := byDay[e.Day]
+		if !ok {
+			day, err := time.ParseInLocation(dayFormat, e.Day, time.Local)
+			if err != nil {
+				return nil, nil, err
+			}
+			d = &analysis.DailyEggs{Day: day, Hens: liveCount(flock, records, e.Day, false)}
+			byDay[e.Day] = d
+		}
+		d.Eggs += e.Total
+		d.Cracked += e.Cracked
+		d.Dirty += e.Dirty
+	}
+	daily := make([]analysis.DailyEggs, 0, len(byDay))
+	for _, d := range byDay {
+		daily = append(daily, *d)
+	}
+
+	laying := []analysis.LayingDay{}
+	for _, l := range analysis.LayingRates(daily, eggDropConfig) {
+		if !l.Day.Before(from) {
+			laying = append(laying, l)
+		}
+	}
+
+	inWindow := []database.EggCollection{}
+	for _, e := range collections {
+		if e.Day >= from.Format(dayFormat) {
+			inWindow = append(inWindow, e)
+		}
+	}
+	return inWindow, laying, nil
+}
+
+// Correlate the daily hen-day production with the highest temperature of the
+// same and of the previous day, heat cuts laying with a lag
+func heatContext(flock database.Flock, laying []analysis.LayingDay) (fiber.Map, error) {
+	result := fiber.Map{"heat_stress_temperature": heatStressTemperature}
+	if len(laying) == 0 {
+		return result, nil
+	}
+
+	from := laying[0].Day.AddDate(0, 0, -1)
+	to := laying[len(laying)-1].Day.AddDate(0, 0, 1)
+	readings, err := database.ListFlockTelemetry(DB, flock.ID, from, to)
+	if err != nil || len(readings) == 0 {
+		return result, err
+	}
+
+	maxTemperature := map[time.Time]float64{}
+	for _, r := range readings {
+		day := analysis.StartOfDay(r.RecordedAt)
+		if t, ok := maxTemperature[day]; !ok || r.Temperature > t {
+			maxTemperature[day] = r.Temperature
+		}
+	}
+
+	var henDay, sameDay, prevHenDay, previousDay []float64
+	hotDays := 0
+	for _, l := range laying {
+		if t, ok := maxTemperature[l.Day]; ok {
+			henDay = append(henDay, l.HenDay)
+			sameDay = append(sameDay, t)
+			if t >= heatStressTemperature {
+				hotDays++
+			}
+		}
+		if t, ok := maxTemperature[l.Day.AddDate(0, 0, -1)]; ok {
+			prevHenDay = append(prevHenDay, l.HenDay)
+			previousDay = append(previousDay, t)
+		}
+	}
+	result["days"] = len(henDay)
+	result["hot_days"] = hotDays
+	if r, ok := analysis.Pearson(sameDay, henDay); ok {
+		result["correlation_same_day"] = r
+	}
+	if r, ok := analysis.Pearson(previousDay, prevHenDay); ok {
+		result["correlation_previous_day"] = r
+	}
+
+	// Heat over the last two days of the curve
+	last := laying[len(laying)-1].Day
+	recent := math.Inf(-1)
+	for _, day := range []time.Time{last, last.AddDate(0, 0, -1)} {
+		if t, ok := maxTemperature[day]; ok {
+			recent = math.Max(recent, t)
+		}
+	}
+	if !math.IsInf(recent, -1) {
+		result["recent_max_temperature"] = recent
+		result["heat_stress_likely"] = recent >= heatStressTemperature
+	}
+	return result, nil
+}
+
+// Raise a finding when the production of a day drops below its baseline.
+// The finding of a day is updated as more collections come in.
+func checkEggDropAlert(flock database.Flock, day string, laying []analysis.LayingDay, heat fiber.Map) (*database.Finding, error) {
+	var today *analysis.LayingDay
+	for i := range laying {
+		if laying[i].Day.Format(dayFormat) == day {
+			today = &laying[i]
+		}
+	}
+	if today == nil || !today.Alert {
+		return nil, nil
+	}
+
+	severity := "medium"
+	if *today.Drop >= 2*eggDropConfig.DropPercent {
+		severity = "high"
+	}
+	summary := fmt.Sprintf("Egg production of flock %s fell to %.1f%% hen-day on %s, %.1f%% below its %.1f%% baseline",
+		flock.Name, today.HenDay, day, *today.Drop, *today.Baseline)
+	if likely, _ := heat["heat_stress_likely"].(bool); likely {
+		summary += fmt.Sprintf(", the house reached %.1f°C", heat["recent_max_temperature"])
+	}
+	finding := database.Finding{
+		Kind:     findingEggDrop,
+		House:    flock.House,
+		Subject:  fmt.Sprintf("flock %d %s", flock.ID, day),
+		Severity: severity,
+		Status:   database.FindingOpen,
+		Summary:  summary,
+		Details: map[string]interface{}{
+			"flock":    flock.ID,
+			"age_days": flock.AgeOn(today.Day),
+			"laying":   today,
+			"heat":     heat,
+		},
+		WindowStart: today.Day,
+		WindowEnd:   today.Day.AddDate(0, 0, 1),
+		FlockID:     &flock.ID,
+	}
+
+	var err error
+	finding.ID, err = database.UpsertFinding(DB, finding)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Egg production finding #%d: %s", finding.ID, finding.Summary)
+	return &finding, nil
+}
+
+// ====== EGG HANDLERS ====== //
+
+// Record an egg collection
+func CreateEggCollectionHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req eggCollectionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Total < 0 || req.Cracked < 0 || req.Dirty < 0 || req.Cracked > req.Total || req.Dirty > req.Total {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "total, cracked and dirty cannot be negative, and cracked and dirty cannot exceed total",
+		})
+	}
+	graded := 0
+	for grade, count := range req.Grades {
+		if !validEggGrade(grade) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":     "Unknown grade: " + grade,
+				"supported": eggGrades,
+			})
+		}
+		if count < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Grade counts cannot be negative"})
+		}
+		graded += count
+	}
+	if graded > req.Total {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Graded eggs cannot exceed total"})
+	}
+
+	day, errBody := flockDay(flock, req.Day)
+	if errBody != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errBody)
+	}
+
+	collection := database.EggCollection{
+		FlockID:    flock.ID,
+		Day:        day.Format(dayFormat),
+		Total:      req.Total,
+		Cracked:    req.Cracked,
+		Dirty:      req.Dirty,
+		Grades:     req.Grades,
+		Notes:      req.Notes,
+		RecordedBy: userID,
+		CreatedAt:  time.Now().UTC(),
+	}
+	collection.ID, err = database.CreateEggCollection(DB, collection)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save egg collection"})
+	}
+
+	// Check the day against the baseline before it
+	days := int(math.Round(analysis.StartOfDay(time.Now()).Sub(day).Hours()/24)) + 1
+	_, laying, err := flockLaying(flock, days)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to compute production"})
+	}
+	heat, err := heatContext(flock, laying[:1])
+	if err != nil {
+		log.Println("Failed to fetch telemetry for egg production:", err)
+	}
+	finding, err := checkEggDropAlert(flock, collection.Day, laying, heat)
+	if err != nil {
+		log.Println("Failed to raise egg production finding:", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Egg collection recorded successfully",
+		"collection": collection,
+		"production": laying[0],
+		"alert":      finding,
+	})
+}
+
+// Egg production of a flock: daily hen-day percentage against its baseline,
+// weekly trend and correlation with temperature. ?days=56
+func GetEggProductionHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	days := c.QueryInt("days", 56)
+	if days < 1 || days > 365 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	collections, laying, err := flockLaying(flock, days)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to compute production"})
+	}
+	heat, err := heatContext(flock, laying)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch telemetry"})
+	}
+	weeks := analysis.WeeklyLaying(laying, func(day time.Time) int { return flock.AgeOn(day)/7 + 1 })
+
+	grades := map[string]int{}
+	for _, e := range collections {
+		for grade, count := range e.Grades {
+			grades[grade] += count
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"flock_id":    flock.ID,
+		"daily":       laying,
+		"weekly":      weeks,
+		"grades":      grades,
+		"heat":        heat,
+		"collections": collections,
+		"alert_config": fiber.Map{
+			"drop_percent":  eggDropConfig.DropPercent,
+			"baseline_days": eggDropConfig.BaselineDays,
+		},
+	})
+}
+
+func DeleteEggCollectionHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	collectionID, err := c.ParamsInt("collectionId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid collection ID"})
+	}
+
+	err = database.DeleteEggCollection(DB, flock.ID, collectionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Egg collection not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete egg collection"})
+	}
+	return c.JSON(fiber.Map{"message": "Egg collection deleted successfully"})
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The farm layout is farm → house → zone → sensor. A house is known
+// elsewhere by its name, so a house whose name is used by flocks or tasks
+// cannot be renamed.
+
+type zoneLayout struct {
+	database.Zone
+	Sensors []database.Sensor `json:"sensors"`
+}
+
+type houseLayout struct {
+	database.House
+	Zones   []*zoneLayout     `json:"zones"`
+	Sensors []database.Sensor `json:"sensors"` // Not placed in a zone
+}
+
+type farmLayout struct {
+	database.Farm
+	Houses []*houseLayout `json:"houses"`
+}
+
+// Every farm with its houses, zones and sensors
+func GetFarmLayoutHandler(c *fiber.Ctx) error {
+	farms, err := database.ListFarms(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch farms"})
+	}
+	houses, err := database.ListHouses(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch houses"})
+	}
+	zones, err := database.ListZones(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch zones"})
+	}
+	sensors, err := database.ListSensors(DB, database.SensorFilter{})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sensors"})
+	}
+
+	layout := make([]*farmLayout, 0, len(farms))
+	farmByID := map[int]*farmLayout{}
+	for _, f := range farms {
+		farmByID[f.ID] = &farmLayout{Farm: f, Houses: []*houseLayout{}}
+		layout = append(layout, farmByID[f.ID])
+	}
+	houseByID := map[int]*houseLayout{}
+	for _, h := range houses {
+		houseByID[h.ID] = &houseLayout{House: h, Zones: []*zoneLayout{}, Sensors: []database.Sensor{}}
+		if farm := farmByID[h.FarmID]; farm != nil {
+			farm.Houses = append(farm.Houses, houseByID[h.ID])
+		}
+	}
+	zoneByID := map[int]*zoneLayout{}
+	for _, z := range zones {
+		zoneByID[z.ID] = &zoneLayout{Zone: z, Sensors: []database.Sensor{}}
+		if house := houseByID[z.HouseID]; house != nil {
+			house.Zones = append(house.Zones, zoneByID[z.ID])
+		}
+	}
+	for _, s := range sensors {
+		if s.ZoneID != nil && zoneByID[*s.ZoneID] != nil {
+			zone := zoneByID[*s.ZoneID]
+			zone.Sensors = append(zone.Sensors, s)
+		} else if house := houseByID[s.HouseID]; house != nil {
+			house.Sensors = append(house.Sensors, s)
+		}
+	}
+	return c.JSON(layout)
+}
+
+// Respond to a failed delete of a farm, house or zone
+func deleteLayoutResponse(c *fiber.Ctx, err error, what, children string) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": what + " not found"})
+	case errors.Is(err, database.ErrInUse):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": what + " still has " + children,
+			"hint":  "Move or delete its " + children + " first",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete " + strings.ToLower(what)})
+	}
+	return c.JSON(fiber.Map{"message": what + " deleted successfully"})
+}
+
+// ====== FARMS ====== //
+
+type farmRequest struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	Notes    string `json:"notes"`
+}
+
+func (req farmRequest) farm(id int) (database.Farm, fiber.Map) {
+	f := database.Farm{ID: id, Name: strings.TrimSpace(req.Name), Location: req.Location, Notes: req.Notes}
+	if f.Name == "" {
+		return f, fiber.Map{"error": "Name is required"}
+	}
+	return f, nil
+}
+
+func CreateFarmHandler(c *fiber.Ctx) error {
+	var req farmRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	farm, body := req.farm(0)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+	id, err := database.CreateFarm(DB, farm)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create farm"})
+	}
+	created, err := database.GetFarm(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch farm"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Farm created successfully", "farm": created})
+}
+
+func UpdateFarmHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid farm ID"})
+	}
+	var req farmRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	farm, body := req.farm(id)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	err = database.UpdateFarm(DB, farm)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Farm not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update farm"})
+	}
+	updated, err := database.GetFarm(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch farm"})
+	}
+	return c.JSON(fiber.Map{"message": "Farm updated successfully", "farm": updated})
+}
+
+func DeleteFarmHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid farm ID"})
+	}
+	return deleteLayoutResponse(c, database.DeleteFarm(DB, id), "Farm", "houses")
+}
+
+// ====== HOUSES ====== //
+
+type houseRequest struct {
+	FarmID     int    `json:"farm_id"` // Only read on update, to move a house
+	Name       string `json:"name"`
+	Controller string `json:"controller"`
+	Notes      string `json:"notes"`
+}
+
+// Validate a house. On failure the status and body of the response are
+// returned.
+func (req houseRequest) house(id, farmID int) (database.House, int, fiber.Map) {
+	h := database.House{
+		ID:         id,
+		FarmID:     farmID,
+		Name:       strings.TrimSpace(req.Name),
+		Controller: strings.TrimRight(strings.TrimSpace(req.Controller), "/"),
+		Notes:      req.Notes,
+	}
+	if h.Name == "" {
+		return h, fiber.StatusBadRequest, fiber.Map{"error": "Name is required"}
+	}
+	if _, err := database.GetFarm(DB, farmID); err != nil {
+		return h, fiber.StatusBadRequest, fiber.Map{"error": "Unknown farm"}
+	}
+	existing, err := database.GetHouseByName(DB, h.Name)
+	if err == nil && existing.ID != id {
+		return h, fiber.StatusConflict, fiber.Map{"error": "A house with this name already exists"}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return h, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch house"}
+	}
+	return h, 0, nil
+}
+
+func CreateHouseHandler(c *fiber.Ctx) error {
+	farmID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid farm ID"})
+	}
+	var req houseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	house, status, body := req.house(0, farmID)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	id, err := database.CreateHouse(DB, house)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create house"})
+	}
+	created, err := database.GetHouse(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch house"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "House created successfully", "house": created})
+}
+
+func UpdateHouseHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid house ID"})
+	}
+	existing, err := database.GetHouse(DB, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "House not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch house"})
+	}
+
+	var req houseRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.FarmID == 0 {
+		req.FarmID = existing.FarmID
+	}
+	house, status, body := req.house(id, req.FarmID)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if house.Name != existing.Name {
+		inUse, err := database.HouseNameInUse(DB, existing.Name)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch house"})
+		}
+		if inUse {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "House name is used by flocks or tasks",
+				"hint":  "Records keep the house name they were made with",
+			})
+		}
+	}
+
+	if err := database.UpdateHouse(DB, house); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update house"})
+	}
+	updated, err := database.GetHouse(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch house"})
+	}
+	return c.JSON(fiber.Map{"message": "House updated successfully", "house": updated})
+}
+
+func DeleteHouseHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid house ID"})
+	}
+	return deleteLayoutResponse(c, database.DeleteHouse(DB, id), "House", "zones and sensors")
+}
+
+// ====== ZONES ====== //
+
+type zoneRequest struct {
+	Name  string `json:"name"`
+	Notes string `json:"notes"`
+}
+
+// Validate a zone. On failure the status and body of the response are
+// returned.
+func (req zoneRequest) zone(id, houseID int) (database.Zone, int, fiber.Map) {
+	z := database.Zone{ID: id, HouseID: houseID, Name: strings.TrimSpace(req.Name), Notes: req.Notes}
+	if z.Name == "" {
+		return z, fiber.StatusBadRequest, fiber.Map{"error": "Name is required"}
+	}
+	existing, err := database.GetZoneByName(DB, houseID, z.Name)
+	if err == nil && existing.ID != id {
+		return z, fiber.StatusConflict, fiber.Map{"error": "A zone with this name already exists in the house"}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return z, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch zone"}
+	}
+	return z, 0, nil
+}
+
+func CreateZoneHandler(c *fiber.Ctx) error {
+	houseID, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid house ID"})
+	}
+	_, err = database.GetHouse(DB, houseID)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "House not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch house"})
+	}
+	var req zoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	zone, status, body := req.zone(0, houseID)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	id, err := database.CreateZone(DB, zone)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create zone"})
+	}
+	created, err := database.GetZone(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch zone"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Zone created successfully", "zone": created})
+}
+
+func UpdateZoneHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid zone ID"})
+	}
+	existing, err := database.GetZone(DB, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Zone not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch zone"})
+	}
+	var req zoneRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	zone, status, body := req.zone(id, existing.HouseID)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	if err := database.UpdateZone(DB, zone); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update zone"})
+	}
+	updated, err := database.GetZone(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch zone"})
+	}
+	return c.JSON(fiber.Map{"message": "Zone updated successfully", "zone": updated})
+}
+
+func DeleteZoneHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid zone ID"})
+	}
+	return deleteLayoutResponse(c, database.DeleteZone(DB, id), "Zone", "sensors")
+}
@@ -0,0 +1,342 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Feed is recorded by hand as deliveries, and estimated from how long the
+// feeders and pumps ran. Their state changes are recorded when they are
+// switched through the middleware, and polled from the controllers to catch
+// auto mode.
+
+// Functions of the actuators whose run time is metered
+var meteredFunctions = []string{"feeder", "pump"}
+
+// Device event sources
+const (
+	deviceSourceToggle = "toggle"
+	deviceSourcePoll   = "poll"
+)
+
+// Day-old chick weight used as the starting weight when a flock placed at
+// day 0 was not weighed on placement
+const dayOldChickWeight = 40.0 // Grams
+
+var (
+	feederGramsPerSecond = getCalibration("FEEDER_GRAMS_PER_SECOND")
+	pumpLitresPerMinute  = getCalibration("PUMP_LITRES_PER_MINUTE")
+)
+
+// Feeder and pump flow rates. Without calibration consumption is not
+// estimated.
+func getCalibration(key string) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && v > 0 {
+		return v
+	}
+	return 0
+}
+
+func isMetered(function string) bool {
+	return oneOf(function, meteredFunctions)
+}
+
+// Record the state of a metered actuator when it changes
+func recordDeviceState(address, device string, on bool, source string) {
+	last, err := database.LastDeviceState(DB, address, device)
+	if err == nil && last == on {
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Failed to fetch device state:", err)
+		return
+	}
+
+	now := time.Now()
+	err = database.SaveDeviceEvent(DB, database.DeviceEvent{
+		Controller: address,
+		Device:     device,
+		On:         on,
+		Source:     source,
+		ChangedAt:  now,
+		FlockID:    database.ActiveFlockID(DB, "", address, now),
+	})
+	if err != nil {
+		log.Println("Failed to record device state:", err)
+	}
+}
+
+// ====== CONSUMPTION ====== //
+
+// Run time of the feeders and pumps of a flock's controller, with the feed
+// and water it amounts to
+type consumptionEstimate struct {
+	FeederSeconds        float64  `json:"feeder_seconds"`
+	FeedKg               *float64 `json:"feed_kg"` // Nil without calibration
+	PumpSeconds          float64  `json:"pump_seconds"`
+	WaterLitres          *float64 `json:"water_litres"`
+	FeederGramsPerSecond float64  `json:"feeder_grams_per_second"`
+	PumpLitresPerMinute  float64  `json:"pump_litres_per_minute"`
+}
+
+func estimateConsumption(flock database.Flock, from, to time.Time) (*consumptionEstimate, error) {
+	if flock.Controller == "" {
+		return nil, nil
+	}
+
+	actuators, err := database.ListActuators(DB, database.ActuatorFilter{})
+	if err != nil {
+		return nil, err
+	}
+	run := map[string]time.Duration{}
+	for _, a := range actuators {
+		if !isMetered(a.Function) || actuatorController(a) != flock.Controller {
+			continue
+		}
+		changes, err := database.DeviceStateChanges(DB, flock.Controller, a.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		run[a.Function] += analysis.OnDuration(changes, from, to)
+	}
+
+	e := &consumptionEstimate{
+		FeederSeconds:        run["feeder"].Seconds(),
+		PumpSeconds:          run["pump"].Seconds(),
+		FeederGramsPerSecond: feederGramsPerSecond,
+		PumpLitresPerMinute:  pumpLitresPerMinute,
+	}
+	if feederGramsPerSecond > 0 {
+		feed := e.FeederSeconds * feederGramsPerSecond / 1000
+		e.FeedKg = &feed
+	}
+	if pumpLitresPerMinute > 0 {
+		water := e.PumpSeconds / 60 * pumpLitresPerMinute
+		e.WaterLitres = &water
+	}
+	return e, nil
+}
+
+// End of a flock's records, its close-out or now
+func flockEnd(flock database.Flock) time.Time {
+	if flock.ClosedAt != nil {
+		return *flock.ClosedAt
+	}
+	return time.Now()
+}
+
+// Feed conversion between the first and last weighing of a flock. The
+// biomass is the average weight times the live birds that day. Delivered
+// feed is used when there is any, the feeder estimate otherwise.
+func flockFeedConversion(flock database.Flock, deliveries []database.FeedDelivery) (fiber.Map, error) {
+	samples, err := database.ListWeightSamples(DB, flock.ID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := database.ListMortalityRecords(DB, flock.ID)
+	if err != nil {
+		return nil, err
+	}
+	biomass := func(s database.WeightSample) float64 {
+		return s.AverageWeight * float64(liveCount(flock, records, s.Day, true)) / 1000
+	}
+
+	if len(samples) == 0 {
+		return fiber.Map{"fcr": nil, "hint": "Record a weight sample to compute the feed conversion ratio"}, nil
+	}
+	last := samples[len(samples)-1]
+	end, err := time.ParseInLocation(dayFormat, last.Day, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	end = end.AddDate(0, 0, 1)
+
+	// Day-old chicks start at the chick weight unless weighed on placement.
+	// Older birds start at their first weighing.
+	start := analysis.StartOfDay(flock.PlacedAt)
+	startBiomass := float64(flock.InitialCount) * dayOldChickWeight / 1000
+	first := samples[0]
+	switch {
+	case first.Day == start.Format(dayFormat):
+		startBiomass = biomass(first)
+	case flock.PlacementAge > 0:
+		if len(samples) < 2 {
+			return fiber.Map{"fcr": nil, "hint": "Record a second weight sample to compute the feed conversion ratio"}, nil
+		}
+		if start, err = time.ParseInLocation(dayFormat, first.Day, time.Local); err != nil {
+			return nil, err
+		}
+		startBiomass = biomass(first)
+	}
+
+	feedKg, source := 0.0, "deliveries"
+	for _, d := range deliveries {
+		if !d.DeliveredAt.Before(start) && d.DeliveredAt.Before(end) {
+			feedKg += d.QuantityKg
+		}
+	}
+	if len(deliveries) == 0 {
+		estimate, err := estimateConsumption(flock, start, end)
+		if err != nil {
+			return nil, err
+		}
+		if estimate == nil || estimate.FeedKg == nil {
+			return fiber.Map{"fcr": nil, "hint": "Record feed deliveries, or calibrate the feeder, to compute the feed conversion ratio"}, nil
+		}
+		feedKg, source = *estimate.FeedKg, "feeder"
+	}
+
+	conversion := analysis.FeedConversionRatio(feedKg, startBiomass, biomass(last))
+	return fiber.Map{
+		"fcr":         conversion.FCR,
+		"conversion":  conversion,
+		"feed_source": source,
+		"from":        start,
+		"to":          end,
+	}, nil
+}
+
+// ====== FEED HANDLERS ====== //
+
+type feedDeliveryRequest struct {
+	DeliveredAt string  `json:"delivered_at"` // Date or RFC 3339 timestamp, defaults to now
+	QuantityKg  float64 `json:"quantity_kg"`
+	FeedType    string  `json:"feed_type"`
+	Notes       string  `json:"notes"`
+}
+
+// Record feed put into the feeders of a flock
+func CreateFeedDeliveryHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req feedDeliveryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.QuantityKg <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "quantity_kg must be positive"})
+	}
+	deliveredAt := time.Now()
+	if req.DeliveredAt != "" {
+		if deliveredAt, err = parseTime(req.DeliveredAt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "delivered_at must be a date or RFC 3339 timestamp"})
+		}
+	}
+	if !withinFlock(flock, deliveredAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "delivered_at must be between the placement and close-out of the flock, and not in the future",
+		})
+	}
+
+	delivery := database.FeedDelivery{
+		FlockID:     flock.ID,
+		DeliveredAt: deliveredAt,
+		QuantityKg:  req.QuantityKg,
+		FeedType:    strings.ToLower(strings.TrimSpace(req.FeedType)),
+		Notes:       req.Notes,
+		RecordedBy:  userID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	delivery.ID, err = database.CreateFeedDelivery(DB, delivery)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save feed delivery"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Feed delivery recorded successfully",
+		"delivery": delivery,
+	})
+}
+
+// Feed and water of a flock: deliveries, estimated consumption and feed
+// conversion ratio. ?from=&to= narrow the estimate, by default the whole
+// flock.
+func GetFeedHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid from date"})
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid to date"})
+	}
+	if from.IsZero() || from.Before(flock.PlacedAt) {
+		from = flock.PlacedAt
+	}
+	if end := flockEnd(flock); to.IsZero() || to.After(end) {
+		to = end
+	}
+
+	deliveries, err := database.ListFeedDeliveries(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch feed deliveries"})
+	}
+	delivered := 0.0
+	for _, d := range deliveries {
+		delivered += d.QuantityKg
+	}
+
+	estimate, err := estimateConsumption(flock, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to estimate consumption"})
+	}
+	conversion, err := flockFeedConversion(flock, deliveries)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to compute feed conversion"})
+	}
+
+	response := fiber.Map{
+		"flock_id":        flock.ID,
+		"deliveries":      deliveries,
+		"delivered_kg":    delivered,
+		"estimated":       estimate,
+		"estimate_from":   from,
+		"estimate_to":     to,
+		"feed_conversion": conversion,
+	}
+	if estimate == nil {
+		response["hint"] = "Set the flock's controller to estimate consumption from the feeder and pump"
+	}
+	return c.JSON(response)
+}
+
+func DeleteFeedDeliveryHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	deliveryID, err := c.ParamsInt("deliveryId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid delivery ID"})
+	}
+
+	err = database.DeleteFeedDelivery(DB, flock.ID, deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Feed delivery not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete feed delivery"})
+	}
+	return c.JSON(fiber.Map{"message": "Feed delivery deleted successfully"})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+)
+
+func TestFlockFeedConversion(t *testing.T) {
+	day := func(d int, hour int) time.Time { return time.Date(2025, 3, d, hour, 0, 0, 0, time.Local) }
+	deliveries := []database.FeedDelivery{
+		{DeliveredAt: day(1, 12), QuantityKg: 200},
+		{DeliveredAt: day(10, 8), QuantityKg: 400},
+		{DeliveredAt: day(16, 8), QuantityKg: 100}, // After the last weighing
+	}
+
+	tests := []struct {
+		name         string
+		placementAge int
+		samples      map[string]float64 // Average weight in grams by day
+		deaths       map[string]int
+		wantStart    float64
+		wantEnd      float64
+		wantFeed     float64
+		wantHint     string
+	}{
+		{
+			name:      "day-old chicks start at the chick weight",
+			samples:   map[string]float64{"2025-03-08": 180, "2025-03-15": 450},
+			deaths:    map[string]int{"2025-03-15": 10},
+			wantStart: 40, wantEnd: 445.5, wantFeed: 600,
+		},
+		{
+			name:      "weighed on placement",
+			samples:   map[string]float64{"2025-03-01": 42, "2025-03-15": 450},
+			wantStart: 42, wantEnd: 450, wantFeed: 600,
+		},
+		{
+			name:         "older birds start at the first weighing",
+			placementAge: 20,
+			samples:      map[string]float64{"2025-03-05": 800, "2025-03-15": 1500},
+			deaths:       map[string]int{"2025-03-03": 20},
+			wantStart:    784, wantEnd: 1470, wantFeed: 400,
+		},
+		{
+			name:         "older birds weighed once",
+			placementAge: 20,
+			samples:      map[string]float64{"2025-03-05": 800},
+			wantHint:     "Record a second weight sample to compute the feed conversion ratio",
+		},
+		{
+			name:     "never weighed",
+			wantHint: "Record a weight sample to compute the feed conversion ratio",
+		},
+	}
+	for _, tt := range tests {
+		flock := database.Flock{
+			Name:         tt.name,
+			House:        "feed-test",
+			PlacedAt:     day(1, 10),
+			PlacementAge: tt.placementAge,
+			InitialCount: 1000,
+		}
+		id, err := database.CreateFlock(DB, flock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flock.ID = id
+		for d, weight := range tt.samples {
+			if _, err := database.CreateWeightSample(DB, database.WeightSample{FlockID: id, Day: d, AverageWeight: weight, SampleSize: 50}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for d, deaths := range tt.deaths {
+			if _, err := database.CreateMortalityRecord(DB, database.MortalityRecord{FlockID: id, Day: d, Deaths: deaths}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := flockFeedConversion(flock, deliveries)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.wantHint != "" {
+			if result["fcr"] != nil || result["hint"] != tt.wantHint {
+				t.Errorf("%s: result %v, want hint %q", tt.name, result, tt.wantHint)
+			}
+			continue
+		}
+
+		conversion, ok := result["conversion"].(analysis.FeedConversion)
+		if !ok {
+			t.Fatalf("%s: result %v without a conversion", tt.name, result)
+		}
+		if conversion.StartBiomassKg != tt.wantStart || conversion.EndBiomassKg != tt.wantEnd || conversion.FeedKg != tt.wantFeed {
+			t.Errorf("%s: biomass %v to %v kg with %v kg of feed, want %v to %v kg with %v kg",
+				tt.name, conversion.StartBiomassKg, conversion.EndBiomassKg, conversion.FeedKg, tt.wantStart, tt.wantEnd, tt.wantFeed)
+		}
+		if want := tt.wantFeed / (tt.wantEnd - tt.wantStart); conversion.FCR == nil || math.Abs(*conversion.FCR-want) > 1e-9 {
+			t.Errorf("%s: FCR = %v, want %v", tt.name, conversion.FCR, want)
+		}
+		if result["feed_source"] != "deliveries" {
+			t.Errorf("%s: feed source %v, want deliveries", tt.name, result["feed_source"])
+		}
+	}
+}
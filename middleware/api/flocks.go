@@ -0,0 +1,310 @@
+package api
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A house holds one flock at a time. The data recorded for the house, or by
+// the flock's controller, while the flock is placed is linked to it.
+
+type flockRequest struct {
+	Name         string `json:"name"`
+	Breed        string `json:"breed"`
+	House        string `json:"house"`
+	Controller   string `json:"controller"`
+	PlacedAt     string `json:"placed_at"` // Date or RFC 3339 timestamp
+	PlacementAge int    `json:"placement_age_days"`
+	InitialCount int    `json:"initial_count"`
+	ClosedAt     string `json:"closed_at"`
+	Notes        string `json:"notes"`
+	Force        bool   `json:"force"` // Close out during a withdrawal period, see CloseFlockHandler
+}
+
+// Validate a request into a flock. On failure the returned map is the body of
+// a 400 or 409 response.
+func (req flockRequest) flock(id int) (database.Flock, int, fiber.Map) {
+	f := database.Flock{
+		ID:           id,
+		Name:         strings.TrimSpace(req.Name),
+		Breed:        strings.TrimSpace(req.Breed),
+		House:        strings.TrimSpace(req.House),
+		Controller:   strings.TrimSpace(req.Controller),
+		PlacementAge: req.PlacementAge,
+		InitialCount: req.InitialCount,
+		Notes:        req.Notes,
+	}
+	if f.Name == "" {
+		return f, fiber.StatusBadRequest, fiber.Map{"error": "Name is required"}
+	}
+	if f.House == "" && f.Controller == "" {
+		return f, fiber.StatusBadRequest, fiber.Map{
+			"error": "House or controller is required",
+			"hint":  "Data is linked to the flock through its house or controller",
+		}
+	}
+	if f.InitialCount <= 0 {
+		return f, fiber.StatusBadRequest, fiber.Map{"error": "initial_count must be positive"}
+	}
+	if f.PlacementAge < 0 {
+		return f, fiber.StatusBadRequest, fiber.Map{"error": "placement_age_days cannot be negative"}
+	}
+
+	placedAt, err := parseTime(req.PlacedAt)
+	if err != nil || placedAt.IsZero() {
+		return f, fiber.StatusBadRequest, fiber.Map{"error": "placed_at must be a date or RFC 3339 timestamp"}
+	}
+	f.PlacedAt = placedAt
+
+	if req.ClosedAt != "" {
+		closedAt, err := parseTime(req.ClosedAt)
+		if err != nil {
+			return f, fiber.StatusBadRequest, fiber.Map{"error": "closed_at must be a date or RFC 3339 timestamp"}
+		}
+		if !closedAt.After(placedAt) {
+			return f, fiber.StatusBadRequest, fiber.Map{"error": "closed_at must be after placed_at"}
+		}
+		f.ClosedAt = &closedAt
+	}
+
+	if status, body := checkFlockOverlap(f); body != nil {
+		return f, status, body
+	}
+	return f, 0, nil
+}
+
+func checkFlockOverlap(f database.Flock) (int, fiber.Map) {
+	if f.House == "" {
+		return 0, nil
+	}
+	overlapping, err := database.OverlappingFlocks(DB, f.House, f.PlacedAt, f.ClosedAt, f.ID)
+	if err != nil {
+		return fiber.StatusInternalServerError, fiber.Map{"error": "Failed to check flocks"}
+	}
+	if overlapping > 0 {
+		return fiber.StatusConflict, fiber.Map{
+			"error": "House " + f.House + " already holds a flock during this period",
+			"hint":  "Close out the previous flock first",
+		}
+	}
+	return 0, nil
+}
+
+// Birds still in a medication withdrawal period cannot be sold, so a flock is
+// not closed out during one
+func checkWithdrawal(f database.Flock, closedAt time.Time) (int, fiber.Map) {
+	treatments, err := database.ListTreatments(DB, f.ID)
+	if err != nil {
+		return fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch treatments"}
+	}
+	if sale := saleStatus(treatments, closedAt); sale["ready_for_sale"] == false {
+		return fiber.StatusConflict, fiber.Map{
+			"error":       "Flock is in a medication withdrawal period",
+			"hint":        "Wait for the withdrawal to end, or send force to close out without selling",
+			"sale_status": sale,
+		}
+	}
+	return 0, nil
+}
+
+// Flock named by the route. On failure the returned map is the body of the
+// response with the returned status.
+func flockFromParams(c *fiber.Ctx) (database.Flock, int, fiber.Map) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return database.Flock{}, fiber.StatusBadRequest, fiber.Map{"error": "Invalid flock ID"}
+	}
+	flock, err := database.GetFlock(DB, id)
+	if err == sql.ErrNoRows {
+		return flock, fiber.StatusNotFound, fiber.Map{"error": "Flock not found"}
+	}
+	if err != nil {
+		return flock, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch flock"}
+	}
+	return flock, 0, nil
+}
+
+// Whether a time falls between the placement and the close-out of a flock,
+// and is not in the future
+func withinFlock(flock database.Flock, t time.Time) bool {
+	return !t.Before(analysis.StartOfDay(flock.PlacedAt)) && !t.After(time.Now()) &&
+		(flock.ClosedAt == nil || !t.After(*flock.ClosedAt))
+}
+
+// Parse the day a record of a flock is for, today when empty
+func flockDay(flock database.Flock, raw string) (time.Time, fiber.Map) {
+	day := analysis.StartOfDay(time.Now())
+	if raw != "" {
+		var err error
+		if day, err = time.ParseInLocation(dayFormat, raw, time.Local); err != nil {
+			return day, fiber.Map{"error": "day must be a date (YYYY-MM-DD)"}
+		}
+	}
+	if !withinFlock(flock, day) {
+		return day, fiber.Map{"error": "day must be between the placement and close-out of the flock, and not in the future"}
+	}
+	return day, nil
+}
+
+// ====== FLOCK HANDLERS ====== //
+
+// List flocks: ?house=&active=true
+func ListFlocksHandler(c *fiber.Ctx) error {
+	flocks, err := database.ListFlocks(DB, database.FlockFilter{
+		House:      c.Query("house"),
+		ActiveOnly: c.QueryBool("active"),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch flocks"})
+	}
+	return c.JSON(fiber.Map{"flocks": flocks})
+}
+
+func CreateFlockHandler(c *fiber.Ctx) error {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req flockRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	flock, status, body := req.flock(0)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	flock.CreatedBy = userID
+
+	id, err := database.CreateFlock(DB, flock)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create flock"})
+	}
+	created, err := database.GetFlock(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch flock"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Flock created successfully", "flock": created})
+}
+
+func GetFlockHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	return c.JSON(fiber.Map{"flock": flock})
+}
+
+// Replace the editable fields of a flock
+func UpdateFlockHandler(c *fiber.Ctx) error {
+	existing, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	var req flockRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	flock, status, body := req.flock(existing.ID)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	// Setting or moving the close-out is checked like POST /flocks/:id/close
+	closing := flock.ClosedAt != nil && (existing.ClosedAt == nil || !flock.ClosedAt.Equal(*existing.ClosedAt))
+	if closing && !req.Force {
+		if status, body := checkWithdrawal(flock, *flock.ClosedAt); body != nil {
+			return c.Status(status).JSON(body)
+		}
+	}
+
+	if err := database.UpdateFlock(DB, flock); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update flock"})
+	}
+	updated, err := database.GetFlock(DB, existing.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch flock"})
+	}
+	return c.JSON(fiber.Map{"message": "Flock updated successfully", "flock": updated})
+}
+
+// Close out a flock, now or at {"closed_at": ...}
+func CloseFlockHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if flock.ClosedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Flock is already closed out"})
+	}
+
+	var req struct {
+		ClosedAt string `json:"closed_at"`
+		Force    bool   `json:"force"` // Close out during a withdrawal period, e.g. after culling
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	closedAt := time.Now()
+	if req.ClosedAt != "" {
+		var err error
+		if closedAt, err = parseTime(req.ClosedAt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "closed_at must be a date or RFC 3339 timestamp"})
+		}
+	}
+	if !closedAt.After(flock.PlacedAt) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "closed_at must be after placed_at"})
+	}
+
+	if !req.Force {
+		if status, body := checkWithdrawal(flock, closedAt); body != nil {
+			return c.Status(status).JSON(body)
+		}
+	}
+
+	flock.ClosedAt = &closedAt
+	if err := database.UpdateFlock(DB, flock); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to close out flock"})
+	}
+	closed, err := database.GetFlock(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch flock"})
+	}
+	return c.JSON(fiber.Map{"message": "Flock closed out successfully", "flock": closed})
+}
+
+func DeleteFlockHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid flock ID"})
+	}
+	err = database.DeleteFlock(DB, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Flock not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete flock"})
+	}
+	return c.JSON(fiber.Map{"message": "Flock deleted successfully"})
+}
+
+// Review of a grow-out: the flock with its predictions, telemetry and findings
+func GetFlockSummaryHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	summary, err := database.SummarizeFlock(DB, flock)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to summarize flock"})
+	}
+	return c.JSON(fiber.Map{"flock": flock, "summary": summary})
+}
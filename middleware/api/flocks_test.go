@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFlockDay(t *testing.T) {
+	today := time.Now().Format(dayFormat)
+	placed := time.Now().AddDate(0, 0, -10)
+	closed := time.Now().AddDate(0, 0, -3)
+	open := database.Flock{PlacedAt: placed}
+	closedFlock := database.Flock{PlacedAt: placed, ClosedAt: &closed}
+
+	tests := []struct {
+		name    string
+		flock   database.Flock
+		raw     string
+		want    string
+		wantErr string
+	}{
+		{"today by default", open, "", today, ""},
+		{"placement day", open, placed.Format(dayFormat), placed.Format(dayFormat), ""},
+		{"close-out day", closedFlock, closed.Format(dayFormat), closed.Format(dayFormat), ""},
+		{"before placement", open, placed.AddDate(0, 0, -1).Format(dayFormat), "", "between the placement"},
+		{"after close-out", closedFlock, closed.AddDate(0, 0, 1).Format(dayFormat), "", "between the placement"},
+		{"closed flock today", closedFlock, "", "", "between the placement"},
+		{"tomorrow", open, time.Now().AddDate(0, 0, 1).Format(dayFormat), "", "not in the future"},
+		{"not a date", open, "10/05/2025", "", "YYYY-MM-DD"},
+	}
+	for _, tt := range tests {
+		day, errBody := flockDay(tt.flock, tt.raw)
+		if tt.wantErr != "" {
+			if msg, _ := errBody["error"].(string); !strings.Contains(msg, tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, errBody, tt.wantErr)
+			}
+			continue
+		}
+		if errBody != nil || day.Format(dayFormat) != tt.want || !day.Equal(analysis.StartOfDay(day)) {
+			t.Errorf("%s: flockDay() = %v, %v, want %s", tt.name, day, errBody, tt.want)
+		}
+	}
+}
+
+func TestFlockCloseOutDuringWithdrawal(t *testing.T) {
+	placed := time.Now().AddDate(0, 0, -30)
+	id, err := database.CreateFlock(DB, database.Flock{Name: "Withdrawal test", House: "withdrawal-test", PlacedAt: placed, InitialCount: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	given := time.Now().AddDate(0, 0, -2)
+	if _, err := database.CreateTreatments(DB, []database.Treatment{{
+		FlockID:        id,
+		ProgramStep:    database.ProgramStep{Kind: database.TreatmentMedication, Product: "Amprolium", WithdrawalDays: 7},
+		DueDay:         given.Format(dayFormat),
+		Status:         database.TreatmentDone,
+		AdministeredAt: &given,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Put("/flocks/:id", UpdateFlockHandler)
+	app.Post("/flocks/:id/close", CloseFlockHandler)
+	send := func(method, target string, body fiber.Map) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, target, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	update := func(closedAt string, force bool) fiber.Map {
+		return fiber.Map{"name": "Withdrawal test", "house": "withdrawal-test", "initial_count": 500,
+			"placed_at": placed.Format(time.RFC3339), "closed_at": closedAt, "force": force}
+	}
+	flockURL := fmt.Sprintf("/flocks/%d", id)
+	today := time.Now().Format(dayFormat)
+	afterWithdrawal := time.Now().AddDate(0, 0, 6).Format(dayFormat)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   fiber.Map
+		want   int
+	}{
+		{"close", http.MethodPost, flockURL + "/close", fiber.Map{}, fiber.StatusConflict},
+		{"close out with an update", http.MethodPut, flockURL, update(today, false), fiber.StatusConflict},
+		{"update without closing out", http.MethodPut, flockURL, update("", false), fiber.StatusOK},
+		{"close out after the withdrawal", http.MethodPut, flockURL, update(afterWithdrawal, false), fiber.StatusOK},
+		{"move the close-out into the withdrawal", http.MethodPut, flockURL, update(today, false), fiber.StatusConflict},
+		{"keep the close-out", http.MethodPut, flockURL, update(afterWithdrawal, false), fiber.StatusOK},
+		{"force", http.MethodPut, flockURL, update(today, true), fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := send(tt.method, tt.target, tt.body); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	flock, err := database.GetFlock(DB, id)
+	if err != nil || flock.ClosedAt == nil || flock.ClosedAt.Format(dayFormat) != today {
+		t.Errorf("flock closed at %v, %v, want %s", flock.ClosedAt, err, today)
+	}
+}
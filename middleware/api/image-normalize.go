@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Uploads are decoded and re-encoded before they are stored or sent to the AI
+// service. The content type is sniffed from the data instead of trusting the
+// client, the EXIF orientation is applied so the model sees the photo upright,
+// and re-encoding drops every metadata block, including the GPS position of
+// the farm. Photos are downscaled so the AI service never receives the full
+// phone camera resolution.
+
+const (
+	maxImagePixels     = 50_000_000 // Decompression bomb guard
+	minImageSide       = 32
+	normalizedQuality  = 90
+	normalizedMimeType = "image/jpeg"
+)
+
+var ImageMaxDimension = getImageMaxDimension()
+
+// Longest side of a normalized image. The model only needs 224x224, but vets
+// review the stored photos too.
+func getImageMaxDimension() int {
+	if n, err := strconv.Atoi(os.Getenv("IMAGE_MAX_DIMENSION")); err == nil && n >= minImageSide {
+		return n
+	}
+	return 1600
+}
+
+// imageError describes why an upload is not a usable image
+type imageError struct {
+	message string
+	hint    string
+}
+
+func (e *imageError) Error() string {
+	return e.message
+}
+
+var (
+	errImageType = &imageError{"Invalid file type", "Please upload a JPEG, PNG, or WebP image"}
+	errImageData = &imageError{"Image is corrupt or could not be decoded", "Please take the photo again"}
+	errImageSize = &imageError{"Image dimensions are not supported", "Please upload a photo between 32 pixels and 50 megapixels"}
+)
+
+// Validate an image and return it upright, downscaled and re-encoded as JPEG
+// without metadata
+func normalizeImage(data []byte) ([]byte, string, error) {
+	if !isValidImageType(http.DetectContentType(data)) {
+		return nil, "", errImageType
+	}
+
+	// Check the dimensions before decoding the pixels
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errImageData
+	}
+	if cfg.Width < minImageSide || cfg.Height < minImageSide || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, "", errImageSize
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", errImageData
+	}
+
+	// Orient after scaling, it is cheaper on the smaller image
+	normalized := orientImage(scaleImage(img, ImageMaxDimension), exifOrientation(data, format))
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, normalized, &jpeg.Options{Quality: normalizedQuality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), normalizedMimeType, nil
+}
+
+// Fit the image within maxSide on a white background, since JPEG has no
+// transparency
+func scaleImage(img image.Image, maxSide int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			w, h = maxSide, max(1, h*maxSide/w)
+		} else {
+			w, h = max(1, w*maxSide/h), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
+
+// Apply an EXIF orientation (1-8) to the pixels
+func orientImage(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Rotated 90° clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90° counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// ====== EXIF ====== //
+
+const exifOrientationTag = 0x0112
+
+// Orientation stored in the EXIF block of the image, 1 when there is none
+func exifOrientation(data []byte, format string) int {
+	var exif []byte
+	switch format {
+	case "jpeg":
+		exif = jpegExif(data)
+	case "png":
+		exif = pngChunk(data, "eXIf")
+	case "webp":
+		exif = webpChunk(data, "EXIF")
+	}
+	return tiffOrientation(bytes.TrimPrefix(exif, []byte("Exif\x00\x00")))
+}
+
+// EXIF is stored in an APP1 segment before the image data
+func jpegExif(data []byte) []byte {
+	pos := 2 // SOI marker
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan, end of image
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+func pngChunk(data []byte, chunkType string) []byte {
+	pos := 8 // Signature
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if length < 0 || pos+12+length > len(data) {
+			return nil
+		}
+		if string(data[pos+4:pos+8]) == chunkType {
+			return data[pos+8 : pos+8+length]
+		}
+		pos += 12 + length // Length, type, data and CRC
+	}
+	return nil
+}
+
+func webpChunk(data []byte, fourCC string) []byte {
+	pos := 12 // RIFF header
+	for pos+8 <= len(data) {
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if length < 0 || pos+8+length > len(data) {
+			return nil
+		}
+		if string(data[pos:pos+4]) == fourCC {
+			return data[pos+8 : pos+8+length]
+		}
+		pos += 8 + length + length%2 // Chunks are padded to even sizes
+	}
+	return nil
+}
+
+// Read the orientation tag from the first IFD of a TIFF structure
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			// SHORT value stored inline in the value field
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// TIFF header with a single IFD holding the orientation tag
+func tiffWithOrientation(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3) // SHORT
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+	return tiff
+}
+
+// Insert an APP1 EXIF segment after the SOI marker
+func jpegWithExif(t *testing.T, img image.Image, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	exif := append([]byte("Exif\x00\x00"), tiffWithOrientation(binary.BigEndian, orientation)...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(segment, exif...)...), data[2:]...)
+}
+
+// Insert an eXIf chunk after the IHDR chunk
+func pngWithExif(t *testing.T, img image.Image, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	exif := tiffWithOrientation(binary.LittleEndian, orientation)
+	chunk := make([]byte, 8, 12+len(exif))
+	binary.BigEndian.PutUint32(chunk, uint32(len(exif)))
+	copy(chunk[4:], "eXIf")
+	chunk = append(chunk, exif...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	data := buf.Bytes()
+	ihdrEnd := 8 + 12 + 13
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestTiffOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", tiffWithOrientation(binary.LittleEndian, 6), 6},
+		{"big endian", tiffWithOrientation(binary.BigEndian, 8), 8},
+		{"out of range", tiffWithOrientation(binary.BigEndian, 9), 1},
+		{"empty", nil, 1},
+		{"garbage", []byte("not a tiff header at all"), 1},
+		{"wrong magic", append([]byte("II\x2b\x00"), tiffWithOrientation(binary.LittleEndian, 6)[4:]...), 1},
+		{"IFD past the end", append([]byte("MM\x00\x2a\xff\xff\xff\xf0"), make([]byte, 8)...), 1},
+		{"truncated entry", tiffWithOrientation(binary.LittleEndian, 6)[:16], 1},
+		{"entry count past the end", append([]byte("II\x2a\x00\x08\x00\x00\x00"), 0xff, 0xff, 0, 0), 1},
+	}
+	for _, tt := range tests {
+		if got := tiffOrientation(tt.tiff); got != tt.want {
+			t.Errorf("%s: tiffOrientation() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// No prefix of a valid block panics
+	tiff := tiffWithOrientation(binary.BigEndian, 3)
+	for i := range tiff {
+		tiffOrientation(tiff[:i])
+	}
+}
+
+func TestExifChunks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	tiff := tiffWithOrientation(binary.BigEndian, 6)
+
+	jpegData := jpegWithExif(t, img, 6)
+	if got := jpegExif(jpegData); !bytes.Equal(got, append([]byte("Exif\x00\x00"), tiff...)) {
+		t.Errorf("jpegExif() = %x", got)
+	}
+	pngData := pngWithExif(t, img, 6)
+	if got := pngChunk(pngData, "eXIf"); !bytes.Equal(got, tiffWithOrientation(binary.LittleEndian, 6)) {
+		t.Errorf("pngChunk() = %x", got)
+	}
+
+	// RIFF header, an odd sized chunk padded to even and the EXIF chunk
+	webpData := []byte("RIFF\x00\x00\x00\x00WEBPVP8X\x03\x00\x00\x00abc\x00EXIF")
+	webpData = binary.LittleEndian.AppendUint32(webpData, uint32(len(tiff)))
+	webpData = append(webpData, tiff...)
+	if got := webpChunk(webpData, "EXIF"); !bytes.Equal(got, tiff) {
+		t.Errorf("webpChunk() = %x", got)
+	}
+
+	tests := []struct {
+		name string
+		read func([]byte) []byte
+		data []byte
+	}{
+		{"jpeg", jpegExif, jpegData},
+		{"png", func(b []byte) []byte { return pngChunk(b, "eXIf") }, pngData},
+		{"webp", func(b []byte) []byte { return webpChunk(b, "EXIF") }, webpData},
+	}
+	for _, tt := range tests {
+		// A truncated file gives the whole block or none
+		want := tt.read(tt.data)
+		for i := range tt.data {
+			if got := tt.read(tt.data[:i]); got != nil && !bytes.Equal(got, want) {
+				t.Errorf("%s truncated to %d bytes: read %x", tt.name, i, got)
+			}
+		}
+		// Lengths running past the end are ignored
+		garbage := append([]byte{}, tt.data...)
+		for i := 2; i < len(garbage) && i < 64; i++ {
+			garbage[i] = 0xFF
+		}
+		if got := tt.read(garbage); got != nil {
+			t.Errorf("%s with garbage lengths: read %x", tt.name, got)
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// A 3x2 image whose pixels are labelled a to f
+	//   a b c
+	//   d e f
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, label := range "abcdef" {
+		src.SetRGBA(i%3, i/3, color.RGBA{uint8(label), 0, 0, 255})
+	}
+	labels := func(img *image.RGBA) []string {
+		var rows []string
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			row := ""
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				row += string(rune(img.RGBAAt(x, y).R))
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{0, []string{"abc", "def"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, tt := range tests {
+		got := labels(orientImage(src, tt.orientation))
+		if len(got) != len(tt.want) {
+			t.Errorf("orientation %d = %q, want %q", tt.orientation, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("orientation %d = %q, want %q", tt.orientation, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizeImageOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	tests := []struct {
+		name string
+		data []byte
+		want image.Point
+	}{
+		{"jpeg rotated", jpegWithExif(t, img, 6), image.Pt(32, 64)},
+		{"jpeg upright", jpegWithExif(t, img, 1), image.Pt(64, 32)},
+		{"png rotated", pngWithExif(t, img, 8), image.Pt(32, 64)},
+	}
+	for _, tt := range tests {
+		data, contentType, err := normalizeImage(tt.data)
+		if err != nil || contentType != normalizedMimeType {
+			t.Errorf("%s: normalizeImage() = %q, %v", tt.name, contentType, err)
+			continue
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil || image.Pt(cfg.Width, cfg.Height) != tt.want {
+			t.Errorf("%s: normalized to %dx%d, %v, want %v", tt.name, cfg.Width, cfg.Height, err, tt.want)
+		}
+		if bytes.Contains(data, []byte("Exif\x00\x00")) {
+			t.Errorf("%s: the EXIF block was kept", tt.name)
+		}
+
+		// A truncated upload is rejected, never a panic
+		for i := 0; i < len(tt.data)-1; i += 7 {
+			if _, _, err := normalizeImage(tt.data[:i]); err == nil {
+				t.Errorf("%s truncated to %d bytes was accepted", tt.name, i)
+			}
+		}
+	}
+}
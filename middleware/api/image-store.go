@@ -0,0 +1,53 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// Uploaded images are stored content-addressed, so the same photo uploaded
+// twice is kept once: <dir>/<first two hash chars>/<hash><ext>
+var ImageStoreDir = getImageStoreDir()
+
+func getImageStoreDir() string {
+	dir := os.Getenv("IMAGE_STORE_DIR")
+	if dir == "" {
+		return filepath.Join("data", "images")
+	}
+	return dir
+}
+
+// File extension for a validated image content type
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// Store image data and return its SHA-256 hash and path on disk
+func storeImage(data []byte, contentType string) (string, string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := filepath.Join(ImageStoreDir, hash[:2], hash+imageExtension(contentType))
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", "", err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", "", err
+	}
+	return hash, path, os.Rename(tmp, path)
+}
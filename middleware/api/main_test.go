@@ -0,0 +1,53 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"middleware/database"
+)
+
+// Tests run against a fresh database in a temporary directory, InitDB creates
+// users.db in the working directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "middleware-api")
+	if err != nil {
+		log.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	if os.Getenv("JWT_SECRET") == "" {
+		os.Setenv("JWT_SECRET", "test-secret")
+	}
+
+	DB = database.InitDB()
+	code := m.Run()
+	DB.Close()
+
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// Create a user with a role and return its ID and session cookie
+func testUser(t *testing.T, username, role string) (int, *http.Cookie) {
+	t.Helper()
+	result, err := DB.Exec("INSERT INTO users (username, password, role) VALUES (?, 'x', ?)", username, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	token, err := GenerateToken(username, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(id), &http.Cookie{Name: "token", Value: token}
+}
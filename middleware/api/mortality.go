@@ -0,0 +1,302 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Farmers record the birds found dead or culled each day. A photo of a dead
+// bird can be attached, it goes through the disease prediction queue. When
+// the deaths of a day cross the alert threshold a finding is raised.
+
+const (
+	findingHighMortality = "high_mortality"
+	dayFormat            = "2006-01-02"
+)
+
+var (
+	mortalityAlertPercent = getMortalityAlertPercent()
+	mortalityAlertMin     = getMortalityAlertMin()
+)
+
+// Daily deaths, in percent of the live birds, that raise a finding
+func getMortalityAlertPercent() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("MORTALITY_ALERT_PERCENT"), 64); err == nil && v > 0 {
+		return v
+	}
+	return 0.5
+}
+
+// Fewest deaths in a day that raise a finding, so one bird in a small flock
+// does not
+func getMortalityAlertMin() int {
+	if n, err := strconv.Atoi(os.Getenv("MORTALITY_ALERT_MIN")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+type mortalityRequest struct {
+	Day    string `json:"day" form:"day"` // Defaults to today
+	Deaths int    `json:"deaths" form:"deaths"`
+	Culls  int    `json:"culls" form:"culls"`
+	Cause  string `json:"cause" form:"cause"`
+	Notes  string `json:"notes" form:"notes"`
+}
+
+// Mortality curve and head count of a flock
+func flockMortality(flock database.Flock) (fiber.Map, []database.MortalityRecord, []analysis.DailyMortality, error) {
+	records, err := database.ListMortalityRecords(DB, flock.ID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	losses := make([]analysis.DailyLoss, 0, len(records))
+	deaths, culls := 0, 0
+	for _, r := range records {
+		day, err := time.ParseInLocation(dayFormat, r.Day, time.Local)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		losses = append(losses, analysis.DailyLoss{Day: day, Deaths: r.Deaths, Culls: r.Culls})
+		deaths += r.Deaths
+		culls += r.Culls
+	}
+	curve := analysis.MortalityCurve(flock.InitialCount, losses)
+
+	stats := fiber.Map{
+		"initial_count":                flock.InitialCount,
+		"live_count":                   flock.InitialCount - deaths - culls,
+		"deaths":                       deaths,
+		"culls":                        culls,
+		"cumulative_mortality_percent": 0.0,
+		"alert_percent":                mortalityAlertPercent,
+	}
+	if flock.InitialCount > 0 {
+		stats["cumulative_mortality_percent"] = float64(deaths+culls) / float64(flock.InitialCount) * 100
+	}
+	return stats, records, curve, nil
+}
+
+// Live birds of a flock on a day by its mortality log, before the losses of
+// the day or, with closing, after them
+func liveCount(flock database.Flock, records []database.MortalityRecord, day string, closing bool) int {
+	live := flock.InitialCount
+	for _, r := range records {
+		if r.Day < day || (closing && r.Day == day) {
+			live -= r.Deaths + r.Culls
+		}
+	}
+	return live
+}
+
+// Raise a finding when the deaths of a day cross the threshold. The finding
+// of a day is updated as more records come in.
+func checkMortalityAlert(flock database.Flock, day string, curve []analysis.DailyMortality) (*database.Finding, error) {
+	var today *analysis.DailyMortality
+	for i := range curve {
+		if curve[i].Day.Format(dayFormat) == day {
+			today = &curve[i]
+		}
+	}
+	if today == nil || today.Deaths < mortalityAlertMin || today.DeathRate < mortalityAlertPercent {
+		return nil, nil
+	}
+
+	severity := "medium"
+	if today.DeathRate >= 2*mortalityAlertPercent {
+		severity = "high"
+	}
+	finding := database.Finding{
+		Kind:     findingHighMortality,
+		House:    flock.House,
+		Subject:  fmt.Sprintf("flock %d %s", flock.ID, day),
+		Severity: severity,
+		Status:   database.FindingOpen,
+		Summary: fmt.Sprintf("%d birds died in flock %s on %s, %.2f%% of the flock (alert at %.2f%%)",
+			today.Deaths, flock.Name, day, today.DeathRate, mortalityAlertPercent),
+		Details: map[string]interface{}{
+			"flock":     flock.ID,
+			"age_days":  flock.AgeOn(today.Day),
+			"mortality": today,
+		},
+		WindowStart: today.Day,
+		WindowEnd:   today.Day.AddDate(0, 0, 1),
+		FlockID:     &flock.ID,
+	}
+
+	var err error
+	finding.ID, err = database.UpsertFinding(DB, finding)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Mortality finding #%d: %s", finding.ID, finding.Summary)
+	return &finding, nil
+}
+
+// Queue the photo of a mortality record for disease prediction. Failures are
+// returned as a message for the farmer.
+func queueMortalityPhoto(c *fiber.Ctx, userID int, flock database.Flock, file *multipart.FileHeader) (database.PredictionJob, string) {
+	imageData, contentType, uploadErr := readImageUpload(file)
+	if uploadErr != nil {
+		return database.PredictionJob{}, fmt.Sprint(uploadErr["error"])
+	}
+	job, err := newPredictionJob(c, userID, imageData, contentType, file.Filename)
+	if err != nil {
+		log.Println("Failed to queue mortality photo:", err)
+		return database.PredictionJob{}, "Failed to queue prediction"
+	}
+	job.House, job.Controller = flock.House, flock.Controller
+
+	jobs := []pendingJob{job}
+	err = enqueueJobs(userID, jobs)
+	if errors.Is(err, errTooManyJobs) {
+		return database.PredictionJob{}, "Too many predictions in progress, the photo was not analyzed"
+	}
+	if err != nil {
+		log.Println("Failed to queue mortality photo:", err)
+		return database.PredictionJob{}, "Failed to queue prediction"
+	}
+	return jobs[0].PredictionJob, ""
+}
+
+// ====== MORTALITY HANDLERS ====== //
+
+// Record deaths and culls, as JSON or as a form with an optional photo.
+// Findings are the alert channel: a day over the mortality threshold raises
+// a finding, returned as "alert", and nothing else notifies the farmer.
+func RecordMortalityHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req mortalityRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if req.Deaths < 0 || req.Culls < 0 || req.Deaths+req.Culls == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "deaths and culls must not be negative, and not both zero"})
+	}
+
+	day, errBody := flockDay(flock, req.Day)
+	if errBody != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errBody)
+	}
+
+	totals, err := database.FlockMortality(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch mortality"})
+	}
+	if live := flock.InitialCount - totals.Deaths - totals.Culls; req.Deaths+req.Culls > live {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":      "More birds than the flock has left",
+			"live_count": live,
+		})
+	}
+
+	record := database.MortalityRecord{
+		FlockID:    flock.ID,
+		Day:        day.Format(dayFormat),
+		Deaths:     req.Deaths,
+		Culls:      req.Culls,
+		Cause:      strings.ToLower(strings.TrimSpace(req.Cause)),
+		Notes:      req.Notes,
+		RecordedBy: userID,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	record.ID, err = database.CreateMortalityRecord(DB, record)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save mortality record"})
+	}
+
+	// A photo of a dead bird is checked for disease like any other upload. The
+	// record stands without it when the photo cannot be queued.
+	response := fiber.Map{}
+	if file, err := c.FormFile("photo"); err == nil {
+		if job, msg := queueMortalityPhoto(c, userID, flock, file); msg != "" {
+			response["prediction_error"] = msg
+		} else {
+			record.PredictionJobID = job.ID
+			response["prediction_job"] = fiber.Map{
+				"job_id":     job.ID,
+				"status":     job.Status,
+				"status_url": "/api/ai/jobs/" + job.ID,
+			}
+			if err := database.SetMortalityPredictionJob(DB, record.ID, job.ID); err != nil {
+				log.Println("Failed to link mortality photo:", err)
+			}
+		}
+	}
+
+	stats, _, curve, err := flockMortality(flock)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch mortality"})
+	}
+	finding, err := checkMortalityAlert(flock, record.Day, curve)
+	if err != nil {
+		log.Println("Failed to raise mortality finding:", err)
+	}
+
+	response["message"] = "Mortality recorded successfully"
+	response["record"] = record
+	response["stats"] = stats
+	response["alert"] = finding
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// Mortality log of a flock with its daily and cumulative rates
+func GetMortalityHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+
+	stats, records, curve, err := flockMortality(flock)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch mortality"})
+	}
+	return c.JSON(fiber.Map{
+		"flock_id": flock.ID,
+		"stats":    stats,
+		"daily":    curve,
+		"records":  records,
+	})
+}
+
+func DeleteMortalityHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	recordID, err := c.ParamsInt("recordId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid record ID"})
+	}
+
+	err = database.DeleteMortalityRecord(DB, flock.ID, recordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Mortality record not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete mortality record"})
+	}
+	return c.JSON(fiber.Map{"message": "Mortality record deleted successfully"})
+}
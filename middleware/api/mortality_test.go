@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMortalityIsRecordedWhenThePhotoIsNotQueued(t *testing.T) {
+	userID, cookie := testUser(t, "mortality-busy", database.RoleUser)
+	defer func(dir string) { ImageStoreDir = dir }(ImageStoreDir)
+	ImageStoreDir = t.TempDir()
+
+	flockID, err := database.CreateFlock(DB, database.Flock{
+		Name:         "Mortality test",
+		House:        "house-1",
+		PlacedAt:     time.Now().AddDate(0, 0, -10),
+		InitialCount: 1000,
+		CreatedBy:    userID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the queue of the user
+	for n := 0; n < aiJobsPerUser; n++ {
+		id, _ := newJobID()
+		job := pendingJob{
+			PredictionJob: database.PredictionJob{ID: id, UserID: userID, Status: database.JobQueued,
+				ContentType: "image/jpeg", CreatedAt: time.Now(), Deadline: time.Now().Add(time.Hour)},
+			image: []byte(fmt.Sprintf("queued %d", n)),
+		}
+		if err := enqueueJobs(userID, []pendingJob{job}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var photo bytes.Buffer
+	png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 64, 64)))
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("deaths", "2")
+	part, _ := form.CreateFormFile("photo", "bird.png")
+	part.Write(photo.Bytes())
+	form.Close()
+
+	app := fiber.New()
+	app.Post("/flocks/:id/mortality", RecordMortalityHandler)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/flocks/%d/mortality", flockID), &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.AddCookie(cookie)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		PredictionError string                   `json:"prediction_error"`
+		Record          database.MortalityRecord `json:"record"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != fiber.StatusCreated || result.PredictionError == "" {
+		t.Fatalf("status %d, prediction_error %q, want 201 with the queue error", resp.StatusCode, result.PredictionError)
+	}
+
+	records, err := database.ListMortalityRecords(DB, flockID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Deaths != 2 || records[0].PredictionJobID != "" {
+		t.Errorf("records = %+v, want the deaths without a prediction job", records)
+	}
+}
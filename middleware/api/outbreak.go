@@ -0,0 +1,273 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A single low confidence prediction means little, but a run of the same
+// disease in one house is an outbreak. This job compares each house's recent
+// disease rate with its own history and raises a finding when the increase is
+// statistically unusual, along with the humidity in the house because wet
+// litter drives coccidiosis.
+
+const (
+	findingDiseaseOutbreak = "disease_outbreak"
+	wetLitterHumidity      = 75.0 // %RH above which litter stays wet
+	wetLitterCorrelation   = 0.5  // Humidity/disease correlation worth reporting
+)
+
+// Predictions of one house
+type housePredictions struct {
+	observations []analysis.Observation
+	controllers  map[string]int
+	diseases     map[string]bool
+}
+
+// Controller the house's photos are most often associated with
+func (h *housePredictions) controller() string {
+	best, bestCount := "", 0
+	for controller, count := range h.controllers {
+		if count > bestCount {
+			best, bestCount = controller, count
+		}
+	}
+	return best
+}
+
+func groupPredictionsByHouse(predictions []database.StoredPrediction) map[string]*housePredictions {
+	houses := map[string]*housePredictions{}
+	for _, p := range predictions {
+		// The farmer was asked to retake these photos
+		if p.Outcome == analysis.OutcomeUncertain {
+			continue
+		}
+		h, ok := houses[p.House]
+		if !ok {
+			h = &housePredictions{controllers: map[string]int{}, diseases: map[string]bool{}}
+			houses[p.House] = h
+		}
+		h.observations = append(h.observations, analysis.Observation{
+			Time:       p.CreatedAt,
+			Disease:    p.PredictedDisease,
+			Healthy:    p.IsHealthy,
+			Confidence: p.Confidence,
+		})
+		h.controllers[p.Controller]++
+		if !p.IsHealthy {
+			h.diseases[p.PredictedDisease] = true
+		}
+	}
+	return houses
+}
+
+// RunOutbreakAnalysis tests every house and disease and raises a finding for
+// each unusual increase
+func RunOutbreakAnalysis(now time.Time) ([]database.Finding, error) {
+	cfg := analysis.DefaultSurgeConfig
+	windowStart := now.Add(-cfg.Window)
+	from := windowStart.Add(-cfg.Baseline)
+
+	predictions, err := database.PredictionsBetween(DB, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Diseases marked high severity in the knowledge base need a vet immediately
+	severities, err := database.DiseaseSeverities(DB)
+	if err != nil {
+		log.Println("Failed to fetch disease severities:", err)
+	}
+
+	findings := []database.Finding{}
+	for house, h := range groupPredictionsByHouse(predictions) {
+		for disease := range h.diseases {
+			surge := analysis.DetectSurge(h.observations, disease, now, cfg)
+			if !surge.Unusual {
+				continue
+			}
+
+			humidity, err := humidityContext(h, disease, from, windowStart, now)
+			if err != nil {
+				log.Println("Failed to correlate humidity:", err)
+			}
+
+			severity := "medium"
+			if severities[disease] == database.SeverityHigh || surge.PValue < 0.001 {
+				severity = "high"
+			}
+
+			houseName := house
+			if houseName == "" {
+				houseName = "unassigned"
+			}
+
+			finding := database.Finding{
+				Kind:     findingDiseaseOutbreak,
+				House:    house,
+				Subject:  disease,
+				Severity: severity,
+				Status:   database.FindingOpen,
+				Summary: fmt.Sprintf("%d of %d predictions in house %s were %s in the last %.0f hours (usual rate %.0f%%)",
+					surge.WindowCases, surge.WindowTotal, houseName, disease, cfg.Window.Hours(), surge.BaselineRate*100),
+				Details: map[string]interface{}{
+					"surge":    surge,
+					"humidity": humidity,
+				},
+				WindowStart: windowStart,
+				WindowEnd:   now,
+				FlockID:     database.ActiveFlockID(DB, house, h.controller(), now),
+			}
+
+			finding.ID, err = database.UpsertFinding(DB, finding)
+			if err != nil {
+				return findings, err
+			}
+			log.Printf("Outbreak finding #%d: %s", finding.ID, finding.Summary)
+			findings = append(findings, finding)
+		}
+	}
+	return findings, nil
+}
+
+// Compare the humidity of the house during the window with the baseline and
+// correlate daily humidity with the daily disease rate
+func humidityContext(h *housePredictions, disease string, from, windowStart, now time.Time) (fiber.Map, error) {
+	controller := h.controller()
+	result := fiber.Map{"controller": controller}
+
+	readings, err := database.ListTelemetry(DB, controller, from, now)
+	if err != nil || len(readings) == 0 {
+		return result, err
+	}
+
+	var window, baseline []float64
+	daily := map[time.Time][]float64{}
+	for _, r := range readings {
+		if r.RecordedAt.Before(windowStart) {
+			baseline = append(baseline, r.Humidity)
+		} else {
+			window = append(window, r.Humidity)
+		}
+		day := analysis.StartOfDay(r.RecordedAt)
+		daily[day] = append(daily[day], r.Humidity)
+	}
+
+	windowMean := analysis.Mean(window)
+	result["window_mean"] = jsonNumber(windowMean)
+	result["baseline_mean"] = jsonNumber(analysis.Mean(baseline))
+
+	// Pair each day's mean humidity with that day's disease rate
+	var humidity, rates []float64
+	for _, rate := range analysis.DailyRates(h.observations, disease, from, now, analysis.DefaultSurgeConfig.MinConfidence) {
+		if values, ok := daily[rate.Day]; ok {
+			humidity = append(humidity, analysis.Mean(values))
+			rates = append(rates, rate.Rate)
+		}
+	}
+	result["days"] = len(rates)
+
+	correlation, ok := analysis.Pearson(humidity, rates)
+	if ok {
+		result["correlation"] = correlation
+	}
+
+	result["wet_litter_risk"] = windowMean >= wetLitterHumidity || (ok && correlation >= wetLitterCorrelation)
+	return result, nil
+}
+
+// NaN cannot be encoded as JSON
+func jsonNumber(v float64) interface{} {
+	if math.IsNaN(v) {
+		return nil
+	}
+	return v
+}
+
+// StartOutbreakMonitor runs the analysis every interval. It never returns.
+func StartOutbreakMonitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := RunOutbreakAnalysis(time.Now()); err != nil {
+			log.Println("Outbreak analysis failed:", err)
+		}
+	}
+}
+
+// ====== TREND HANDLERS ====== //
+
+// Daily disease rates per house: ?days=14&house=
+func GetDiseaseTrendsHandler(c *fiber.Ctx) error {
+	days := c.QueryInt("days", 14)
+	if days < 1 || days > 365 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "days must be between 1 and 365"})
+	}
+
+	now := time.Now()
+	from := analysis.StartOfDay(now).AddDate(0, 0, -days+1)
+	predictions, err := database.PredictionsBetween(DB, from, now)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch predictions"})
+	}
+
+	houseFilter := c.Query("house")
+	trends := fiber.Map{}
+	for house, h := range groupPredictionsByHouse(predictions) {
+		if houseFilter != "" && house != houseFilter {
+			continue
+		}
+		diseases := fiber.Map{}
+		for disease := range h.diseases {
+			diseases[disease] = analysis.DailyRates(h.observations, disease, from, now, 0)
+		}
+		trends[house] = diseases
+	}
+
+	return c.JSON(fiber.Map{"from": from, "to": now, "houses": trends})
+}
+
+// ====== FINDING HANDLERS ====== //
+func ListFindingsHandler(c *fiber.Ctx) error {
+	findings, err := database.ListFindings(DB, c.Query("kind"), c.Query("status"), c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch findings"})
+	}
+	return c.JSON(fiber.Map{"findings": findings})
+}
+
+func AcknowledgeFindingHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid finding ID"})
+	}
+
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	err = database.AcknowledgeFinding(DB, id, userID)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Finding not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to acknowledge finding"})
+	}
+	return c.JSON(fiber.Map{"message": "Finding acknowledged"})
+}
+
+// Run the outbreak analysis immediately instead of waiting for the next cycle
+func RunOutbreakAnalysisHandler(c *fiber.Ctx) error {
+	findings, err := RunOutbreakAnalysis(time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Outbreak analysis failed"})
+	}
+	return c.JSON(fiber.Map{"findings": findings})
+}
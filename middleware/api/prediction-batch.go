@@ -0,0 +1,421 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Farmers photograph a whole house of trays at once. A batch is uploaded as
+// many "images" form files and/or zip files in "archive"; every image becomes
+// a prediction job and the batch counts as a single submission against the
+// per-user limit.
+
+const (
+	maxBatchImages      = 50
+	maxBatchArchiveSize = 256 << 20 // Uncompressed size of the archive entries of a batch
+)
+
+var (
+	errBatchTooManyImages = errors.New("too many images in the batch")
+	errBatchTooLarge      = errors.New("archives of the batch are too large")
+)
+
+// Room left in a batch upload. It is checked before files are read so an
+// oversized upload is rejected without extracting anything.
+type batchBudget struct {
+	entries int    // Files and archive entries, whatever their outcome
+	bytes   uint64 // Uncompressed size of archive entries
+}
+
+// An image of a batch upload
+type batchImage struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// A file of the upload that was not queued
+type rejectedImage struct {
+	Filename string `json:"filename"`
+	Error    string `json:"error"`
+}
+
+const errImageTooLarge = "File too large, images must be smaller than 10MB"
+
+// Normalize an image of the batch, with the same rules as single uploads
+func prepareBatchImage(filename string, data []byte) (batchImage, string) {
+	normalized, contentType, err := normalizeImage(data)
+	if err != nil {
+		var imgErr *imageError
+		if errors.As(err, &imgErr) {
+			return batchImage{}, imgErr.message
+		}
+		return batchImage{}, "Failed to process image"
+	}
+	return batchImage{filename, contentType, normalized}, ""
+}
+
+// Collect the images of a multipart batch upload
+func collectBatchImages(form *multipart.Form) ([]batchImage, []rejectedImage, error) {
+	files := len(form.File["images"]) + len(form.File["image"])
+	if files > maxBatchImages {
+		return nil, nil, errBatchTooManyImages
+	}
+	budget := &batchBudget{entries: maxBatchImages - files, bytes: maxBatchArchiveSize}
+
+	var images []batchImage
+	rejected := []rejectedImage{}
+
+	for _, field := range []string{"images", "image"} {
+		for _, file := range form.File[field] {
+			if file.Size > maxImageSize {
+				rejected = append(rejected, rejectedImage{file.Filename, errImageTooLarge})
+				continue
+			}
+			data, err := readMultipartFile(file)
+			if err != nil {
+				rejected = append(rejected, rejectedImage{file.Filename, "Failed to read file"})
+				continue
+			}
+			image, msg := prepareBatchImage(file.Filename, data)
+			if msg != "" {
+				rejected = append(rejected, rejectedImage{file.Filename, msg})
+				continue
+			}
+			images = append(images, image)
+		}
+	}
+
+	for _, file := range form.File["archive"] {
+		data, err := readMultipartFile(file)
+		if err != nil {
+			rejected = append(rejected, rejectedImage{file.Filename, "Failed to read file"})
+			continue
+		}
+		archived, archiveRejected, err := readImageArchive(data, budget)
+		if errors.Is(err, errBatchTooManyImages) || errors.Is(err, errBatchTooLarge) {
+			return nil, nil, err
+		}
+		if err != nil {
+			rejected = append(rejected, rejectedImage{file.Filename, "Not a valid zip archive"})
+			continue
+		}
+		images = append(images, archived...)
+		rejected = append(rejected, archiveRejected...)
+	}
+
+	return images, rejected, nil
+}
+
+func readMultipartFile(file *multipart.FileHeader) ([]byte, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return io.ReadAll(src)
+}
+
+// Extract the images of a zip archive, within the room left in the batch
+func readImageArchive(data []byte, budget *batchBudget) ([]batchImage, []rejectedImage, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Count every entry and its declared size before extracting anything
+	var entries []*zip.File
+	for _, entry := range archive.File {
+		// Skip folders and the metadata macOS adds to archives
+		name := path.Base(entry.Name)
+		if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		budget.entries--
+		if budget.entries < 0 {
+			return nil, nil, errBatchTooManyImages
+		}
+		entries = append(entries, entry)
+		// Oversized entries are rejected without being extracted
+		if entry.UncompressedSize64 > maxImageSize {
+			continue
+		}
+		if entry.UncompressedSize64 > budget.bytes {
+			return nil, nil, errBatchTooLarge
+		}
+		budget.bytes -= entry.UncompressedSize64
+	}
+
+	var images []batchImage
+	var rejected []rejectedImage
+	for _, entry := range entries {
+		if entry.UncompressedSize64 > maxImageSize {
+			rejected = append(rejected, rejectedImage{entry.Name, errImageTooLarge})
+			continue
+		}
+
+		content, err := readZipEntry(entry)
+		if err != nil {
+			rejected = append(rejected, rejectedImage{entry.Name, "Failed to extract file"})
+			continue
+		}
+
+		image, msg := prepareBatchImage(path.Base(entry.Name), content)
+		if msg != "" {
+			rejected = append(rejected, rejectedImage{entry.Name, msg})
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, rejected, nil
+}
+
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	// The declared size cannot be trusted, never read past the limit
+	content, err := io.ReadAll(io.LimitReader(src, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxImageSize {
+		return nil, errors.New("file too large")
+	}
+	return content, nil
+}
+
+// ====== BATCH SUMMARY ====== //
+
+// BatchSummary aggregates the predictions of a batch at flock level
+type BatchSummary struct {
+	Total             int            `json:"total"`
+	Completed         int            `json:"completed"`
+	Pending           int            `json:"pending"`
+	Failed            int            `json:"failed"`
+	Healthy           int            `json:"healthy"`
+	Unhealthy         int            `json:"unhealthy"`
+	Uncertain         int            `json:"uncertain"` // Photos to retake, not counted as healthy or unhealthy
+	UnhealthyPercent  float64        `json:"unhealthy_percent"`
+	DominantDisease   string         `json:"dominant_disease,omitempty"`
+	DiseaseCounts     map[string]int `json:"disease_counts"`
+	AverageConfidence float64        `json:"average_confidence"`
+	Done              bool           `json:"done"`
+}
+
+// Result of one image of a batch
+type batchItem struct {
+	Job        database.PredictionJob     `json:"job"`
+	Prediction *database.StoredPrediction `json:"prediction,omitempty"`
+}
+
+func summarizeBatch(jobs []database.PredictionJob) ([]batchItem, BatchSummary, error) {
+	summary := BatchSummary{Total: len(jobs), DiseaseCounts: map[string]int{}}
+	items := make([]batchItem, 0, len(jobs))
+	var confidence float64
+
+	for _, job := range jobs {
+		item := batchItem{Job: job}
+		switch {
+		case !jobDone(job):
+			summary.Pending++
+		case job.Status != database.JobSucceeded || job.PredictionID == nil:
+			summary.Failed++
+		default:
+			stored, err := database.GetPrediction(DB, *job.PredictionID)
+			if err != nil {
+				return nil, summary, err
+			}
+			item.Prediction = &stored
+			summary.Completed++
+			confidence += stored.Confidence
+			if stored.Outcome == analysis.OutcomeUncertain {
+				summary.Uncertain++
+			} else if stored.IsHealthy {
+				summary.Healthy++
+			} else {
+				summary.Unhealthy++
+				summary.DiseaseCounts[stored.PredictedDisease]++
+			}
+		}
+		items = append(items, item)
+	}
+
+	if assessed := summary.Healthy + summary.Unhealthy; assessed > 0 {
+		summary.UnhealthyPercent = float64(summary.Unhealthy) / float64(assessed) * 100
+	}
+	if summary.Completed > 0 {
+		summary.AverageConfidence = confidence / float64(summary.Completed)
+	}
+	summary.DominantDisease = dominantDisease(summary.DiseaseCounts)
+	summary.Done = summary.Pending == 0
+	return items, summary, nil
+}
+
+// Diseases predicted in a batch
+func batchDiseases(items []batchItem) []string {
+	seen := map[string]bool{}
+	var diseases []string
+	for _, item := range items {
+		if item.Prediction != nil && !seen[item.Prediction.PredictedDisease] {
+			seen[item.Prediction.PredictedDisease] = true
+			diseases = append(diseases, item.Prediction.PredictedDisease)
+		}
+	}
+	return diseases
+}
+
+// Most frequent disease, ties broken by name so the answer is stable
+func dominantDisease(counts map[string]int) string {
+	diseases := make([]string, 0, len(counts))
+	for disease := range counts {
+		diseases = append(diseases, disease)
+	}
+	sort.Strings(diseases)
+
+	best := ""
+	for _, disease := range diseases {
+		if best == "" || counts[disease] > counts[best] {
+			best = disease
+		}
+	}
+	return best
+}
+
+// ====== BATCH HANDLERS ====== //
+
+// Queue every image of a batch upload. Images that fail validation are
+// reported and the rest are queued.
+func CreatePredictionBatchHandler(c *fiber.Ctx) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid multipart upload",
+			"hint":  "Upload images in the 'images' form field or a zip file in the 'archive' field",
+		})
+	}
+
+	images, rejected, err := collectBatchImages(form)
+	if errors.Is(err, errBatchTooManyImages) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Too many images, a batch can hold at most %d", maxBatchImages),
+		})
+	}
+	if errors.Is(err, errBatchTooLarge) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Archives too large, a batch can hold at most %dMB of images", maxBatchArchiveSize>>20),
+		})
+	}
+	if len(images) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":    "No valid images provided",
+			"hint":     "Upload images in the 'images' form field or a zip file in the 'archive' field",
+			"rejected": rejected,
+		})
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	batchID, err := newJobID()
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	jobs := make([]pendingJob, 0, len(images))
+	for _, image := range images {
+		job, err := newPredictionJob(c, userID, image.data, image.contentType, image.filename)
+		if err != nil {
+			return jobErrorResponse(c, err)
+		}
+		job.BatchID = batchID
+		jobs = append(jobs, job)
+	}
+
+	if err := enqueueJobs(userID, jobs); err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	jobIDs := make([]string, len(jobs))
+	for i, job := range jobs {
+		jobIDs[i] = job.ID
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"batch_id":   batchID,
+		"queued":     len(jobs),
+		"job_ids":    jobIDs,
+		"rejected":   rejected,
+		"status_url": "/api/ai/batches/" + batchID,
+	})
+}
+
+// Results and flock-level summary of a batch. ?wait=N long-polls for up to N
+// seconds until every image has been processed.
+func GetPredictionBatchHandler(c *fiber.Ctx) error {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	batchID := c.Params("id")
+	jobs, err := database.ListBatchJobs(DB, batchID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch batch"})
+	}
+	if len(jobs) == 0 || jobs[0].UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Batch not found"})
+	}
+
+	wait := time.Duration(c.QueryInt("wait")) * time.Second
+	if wait > maxJobWait {
+		wait = maxJobWait
+	}
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(c.UserContext(), wait)
+		defer cancel()
+		for i, job := range jobs {
+			if jobDone(job) {
+				continue
+			}
+			latest, err := predictionJobs.wait(ctx, job.ID)
+			if ctx.Err() != nil {
+				break
+			}
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch batch"})
+			}
+			jobs[i] = latest
+		}
+	}
+
+	items, summary, err := summarizeBatch(jobs)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch predictions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"batch_id": batchID,
+		"summary":  summary,
+		"items":    items,
+		"diseases": diseaseInfoMap(c, batchDiseases(items)),
+	})
+}
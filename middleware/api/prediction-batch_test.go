@@ -0,0 +1,67 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"testing"
+)
+
+// Zip archive of files with the given sizes
+func testArchive(t *testing.T, sizes ...int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i, size := range sizes {
+		f, err := w.Create(fmt.Sprintf("trays/%d.jpg", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(bytes.Repeat([]byte{0}, size))
+	}
+	w.Create("__MACOSX/._0.jpg")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBatchLimits(t *testing.T) {
+	tooMany := make([]int, maxBatchImages+1)
+	tests := []struct {
+		name    string
+		archive []byte
+		budget  batchBudget
+		want    error
+	}{
+		{"entries over the limit", testArchive(t, tooMany...), batchBudget{maxBatchImages, maxBatchArchiveSize}, errBatchTooManyImages},
+		{"entries over the room left", testArchive(t, 1, 1, 1), batchBudget{2, maxBatchArchiveSize}, errBatchTooManyImages},
+		{"size over the limit", testArchive(t, 600, 600), batchBudget{maxBatchImages, 1000}, errBatchTooLarge},
+		{"within limits", testArchive(t, 1, 1), batchBudget{2, 1000}, nil},
+	}
+	for _, tt := range tests {
+		budget := tt.budget
+		images, rejected, err := readImageArchive(tt.archive, &budget)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want != nil && (images != nil || rejected != nil) {
+			t.Errorf("%s: extracted %d entries of a rejected archive", tt.name, len(images)+len(rejected))
+		}
+		// Every entry counts against the batch, accepted or not
+		if tt.want == nil && len(images)+len(rejected) != 2 {
+			t.Errorf("%s: %d entries, want 2", tt.name, len(images)+len(rejected))
+		}
+	}
+
+	// Uploads with too many files are rejected before any is read
+	form := &multipart.Form{File: map[string][]*multipart.FileHeader{
+		"images": make([]*multipart.FileHeader, maxBatchImages),
+		"image":  {{Filename: "extra.jpg"}},
+	}}
+	if _, _, err := collectBatchImages(form); !errors.Is(err, errBatchTooManyImages) {
+		t.Errorf("collectBatchImages() error = %v, want %v", err, errBatchTooManyImages)
+	}
+}
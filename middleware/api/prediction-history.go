@@ -0,0 +1,228 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPredictionPageSize = 20
+	maxPredictionPageSize     = 100
+)
+
+// Store the prediction made for a queued job, tagged with the backend and model
+// that made it. Older AI services do not report the model, the last probed one
+// is used.
+func savePrediction(job database.PredictionJob, resp PredictionResponse, backend *aiBackend) (database.StoredPrediction, error) {
+	prediction := resp.Prediction
+	model := resp.Model
+	if model == nil {
+		model = backend.model()
+	}
+
+	stored := database.StoredPrediction{
+		UserID:           job.UserID,
+		Controller:       job.Controller,
+		House:            job.House,
+		ImageHash:        job.ImageHash,
+		ImagePath:        job.ImagePath,
+		ContentType:      job.ContentType,
+		PredictedDisease: prediction.PredictedDisease,
+		Confidence:       prediction.Confidence,
+		AllProbabilities: prediction.AllProbabilities,
+		IsHealthy:        prediction.IsHealthy,
+		Recommendation:   prediction.Recommendation,
+		Backend:          backend.name,
+		FlockID:          database.ActiveFlockID(DB, job.House, job.Controller, job.CreatedAt),
+		CreatedAt:        time.Now(),
+	}
+	assessment := assessPrediction(prediction)
+	stored.Outcome = assessment.Outcome
+	stored.Assessment = &assessment
+	if model != nil {
+		stored.ModelName = model.Name
+		stored.ModelVersion = model.Version
+	}
+
+	var err error
+	stored.ID, err = database.SavePrediction(DB, stored)
+	return stored, err
+}
+
+// Convert a stored prediction back to the AI service format
+func predictionFromStored(stored database.StoredPrediction) DiseasePrediction {
+	return DiseasePrediction{
+		PredictedDisease: stored.PredictedDisease,
+		Confidence:       stored.Confidence,
+		AllProbabilities: stored.AllProbabilities,
+		IsHealthy:        stored.IsHealthy,
+		Recommendation:   stored.Recommendation,
+	}
+}
+
+// Parse a date or RFC 3339 timestamp query parameter
+func parseTimeQuery(c *fiber.Ctx, key string) (time.Time, error) {
+	return parseTime(c.Query(key))
+}
+
+// Parse a date, as local midnight, or an RFC 3339 timestamp. Empty is the
+// zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// The logged in user, and whether they may see the predictions of other users
+func predictionViewer(c *fiber.Ctx) (userID int, allUsers bool, err error) {
+	userID, err = CurrentUserID(c)
+	if err != nil {
+		return 0, false, err
+	}
+	role, err := CurrentUserRole(c)
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, role == database.RoleVet || role == database.RoleAdmin, nil
+}
+
+// Fetch a prediction the logged in user may see. Predictions of other users
+// are not found unless the user is a veterinarian or admin.
+func visiblePrediction(c *fiber.Ctx, id int) (database.StoredPrediction, error) {
+	userID, allUsers, err := predictionViewer(c)
+	if err != nil {
+		return database.StoredPrediction{}, err
+	}
+	prediction, err := database.GetPrediction(DB, id)
+	if err == nil && !allUsers && prediction.UserID != userID {
+		return database.StoredPrediction{}, sql.ErrNoRows
+	}
+	return prediction, err
+}
+
+// List stored predictions with paging and filters:
+// ?page=1&page_size=20&disease=&house=&controller=&user_id=&healthy=&model=&from=&to=
+// Users see their own predictions, user_id is for veterinarians and admins.
+func ListPredictionsHandler(c *fiber.Ctx) error {
+	userID, allUsers, err := predictionViewer(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	page := c.QueryInt("page", 1)
+	pageSize := c.QueryInt("page_size", defaultPredictionPageSize)
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPredictionPageSize
+	}
+	if pageSize > maxPredictionPageSize {
+		pageSize = maxPredictionPageSize
+	}
+
+	filter := database.PredictionFilter{
+		UserID:     userID,
+		Controller: c.Query("controller"),
+		House:      c.Query("house"),
+		Disease:    c.Query("disease"),
+		Model:      c.Query("model"),
+		Backend:    c.Query("backend"),
+		Outcome:    c.Query("outcome"),
+		FlockID:    c.QueryInt("flock"),
+		Limit:      pageSize,
+		Offset:     (page - 1) * pageSize,
+	}
+	if allUsers {
+		filter.UserID = c.QueryInt("user_id")
+	}
+
+	if healthy := c.Query("healthy"); healthy != "" {
+		value, err := strconv.ParseBool(healthy)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "healthy must be true or false"})
+		}
+		filter.Healthy = &value
+	}
+
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'from' date"})
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'to' date"})
+	}
+
+	predictions, total, err := database.ListPredictions(DB, filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch predictions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"predictions": predictions,
+		"page":        page,
+		"page_size":   pageSize,
+		"total":       total,
+	})
+}
+
+func GetPredictionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid prediction ID"})
+	}
+
+	prediction, err := visiblePrediction(c, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prediction not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch prediction"})
+	}
+
+	// Include the veterinarian review if there is one
+	review, err := database.GetReview(DB, id)
+	if err == sql.ErrNoRows {
+		return c.JSON(fiber.Map{
+			"prediction":   prediction,
+			"disease_info": diseaseInfoFor(c, prediction.PredictedDisease),
+			"retake":       retakeGuidance(c, prediction),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch review"})
+	}
+	return c.JSON(fiber.Map{
+		"prediction":   prediction,
+		"review":       review,
+		"disease_info": diseaseInfoFor(c, prediction.PredictedDisease),
+		"retake":       retakeGuidance(c, prediction),
+	})
+}
+
+// Serve the image a prediction was made from
+func GetPredictionImageHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid prediction ID"})
+	}
+
+	prediction, err := visiblePrediction(c, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prediction not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch prediction"})
+	}
+
+	c.Set(fiber.HeaderContentType, prediction.ContentType)
+	return c.SendFile(prediction.ImagePath)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPredictionsAreScopedToTheirOwner(t *testing.T) {
+	ownerID, owner := testUser(t, "history-owner", database.RoleUser)
+	_, other := testUser(t, "history-other", database.RoleUser)
+	_, vet := testUser(t, "history-vet", database.RoleVet)
+
+	image := filepath.Join(t.TempDir(), "bird.jpg")
+	if err := os.WriteFile(image, []byte("jpeg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := database.SavePrediction(DB, database.StoredPrediction{
+		UserID:           ownerID,
+		ImagePath:        image,
+		ContentType:      "image/jpeg",
+		PredictedDisease: "Healthy",
+		Confidence:       0.9,
+		IsHealthy:        true,
+		CreatedAt:        time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/predictions", ListPredictionsHandler)
+	app.Get("/predictions/:id", GetPredictionHandler)
+	app.Get("/predictions/:id/image", GetPredictionImageHandler)
+
+	get := func(target string, cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(cookie)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	listed := func(target string, cookie *http.Cookie) (total, pageSize int) {
+		var body struct {
+			Total    int `json:"total"`
+			PageSize int `json:"page_size"`
+		}
+		if err := json.NewDecoder(get(target, cookie).Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Total, body.PageSize
+	}
+
+	detail := fmt.Sprintf("/predictions/%d", id)
+	tests := []struct {
+		name   string
+		target string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"owner detail", detail, owner, fiber.StatusOK},
+		{"owner image", detail + "/image", owner, fiber.StatusOK},
+		{"other detail", detail, other, fiber.StatusNotFound},
+		{"other image", detail + "/image", other, fiber.StatusNotFound},
+		{"vet detail", detail, vet, fiber.StatusOK},
+		{"vet image", detail + "/image", vet, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := get(tt.target, tt.cookie).StatusCode; got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if total, _ := listed("/predictions", owner); total != 1 {
+		t.Errorf("owner lists %d predictions, want 1", total)
+	}
+	if total, _ := listed(fmt.Sprintf("/predictions?user_id=%d", ownerID), other); total != 0 {
+		t.Errorf("other user lists %d predictions of the owner, want 0", total)
+	}
+	if total, _ := listed(fmt.Sprintf("/predictions?user_id=%d", ownerID), vet); total != 1 {
+		t.Errorf("vet lists %d predictions of the owner, want 1", total)
+	}
+	if _, pageSize := listed("/predictions?page_size=0", owner); pageSize != defaultPredictionPageSize {
+		t.Errorf("page_size=0 gives pages of %d, want %d", pageSize, defaultPredictionPageSize)
+	}
+}
@@ -0,0 +1,429 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The AI service is a single TensorFlow process, so predictions go through a
+// queue drained by a fixed number of workers instead of being sent from the
+// request handlers. Jobs live in the database and are picked up again after a
+// restart.
+
+const (
+	maxJobAttempts  = 2               // Attempts when the AI service is unreachable
+	jobRetryDelay   = 2 * time.Second // Pause before retrying an unreachable AI service
+	jobPollInterval = 5 * time.Second // Idle workers check the queue at least this often
+	maxJobWait      = 30 * time.Second
+)
+
+var (
+	aiJobsPerUser = getAIJobsPerUser()
+	aiJobTimeout  = getAIJobTimeout()
+
+	errTooManyJobs = errors.New("too many predictions in progress")
+)
+
+// Queued or running submissions allowed per user
+func getAIJobsPerUser() int {
+	if n, err := strconv.Atoi(os.Getenv("AI_JOBS_PER_USER")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+// Time a job may spend in the queue and the AI service together
+func getAIJobTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("AI_JOB_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// jobQueue wakes idle workers when a job is queued and the requests waiting
+// for a job when it finishes
+type jobQueue struct {
+	wake chan struct{}
+
+	mu      sync.Mutex // Also serializes the per-user limit check with the insert
+	waiters map[string][]chan struct{}
+}
+
+var predictionJobs = &jobQueue{
+	wake:    make(chan struct{}, 1),
+	waiters: make(map[string][]chan struct{}),
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Wake everyone waiting for a job
+func (q *jobQueue) finished(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, ch := range q.waiters[id] {
+		close(ch)
+	}
+	delete(q.waiters, id)
+}
+
+func (q *jobQueue) subscribe(id string) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ch := make(chan struct{})
+	q.waiters[id] = append(q.waiters[id], ch)
+	return ch
+}
+
+func (q *jobQueue) unsubscribe(id string, ch chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.waiters[id]
+	for i, w := range waiters {
+		if w == ch {
+			q.waiters[id] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(q.waiters[id]) == 0 {
+		delete(q.waiters, id)
+	}
+}
+
+// Wait until the job has finished or ctx is done, returning the latest state
+// of the job either way
+func (q *jobQueue) wait(ctx context.Context, id string) (database.PredictionJob, error) {
+	for {
+		// Subscribe before reading so a job finishing in between is not missed
+		ch := q.subscribe(id)
+		job, err := database.GetJob(DB, id)
+		if err != nil || jobDone(job) {
+			q.unsubscribe(id, ch)
+			return job, err
+		}
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			q.unsubscribe(id, ch)
+			return job, ctx.Err()
+		}
+	}
+}
+
+func jobDone(job database.PredictionJob) bool {
+	return job.Status != database.JobQueued && job.Status != database.JobRunning
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// pendingJob is a prediction job with its uploaded image, which is stored
+// only once the job is accepted
+type pendingJob struct {
+	database.PredictionJob
+	image []byte
+}
+
+// Prepare the prediction job of an uploaded image
+func newPredictionJob(c *fiber.Ctx, userID int, imageData []byte, contentType, filename string) (pendingJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return pendingJob{}, err
+	}
+
+	now := time.Now()
+	return pendingJob{
+		PredictionJob: database.PredictionJob{
+			ID:          id,
+			UserID:      userID,
+			Status:      database.JobQueued,
+			Filename:    filename,
+			ContentType: contentType,
+			Controller:  c.FormValue("controller"),
+			House:       c.FormValue("house"),
+			CreatedAt:   now,
+			Deadline:    now.Add(aiJobTimeout),
+		},
+		image: imageData,
+	}, nil
+}
+
+// Store the images of one submission and queue its jobs, unless the user
+// already has too many submissions in progress. Images are stored after the
+// check so rejected submissions leave nothing on disk.
+func enqueueJobs(userID int, jobs []pendingJob) error {
+	predictionJobs.mu.Lock()
+	defer predictionJobs.mu.Unlock()
+
+	active, err := database.CountActiveJobs(DB, userID)
+	if err != nil {
+		return err
+	}
+	if active >= aiJobsPerUser {
+		return errTooManyJobs
+	}
+
+	records := make([]database.PredictionJob, len(jobs))
+	for i := range jobs {
+		jobs[i].ImageHash, jobs[i].ImagePath, err = storeImage(jobs[i].image, jobs[i].ContentType)
+		if err != nil {
+			return err
+		}
+		records[i] = jobs[i].PredictionJob
+	}
+
+	if err := database.CreateJobs(DB, records); err != nil {
+		return err
+	}
+	predictionJobs.signal()
+	return nil
+}
+
+// Store the uploaded image and queue a prediction for the current user
+func enqueuePredictionJob(c *fiber.Ctx, imageData []byte, contentType, filename string) (database.PredictionJob, error) {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return database.PredictionJob{}, err
+	}
+
+	job, err := newPredictionJob(c, userID, imageData, contentType, filename)
+	if err != nil {
+		return database.PredictionJob{}, err
+	}
+	jobs := []pendingJob{job}
+	err = enqueueJobs(userID, jobs)
+	return jobs[0].PredictionJob, err
+}
+
+func jobErrorResponse(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errTooManyJobs) {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many predictions in progress",
+			"hint":  "Wait for your previous photos to be analyzed",
+			"limit": aiJobsPerUser,
+		})
+	}
+	log.Println("Failed to queue prediction:", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to queue prediction"})
+}
+
+// HTTP status for a job that did not succeed
+func jobFailureStatus(job database.PredictionJob) int {
+	if job.Status == database.JobTimedOut {
+		return fiber.StatusGatewayTimeout
+	}
+	return fiber.StatusBadGateway
+}
+
+// ====== WORKERS ====== //
+
+// StartPredictionWorkers requeues the jobs interrupted by the last shutdown
+// and starts n workers
+func StartPredictionWorkers(n int) {
+	recovered, err := database.RecoverInterruptedJobs(DB)
+	if err != nil {
+		log.Println("Failed to recover prediction jobs:", err)
+	} else if recovered > 0 {
+		log.Printf("Requeued %d interrupted prediction jobs", recovered)
+	}
+
+	for i := 0; i < n; i++ {
+		go predictionWorker()
+	}
+}
+
+func predictionWorker() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Leave the jobs queued while the AI service is down, they only expire
+		if !aiAvailable() {
+			expireJobs()
+			select {
+			case <-predictionJobs.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		job, err := database.ClaimNextJob(DB)
+		if err == sql.ErrNoRows {
+			select {
+			case <-predictionJobs.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+		if err != nil {
+			log.Println("Failed to claim prediction job:", err)
+			time.Sleep(jobPollInterval)
+			continue
+		}
+
+		runPredictionJob(job)
+		predictionJobs.finished(job.ID)
+	}
+}
+
+func expireJobs() {
+	expired, err := database.ExpireJobs(DB, time.Now())
+	if err != nil {
+		log.Println("Failed to expire prediction jobs:", err)
+	}
+	for _, id := range expired {
+		predictionJobs.finished(id)
+	}
+}
+
+func runPredictionJob(job database.PredictionJob) {
+	if time.Now().After(job.Deadline) {
+		finishJob(job, database.JobTimedOut, "Job expired before the AI service was available", nil)
+		return
+	}
+
+	imageData, err := os.ReadFile(job.ImagePath)
+	if err != nil {
+		finishJob(job, database.JobFailed, "Image is no longer available", nil)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), job.Deadline)
+	defer cancel()
+
+	resp, backend, err := classifyJob(ctx, job, imageData)
+	var aiErr *aiServiceError
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil:
+		finishJob(job, database.JobTimedOut, "AI service did not answer in time", nil)
+		return
+	case errors.As(err, &aiErr):
+		finishJob(job, database.JobFailed, aiErr.message, nil)
+		return
+	case job.Attempts < maxJobAttempts:
+		// AI service unreachable, probably restarting
+		log.Printf("Prediction job %s failed, retrying: %v", job.ID, err)
+		if !sleepContext(ctx, jobRetryDelay) {
+			finishJob(job, database.JobTimedOut, "AI service did not answer in time", nil)
+			return
+		}
+		if err := database.RequeueJob(DB, job.ID, err.Error()); err != nil {
+			log.Println("Failed to requeue prediction job:", err)
+		}
+		predictionJobs.signal()
+		return
+	default:
+		finishJob(job, database.JobFailed, "AI service is unavailable", nil)
+		return
+	}
+
+	stored, err := savePrediction(job, resp, backend)
+	if err != nil {
+		log.Println("Failed to save prediction:", err)
+		finishJob(job, database.JobFailed, "Failed to save prediction", nil)
+		return
+	}
+	finishJob(job, database.JobSucceeded, "", &stored.ID)
+
+	// Logged for comparing the backends when jobs are split between them
+	log.Printf("Disease prediction: %s (%.2f%% confidence) by %s model %s",
+		stored.PredictedDisease, stored.Confidence*100, stored.Backend, stored.ModelVersion)
+}
+
+func finishJob(job database.PredictionJob, status, errMsg string, predictionID *int) {
+	if err := database.FinishJob(DB, job.ID, status, errMsg, predictionID); err != nil {
+		log.Printf("Failed to finish prediction job %s: %v", job.ID, err)
+	}
+}
+
+// ====== JOB HANDLERS ====== //
+
+// Queue an image for prediction and return immediately with the job ID
+func CreatePredictionJobHandler(c *fiber.Ctx) error {
+	file, err := c.FormFile("image")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No image file provided",
+			"hint":  "Please upload an image file using the 'image' form field",
+		})
+	}
+
+	imageData, contentType, uploadErr := readImageUpload(file)
+	if uploadErr != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(uploadErr)
+	}
+
+	job, err := enqueuePredictionJob(c, imageData, contentType, file.Filename)
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"job_id":       job.ID,
+		"status":       job.Status,
+		"deadline":     job.Deadline,
+		"status_url":   "/api/ai/jobs/" + job.ID,
+		"ai_available": aiAvailable(),
+	})
+}
+
+// Status of a job. ?wait=N long-polls for up to N seconds until it finishes.
+func GetPredictionJobHandler(c *fiber.Ctx) error {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	job, err := database.GetJob(DB, c.Params("id"))
+	if err == sql.ErrNoRows || (err == nil && job.UserID != userID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Job not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch job"})
+	}
+
+	wait := time.Duration(c.QueryInt("wait")) * time.Second
+	if wait > maxJobWait {
+		wait = maxJobWait
+	}
+	if wait > 0 && !jobDone(job) {
+		ctx, cancel := context.WithTimeout(c.UserContext(), wait)
+		defer cancel()
+		if latest, err := predictionJobs.wait(ctx, job.ID); err == nil || errors.Is(err, context.DeadlineExceeded) {
+			job = latest
+		}
+	}
+
+	response := fiber.Map{"job": job}
+	if job.Status == database.JobSucceeded && job.PredictionID != nil {
+		if stored, err := database.GetPrediction(DB, *job.PredictionID); err == nil {
+			response["prediction"] = stored
+			response["disease_info"] = diseaseInfoFor(c, stored.PredictedDisease)
+			response["retake"] = retakeGuidance(c, stored)
+		}
+	}
+	return c.JSON(response)
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"middleware/database"
+)
+
+func TestRejectedJobsLeaveNoImage(t *testing.T) {
+	userID, _ := testUser(t, "jobs-limit", database.RoleUser)
+	defer func(dir string) { ImageStoreDir = dir }(ImageStoreDir)
+	ImageStoreDir = t.TempDir()
+
+	job := func(n int) pendingJob {
+		id, err := newJobID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pendingJob{
+			PredictionJob: database.PredictionJob{
+				ID:          id,
+				UserID:      userID,
+				Status:      database.JobQueued,
+				ContentType: "image/jpeg",
+				CreatedAt:   time.Now(),
+				Deadline:    time.Now().Add(time.Hour),
+			},
+			image: []byte(fmt.Sprintf("image %d", n)),
+		}
+	}
+
+	for n := 0; n <= aiJobsPerUser; n++ {
+		err := enqueueJobs(userID, []pendingJob{job(n)})
+		if n < aiJobsPerUser && err != nil {
+			t.Fatalf("job %d: %v", n, err)
+		}
+		if n == aiJobsPerUser && !errors.Is(err, errTooManyJobs) {
+			t.Fatalf("job over the limit: error = %v, want %v", err, errTooManyJobs)
+		}
+	}
+
+	stored := 0
+	filepath.WalkDir(ImageStoreDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			stored++
+		}
+		return err
+	})
+	if stored != aiJobsPerUser {
+		t.Errorf("%d images stored, want %d", stored, aiJobsPerUser)
+	}
+}
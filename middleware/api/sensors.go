@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Sensors are registered with a type, a unit and their place in a house.
+// Readings are ingested by sensor ID, so new hardware only needs a registry
+// entry. Sensors of a polled controller name the field of its readings they
+// read, and are recorded with the telemetry.
+
+const (
+	maxReadingBatch = 1000
+	maxReadingSkew  = 5 * time.Minute // Clock difference allowed for readings from the future
+)
+
+// Sensor IDs are chosen by the installer, e.g. "house-a-nh3-1"
+var sensorIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// Whether a value is possible in a unit, to catch wiring and decoding faults
+func plausibleReading(unit string, value float64) bool {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return false
+	}
+	switch unit {
+	case "%":
+		return value >= 0 && value <= 100
+	case "°C":
+		return value >= -50 && value <= 80
+	case "°F":
+		return value >= -58 && value <= 176
+	case "ppm", "lux", "cm":
+		return value >= 0
+	}
+	return true
+}
+
+// Store the readings of the registered sensors of a polled controller. Each
+// sensor names the field of the controller's readings it reads.
+func recordControllerSensors(address string, values map[string]float64, at time.Time) error {
+	sensors, err := database.ListSensors(DB, database.SensorFilter{Controller: address, ActiveOnly: true})
+	if err != nil || len(sensors) == 0 {
+		return err
+	}
+
+	readings := []database.SensorReading{}
+	for _, s := range sensors {
+		value, ok := values[s.Field]
+		if s.Field == "" || !ok || !plausibleReading(s.Unit, value) {
+			continue
+		}
+		readings = append(readings, database.SensorReading{
+			SensorID:   s.ID,
+			Value:      value,
+			RecordedAt: at,
+			FlockID:    database.ActiveFlockID(DB, s.House, s.Controller, at),
+		})
+	}
+	if len(readings) == 0 {
+		return nil
+	}
+	return database.SaveSensorReadings(DB, readings)
+}
+
+// Poll the sensors of controllers other than the default one through their
+// drivers. The default controller is read with the telemetry.
+func recordPolledSensorsOnce() error {
+	sensors, err := database.ListSensors(DB, database.SensorFilter{ActiveOnly: true})
+	if err != nil {
+		return err
+	}
+	addresses := map[string]bool{}
+	for _, s := range sensors {
+		if s.Field != "" && s.Controller != "" && s.Controller != controller.Address {
+			addresses[s.Controller] = true
+		}
+	}
+
+	var firstErr error
+	for address := range addresses {
+		driver, err := driverFor(address)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), controllerRequestTimeout)
+			var values map[string]float64
+			values, err = driver.ReadSensors(ctx)
+			cancel()
+			if err == nil {
+				err = recordControllerSensors(address, values, time.Now())
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", address, err)
+		}
+	}
+	return firstErr
+}
+
+func ListSensorTypesHandler(c *fiber.Ctx) error {
+	return c.JSON(database.SensorTypes)
+}
+
+// ====== SENSOR HANDLERS ====== //
+
+type sensorRequest struct {
+	ID         string `json:"id"` // Only read on create
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Unit       string `json:"unit"` // Defaults to the first unit of the type
+	HouseID    int    `json:"house_id"`
+	ZoneID     *int   `json:"zone_id"`
+	Controller string `json:"controller"` // Defaults to the controller of the house when a field is set
+	Field      string `json:"field"`
+	Active     *bool  `json:"active"` // Defaults to true
+}
+
+// Validate a request into a sensor. On failure the returned map is the body
+// of a 400 response.
+func (req sensorRequest) sensor(id string) (database.Sensor, fiber.Map) {
+	s := database.Sensor{
+		ID:         id,
+		Name:       strings.TrimSpace(req.Name),
+		Type:       strings.ToLower(strings.TrimSpace(req.Type)),
+		Unit:       strings.TrimSpace(req.Unit),
+		HouseID:    req.HouseID,
+		ZoneID:     req.ZoneID,
+		Controller: strings.TrimRight(strings.TrimSpace(req.Controller), "/"),
+		Field:      strings.TrimSpace(req.Field),
+		Active:     req.Active == nil || *req.Active,
+	}
+
+	sensorType, ok := database.GetSensorType(s.Type)
+	if !ok {
+		keys := make([]string, 0, len(database.SensorTypes))
+		for _, t := range database.SensorTypes {
+			keys = append(keys, t.Key)
+		}
+		return s, fiber.Map{"error": "Unknown sensor type", "hint": "One of " + strings.Join(keys, ", ")}
+	}
+	if s.Unit == "" {
+		s.Unit = sensorType.Units[0]
+	}
+	valid := false
+	for _, unit := range sensorType.Units {
+		valid = valid || unit == s.Unit
+	}
+	if !valid {
+		return s, fiber.Map{"error": "Invalid unit for " + s.Type, "hint": "One of " + strings.Join(sensorType.Units, ", ")}
+	}
+
+	house, err := database.GetHouse(DB, s.HouseID)
+	if err != nil {
+		return s, fiber.Map{"error": "Unknown house"}
+	}
+	if s.ZoneID != nil {
+		zone, err := database.GetZone(DB, *s.ZoneID)
+		if err != nil || zone.HouseID != house.ID {
+			return s, fiber.Map{"error": "Zone must belong to the house"}
+		}
+	}
+	if s.Field != "" && s.Controller == "" {
+		s.Controller = house.Controller
+	}
+	if s.Field != "" && s.Controller == "" {
+		return s, fiber.Map{"error": "A polled sensor needs a controller", "hint": "Set the controller of the sensor or of its house"}
+	}
+	return s, nil
+}
+
+func sensorFromParams(c *fiber.Ctx) (database.Sensor, int, fiber.Map) {
+	sensor, err := database.GetSensor(DB, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return sensor, fiber.StatusNotFound, fiber.Map{"error": "Sensor not found"}
+	}
+	if err != nil {
+		return sensor, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch sensor"}
+	}
+	return sensor, 0, nil
+}
+
+// List sensors: ?house_id=&zone_id=&type=&active=true
+func ListSensorsHandler(c *fiber.Ctx) error {
+	sensors, err := database.ListSensors(DB, database.SensorFilter{
+		HouseID:    c.QueryInt("house_id"),
+		ZoneID:     c.QueryInt("zone_id"),
+		Type:       c.Query("type"),
+		ActiveOnly: c.Query("active") == "true",
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sensors"})
+	}
+	return c.JSON(sensors)
+}
+
+func GetSensorHandler(c *fiber.Ctx) error {
+	sensor, status, body := sensorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	return c.JSON(sensor)
+}
+
+func CreateSensorHandler(c *fiber.Ctx) error {
+	var req sensorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !sensorIDPattern.MatchString(req.ID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid sensor ID",
+			"hint":  "Up to 64 letters, digits and _ . : -",
+		})
+	}
+	sensor, body := req.sensor(req.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+	if _, err := database.GetSensor(DB, sensor.ID); err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A sensor with this ID already exists"})
+	}
+
+	if err := database.CreateSensor(DB, sensor); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create sensor"})
+	}
+	created, err := database.GetSensor(DB, sensor.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sensor"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Sensor registered successfully", "sensor": created})
+}
+
+// Replace a sensor. Its readings are kept.
+func UpdateSensorHandler(c *fiber.Ctx) error {
+	existing, status, body := sensorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	var req sensorRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	sensor, body := req.sensor(existing.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	if err := database.UpdateSensor(DB, sensor); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update sensor"})
+	}
+	updated, err := database.GetSensor(DB, sensor.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sensor"})
+	}
+	return c.JSON(fiber.Map{"message": "Sensor updated successfully", "sensor": updated})
+}
+
+func DeleteSensorHandler(c *fiber.Ctx) error {
+	err := database.DeleteSensor(DB, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Sensor not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete sensor"})
+	}
+	return c.JSON(fiber.Map{"message": "Sensor deleted successfully"})
+}
+
+// ====== READINGS ====== //
+
+type sensorReadingRequest struct {
+	SensorID   string   `json:"sensor_id"`
+	Value      *float64 `json:"value"`
+	RecordedAt string   `json:"recorded_at"` // Defaults to now
+}
+
+// Ingest readings pushed by a controller, behind RequireControllerEnvelope.
+// The sealed body is
+//
+//	{"readings": [{"sensor_id": "house-a-nh3-1", "value": 12.5, "recorded_at": "2025-01-01T06:00:00Z"}]}
+//
+// A controller only records readings of the sensors linked to it. Valid
+// readings are stored even when others in the batch are rejected.
+func IngestSensorReadingsHandler(c *fiber.Ctx) error {
+	controller := pushingController(c)
+	if controller == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized access"})
+	}
+
+	var req struct {
+		Readings []sensorReadingRequest `json:"readings"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if len(req.Readings) == 0 || len(req.Readings) > maxReadingBatch {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Send between 1 and %d readings", maxReadingBatch),
+		})
+	}
+
+	now := time.Now()
+	sensors := map[string]*database.Sensor{}
+	readings := []database.SensorReading{}
+	rejected := []fiber.Map{}
+	reject := func(i int, r sensorReadingRequest, reason string) {
+		rejected = append(rejected, fiber.Map{"index": i, "sensor_id": r.SensorID, "error": reason})
+	}
+	for i, r := range req.Readings {
+		sensor, ok := sensors[r.SensorID]
+		if !ok {
+			if s, err := database.GetSensor(DB, r.SensorID); err == nil {
+				sensor = &s
+			} else if err != sql.ErrNoRows {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch sensor"})
+			}
+			sensors[r.SensorID] = sensor
+		}
+
+		recordedAt := now
+		if r.RecordedAt != "" {
+			t, err := parseTime(r.RecordedAt)
+			if err != nil {
+				reject(i, r, "recorded_at must be an RFC 3339 timestamp")
+				continue
+			}
+			recordedAt = t
+		}
+
+		switch {
+		case sensor == nil:
+			reject(i, r, "Unknown sensor")
+		case sensor.Controller != controller:
+			reject(i, r, "Sensor is not linked to this controller")
+		case !sensor.Active:
+			reject(i, r, "Sensor is inactive")
+		case r.Value == nil:
+			reject(i, r, "value is required")
+		case !plausibleReading(sensor.Unit, *r.Value):
+			reject(i, r, fmt.Sprintf("value is not possible in %s", sensor.Unit))
+		case recordedAt.After(now.Add(maxReadingSkew)):
+			reject(i, r, "recorded_at is in the future")
+		default:
+			readings = append(readings, database.SensorReading{
+				SensorID:   sensor.ID,
+				Value:      *r.Value,
+				RecordedAt: recordedAt,
+				FlockID:    database.ActiveFlockID(DB, sensor.House, sensor.Controller, recordedAt),
+			})
+		}
+	}
+
+	if len(readings) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No valid readings", "rejected": rejected})
+	}
+	if err := database.SaveSensorReadings(DB, readings); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save readings"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Readings recorded",
+		"accepted": len(readings),
+		"rejected": rejected,
+	})
+}
+
+// Readings of a sensor: ?from=&to=, defaults to the last 24 hours
+func GetSensorReadingsHandler(c *fiber.Ctx) error {
+	sensor, status, body := sensorFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be a date or RFC 3339 timestamp"})
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be a date or RFC 3339 timestamp"})
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+
+	readings, err := database.ListSensorReadings(DB, sensor.ID, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch readings"})
+	}
+	return c.JSON(fiber.Map{"sensor": sensor, "from": from, "to": to, "readings": readings})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"middleware/database"
+	"middleware/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIngestSensorReadingsNeedsTheControllerKey(t *testing.T) {
+	const address, other = "10.0.0.9:443", "10.0.0.10:443"
+	farmID, err := database.CreateFarm(DB, database.Farm{Name: "Push farm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	houseID, err := database.CreateHouse(DB, database.House{FarmID: farmID, Name: "push-house", Controller: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, controller := range map[string]string{"push-temp": address, "push-other": other} {
+		err := database.CreateSensor(DB, database.Sensor{ID: id, Type: "temperature", Unit: "°C", HouseID: houseID, Controller: controller, Active: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	secret := bytes.Repeat([]byte{7}, 32)
+	const keyID = "0a0b0c0d"
+	if err := database.RotateControllerKey(DB, address, keyID, hex.EncodeToString(secret)); err != nil {
+		t.Fatal(err)
+	}
+	seal := func(key []byte, counter uint64, payload string) []byte {
+		env, err := utils.EncryptAESGCM([]byte(payload), key, envelopeAAD(address, directionFromController, keyID, counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.KeyID, env.Counter = keyID, counter
+		body, _ := json.Marshal(env)
+		return body
+	}
+
+	app := fiber.New()
+	app.Post("/device/sensors/readings", RequireControllerEnvelope, IngestSensorReadingsHandler)
+	push := func(controller string, body []byte) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/device/sensors/readings", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if controller != "" {
+			req.Header.Set(controllerHeader, controller)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	const readings = `{"readings": [{"sensor_id": "push-temp", "value": 24.5}, {"sensor_id": "push-other", "value": 22}]}`
+	sealed := seal(secret, 1, readings)
+	code, out := push(address, sealed)
+	if code != fiber.StatusCreated || out["accepted"] != 1.0 {
+		t.Fatalf("sealed push = %d %v, want 201 with 1 reading", code, out)
+	}
+	rejected, _ := out["rejected"].([]interface{})
+	if len(rejected) != 1 || rejected[0].(map[string]interface{})["sensor_id"] != "push-other" {
+		t.Errorf("rejected = %v, want the sensor of the other controller", out["rejected"])
+	}
+
+	tampered := seal(secret, 2, readings)
+	tampered[bytes.Index(tampered, []byte(`"data":"`))+8] ^= 1
+
+	tests := []struct {
+		name       string
+		controller string
+		body       []byte
+	}{
+		{"replayed", address, sealed},
+		{"plaintext", address, []byte(readings)},
+		{"no controller header", "", seal(secret, 3, readings)},
+		{"controller without a key", other, seal(secret, 4, readings)},
+		{"wrong key", address, seal(bytes.Repeat([]byte{8}, 32), 5, readings)},
+		{"tampered", address, tampered},
+	}
+	for _, tt := range tests {
+		if code, out := push(tt.controller, tt.body); code != fiber.StatusUnauthorized {
+			t.Errorf("%s: push = %d %v, want 401", tt.name, code, out)
+		}
+	}
+
+	stored, err := database.ListSensorReadings(DB, "push-temp", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil || len(stored) != 1 {
+		t.Errorf("stored readings = %v, %v, want the first push only", stored, err)
+	}
+}
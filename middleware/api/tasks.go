@@ -0,0 +1,699 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+	"middleware/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Farm chores such as cleaning the conveyor belt are tasks assigned to a
+// worker. A task is due once, or repeats by a rule like
+// "FREQ=DAILY;BYHOUR=6,17". Each occurrence is completed with notes and an
+// optional photo, occurrences left undone raise findings, and the activity
+// report shows owners what every worker did in a day.
+
+const (
+	findingTaskOverdue  = "task_overdue"
+	taskOverdueLookback = 7 * 24 * time.Hour // Older misses no longer raise findings
+	maxAgendaRange      = 31 * 24 * time.Hour
+)
+
+// Task occurrence statuses
+const (
+	taskPending = "pending"
+	taskOverdue = "overdue"
+	taskDone    = "done"
+)
+
+var taskOverdueGrace = getTaskOverdueGrace()
+
+// Time after its due time an occurrence becomes overdue
+func getTaskOverdueGrace() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("TASK_OVERDUE_GRACE")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * time.Minute
+}
+
+type completionView struct {
+	database.TaskCompletion
+	PhotoURL string `json:"photo_url,omitempty"`
+}
+
+func viewCompletion(c database.TaskCompletion) *completionView {
+	v := &completionView{TaskCompletion: c}
+	if c.PhotoPath != "" {
+		v.PhotoURL = fmt.Sprintf("/api/tasks/completions/%d/photo", c.ID)
+	}
+	return v
+}
+
+// taskOccurrence is one due time of a task
+type taskOccurrence struct {
+	TaskID     int             `json:"task_id"`
+	Title      string          `json:"title"`
+	House      string          `json:"house"`
+	AssigneeID *int            `json:"assignee_id"`
+	Assignee   string          `json:"assignee"`
+	DueAt      time.Time       `json:"due_at"`
+	Status     string          `json:"status"`
+	Completion *completionView `json:"completion,omitempty"`
+}
+
+// Due times of a task in [from, to)
+func taskOccurrences(task database.Task, from, to time.Time) ([]time.Time, error) {
+	start := task.DueAt.Local()
+	if task.RRule == "" {
+		if start.Before(from) || !start.Before(to) {
+			return nil, nil
+		}
+		return []time.Time{start}, nil
+	}
+	rule, err := utils.ParseRecurrence(task.RRule)
+	if err != nil {
+		return nil, err
+	}
+	return rule.Between(start, from, to), nil
+}
+
+// Occurrences of a task due in [from, to) with their completions. Completed
+// occurrences the rule no longer produces, after an edit, are kept.
+func taskAgenda(task database.Task, from, to, now time.Time) ([]taskOccurrence, error) {
+	times, err := taskOccurrences(task, from, to)
+	if err != nil {
+		return nil, err
+	}
+	completions, err := database.ListTaskCompletions(DB, task.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	completed := map[int64]database.TaskCompletion{}
+	for _, c := range completions {
+		completed[c.DueAt.Unix()] = c
+	}
+
+	agenda := make([]taskOccurrence, 0, len(times))
+	for _, t := range times {
+		o := taskOccurrence{TaskID: task.ID, Title: task.Title, House: task.House, AssigneeID: task.AssigneeID,
+			Assignee: task.Assignee, DueAt: t, Status: taskPending}
+		if c, ok := completed[t.Unix()]; ok {
+			o.Status, o.Completion = taskDone, viewCompletion(c)
+			delete(completed, t.Unix())
+		} else if now.After(t.Add(taskOverdueGrace)) {
+			o.Status = taskOverdue
+		}
+		agenda = append(agenda, o)
+	}
+	for _, c := range completed {
+		agenda = append(agenda, taskOccurrence{TaskID: task.ID, Title: task.Title, House: task.House,
+			AssigneeID: task.AssigneeID, Assignee: task.Assignee, DueAt: c.DueAt.Local(), Status: taskDone,
+			Completion: viewCompletion(c)})
+	}
+	sort.Slice(agenda, func(i, j int) bool { return agenda[i].DueAt.Before(agenda[j].DueAt) })
+	return agenda, nil
+}
+
+// Occurrences of an active task left undone within the lookback
+func overdueOccurrences(task database.Task, now time.Time) ([]taskOccurrence, error) {
+	if !task.Active {
+		return nil, nil
+	}
+	agenda, err := taskAgenda(task, now.Add(-taskOverdueLookback), now, now)
+	if err != nil {
+		return nil, err
+	}
+	overdue := []taskOccurrence{}
+	for _, o := range agenda {
+		if o.Status == taskOverdue {
+			overdue = append(overdue, o)
+		}
+	}
+	return overdue, nil
+}
+
+// Next due time of a task after a time, nil when the rule has ended
+func nextOccurrence(task database.Task, after time.Time) *time.Time {
+	times, err := taskOccurrences(task, after, after.AddDate(1, 0, 0))
+	if err != nil || len(times) == 0 {
+		return nil
+	}
+	return &times[0]
+}
+
+func taskSubject(id int) string {
+	return fmt.Sprintf("task %d", id)
+}
+
+// RunTaskReminders raises a finding for every active task with overdue
+// occurrences. Several misses, or one older than a day, are high severity.
+// Owners are only alerted through these findings.
+func RunTaskReminders(now time.Time) ([]database.Finding, error) {
+	tasks, err := database.ListTasks(DB, database.TaskFilter{ActiveOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	findings := []database.Finding{}
+	for _, task := range tasks {
+		overdue, err := overdueOccurrences(task, now)
+		if err != nil {
+			log.Printf("Task %d: %v", task.ID, err)
+			continue
+		}
+		if len(overdue) == 0 {
+			continue
+		}
+
+		oldest := overdue[0].DueAt
+		severity := "medium"
+		if len(overdue) > 1 || now.Sub(oldest) > 24*time.Hour {
+			severity = "high"
+		}
+		assignee := "unassigned"
+		if task.Assignee != "" {
+			assignee = "assigned to " + task.Assignee
+		}
+		when := "is overdue since " + oldest.Format("Jan 2 15:04")
+		if len(overdue) > 1 {
+			when = fmt.Sprintf("was missed %d times since %s", len(overdue), oldest.Format("Jan 2 15:04"))
+		}
+		dueTimes := make([]time.Time, 0, len(overdue))
+		for _, o := range overdue {
+			dueTimes = append(dueTimes, o.DueAt)
+		}
+
+		finding := database.Finding{
+			Kind:     findingTaskOverdue,
+			House:    task.House,
+			Subject:  taskSubject(task.ID),
+			Severity: severity,
+			Status:   database.FindingOpen,
+			Summary:  fmt.Sprintf("%s, %s, %s", task.Title, assignee, when),
+			Details: map[string]interface{}{
+				"task":    task,
+				"overdue": dueTimes,
+			},
+			WindowStart: oldest,
+			WindowEnd:   now,
+			FlockID:     task.FlockID,
+		}
+		finding.ID, err = database.UpsertFinding(DB, finding)
+		if err != nil {
+			return findings, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+// StartTaskReminders checks for overdue tasks every interval. It never
+// returns.
+func StartTaskReminders(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := RunTaskReminders(time.Now()); err != nil {
+			log.Println("Task reminders failed:", err)
+		}
+	}
+}
+
+// ====== TASK HANDLERS ====== //
+
+type taskRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	House       string `json:"house"`
+	FlockID     *int   `json:"flock_id"`
+	Assignee    string `json:"assignee"` // Username, empty for unassigned
+	DueAt       string `json:"due_at"`   // First occurrence
+	RRule       string `json:"rrule"`
+	Active      *bool  `json:"active"` // Defaults to true
+}
+
+// Validate a request into a task. On failure the returned map is the body of
+// a 400 response.
+func (req taskRequest) task(id int) (database.Task, fiber.Map) {
+	t := database.Task{
+		ID:          id,
+		Title:       strings.TrimSpace(req.Title),
+		Description: req.Description,
+		House:       strings.TrimSpace(req.House),
+		FlockID:     req.FlockID,
+		RRule:       strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(req.RRule)), "RRULE:"),
+		Active:      req.Active == nil || *req.Active,
+	}
+	if t.Title == "" {
+		return t, fiber.Map{"error": "Title is required"}
+	}
+
+	dueAt, err := parseTime(req.DueAt)
+	if err != nil || dueAt.IsZero() {
+		return t, fiber.Map{"error": "due_at must be a date or RFC 3339 timestamp"}
+	}
+	t.DueAt = dueAt
+
+	if t.RRule != "" {
+		if _, err := utils.ParseRecurrence(t.RRule); err != nil {
+			return t, fiber.Map{
+				"error": "Invalid rrule: " + err.Error(),
+				"hint":  "For example FREQ=DAILY;BYHOUR=6,17 or FREQ=WEEKLY;BYDAY=MO,TH",
+			}
+		}
+	}
+
+	if username := strings.TrimSpace(req.Assignee); username != "" {
+		userID, err := database.GetUserID(DB, username)
+		if err != nil {
+			return t, fiber.Map{"error": "Unknown assignee " + username}
+		}
+		t.AssigneeID, t.Assignee = &userID, username
+	}
+
+	if t.FlockID != nil {
+		flock, err := database.GetFlock(DB, *t.FlockID)
+		if err != nil {
+			return t, fiber.Map{"error": "Unknown flock"}
+		}
+		if t.House == "" {
+			t.House = flock.House
+		}
+	}
+	return t, nil
+}
+
+func taskFromParams(c *fiber.Ctx) (database.Task, int, fiber.Map) {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return database.Task{}, fiber.StatusBadRequest, fiber.Map{"error": "Invalid task ID"}
+	}
+	task, err := database.GetTask(DB, id)
+	if err == sql.ErrNoRows {
+		return task, fiber.StatusNotFound, fiber.Map{"error": "Task not found"}
+	}
+	if err != nil {
+		return task, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch task"}
+	}
+	return task, 0, nil
+}
+
+// User ID of the ?assignee= query, "me" for the logged in user. 0 when not
+// filtered.
+func assigneeQuery(c *fiber.Ctx) (int, int, fiber.Map) {
+	switch username := c.Query("assignee"); username {
+	case "":
+		return 0, 0, nil
+	case "me":
+		userID, err := CurrentUserID(c)
+		if err != nil {
+			return 0, fiber.StatusInternalServerError, fiber.Map{"error": "Error fetching user"}
+		}
+		return userID, 0, nil
+	default:
+		userID, err := database.GetUserID(DB, username)
+		if err == sql.ErrNoRows {
+			return 0, fiber.StatusBadRequest, fiber.Map{"error": "Unknown assignee " + username}
+		}
+		if err != nil {
+			return 0, fiber.StatusInternalServerError, fiber.Map{"error": "Error fetching user"}
+		}
+		return userID, 0, nil
+	}
+}
+
+// List tasks: ?assignee=me|username&house=&active=true
+func ListTasksHandler(c *fiber.Ctx) error {
+	assigneeID, status, body := assigneeQuery(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	tasks, err := database.ListTasks(DB, database.TaskFilter{
+		AssigneeID: assigneeID,
+		House:      c.Query("house"),
+		ActiveOnly: c.Query("active") == "true",
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch tasks"})
+	}
+	return c.JSON(tasks)
+}
+
+func CreateTaskHandler(c *fiber.Ctx) error {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+	var req taskRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	task, body := req.task(0)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+	task.CreatedBy = userID
+
+	id, err := database.CreateTask(DB, task)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create task"})
+	}
+	created, err := database.GetTask(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":  "Task created successfully",
+		"task":     created,
+		"next_due": nextOccurrence(created, time.Now()),
+	})
+}
+
+// A task with its occurrences of the past and coming week
+func GetTaskHandler(c *fiber.Ctx) error {
+	task, status, body := taskFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	now := time.Now()
+	today := analysis.StartOfDay(now)
+	agenda, err := taskAgenda(task, today.AddDate(0, 0, -7), today.AddDate(0, 0, 8), now)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task occurrences"})
+	}
+	return c.JSON(fiber.Map{"task": task, "occurrences": agenda, "next_due": nextOccurrence(task, now)})
+}
+
+// Replace a task. Its completions are kept.
+func UpdateTaskHandler(c *fiber.Ctx) error {
+	existing, status, body := taskFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	var req taskRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	task, body := req.task(existing.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	if err := database.UpdateTask(DB, task); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update task"})
+	}
+	updated, err := database.GetTask(DB, task.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task"})
+	}
+	return c.JSON(fiber.Map{
+		"message":  "Task updated successfully",
+		"task":     updated,
+		"next_due": nextOccurrence(updated, time.Now()),
+	})
+}
+
+func DeleteTaskHandler(c *fiber.Ctx) error {
+	task, status, body := taskFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if err := database.DeleteTask(DB, task.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete task"})
+	}
+	if userID, err := CurrentUserID(c); err == nil {
+		if err := database.AcknowledgeOpenFinding(DB, findingTaskOverdue, task.House, taskSubject(task.ID), userID); err != nil {
+			log.Println("Failed to clear task reminder:", err)
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Task deleted successfully"})
+}
+
+// Occurrences of the active tasks in a period, by due time:
+// ?from=&to=&assignee=me|username&house=. Defaults to today.
+func GetTaskAgendaHandler(c *fiber.Ctx) error {
+	assigneeID, status, body := assigneeQuery(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "from must be a date or RFC 3339 timestamp"})
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be a date or RFC 3339 timestamp"})
+	}
+	if from.IsZero() {
+		from = analysis.StartOfDay(time.Now())
+	}
+	if to.IsZero() {
+		to = from.AddDate(0, 0, 1)
+	}
+	if !to.After(from) || to.Sub(from) > maxAgendaRange {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "to must be after from, by at most 31 days"})
+	}
+
+	tasks, err := database.ListTasks(DB, database.TaskFilter{AssigneeID: assigneeID, House: c.Query("house"), ActiveOnly: true})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch tasks"})
+	}
+	now := time.Now()
+	agenda := []taskOccurrence{}
+	for _, task := range tasks {
+		occurrences, err := taskAgenda(task, from, to, now)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task occurrences"})
+		}
+		agenda = append(agenda, occurrences...)
+	}
+	sort.SliceStable(agenda, func(i, j int) bool { return agenda[i].DueAt.Before(agenda[j].DueAt) })
+	return c.JSON(fiber.Map{"from": from, "to": to, "occurrences": agenda})
+}
+
+type completeTaskRequest struct {
+	DueAt string `json:"due_at" form:"due_at"` // Occurrence, defaults to the oldest undone one
+	Notes string `json:"notes" form:"notes"`
+}
+
+// Complete an occurrence of a task, as JSON or as a form with an optional
+// photo of the work
+func CompleteTaskHandler(c *fiber.Ctx) error {
+	task, status, body := taskFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if !task.Active {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Task is paused"})
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req completeTaskRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	// Occurrences up to the end of today can be completed
+	now := time.Now()
+	agenda, err := taskAgenda(task, now.Add(-taskOverdueLookback), analysis.StartOfDay(now).AddDate(0, 0, 1), now)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task occurrences"})
+	}
+	var occurrence *taskOccurrence
+	if req.DueAt != "" {
+		dueAt, err := parseTime(req.DueAt)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "due_at must be a date or RFC 3339 timestamp"})
+		}
+		for i := range agenda {
+			if agenda[i].DueAt.Equal(dueAt) {
+				occurrence = &agenda[i]
+				break
+			}
+		}
+		if occurrence == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "due_at is not an occurrence of this task due by today",
+				"hint":  "Use a due_at from the task agenda",
+			})
+		}
+		if occurrence.Status == taskDone {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This occurrence is already completed"})
+		}
+	} else {
+		for i := range agenda {
+			if agenda[i].Status != taskDone {
+				occurrence = &agenda[i]
+				break
+			}
+		}
+		if occurrence == nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":    "Nothing is due by today",
+				"next_due": nextOccurrence(task, now),
+			})
+		}
+	}
+
+	completion := database.TaskCompletion{
+		TaskID:      task.ID,
+		DueAt:       occurrence.DueAt,
+		CompletedBy: userID,
+		CompletedAt: now,
+		Notes:       req.Notes,
+	}
+	if file, err := c.FormFile("photo"); err == nil {
+		imageData, contentType, uploadErr := readImageUpload(file)
+		if uploadErr != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(uploadErr)
+		}
+		_, path, err := storeImage(imageData, contentType)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store photo"})
+		}
+		completion.PhotoPath, completion.PhotoType = path, contentType
+	}
+
+	completion.ID, err = database.CreateTaskCompletion(DB, completion)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save task completion"})
+	}
+	completion, err = database.GetTaskCompletion(DB, completion.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task completion"})
+	}
+
+	// The reminder is cleared once nothing is left overdue
+	if overdue, err := overdueOccurrences(task, now); err == nil && len(overdue) == 0 {
+		if err := database.AcknowledgeOpenFinding(DB, findingTaskOverdue, task.House, taskSubject(task.ID), userID); err != nil {
+			log.Println("Failed to clear task reminder:", err)
+		}
+	}
+
+	next := now
+	if completion.DueAt.After(now) {
+		next = completion.DueAt.Add(time.Second)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Task completed successfully",
+		"completion": viewCompletion(completion),
+		"next_due":   nextOccurrence(task, next),
+	})
+}
+
+func GetTaskCompletionPhotoHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("completionId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid completion ID"})
+	}
+	completion, err := database.GetTaskCompletion(DB, id)
+	if err == sql.ErrNoRows || (err == nil && completion.PhotoPath == "") {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Photo not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task completion"})
+	}
+
+	c.Set(fiber.HeaderContentType, completion.PhotoType)
+	return c.SendFile(completion.PhotoPath)
+}
+
+// ====== DAILY ACTIVITY ====== //
+
+// userActivity is what a worker did, missed and still has to do in a day
+type userActivity struct {
+	UserID    int              `json:"user_id"`
+	Username  string           `json:"username"`
+	Completed []taskOccurrence `json:"completed"`
+	Missed    []taskOccurrence `json:"missed"`
+	Pending   []taskOccurrence `json:"pending"`
+}
+
+// Work of every user in a day: ?day=YYYY-MM-DD, defaults to today. Completed
+// occurrences count for the user who did them, missed and pending ones for
+// the assignee.
+func GetTaskActivityHandler(c *fiber.Ctx) error {
+	day := analysis.StartOfDay(time.Now())
+	if value := c.Query("day"); value != "" {
+		var err error
+		if day, err = time.ParseInLocation(dayFormat, value, time.Local); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "day must be a date (YYYY-MM-DD)"})
+		}
+	}
+	end := day.AddDate(0, 0, 1)
+
+	tasks, err := database.ListTasks(DB, database.TaskFilter{})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch tasks"})
+	}
+	byID := make(map[int]database.Task, len(tasks))
+	for _, t := range tasks {
+		byID[t.ID] = t
+	}
+
+	users := map[int]*userActivity{}
+	user := func(id int, username string) *userActivity {
+		if users[id] == nil {
+			users[id] = &userActivity{UserID: id, Username: username,
+				Completed: []taskOccurrence{}, Missed: []taskOccurrence{}, Pending: []taskOccurrence{}}
+		}
+		return users[id]
+	}
+
+	completions, err := database.ListCompletionsMade(DB, day, end)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task completions"})
+	}
+	for _, completion := range completions {
+		task := byID[completion.TaskID]
+		u := user(completion.CompletedBy, completion.CompletedByName)
+		u.Completed = append(u.Completed, taskOccurrence{TaskID: task.ID, Title: task.Title, House: task.House,
+			AssigneeID: task.AssigneeID, Assignee: task.Assignee, DueAt: completion.DueAt.Local(),
+			Status: taskDone, Completion: viewCompletion(completion)})
+	}
+
+	now := time.Now()
+	unassigned := []taskOccurrence{}
+	for _, task := range tasks {
+		if !task.Active {
+			continue
+		}
+		agenda, err := taskAgenda(task, day, end, now)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch task occurrences"})
+		}
+		for _, o := range agenda {
+			switch {
+			case o.Status == taskDone:
+				continue
+			case task.AssigneeID == nil:
+				unassigned = append(unassigned, o)
+			case o.Status == taskOverdue:
+				u := user(*task.AssigneeID, task.Assignee)
+				u.Missed = append(u.Missed, o)
+			default:
+				u := user(*task.AssigneeID, task.Assignee)
+				u.Pending = append(u.Pending, o)
+			}
+		}
+	}
+
+	activity := make([]*userActivity, 0, len(users))
+	for _, u := range users {
+		activity = append(activity, u)
+	}
+	sort.Slice(activity, func(i, j int) bool { return activity[i].Username < activity[j].Username })
+	return c.JSON(fiber.Map{"day": day.Format(dayFormat), "users": activity, "unassigned": unassigned})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"middleware/database"
+)
+
+// RecordTelemetry samples the controller's current data, the actuator states
+// and the sensors of the other controllers every interval and stores them,
+// so analysis jobs can look back at conditions in the house. It never
+// returns.
+func RecordTelemetry(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := recordTelemetryOnce(); err != nil {
+			log.Println("Failed to record telemetry:", err)
+		}
+		if err := recordActuatorStatesOnce(); err != nil {
+			log.Println("Failed to record actuator states:", err)
+		}
+		if err := recordPolledSensorsOnce(); err != nil {
+			log.Println("Failed to poll sensors:", err)
+		}
+	}
+}
+
+func recordTelemetryOnce() error {
+	ctx, cancel := context.WithTimeout(context.Background(), controllerRequestTimeout)
+	defer cancel()
+
+	reading, err := controller.Read(ctx, "/get-current-data")
+	if err != nil {
+		return err
+	}
+	if reading.Stale {
+		// Do not record the cached reading twice
+		return nil
+	}
+
+	var data struct {
+		Temperature float64 `json:"temperature"`
+		Humidity    float64 `json:"humidity"`
+	}
+	if err := json.Unmarshal(reading.Body, &data); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var values interface{}
+	if err := json.Unmarshal(reading.Body, &values); err != nil {
+		return err
+	}
+	fields := map[string]float64{}
+	flattenReadings("", values, fields)
+	if err := recordControllerSensors(controller.Address, fields, now); err != nil {
+		log.Println("Failed to record sensor readings:", err)
+	}
+	return database.SaveTelemetry(DB, database.TelemetryReading{
+		Controller:  controller.Address,
+		Temperature: data.Temperature,
+		Humidity:    data.Humidity,
+		RecordedAt:  now,
+		FlockID:     database.ActiveFlockID(DB, "", controller.Address, now),
+	})
+}
@@ -0,0 +1,560 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Vaccination and medication programs are templates keyed by bird age.
+// Applying one to a flock generates its dated treatments. Pending
+// treatments raise reminder findings, and the withdrawal period of a
+// medication keeps the flock from being sold.
+
+const findingTreatmentDue = "treatment_due"
+
+// Validate a program step. On failure the returned map is the body of a 400
+// response.
+func validateStep(step *database.ProgramStep) fiber.Map {
+	step.Product = strings.TrimSpace(step.Product)
+	step.Route = strings.ToLower(strings.TrimSpace(step.Route))
+	if step.Product == "" {
+		return fiber.Map{"error": "product is required"}
+	}
+	if !database.ValidTreatmentKind(step.Kind) {
+		return fiber.Map{"error": "kind must be vaccine or medication"}
+	}
+	if step.AgeDays < 0 || step.WithdrawalDays < 0 {
+		return fiber.Map{"error": "age_days and withdrawal_days cannot be negative"}
+	}
+	return nil
+}
+
+// Sale readiness of a flock on a day: medications given within their
+// withdrawal period block it
+func saleStatus(treatments []database.Treatment, on time.Time) fiber.Map {
+	blocking := []database.Treatment{}
+	var until *time.Time
+	for _, t := range treatments {
+		end := t.WithdrawalUntil()
+		if end == nil || !end.After(on) {
+			continue
+		}
+		blocking = append(blocking, t)
+		if until == nil || end.After(*until) {
+			until = end
+		}
+	}
+
+	status := fiber.Map{"ready_for_sale": len(blocking) == 0, "blocking": blocking}
+	if until != nil {
+		status["ready_from"] = until.Format(dayFormat)
+	}
+	return status
+}
+
+// Describe a treatment for a reminder
+func treatmentLabel(t database.Treatment) string {
+	label := t.Product + " (" + t.Kind
+	if t.Route != "" {
+		label += ", " + strings.ReplaceAll(t.Route, "_", " ")
+	}
+	return label + ")"
+}
+
+func treatmentSubject(t database.Treatment) string {
+	return fmt.Sprintf("treatment %d", t.ID)
+}
+
+// RunTreatmentReminders raises a finding for every pending treatment due by
+// tomorrow. Its severity rises as the treatment becomes due and overdue.
+// The finding is the reminder, there is no other notification.
+func RunTreatmentReminders(now time.Time) ([]database.Finding, error) {
+	today := analysis.StartOfDay(now)
+	due, err := database.DueTreatments(DB, today.AddDate(0, 0, 1).Format(dayFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	flocks := map[int]database.Flock{}
+	findings := []database.Finding{}
+	for _, t := range due {
+		flock, ok := flocks[t.FlockID]
+		if !ok {
+			if flock, err = database.GetFlock(DB, t.FlockID); err != nil {
+				return findings, err
+			}
+			flocks[t.FlockID] = flock
+		}
+
+		severity, when := "low", "is due tomorrow"
+		switch {
+		case t.DueDay < today.Format(dayFormat):
+			severity, when = "high", "was due on "+t.DueDay
+		case t.DueDay == today.Format(dayFormat):
+			severity, when = "medium", "is due today"
+		}
+		dueDay, err := time.ParseInLocation(dayFormat, t.DueDay, time.Local)
+		if err != nil {
+			return findings, err
+		}
+
+		finding := database.Finding{
+			Kind:     findingTreatmentDue,
+			House:    flock.House,
+			Subject:  treatmentSubject(t),
+			Severity: severity,
+			Status:   database.FindingOpen,
+			Summary:  fmt.Sprintf("%s for flock %s %s", treatmentLabel(t), flock.Name, when),
+			Details: map[string]interface{}{
+				"flock":     flock.ID,
+				"treatment": t,
+			},
+			WindowStart: dueDay,
+			WindowEnd:   dueDay.AddDate(0, 0, 1),
+			FlockID:     &flock.ID,
+		}
+		finding.ID, err = database.UpsertFinding(DB, finding)
+		if err != nil {
+			return findings, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+// StartTreatmentReminders checks the due treatments every interval. It never
+// returns.
+func StartTreatmentReminders(interval time.Duration) {
+	for range time.Tick(interval) {
+		if _, err := RunTreatmentReminders(time.Now()); err != nil {
+			log.Println("Treatment reminders failed:", err)
+		}
+	}
+}
+
+// ====== PROGRAM HANDLERS ====== //
+
+type healthProgramRequest struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Steps       []database.ProgramStep `json:"steps"`
+}
+
+func (req healthProgramRequest) program(id int) (database.HealthProgram, fiber.Map) {
+	p := database.HealthProgram{
+		ID:          id,
+		Name:        strings.TrimSpace(req.Name),
+		Description: req.Description,
+		Steps:       req.Steps,
+	}
+	if p.Name == "" {
+		return p, fiber.Map{"error": "Name is required"}
+	}
+	if len(p.Steps) == 0 {
+		return p, fiber.Map{"error": "A program needs at least one step"}
+	}
+	for i := range p.Steps {
+		if body := validateStep(&p.Steps[i]); body != nil {
+			body["step"] = i
+			return p, body
+		}
+	}
+	return p, nil
+}
+
+func programFromParams(c *fiber.Ctx, param string) (database.HealthProgram, int, fiber.Map) {
+	id, err := c.ParamsInt(param)
+	if err != nil {
+		return database.HealthProgram{}, fiber.StatusBadRequest, fiber.Map{"error": "Invalid program ID"}
+	}
+	program, err := database.GetHealthProgram(DB, id)
+	if err == sql.ErrNoRows {
+		return program, fiber.StatusNotFound, fiber.Map{"error": "Health program not found"}
+	}
+	if err != nil {
+		return program, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch health program"}
+	}
+	return program, 0, nil
+}
+
+func ListHealthProgramsHandler(c *fiber.Ctx) error {
+	programs, err := database.ListHealthPrograms(DB)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch health programs"})
+	}
+	return c.JSON(programs)
+}
+
+func GetHealthProgramHandler(c *fiber.Ctx) error {
+	program, status, body := programFromParams(c, "id")
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	return c.JSON(program)
+}
+
+func CreateHealthProgramHandler(c *fiber.Ctx) error {
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+	var req healthProgramRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	program, body := req.program(0)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+	program.CreatedBy = &userID
+
+	id, err := database.CreateHealthProgram(DB, program)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create health program"})
+	}
+	created, err := database.GetHealthProgram(DB, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch health program"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Health program created successfully", "program": created})
+}
+
+// Replace a program. Flocks it was applied to keep their treatments.
+func UpdateHealthProgramHandler(c *fiber.Ctx) error {
+	existing, status, body := programFromParams(c, "id")
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	var req healthProgramRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	program, body := req.program(existing.ID)
+	if body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	if err := database.UpdateHealthProgram(DB, program); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update health program"})
+	}
+	updated, err := database.GetHealthProgram(DB, program.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch health program"})
+	}
+	return c.JSON(fiber.Map{"message": "Health program updated successfully", "program": updated})
+}
+
+func DeleteHealthProgramHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid program ID"})
+	}
+	err = database.DeleteHealthProgram(DB, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Health program not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete health program"})
+	}
+	return c.JSON(fiber.Map{"message": "Health program deleted successfully"})
+}
+
+// ====== TREATMENT HANDLERS ====== //
+
+// Generate the treatments of a program for a flock. Steps for ages the birds
+// had already passed at placement are skipped.
+func ApplyHealthProgramHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	program, status, body := programFromParams(c, "programId")
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	if flock.ClosedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Flock is closed out"})
+	}
+	applied, err := database.ProgramApplied(DB, flock.ID, program.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch treatments"})
+	}
+	if applied {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Program is already applied to this flock",
+			"hint":  "Delete its treatments to apply it again",
+		})
+	}
+
+	placed := analysis.StartOfDay(flock.PlacedAt)
+	treatments := []database.Treatment{}
+	skipped := []database.ProgramStep{}
+	for _, step := range program.Steps {
+		if step.AgeDays < flock.PlacementAge {
+			skipped = append(skipped, step)
+			continue
+		}
+		treatments = append(treatments, database.Treatment{
+			FlockID:     flock.ID,
+			ProgramID:   &program.ID,
+			ProgramStep: step,
+			DueDay:      placed.AddDate(0, 0, step.AgeDays-flock.PlacementAge).Format(dayFormat),
+			Status:      database.TreatmentPending,
+		})
+	}
+	if len(treatments) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The birds are older than every step of the program"})
+	}
+
+	treatments, err = database.CreateTreatments(DB, treatments)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create treatments"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message":    "Health program applied successfully",
+		"treatments": treatments,
+		"skipped":    skipped,
+	})
+}
+
+// Add a single treatment, e.g. a medication prescribed by a vet
+func CreateTreatmentHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	var req struct {
+		database.ProgramStep
+		DueDay string `json:"due_day"` // Defaults to today
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if body := validateStep(&req.ProgramStep); body != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	due := analysis.StartOfDay(time.Now())
+	if req.DueDay != "" {
+		var err error
+		if due, err = time.ParseInLocation(dayFormat, req.DueDay, time.Local); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "due_day must be a date (YYYY-MM-DD)"})
+		}
+	}
+	if due.Before(analysis.StartOfDay(flock.PlacedAt)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "due_day cannot be before the placement of the flock"})
+	}
+	req.AgeDays = flock.AgeOn(due)
+
+	treatments, err := database.CreateTreatments(DB, []database.Treatment{{
+		FlockID:     flock.ID,
+		ProgramStep: req.ProgramStep,
+		DueDay:      due.Format(dayFormat),
+		Status:      database.TreatmentPending,
+	}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create treatment"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Treatment created successfully", "treatment": treatments[0]})
+}
+
+type treatmentView struct {
+	database.Treatment
+	Overdue         bool    `json:"overdue"`
+	WithdrawalUntil *string `json:"withdrawal_until,omitempty"`
+}
+
+// Treatments of a flock with its sale readiness
+func ListTreatmentsHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	treatments, err := database.ListTreatments(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch treatments"})
+	}
+
+	today := analysis.StartOfDay(time.Now()).Format(dayFormat)
+	views := make([]treatmentView, 0, len(treatments))
+	for _, t := range treatments {
+		v := treatmentView{Treatment: t, Overdue: t.Status == database.TreatmentPending && t.DueDay < today}
+		if until := t.WithdrawalUntil(); until != nil {
+			day := until.Format(dayFormat)
+			v.WithdrawalUntil = &day
+		}
+		views = append(views, v)
+	}
+	return c.JSON(fiber.Map{
+		"flock_id":    flock.ID,
+		"treatments":  views,
+		"sale_status": saleStatus(treatments, time.Now()),
+	})
+}
+
+func GetSaleStatusHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	treatments, err := database.ListTreatments(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch treatments"})
+	}
+	return c.JSON(saleStatus(treatments, time.Now()))
+}
+
+// Treatment named by the route, pending
+func pendingTreatment(c *fiber.Ctx, flock database.Flock) (database.Treatment, int, fiber.Map) {
+	id, err := c.ParamsInt("treatmentId")
+	if err != nil {
+		return database.Treatment{}, fiber.StatusBadRequest, fiber.Map{"error": "Invalid treatment ID"}
+	}
+	t, err := database.GetTreatment(DB, flock.ID, id)
+	if err == sql.ErrNoRows {
+		return t, fiber.StatusNotFound, fiber.Map{"error": "Treatment not found"}
+	}
+	if err != nil {
+		return t, fiber.StatusInternalServerError, fiber.Map{"error": "Failed to fetch treatment"}
+	}
+	if t.Status != database.TreatmentPending {
+		return t, fiber.StatusConflict, fiber.Map{"error": "Treatment is already " + t.Status}
+	}
+	return t, 0, nil
+}
+
+// Record a dose as given, with the batch number of the product
+func CompleteTreatmentHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	t, status, body := pendingTreatment(c, flock)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req struct {
+		AdministeredAt string `json:"administered_at"` // Defaults to now
+		BatchNumber    string `json:"batch_number"`
+		Dose           string `json:"dose"`
+		Notes          string `json:"notes"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if strings.TrimSpace(req.BatchNumber) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "batch_number is required",
+			"hint":  "The batch number is printed on the vial or package",
+		})
+	}
+	administeredAt := time.Now()
+	if req.AdministeredAt != "" {
+		if administeredAt, err = parseTime(req.AdministeredAt); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "administered_at must be a date or RFC 3339 timestamp"})
+		}
+	}
+	if administeredAt.After(time.Now()) || administeredAt.Before(analysis.StartOfDay(flock.PlacedAt)) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "administered_at must be after the placement of the flock and not in the future",
+		})
+	}
+
+	t.Status = database.TreatmentDone
+	t.AdministeredAt = &administeredAt
+	t.AdministeredBy = &userID
+	t.BatchNumber = strings.TrimSpace(req.BatchNumber)
+	if req.Dose != "" {
+		t.Dose = req.Dose
+	}
+	if req.Notes != "" {
+		t.Notes = req.Notes
+	}
+	return finishTreatment(c, flock, t, userID, "Treatment recorded successfully")
+}
+
+// Mark a treatment as not given
+func SkipTreatmentHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	t, status, body := pendingTreatment(c, flock)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req struct {
+		Notes string `json:"notes"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+	t.Status = database.TreatmentSkipped
+	if req.Notes != "" {
+		t.Notes = req.Notes
+	}
+	return finishTreatment(c, flock, t, userID, "Treatment skipped")
+}
+
+// Save the outcome of a treatment and clear its reminder
+func finishTreatment(c *fiber.Ctx, flock database.Flock, t database.Treatment, userID int, message string) error {
+	if err := database.UpdateTreatmentStatus(DB, t); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update treatment"})
+	}
+	if err := database.AcknowledgeOpenFinding(DB, findingTreatmentDue, flock.House, treatmentSubject(t), userID); err != nil {
+		log.Println("Failed to clear treatment reminder:", err)
+	}
+
+	response := fiber.Map{"message": message, "treatment": t}
+	if until := t.WithdrawalUntil(); until != nil {
+		response["withdrawal_until"] = until.Format(dayFormat)
+	}
+	return c.JSON(response)
+}
+
+func DeleteTreatmentHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	id, err := c.ParamsInt("treatmentId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid treatment ID"})
+	}
+
+	err = database.DeleteTreatment(DB, flock.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Treatment not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete treatment"})
+	}
+	if userID, err := CurrentUserID(c); err == nil {
+		subject := treatmentSubject(database.Treatment{ID: id})
+		if err := database.AcknowledgeOpenFinding(DB, findingTreatmentDue, flock.House, subject, userID); err != nil {
+			log.Println("Failed to clear treatment reminder:", err)
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Treatment deleted successfully"})
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Labels become folder names in the exported dataset
+var datasetLabel = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ====== REVIEW QUEUE ====== //
+
+// Unreviewed predictions, least confident first: ?page=1&page_size=20&house=
+func GetReviewQueueHandler(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	pageSize := c.QueryInt("page_size", defaultPredictionPageSize)
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPredictionPageSize
+	}
+	if pageSize > maxPredictionPageSize {
+		pageSize = maxPredictionPageSize
+	}
+
+	predictions, total, err := database.ListUnreviewedPredictions(DB, c.Query("house"), pageSize, (page-1)*pageSize)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch review queue"})
+	}
+
+	return c.JSON(fiber.Map{
+		"predictions": predictions,
+		"page":        page,
+		"page_size":   pageSize,
+		"total":       total,
+	})
+}
+
+// Confirm or relabel a prediction. The label is the class the vet diagnosed,
+// which becomes the ground truth for retraining.
+func ReviewPredictionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid prediction ID"})
+	}
+
+	var req struct {
+		Label     string `json:"label"`
+		Notes     string `json:"notes"`
+		Treatment string `json:"treatment"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	prediction, err := database.GetPrediction(DB, id)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Prediction not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch prediction"})
+	}
+
+	// Without a label the vet confirms the prediction
+	label := strings.TrimSpace(req.Label)
+	if label == "" {
+		label = prediction.PredictedDisease
+	}
+	if !datasetLabel.MatchString(label) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid label"})
+	}
+	if _, known := prediction.AllProbabilities[label]; !known && len(prediction.AllProbabilities) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unknown label",
+			"hint":  "The label must be one of the classes of the model",
+		})
+	}
+
+	reviewerID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	review := database.PredictionReview{
+		PredictionID: id,
+		ReviewerID:   reviewerID,
+		Verdict:      database.VerdictConfirmed,
+		Label:        label,
+		Notes:        req.Notes,
+		Treatment:    req.Treatment,
+		ReviewedAt:   time.Now(),
+	}
+	if label != prediction.PredictedDisease {
+		review.Verdict = database.VerdictRelabeled
+	}
+
+	if err := database.UpsertReview(DB, review); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save review"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Review saved successfully",
+		"review":  review,
+	})
+}
+
+// ====== DATASET EXPORT ====== //
+
+// Export reviewed predictions as a zip in the layout the AI service trains
+// from: one folder per class under images/, plus a manifest of every sample.
+// ?format=csv|jsonl&from=&to=
+func ExportDatasetHandler(c *fiber.Ctx) error {
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be 'csv' or 'jsonl'"})
+	}
+
+	from, err := parseTimeQuery(c, "from")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'from' date"})
+	}
+	to, err := parseTimeQuery(c, "to")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid 'to' date"})
+	}
+
+	samples, err := database.ListLabeledPredictions(DB, from, to)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch labeled predictions"})
+	}
+
+	filename := fmt.Sprintf("tokkatot-dataset-%s.zip", time.Now().Format("20060102-150405"))
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := writeDataset(w, samples, format); err != nil {
+			log.Println("Dataset export failed:", err)
+		}
+	})
+	return nil
+}
+
+// Relative path of a sample image inside the dataset
+func datasetImagePath(l database.LabeledPrediction) string {
+	return filepath.ToSlash(filepath.Join("images", l.Review.Label, l.Prediction.ImageHash+filepath.Ext(l.Prediction.ImagePath)))
+}
+
+func writeDataset(w io.Writer, samples []database.LabeledPrediction, format string) error {
+	archive := zip.NewWriter(w)
+	written := map[string]bool{}
+
+	for _, sample := range samples {
+		path := datasetImagePath(sample)
+		if written[path] {
+			continue
+		}
+		if err := copyIntoZip(archive, path, sample.Prediction.ImagePath); err != nil {
+			log.Printf("Skipping image of prediction %d: %v", sample.Prediction.ID, err)
+			continue
+		}
+		written[path] = true
+	}
+
+	manifest, err := archive.Create("manifest." + format)
+	if err != nil {
+		return err
+	}
+	if format == "jsonl" {
+		err = writeJSONLManifest(manifest, samples, written)
+	} else {
+		err = writeCSVManifest(manifest, samples, written)
+	}
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func copyIntoZip(archive *zip.Writer, name, source string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Images are already compressed
+	dst, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+var manifestHeader = []string{
+	"file", "label", "predicted", "confidence", "model_version", "verdict", "house", "controller",
+	"captured_at", "reviewed_at", "reviewer_id", "notes", "treatment",
+}
+
+func writeCSVManifest(w io.Writer, samples []database.LabeledPrediction, written map[string]bool) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(manifestHeader); err != nil {
+		return err
+	}
+
+	for _, s := range samples {
+		path := datasetImagePath(s)
+		if !written[path] {
+			continue
+		}
+		err := out.Write([]string{
+			path,
+			s.Review.Label,
+			s.Prediction.PredictedDisease,
+			strconv.FormatFloat(s.Prediction.Confidence, 'f', 4, 64),
+			s.Prediction.ModelVersion,
+			s.Review.Verdict,
+			s.Prediction.House,
+			s.Prediction.Controller,
+			s.Prediction.CreatedAt.Format(time.RFC3339),
+			s.Review.ReviewedAt.Format(time.RFC3339),
+			strconv.Itoa(s.Review.ReviewerID),
+			s.Review.Notes,
+			s.Review.Treatment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func writeJSONLManifest(w io.Writer, samples []database.LabeledPrediction, written map[string]bool) error {
+	encoder := json.NewEncoder(w)
+	for _, s := range samples {
+		path := datasetImagePath(s)
+		if !written[path] {
+			continue
+		}
+		err := encoder.Encode(fiber.Map{
+			"file":              path,
+			"label":             s.Review.Label,
+			"predicted":         s.Prediction.PredictedDisease,
+			"confidence":        s.Prediction.Confidence,
+			"all_probabilities": s.Prediction.AllProbabilities,
+			"model_version":     s.Prediction.ModelVersion,
+			"verdict":           s.Review.Verdict,
+			"house":             s.Prediction.House,
+			"controller":        s.Prediction.Controller,
+			"captured_at":       s.Prediction.CreatedAt,
+			"reviewed_at":       s.Review.ReviewedAt,
+			"reviewer_id":       s.Review.ReviewerID,
+			"notes":             s.Review.Notes,
+			"treatment":         s.Review.Treatment,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ====== USER ROLES ====== //
+
+// Grant a role to a user, e.g. to give a veterinarian review rights
+func SetUserRoleHandler(c *fiber.Ctx) error {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if !database.ValidRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown role"})
+	}
+
+	username := c.Params("username")
+	err := database.SetUserRole(DB, username, req.Role)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update role"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Role updated successfully", "username": username, "role": req.Role})
+}
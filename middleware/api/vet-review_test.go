@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReviewQueuePageSize(t *testing.T) {
+	app := fiber.New()
+	app.Get("/review", GetReviewQueueHandler)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", defaultPredictionPageSize},
+		{"?page_size=0", defaultPredictionPageSize},
+		{"?page_size=-5", defaultPredictionPageSize},
+		{"?page_size=50", 50},
+		{"?page_size=1000", maxPredictionPageSize},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/review"+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			PageSize int `json:"page_size"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.PageSize != tt.want {
+			t.Errorf("%q: page_size %d, want %d", tt.query, body.PageSize, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"middleware/analysis"
+	"middleware/database"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Most birds in one weight sample
+const maxWeightSample = 1000
+
+// A sample is either the weight of each bird, or the batch average read from
+// a scale with its uniformity if the scale reports it
+type weightSampleRequest struct {
+	Day           string    `json:"day"` // Defaults to today
+	Weights       []float64 `json:"weights"`
+	AverageWeight float64   `json:"average_weight_g"`
+	SampleSize    int       `json:"sample_size"`
+	CV            *float64  `json:"cv_percent"`
+	Uniformity    *float64  `json:"uniformity_percent"`
+	Notes         string    `json:"notes"`
+}
+
+// Validate a request into a sample. On failure the returned map is the body
+// of a 400 response.
+func (req weightSampleRequest) sample() (database.WeightSample, fiber.Map) {
+	sample := database.WeightSample{Notes: req.Notes}
+	if len(req.Weights) > 0 {
+		if len(req.Weights) > maxWeightSample {
+			return sample, fiber.Map{"error": "Too many weights", "limit": maxWeightSample}
+		}
+		for _, w := range req.Weights {
+			if w <= 0 {
+				return sample, fiber.Map{"error": "Weights must be positive"}
+			}
+		}
+		stats := analysis.SummarizeWeights(req.Weights)
+		sample.Weights = req.Weights
+		sample.AverageWeight, sample.SampleSize = stats.Mean, stats.Count
+		sample.Uniformity = &stats.Uniformity
+		if stats.Count > 1 {
+			sample.CV = &stats.CV
+		}
+		return sample, nil
+	}
+
+	if req.AverageWeight <= 0 || req.SampleSize <= 0 {
+		return sample, fiber.Map{
+			"error": "average_weight_g and sample_size must be positive",
+			"hint":  "Send weights to record each bird's weight",
+		}
+	}
+	if (req.CV != nil && *req.CV < 0) || (req.Uniformity != nil && (*req.Uniformity < 0 || *req.Uniformity > 100)) {
+		return sample, fiber.Map{"error": "cv_percent cannot be negative and uniformity_percent must be between 0 and 100"}
+	}
+	sample.AverageWeight, sample.SampleSize = req.AverageWeight, req.SampleSize
+	sample.CV, sample.Uniformity = req.CV, req.Uniformity
+	return sample, nil
+}
+
+// ====== WEIGHT HANDLERS ====== //
+
+// Record the average weight of birds weighed in a flock
+func CreateWeightSampleHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	userID, err := CurrentUserID(c)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching user"})
+	}
+
+	var req weightSampleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	sample, errBody := req.sample()
+	if errBody != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errBody)
+	}
+
+	day, errBody := flockDay(flock, req.Day)
+	if errBody != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errBody)
+	}
+
+	sample.FlockID = flock.ID
+	sample.Day = day.Format(dayFormat)
+	sample.RecordedBy = userID
+	sample.CreatedAt = time.Now().UTC()
+	sample.ID, err = database.CreateWeightSample(DB, sample)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save weight sample"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Weight sample recorded successfully",
+		"sample":  sample,
+	})
+}
+
+func ListWeightSamplesHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	samples, err := database.ListWeightSamples(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch weight samples"})
+	}
+	return c.JSON(fiber.Map{"flock_id": flock.ID, "samples": samples})
+}
+
+// growthPoint is a weighing against the breed standard
+type growthPoint struct {
+	Day        string   `json:"day"`
+	AgeDays    int      `json:"age_days"`
+	Actual     float64  `json:"actual_g"`
+	Target     *float64 `json:"target_g"`
+	Percent    *float64 `json:"percent_of_target"`
+	SampleSize int      `json:"sample_size"`
+	CV         *float64 `json:"cv_percent"`
+	Uniformity *float64 `json:"uniformity_percent"`
+}
+
+// Growth of a flock against its breed standard by age, with the projected
+// market date. ?breed= compares against another breed.
+func GetGrowthHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	samples, err := database.ListWeightSamples(DB, flock.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch weight samples"})
+	}
+
+	breedName := c.Query("breed", flock.Breed)
+	var breed *database.BreedStandard
+	if standard, err := database.FindBreedStandard(DB, breedName); err == nil {
+		breed = &standard
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch breed standard"})
+	}
+
+	points := make([]growthPoint, 0, len(samples))
+	for _, s := range samples {
+		day, err := time.ParseInLocation(dayFormat, s.Day, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Invalid weight sample day"})
+		}
+		p := growthPoint{
+			Day:        s.Day,
+			AgeDays:    flock.AgeOn(day),
+			Actual:     s.AverageWeight,
+			SampleSize: s.SampleSize,
+			CV:         s.CV,
+			Uniformity: s.Uniformity,
+		}
+		if breed != nil {
+			if target, ok := analysis.TargetWeight(breed.Curve, float64(p.AgeDays)); ok {
+				percent := p.Actual / target * 100
+				p.Target, p.Percent = &target, &percent
+			}
+		}
+		points = append(points, p)
+	}
+
+	response := fiber.Map{
+		"flock_id": flock.ID,
+		"age_days": flock.AgeDays,
+		"breed":    breed,
+		"points":   points,
+	}
+	if breed == nil {
+		response["hint"] = "No breed standard matches " + strconv.Quote(breedName) + ", set the flock's breed to one of /api/breeds"
+		return c.JSON(response)
+	}
+
+	// Project from the last weighing
+	if len(points) > 0 && breed.MarketWeight > 0 {
+		last := points[len(points)-1]
+		if age, ok := analysis.ProjectMarketAge(breed.Curve, last.AgeDays, last.Actual, breed.MarketWeight); ok {
+			marketAge := int(math.Ceil(age))
+			response["projected_market_age_days"] = marketAge
+			response["projected_market_date"] = analysis.StartOfDay(flock.PlacedAt).
+				AddDate(0, 0, marketAge-flock.PlacementAge).Format(dayFormat)
+		}
+	}
+	return c.JSON(response)
+}
+
+func DeleteWeightSampleHandler(c *fiber.Ctx) error {
+	flock, status, body := flockFromParams(c)
+	if body != nil {
+		return c.Status(status).JSON(body)
+	}
+	sampleID, err := c.ParamsInt("sampleId")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid sample ID"})
+	}
+
+	err = database.DeleteWeightSample(DB, flock.ID, sampleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Weight sample not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete weight sample"})
+	}
+	return c.JSON(fiber.Map{"message": "Weight sample deleted successfully"})
+}
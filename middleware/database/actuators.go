@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Actuator types and the state they take
+const (
+	ActuatorRelay  = "relay"  // On or off
+	ActuatorServo  = "servo"  // Angle in degrees
+	ActuatorDimmer = "dimmer" // Brightness in percent
+)
+
+// Actuator is an output of a controller. The address is driver specific,
+// for the ESP32 firmware it is the device of its toggle route.
+type Actuator struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Function       string     `json:"function"`   // What it drives, e.g. fan or feeder
+	Controller     string     `json:"controller"` // Empty for the default controller
+	Address        string     `json:"address"`
+	HouseID        *int       `json:"house_id"`
+	House          string     `json:"house"` // Derived
+	Active         bool       `json:"active"`
+	LastState      *float64   `json:"last_state"` // 0 or 1 for relays
+	StateChangedAt *time.Time `json:"state_changed_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ActuatorFilter narrows an actuator query. Zero values are ignored.
+type ActuatorFilter struct {
+	HouseID    int
+	Function   string
+	ActiveOnly bool
+}
+
+// Outputs of the ESP32 controller the registry starts with
+//
+//go:embed actuators_seed.json
+var actuatorSeed []byte
+
+// Initialize actuator table and seed it on first run
+func InitActuatorDB(db *sql.DB) error {
+	createActuatorTable := `
+    CREATE TABLE IF NOT EXISTS actuators (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL DEFAULT '',
+        type TEXT NOT NULL,
+        function TEXT NOT NULL,
+        controller TEXT NOT NULL DEFAULT '',
+        address TEXT NOT NULL,
+        house_id INTEGER,
+        active BOOLEAN NOT NULL DEFAULT 1,
+        last_state REAL,
+        state_changed_at TIMESTAMP,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (house_id) REFERENCES houses(id)
+    );`
+
+	_, err := db.Exec(createActuatorTable)
+	if err != nil {
+		log.Println("Error creating actuators table:", err)
+		return err
+	}
+
+	// Only seed an empty table, actuators deleted by an admin stay deleted
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM actuators").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var seed []Actuator
+	if err := json.Unmarshal(actuatorSeed, &seed); err != nil {
+		return err
+	}
+	for _, a := range seed {
+		a.Active = true
+		if err := CreateActuator(db, a); err != nil {
+			log.Println("Error seeding actuator:", err)
+			return err
+		}
+	}
+	log.Printf("Seeded %d actuators", len(seed))
+	return nil
+}
+
+const actuatorColumns = `a.id, a.name, a.type, a.function, a.controller, a.address, a.house_id, COALESCE(h.name, ''),
+        a.active, a.last_state, a.state_changed_at, a.created_at, a.updated_at`
+
+const actuatorFrom = ` FROM actuators a
+    LEFT JOIN houses h ON h.id = a.house_id`
+
+func scanActuator(row rowScanner) (Actuator, error) {
+	var a Actuator
+	var houseID sql.NullInt64
+	var lastState sql.NullFloat64
+	var changedAt sql.NullTime
+	err := row.Scan(&a.ID, &a.Name, &a.Type, &a.Function, &a.Controller, &a.Address, &houseID, &a.House,
+		&a.Active, &lastState, &changedAt, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return a, err
+	}
+	if houseID.Valid {
+		id := int(houseID.Int64)
+		a.HouseID = &id
+	}
+	if lastState.Valid {
+		a.LastState = &lastState.Float64
+	}
+	if changedAt.Valid {
+		a.StateChangedAt = &changedAt.Time
+	}
+	return a, nil
+}
+
+// Register an actuator
+func CreateActuator(db *sql.DB, a Actuator) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+    INSERT INTO actuators (id, name, type, function, controller, address, house_id, active, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.ID, a.Name, a.Type, a.Function, a.Controller, a.Address, a.HouseID, a.Active, now, now)
+	return err
+}
+
+// Get an actuator by ID
+func GetActuator(db *sql.DB, id string) (Actuator, error) {
+	return scanActuator(db.QueryRow("SELECT "+actuatorColumns+actuatorFrom+" WHERE a.id = ?", id))
+}
+
+// List actuators by house and ID
+func ListActuators(db *sql.DB, filter ActuatorFilter) ([]Actuator, error) {
+	query := "SELECT " + actuatorColumns + actuatorFrom + " WHERE 1 = 1"
+	var args []interface{}
+	if filter.HouseID != 0 {
+		query += " AND a.house_id = ?"
+		args = append(args, filter.HouseID)
+	}
+	if filter.Function != "" {
+		query += " AND a.function = ?"
+		args = append(args, filter.Function)
+	}
+	if filter.ActiveOnly {
+		query += " AND a.active = 1"
+	}
+	query += " ORDER BY h.name, a.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actuators := []Actuator{}
+	for rows.Next() {
+		a, err := scanActuator(rows)
+		if err != nil {
+			return nil, err
+		}
+		actuators = append(actuators, a)
+	}
+	return actuators, rows.Err()
+}
+
+// Update the editable fields of an actuator
+func UpdateActuator(db *sql.DB, a Actuator) error {
+	return execOne(db, `
+    UPDATE actuators SET name = ?, type = ?, function = ?, controller = ?, address = ?, house_id = ?, active = ?,
+        updated_at = ?
+    WHERE id = ?`,
+		a.Name, a.Type, a.Function, a.Controller, a.Address, a.HouseID, a.Active, time.Now().UTC(), a.ID)
+}
+
+// Store the state an actuator was last seen in
+func SetActuatorState(db *sql.DB, id string, state float64) error {
+	_, err := db.Exec(`
+    UPDATE actuators SET last_state = ?, state_changed_at = ?
+    WHERE id = ? AND (last_state IS NULL OR last_state != ?)`,
+		state, time.Now().UTC(), id, state)
+	return err
+}
+
+// Delete an actuator. Its recorded device events are kept for the feed
+// estimates.
+func DeleteActuator(db *sql.DB, id string) error {
+	return execOne(db, "DELETE FROM actuators WHERE id = ?", id)
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// AIModel is a model version the AI service has served
+type AIModel struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Classes   []string  `json:"classes"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Initialize AI models table
+func InitAIModelDB(db *sql.DB) error {
+	createAIModelsTable := `
+    CREATE TABLE IF NOT EXISTS ai_models (
+        version TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        classes TEXT NOT NULL DEFAULT '[]',
+        first_seen TIMESTAMP NOT NULL,
+        last_seen TIMESTAMP NOT NULL
+    );`
+
+	_, err := db.Exec(createAIModelsTable)
+	if err != nil {
+		log.Println("Error creating AI models table:", err)
+		return err
+	}
+	return nil
+}
+
+// Record that a model version is being served. Returns true when the version
+// has not been seen before.
+func RecordAIModel(db *sql.DB, m AIModel) (bool, error) {
+	classes, err := json.Marshal(m.Classes)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+    INSERT INTO ai_models (version, name, classes, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+    ON CONFLICT(version) DO NOTHING`, m.Version, m.Name, string(classes), now, now)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+
+	_, err = db.Exec(`
+    UPDATE ai_models SET name = ?, classes = ?, last_seen = ? WHERE version = ?`,
+		m.Name, string(classes), now, m.Version)
+	return false, err
+}
+
+// List the model versions served, most recent first
+func ListAIModels(db *sql.DB) ([]AIModel, error) {
+	rows, err := db.Query(`
+    SELECT name, version, classes, first_seen, last_seen FROM ai_models
+    ORDER BY last_seen DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	models := []AIModel{}
+	for rows.Next() {
+		var m AIModel
+		var classes string
+		if err := rows.Scan(&m.Name, &m.Version, &classes, &m.FirstSeen, &m.LastSeen); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(classes), &m.Classes); err != nil {
+			return nil, err
+		}
+		models = append(models, m)
+	}
+	return models, rows.Err()
+}
+
+// ModelComparison summarizes the predictions of one backend and model version
+type ModelComparison struct {
+	Backend           string   `json:"backend"`
+	ModelVersion      string   `json:"model_version"`
+	Predictions       int      `json:"predictions"`
+	AverageConfidence float64  `json:"average_confidence"`
+	Healthy           int      `json:"healthy"`
+	Reviewed          int      `json:"reviewed"`
+	Agreed            int      `json:"agreed"`   // Reviews whose label is the predicted disease
+	Accuracy          *float64 `json:"accuracy"` // Agreed over reviewed, nil before any review
+}
+
+// Compare the backends and model versions on the predictions made since a time
+func CompareModels(db *sql.DB, since time.Time) ([]ModelComparison, error) {
+	rows, err := db.Query(`
+    SELECT p.backend, p.model_version, COUNT(*), AVG(p.confidence), SUM(p.is_healthy),
+        COUNT(r.id), SUM(CASE WHEN r.label = p.predicted_disease THEN 1 ELSE 0 END)
+    FROM predictions p
+    LEFT JOIN prediction_reviews r ON r.prediction_id = p.id
+    WHERE p.created_at >= ?
+    GROUP BY p.backend, p.model_version
+    ORDER BY COUNT(*) DESC`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comparisons := []ModelComparison{}
+	for rows.Next() {
+		var m ModelComparison
+		err := rows.Scan(&m.Backend, &m.ModelVersion, &m.Predictions, &m.AverageConfidence, &m.Healthy,
+			&m.Reviewed, &m.Agreed)
+		if err != nil {
+			return nil, err
+		}
+		if m.Reviewed > 0 {
+			accuracy := float64(m.Agreed) / float64(m.Reviewed)
+			m.Accuracy = &accuracy
+		}
+		comparisons = append(comparisons, m)
+	}
+	return comparisons, rows.Err()
+}
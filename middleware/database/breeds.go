@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+)
+
+// BreedStandard is the target body weight of a breed by age
+type BreedStandard struct {
+	Key          string                 `json:"key"`
+	Name         string                 `json:"name"`
+	Type         string                 `json:"type"` // broiler, layer or local
+	MarketWeight float64                `json:"market_weight_g"`
+	Notes        string                 `json:"notes"`
+	Curve        []analysis.GrowthPoint `json:"curve"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// Breed types
+const (
+	BreedBroiler = "broiler"
+	BreedLayer   = "layer"
+	BreedLocal   = "local"
+)
+
+func ValidBreedType(t string) bool {
+	switch t {
+	case BreedBroiler, BreedLayer, BreedLocal:
+		return true
+	}
+	return false
+}
+
+// Curves the breed standards start with
+//
+//go:embed breeds_seed.json
+var breedSeed []byte
+
+// Initialize breed standard table and seed it on first run
+func InitBreedDB(db *sql.DB) error {
+	createBreedTable := `
+    CREATE TABLE IF NOT EXISTS breed_standards (
+        key TEXT PRIMARY KEY,
+        name TEXT NOT NULL,
+        type TEXT NOT NULL,
+        market_weight REAL NOT NULL DEFAULT 0,
+        notes TEXT NOT NULL DEFAULT '',
+        curve TEXT NOT NULL,
+        updated_at TIMESTAMP NOT NULL
+    );`
+
+	_, err := db.Exec(createBreedTable)
+	if err != nil {
+		log.Println("Error creating breed standards table:", err)
+		return err
+	}
+
+	// Only seed an empty table, breeds deleted by an admin stay deleted
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM breed_standards").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var seed []BreedStandard
+	if err := json.Unmarshal(breedSeed, &seed); err != nil {
+		return err
+	}
+	for _, b := range seed {
+		if err := UpsertBreedStandard(db, b); err != nil {
+			log.Println("Error seeding breed standard:", err)
+			return err
+		}
+	}
+	log.Printf("Seeded %d breed standards", len(seed))
+	return nil
+}
+
+func scanBreedStandard(row rowScanner) (BreedStandard, error) {
+	var b BreedStandard
+	var curve string
+	if err := row.Scan(&b.Key, &b.Name, &b.Type, &b.MarketWeight, &b.Notes, &curve, &b.UpdatedAt); err != nil {
+		return b, err
+	}
+	err := json.Unmarshal([]byte(curve), &b.Curve)
+	return b, err
+}
+
+const breedColumns = "key, name, type, market_weight, notes, curve, updated_at"
+
+// List every breed standard
+func ListBreedStandards(db *sql.DB) ([]BreedStandard, error) {
+	rows, err := db.Query("SELECT " + breedColumns + " FROM breed_standards ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breeds := []BreedStandard{}
+	for rows.Next() {
+		b, err := scanBreedStandard(rows)
+		if err != nil {
+			return nil, err
+		}
+		breeds = append(breeds, b)
+	}
+	return breeds, rows.Err()
+}
+
+// Get a breed standard by key
+func GetBreedStandard(db *sql.DB, key string) (BreedStandard, error) {
+	return scanBreedStandard(db.QueryRow("SELECT "+breedColumns+" FROM breed_standards WHERE key = ?", key))
+}
+
+// Find the standard of a flock's breed, written as its key or its name.
+// Returns sql.ErrNoRows when there is none.
+func FindBreedStandard(db *sql.DB, breed string) (BreedStandard, error) {
+	breed = strings.TrimSpace(breed)
+	return scanBreedStandard(db.QueryRow(`
+    SELECT `+breedColumns+` FROM breed_standards
+    WHERE key = ? OR LOWER(name) = LOWER(?) OR key = REPLACE(LOWER(?), ' ', '_')
+    LIMIT 1`, breed, breed, breed))
+}
+
+// Create or replace a breed standard
+func UpsertBreedStandard(db *sql.DB, b BreedStandard) error {
+	curve, err := json.Marshal(b.Curve)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+    INSERT INTO breed_standards (key, name, type, market_weight, notes, curve, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(key) DO UPDATE SET name = excluded.name, type = excluded.type,
+        market_weight = excluded.market_weight, notes = excluded.notes, curve = excluded.curve,
+        updated_at = excluded.updated_at`,
+		b.Key, b.Name, b.Type, b.MarketWeight, b.Notes, string(curve), time.Now().UTC())
+	return err
+}
+
+// Delete a breed standard. Returns sql.ErrNoRows when it does not exist.
+func DeleteBreedStandard(db *sql.DB, key string) error {
+	result, err := db.Exec("DELETE FROM breed_standards WHERE key = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
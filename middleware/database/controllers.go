@@ -0,0 +1,323 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ControllerPin is the trusted certificate fingerprint of an ESP32 controller.
+// PinType is either "spki" (hash of the public key) or "cert" (hash of the
+// whole DER certificate).
+type ControllerPin struct {
+	ID          int       `json:"id"`
+	Address     string    `json:"address"`
+	PinType     string    `json:"pin_type"`
+	Fingerprint string    `json:"fingerprint"`
+	PinnedAt    time.Time `json:"pinned_at"`
+}
+
+// Initialize controller trust tables
+func InitControllerDB(db *sql.DB) error {
+	createPinsTable := `
+    CREATE TABLE IF NOT EXISTS controller_pins (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        address TEXT UNIQUE NOT NULL,
+        pin_type TEXT NOT NULL DEFAULT 'spki',
+        fingerprint TEXT NOT NULL,
+        pinned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );`
+
+	_, err := db.Exec(createPinsTable)
+	if err != nil {
+		log.Println("Error creating controller pins table:", err)
+		return err
+	}
+
+	createKeysTable := `
+    CREATE TABLE IF NOT EXISTS controller_keys (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        address TEXT NOT NULL,
+        key_id TEXT NOT NULL,
+        key_hex TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'active',
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        retired_at TIMESTAMP,
+        UNIQUE (address, key_id)
+    );`
+
+	_, err = db.Exec(createKeysTable)
+	if err != nil {
+		log.Println("Error creating controller keys table:", err)
+		return err
+	}
+
+	createCountersTable := `
+    CREATE TABLE IF NOT EXISTS controller_counters (
+        address TEXT PRIMARY KEY,
+        rx_counter INTEGER NOT NULL DEFAULT 0,
+        tx_counter INTEGER NOT NULL DEFAULT 0,
+        last_key_id TEXT NOT NULL DEFAULT ''
+    );`
+
+	_, err = db.Exec(createCountersTable)
+	if err != nil {
+		log.Println("Error creating controller counters table:", err)
+		return err
+	}
+
+	createControllersTable := `
+    CREATE TABLE IF NOT EXISTS controllers (
+        address TEXT PRIMARY KEY,
+        driver TEXT NOT NULL,
+        username TEXT NOT NULL DEFAULT '',
+        password TEXT NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        updated_at TIMESTAMP NOT NULL
+    );`
+
+	_, err = db.Exec(createControllersTable)
+	if err != nil {
+		log.Println("Error creating controllers table:", err)
+		return err
+	}
+	return nil
+}
+
+// Get the pinned fingerprint of a controller, sql.ErrNoRows if not enrolled
+func GetControllerPin(db *sql.DB, address string) (ControllerPin, error) {
+	var pin ControllerPin
+	query := `
+    SELECT id, address, pin_type, fingerprint, pinned_at
+    FROM controller_pins
+    WHERE address = ?`
+
+	err := db.QueryRow(query, address).Scan(
+		&pin.ID,
+		&pin.Address,
+		&pin.PinType,
+		&pin.Fingerprint,
+		&pin.PinnedAt)
+	return pin, err
+}
+
+// Pin or re-pin a controller certificate
+func SaveControllerPin(db *sql.DB, pin ControllerPin) error {
+	query := `
+    INSERT INTO controller_pins (address, pin_type, fingerprint, pinned_at)
+    VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(address) DO UPDATE SET
+        pin_type = excluded.pin_type,
+        fingerprint = excluded.fingerprint,
+        pinned_at = excluded.pinned_at;`
+
+	_, err := db.Exec(query, pin.Address, pin.PinType, pin.Fingerprint)
+	return err
+}
+
+// List all enrolled controllers
+func ListControllerPins(db *sql.DB) ([]ControllerPin, error) {
+	rows, err := db.Query(`
+    SELECT id, address, pin_type, fingerprint, pinned_at
+    FROM controller_pins
+    ORDER BY address`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []ControllerPin{}
+	for rows.Next() {
+		var pin ControllerPin
+		if err := rows.Scan(&pin.ID, &pin.Address, &pin.PinType, &pin.Fingerprint, &pin.PinnedAt); err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// ControllerKey is an AES-256 key shared with a controller for the encrypted
+// payload channel. Retired keys are still accepted for a grace period so the
+// firmware can be updated after a rotation.
+type ControllerKey struct {
+	ID        int        `json:"id"`
+	Address   string     `json:"address"`
+	KeyID     string     `json:"key_id"`
+	KeyHex    string     `json:"-"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
+
+// Controller key statuses
+const (
+	KeyActive  = "active"
+	KeyRetired = "retired"
+)
+
+// List the keys of a controller, newest first
+func ListControllerKeys(db *sql.DB, address string) ([]ControllerKey, error) {
+	rows, err := db.Query(`
+    SELECT id, address, key_id, key_hex, status, created_at, retired_at
+    FROM controller_keys
+    WHERE address = ?
+    ORDER BY id DESC`, address)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []ControllerKey{}
+	for rows.Next() {
+		var key ControllerKey
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Address, &key.KeyID, &key.KeyHex, &key.Status, &key.CreatedAt, &retiredAt); err != nil {
+			return nil, err
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Add a new active key for a controller and retire the previous ones
+func RotateControllerKey(db *sql.DB, address, keyID, keyHex string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+    UPDATE controller_keys SET status = ?, retired_at = CURRENT_TIMESTAMP
+    WHERE address = ? AND status = ?`, KeyRetired, address, KeyActive)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+    INSERT INTO controller_keys (address, key_id, key_hex, status)
+    VALUES (?, ?, ?, ?)`, address, keyID, keyHex, KeyActive)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Accept an inbound counter only if it is higher than every counter seen
+// before. Returns false for replayed or reordered messages.
+func AdvanceRxCounter(db *sql.DB, address, keyID string, counter uint64) (bool, error) {
+	_, err := db.Exec(`INSERT OR IGNORE INTO controller_counters (address) VALUES (?)`, address)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Exec(`
+    UPDATE controller_counters SET rx_counter = ?, last_key_id = ?
+    WHERE address = ? AND rx_counter < ?`, int64(counter), keyID, address, int64(counter))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// Reserve the next outbound counter for a controller
+func NextTxCounter(db *sql.DB, address string) (uint64, error) {
+	_, err := db.Exec(`INSERT OR IGNORE INTO controller_counters (address) VALUES (?)`, address)
+	if err != nil {
+		return 0, err
+	}
+
+	var counter int64
+	err = db.QueryRow(`
+    UPDATE controller_counters SET tx_counter = tx_counter + 1
+    WHERE address = ?
+    RETURNING tx_counter`, address).Scan(&counter)
+	return uint64(counter), err
+}
+
+// Key ID the controller used in its most recent accepted message
+func GetLastControllerKeyID(db *sql.DB, address string) (string, error) {
+	var keyID string
+	err := db.QueryRow(`SELECT last_key_id FROM controller_counters WHERE address = ?`, address).Scan(&keyID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return keyID, err
+}
+
+// ====== CONTROLLER DRIVERS ====== //
+
+// Controller is a controller registered with the driver of its firmware.
+// Controllers that are not registered run our ESP32 firmware.
+type Controller struct {
+	Address     string    `json:"address"`
+	Driver      string    `json:"driver"`
+	Username    string    `json:"username"`
+	Password    string    `json:"-"`
+	HasPassword bool      `json:"has_password"` // Derived
+	Notes       string    `json:"notes"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func scanController(row rowScanner) (Controller, error) {
+	var c Controller
+	err := row.Scan(&c.Address, &c.Driver, &c.Username, &c.Password, &c.Notes, &c.UpdatedAt)
+	c.HasPassword = c.Password != ""
+	return c, err
+}
+
+// Get a registered controller, sql.ErrNoRows if it is not registered
+func GetController(db *sql.DB, address string) (Controller, error) {
+	return scanController(db.QueryRow(`
+    SELECT address, driver, username, password, notes, updated_at
+    FROM controllers
+    WHERE address = ?`, address))
+}
+
+// Register a controller or replace its registration
+func SaveController(db *sql.DB, c Controller) error {
+	_, err := db.Exec(`
+    INSERT INTO controllers (address, driver, username, password, notes, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?)
+    ON CONFLICT(address) DO UPDATE SET
+        driver = excluded.driver,
+        username = excluded.username,
+        password = excluded.password,
+        notes = excluded.notes,
+        updated_at = excluded.updated_at`,
+		c.Address, c.Driver, c.Username, c.Password, c.Notes, time.Now().UTC())
+	return err
+}
+
+// List all registered controllers
+func ListControllers(db *sql.DB) ([]Controller, error) {
+	rows, err := db.Query(`
+    SELECT address, driver, username, password, notes, updated_at
+    FROM controllers
+    ORDER BY address`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	controllers := []Controller{}
+	for rows.Next() {
+		c, err := scanController(rows)
+		if err != nil {
+			return nil, err
+		}
+		controllers = append(controllers, c)
+	}
+	return controllers, rows.Err()
+}
+
+// Remove the registration of a controller, it falls back to the ESP32 driver
+func DeleteController(db *sql.DB, address string) error {
+	return execOne(db, "DELETE FROM controllers WHERE address = ?", address)
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+)
+
+// Disease is an entry of the disease knowledge base with its content in every
+// locale it has been translated to
+type Disease struct {
+	Key           string                        `json:"key"` // Class name used by the AI model
+	Severity      string                        `json:"severity"`
+	MinConfidence float64                       `json:"min_confidence"` // Threshold below which a prediction is uncertain, 0 for the default
+	Translations  map[string]DiseaseTranslation `json:"translations"`
+	UpdatedAt     time.Time                     `json:"updated_at"`
+}
+
+// DiseaseTranslation is the content of a disease in one locale
+type DiseaseTranslation struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Symptoms       string `json:"symptoms"`
+	Treatment      string `json:"treatment"`
+	Prevention     string `json:"prevention"`
+	Recommendation string `json:"recommendation"`
+}
+
+// LocalizedDisease is a disease in a single locale
+type LocalizedDisease struct {
+	Key      string `json:"key"`
+	Severity string `json:"severity"`
+	Locale   string `json:"locale"`
+	DiseaseTranslation
+}
+
+// Disease severities
+const (
+	SeverityNone   = "none"
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+func ValidSeverity(severity string) bool {
+	switch severity {
+	case SeverityNone, SeverityLow, SeverityMedium, SeverityHigh:
+		return true
+	}
+	return false
+}
+
+// Content the knowledge base starts with
+//
+//go:embed diseases_seed.json
+var diseaseSeed []byte
+
+// Initialize disease knowledge base tables and seed them on first run
+func InitDiseaseDB(db *sql.DB) error {
+	createDiseaseTables := `
+    CREATE TABLE IF NOT EXISTS diseases (
+        key TEXT PRIMARY KEY,
+        severity TEXT NOT NULL DEFAULT 'medium',
+        updated_at TIMESTAMP NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS disease_translations (
+        disease_key TEXT NOT NULL,
+        locale TEXT NOT NULL,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        symptoms TEXT NOT NULL DEFAULT '',
+        treatment TEXT NOT NULL DEFAULT '',
+        prevention TEXT NOT NULL DEFAULT '',
+        recommendation TEXT NOT NULL DEFAULT '',
+        PRIMARY KEY (disease_key, locale),
+        FOREIGN KEY (disease_key) REFERENCES diseases(key)
+    );`
+
+	_, err := db.Exec(createDiseaseTables)
+	if err != nil {
+		log.Println("Error creating disease tables:", err)
+		return err
+	}
+	if err := AddColumnIfMissing(db, "diseases", "min_confidence", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Only seed an empty knowledge base, diseases deleted by an admin stay deleted
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM diseases").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var seed []Disease
+	if err := json.Unmarshal(diseaseSeed, &seed); err != nil {
+		return err
+	}
+	for _, d := range seed {
+		if err := UpsertDisease(db, d); err != nil {
+			log.Println("Error seeding disease:", err)
+			return err
+		}
+	}
+	log.Printf("Seeded %d diseases", len(seed))
+	return nil
+}
+
+// List every disease with all its translations
+func ListDiseases(db *sql.DB) ([]Disease, error) {
+	rows, err := db.Query(`
+    SELECT d.key, d.severity, d.min_confidence, d.updated_at, t.locale, t.name, t.description, t.symptoms,
+        t.treatment, t.prevention, t.recommendation
+    FROM diseases d
+    LEFT JOIN disease_translations t ON t.disease_key = d.key
+    ORDER BY d.key, t.locale`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diseases := []Disease{}
+	for rows.Next() {
+		var d Disease
+		var locale, name, description, symptoms, treatment, prevention, recommendation sql.NullString
+		err := rows.Scan(&d.Key, &d.Severity, &d.MinConfidence, &d.UpdatedAt, &locale, &name, &description, &symptoms,
+			&treatment, &prevention, &recommendation)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(diseases) == 0 || diseases[len(diseases)-1].Key != d.Key {
+			d.Translations = map[string]DiseaseTranslation{}
+			diseases = append(diseases, d)
+		}
+		if locale.Valid {
+			diseases[len(diseases)-1].Translations[locale.String] = DiseaseTranslation{
+				Name:           name.String,
+				Description:    description.String,
+				Symptoms:       symptoms.String,
+				Treatment:      treatment.String,
+				Prevention:     prevention.String,
+				Recommendation: recommendation.String,
+			}
+		}
+	}
+	return diseases, rows.Err()
+}
+
+// Get a disease with all its translations
+func GetDisease(db *sql.DB, key string) (Disease, error) {
+	diseases, err := ListDiseases(db)
+	if err != nil {
+		return Disease{}, err
+	}
+	for _, d := range diseases {
+		if d.Key == key {
+			return d, nil
+		}
+	}
+	return Disease{}, sql.ErrNoRows
+}
+
+// Create or replace a disease and its translations
+func UpsertDisease(db *sql.DB, d Disease) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+    INSERT INTO diseases (key, severity, min_confidence, updated_at) VALUES (?, ?, ?, ?)
+    ON CONFLICT(key) DO UPDATE SET severity = excluded.severity, min_confidence = excluded.min_confidence,
+        updated_at = excluded.updated_at`,
+		d.Key, d.Severity, d.MinConfidence, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM disease_translations WHERE disease_key = ?", d.Key); err != nil {
+		return err
+	}
+	for locale, t := range d.Translations {
+		_, err := tx.Exec(`
+        INSERT INTO disease_translations (disease_key, locale, name, description, symptoms, treatment,
+            prevention, recommendation)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.Key, locale, t.Name, t.Description, t.Symptoms, t.Treatment, t.Prevention, t.Recommendation)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete a disease and its translations. Returns sql.ErrNoRows when it does
+// not exist.
+func DeleteDisease(db *sql.DB, key string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM disease_translations WHERE disease_key = ?", key); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM diseases WHERE key = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// Localize a disease, using the first of the locales it is translated to, or
+// any translation when none matches
+func Localize(d Disease, locales []string) (LocalizedDisease, bool) {
+	localized := LocalizedDisease{Key: d.Key, Severity: d.Severity}
+	for _, locale := range locales {
+		if t, ok := d.Translations[locale]; ok {
+			localized.Locale = locale
+			localized.DiseaseTranslation = t
+			return localized, true
+		}
+	}
+	available := make([]string, 0, len(d.Translations))
+	for locale := range d.Translations {
+		available = append(available, locale)
+	}
+	if len(available) == 0 {
+		return localized, false
+	}
+	sort.Strings(available)
+	localized.Locale = available[0]
+	localized.DiseaseTranslation = d.Translations[available[0]]
+	return localized, true
+}
+
+// Severity of every disease by key
+func DiseaseSeverities(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query("SELECT key, severity FROM diseases")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	severities := map[string]string{}
+	for rows.Next() {
+		var key, severity string
+		if err := rows.Scan(&key, &severity); err != nil {
+			return nil, err
+		}
+		severities[key] = severity
+	}
+	return severities, rows.Err()
+}
+
+// Confidence threshold of every disease that overrides the default
+func DiseaseConfidenceThresholds(db *sql.DB) (map[string]float64, error) {
+	rows, err := db.Query("SELECT key, min_confidence FROM diseases WHERE min_confidence > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	thresholds := map[string]float64{}
+	for rows.Next() {
+		var key string
+		var threshold float64
+		if err := rows.Scan(&key, &threshold); err != nil {
+			return nil, err
+		}
+		thresholds[key] = threshold
+	}
+	return thresholds, rows.Err()
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// EggCollection is the eggs collected from a flock, several collections can
+// be made in a day
+type EggCollection struct {
+	ID         int            `json:"id"`
+	FlockID    int            `json:"flock_id"`
+	Day        string         `json:"day"` // YYYY-MM-DD
+	Total      int            `json:"total"`
+	Cracked    int            `json:"cracked"`
+	Dirty      int            `json:"dirty"`
+	Grades     map[string]int `json:"grades"` // Eggs by size grade
+	Notes      string         `json:"notes"`
+	RecordedBy int            `json:"recorded_by"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// Initialize egg collection table
+func InitEggDB(db *sql.DB) error {
+	createEggTable := `
+    CREATE TABLE IF NOT EXISTS egg_collections (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        flock_id INTEGER NOT NULL,
+        day TEXT NOT NULL,
+        total INTEGER NOT NULL,
+        cracked INTEGER NOT NULL DEFAULT 0,
+        dirty INTEGER NOT NULL DEFAULT 0,
+        grades TEXT NOT NULL DEFAULT '{}',
+        notes TEXT NOT NULL DEFAULT '',
+        recorded_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (recorded_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_egg_collections_flock ON egg_collections(flock_id, day);`
+
+	_, err := db.Exec(createEggTable)
+	if err != nil {
+		log.Println("Error creating egg collections table:", err)
+		return err
+	}
+	return nil
+}
+
+// Store an egg collection and return its ID
+func CreateEggCollection(db *sql.DB, e EggCollection) (int, error) {
+	grades, err := json.Marshal(e.Grades)
+	if err != nil {
+		return 0, err
+	}
+	if e.Grades == nil {
+		grades = []byte("{}")
+	}
+	result, err := db.Exec(`
+    INSERT INTO egg_collections (flock_id, day, total, cracked, dirty, grades, notes, recorded_by, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.FlockID, e.Day, e.Total, e.Cracked, e.Dirty, string(grades), e.Notes, e.RecordedBy, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// List the egg collections of a flock from a day on, oldest first
+func ListEggCollections(db *sql.DB, flockID int, fromDay string) ([]EggCollection, error) {
+	rows, err := db.Query(`
+    SELECT id, flock_id, day, total, cracked, dirty, grades, notes, recorded_by, created_at
+    FROM egg_collections WHERE flock_id = ? AND day >= ?
+    ORDER BY day, id`, flockID, fromDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []EggCollection{}
+	for rows.Next() {
+		var e EggCollection
+		var grades string
+		err := rows.Scan(&e.ID, &e.FlockID, &e.Day, &e.Total, &e.Cracked, &e.Dirty, &grades, &e.Notes,
+			&e.RecordedBy, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(grades), &e.Grades); err != nil {
+			return nil, err
+		}
+		collections = append(collections, e)
+	}
+	return collections, rows.Err()
+}
+
+// Delete an egg collection of a flock. Returns sql.ErrNoRows when it does
+// not exist.
+func DeleteEggCollection(db *sql.DB, flockID, id int) error {
+	result, err := db.Exec("DELETE FROM egg_collections WHERE id = ? AND flock_id = ?", id, flockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// Farm is a site holding one or more houses
+type Farm struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Location  string    `json:"location"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// House is a poultry house of a farm. Its name is the house key used by
+// flocks, findings and tasks.
+type House struct {
+	ID         int       `json:"id"`
+	FarmID     int       `json:"farm_id"`
+	Name       string    `json:"name"`
+	Controller string    `json:"controller"` // Address of the controller of the house
+	Notes      string    `json:"notes"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Zone is an area of a house, e.g. the brooding end or a tier of cages
+type Zone struct {
+	ID        int       `json:"id"`
+	HouseID   int       `json:"house_id"`
+	Name      string    `json:"name"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrInUse is returned when deleting a record others still belong to
+var ErrInUse = errors.New("record is in use")
+
+// Initialize farm, house and zone tables
+func InitFarmDB(db *sql.DB) error {
+	createFarmTables := `
+    CREATE TABLE IF NOT EXISTS farms (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        location TEXT NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS houses (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        farm_id INTEGER NOT NULL,
+        name TEXT UNIQUE NOT NULL,
+        controller TEXT NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (farm_id) REFERENCES farms(id)
+    );
+    CREATE TABLE IF NOT EXISTS zones (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        house_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (house_id) REFERENCES houses(id),
+        UNIQUE (house_id, name)
+    );`
+
+	_, err := db.Exec(createFarmTables)
+	if err != nil {
+		log.Println("Error creating farm tables:", err)
+		return err
+	}
+	return nil
+}
+
+// Run an update or delete and report sql.ErrNoRows when nothing matched
+func execOne(db *sql.DB, query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a row unless rows of another table still point to it
+func deleteUnused(db *sql.DB, table string, id int, children ...string) error {
+	for _, child := range children {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+child, id).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrInUse
+		}
+	}
+	return execOne(db, "DELETE FROM "+table+" WHERE id = ?", id)
+}
+
+// ====== FARMS ====== //
+
+// Create a farm and return its ID
+func CreateFarm(db *sql.DB, f Farm) (int, error) {
+	result, err := db.Exec("INSERT INTO farms (name, location, notes, created_at) VALUES (?, ?, ?, ?)",
+		f.Name, f.Location, f.Notes, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a farm by ID
+func GetFarm(db *sql.DB, id int) (Farm, error) {
+	var f Farm
+	err := db.QueryRow("SELECT id, name, location, notes, created_at FROM farms WHERE id = ?", id).
+		Scan(&f.ID, &f.Name, &f.Location, &f.Notes, &f.CreatedAt)
+	return f, err
+}
+
+// List farms by name
+func ListFarms(db *sql.DB) ([]Farm, error) {
+	rows, err := db.Query("SELECT id, name, location, notes, created_at FROM farms ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	farms := []Farm{}
+	for rows.Next() {
+		var f Farm
+		if err := rows.Scan(&f.ID, &f.Name, &f.Location, &f.Notes, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		farms = append(farms, f)
+	}
+	return farms, rows.Err()
+}
+
+// Update the editable fields of a farm
+func UpdateFarm(db *sql.DB, f Farm) error {
+	return execOne(db, "UPDATE farms SET name = ?, location = ?, notes = ? WHERE id = ?",
+		f.Name, f.Location, f.Notes, f.ID)
+}
+
+// Delete a farm without houses. Returns ErrInUse when it has some.
+func DeleteFarm(db *sql.DB, id int) error {
+	return deleteUnused(db, "farms", id, "houses WHERE farm_id = ?")
+}
+
+// ====== HOUSES ====== //
+
+const houseColumns = "id, farm_id, name, controller, notes, created_at"
+
+func scanHouse(row rowScanner) (House, error) {
+	var h House
+	err := row.Scan(&h.ID, &h.FarmID, &h.Name, &h.Controller, &h.Notes, &h.CreatedAt)
+	return h, err
+}
+
+// Create a house and return its ID
+func CreateHouse(db *sql.DB, h House) (int, error) {
+	result, err := db.Exec(`
+    INSERT INTO houses (farm_id, name, controller, notes, created_at) VALUES (?, ?, ?, ?, ?)`,
+		h.FarmID, h.Name, h.Controller, h.Notes, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a house by ID
+func GetHouse(db *sql.DB, id int) (House, error) {
+	return scanHouse(db.QueryRow("SELECT "+houseColumns+" FROM houses WHERE id = ?", id))
+}
+
+// Get a house by name
+func GetHouseByName(db *sql.DB, name string) (House, error) {
+	return scanHouse(db.QueryRow("SELECT "+houseColumns+" FROM houses WHERE name = ?", name))
+}
+
+// List all houses by name
+func ListHouses(db *sql.DB) ([]House, error) {
+	rows, err := db.Query("SELECT " + houseColumns + " FROM houses ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	houses := []House{}
+	for rows.Next() {
+		h, err := scanHouse(rows)
+		if err != nil {
+			return nil, err
+		}
+		houses = append(houses, h)
+	}
+	return houses, rows.Err()
+}
+
+// Whether a house name is used by recorded flocks or tasks
+func HouseNameInUse(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+    SELECT (SELECT COUNT(*) FROM flocks WHERE house = ?) + (SELECT COUNT(*) FROM farm_tasks WHERE house = ?)`,
+		name, name).Scan(&count)
+	return count > 0, err
+}
+
+// Update the editable fields of a house
+func UpdateHouse(db *sql.DB, h House) error {
+	return execOne(db, "UPDATE houses SET farm_id = ?, name = ?, controller = ?, notes = ? WHERE id = ?",
+		h.FarmID, h.Name, h.Controller, h.Notes, h.ID)
+}
+
+// Delete a house without zones, sensors or actuators. Returns ErrInUse when
+// it has some.
+func DeleteHouse(db *sql.DB, id int) error {
+	return deleteUnused(db, "houses", id, "zones WHERE house_id = ?", "sensors WHERE house_id = ?",
+		"actuators WHERE house_id = ?")
+}
+
+// ====== ZONES ====== //
+
+const zoneColumns = "id, house_id, name, notes, created_at"
+
+func scanZone(row rowScanner) (Zone, error) {
+	var z Zone
+	err := row.Scan(&z.ID, &z.HouseID, &z.Name, &z.Notes, &z.CreatedAt)
+	return z, err
+}
+
+// Create a zone and return its ID
+func CreateZone(db *sql.DB, z Zone) (int, error) {
+	result, err := db.Exec("INSERT INTO zones (house_id, name, notes, created_at) VALUES (?, ?, ?, ?)",
+		z.HouseID, z.Name, z.Notes, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a zone by ID
+func GetZone(db *sql.DB, id int) (Zone, error) {
+	return scanZone(db.QueryRow("SELECT "+zoneColumns+" FROM zones WHERE id = ?", id))
+}
+
+// Get a zone of a house by name
+func GetZoneByName(db *sql.DB, houseID int, name string) (Zone, error) {
+	return scanZone(db.QueryRow("SELECT "+zoneColumns+" FROM zones WHERE house_id = ? AND name = ?", houseID, name))
+}
+
+// List all zones by name
+func ListZones(db *sql.DB) ([]Zone, error) {
+	rows, err := db.Query("SELECT " + zoneColumns + " FROM zones ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zones := []Zone{}
+	for rows.Next() {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+// Update the editable fields of a zone
+func UpdateZone(db *sql.DB, z Zone) error {
+	return execOne(db, "UPDATE zones SET name = ?, notes = ? WHERE id = ?", z.Name, z.Notes, z.ID)
+}
+
+// Delete a zone without sensors. Returns ErrInUse when it has some.
+func DeleteZone(db *sql.DB, id int) error {
+	return deleteUnused(db, "zones", id, "sensors WHERE zone_id = ?")
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"middleware/analysis"
+)
+
+// FeedDelivery is feed put into a flock's feeders by hand
+type FeedDelivery struct {
+	ID          int       `json:"id"`
+	FlockID     int       `json:"flock_id"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	QuantityKg  float64   `json:"quantity_kg"`
+	FeedType    string    `json:"feed_type"` // e.g. starter, grower, finisher
+	Notes       string    `json:"notes"`
+	RecordedBy  int       `json:"recorded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DeviceEvent is a controller device switching on or off
+type DeviceEvent struct {
+	ID         int       `json:"id"`
+	Controller string    `json:"controller"`
+	Device     string    `json:"device"`
+	On         bool      `json:"on"`
+	Source     string    `json:"source"` // toggle or poll
+	ChangedAt  time.Time `json:"changed_at"`
+	FlockID    *int      `json:"flock_id,omitempty"`
+}
+
+// Initialize feed delivery and device event tables
+func InitFeedDB(db *sql.DB) error {
+	createFeedTables := `
+    CREATE TABLE IF NOT EXISTS feed_deliveries (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        flock_id INTEGER NOT NULL,
+        delivered_at TIMESTAMP NOT NULL,
+        quantity_kg REAL NOT NULL,
+        feed_type TEXT NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        recorded_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (recorded_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_feed_deliveries_flock ON feed_deliveries(flock_id, delivered_at);
+
+    CREATE TABLE IF NOT EXISTS device_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        controller TEXT NOT NULL,
+        device TEXT NOT NULL,
+        is_on BOOLEAN NOT NULL,
+        source TEXT NOT NULL,
+        changed_at TIMESTAMP NOT NULL,
+        flock_id INTEGER REFERENCES flocks(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_device_events_device ON device_events(controller, device, changed_at);`
+
+	_, err := db.Exec(createFeedTables)
+	if err != nil {
+		log.Println("Error creating feed tables:", err)
+		return err
+	}
+	return nil
+}
+
+// ====== FEED DELIVERIES ====== //
+
+// Store a feed delivery and return its ID
+func CreateFeedDelivery(db *sql.DB, d FeedDelivery) (int, error) {
+	result, err := db.Exec(`
+    INSERT INTO feed_deliveries (flock_id, delivered_at, quantity_kg, feed_type, notes, recorded_by, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.FlockID, d.DeliveredAt.UTC(), d.QuantityKg, d.FeedType, d.Notes, d.RecordedBy, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// List the feed deliveries of a flock, oldest first
+func ListFeedDeliveries(db *sql.DB, flockID int) ([]FeedDelivery, error) {
+	rows, err := db.Query(`
+    SELECT id, flock_id, delivered_at, quantity_kg, feed_type, notes, recorded_by, created_at
+    FROM feed_deliveries WHERE flock_id = ?
+    ORDER BY delivered_at, id`, flockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []FeedDelivery{}
+	for rows.Next() {
+		var d FeedDelivery
+		err := rows.Scan(&d.ID, &d.FlockID, &d.DeliveredAt, &d.QuantityKg, &d.FeedType, &d.Notes,
+			&d.RecordedBy, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Delete a feed delivery of a flock. Returns sql.ErrNoRows when it does not
+// exist.
+func DeleteFeedDelivery(db *sql.DB, flockID, id int) error {
+	result, err := db.Exec("DELETE FROM feed_deliveries WHERE id = ? AND flock_id = ?", id, flockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ====== DEVICE EVENTS ====== //
+
+// Store a device event
+func SaveDeviceEvent(db *sql.DB, e DeviceEvent) error {
+	_, err := db.Exec(`
+    INSERT INTO device_events (controller, device, is_on, source, changed_at, flock_id)
+    VALUES (?, ?, ?, ?, ?, ?)`,
+		e.Controller, e.Device, e.On, e.Source, e.ChangedAt.UTC(), e.FlockID)
+	return err
+}
+
+// Last known state of a device. Returns sql.ErrNoRows when it was never
+// recorded.
+func LastDeviceState(db *sql.DB, controller, device string) (bool, error) {
+	var on bool
+	err := db.QueryRow(`
+    SELECT is_on FROM device_events WHERE controller = ? AND device = ?
+    ORDER BY changed_at DESC, id DESC LIMIT 1`, controller, device).Scan(&on)
+	return on, err
+}
+
+// State changes of a device in [from, to), preceded by the last change
+// before from so the state at from is known
+func DeviceStateChanges(db *sql.DB, controller, device string, from, to time.Time) ([]analysis.StateChange, error) {
+	rows, err := db.Query(`
+    SELECT changed_at, is_on FROM (
+        SELECT changed_at, is_on, id FROM device_events
+        WHERE controller = ? AND device = ? AND changed_at < ?
+        ORDER BY changed_at DESC, id DESC LIMIT 1
+    )
+    UNION ALL
+    SELECT changed_at, is_on FROM device_events
+    WHERE controller = ? AND device = ? AND changed_at >= ? AND changed_at < ?
+    ORDER BY changed_at`,
+		controller, device, from.UTC(), controller, device, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []analysis.StateChange{}
+	for rows.Next() {
+		var c analysis.StateChange
+		if err := rows.Scan(&c.At, &c.On); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Finding is a structured alert raised by an analysis job
+type Finding struct {
+	ID           int                    `json:"id"`
+	Kind         string                 `json:"kind"`
+	House        string                 `json:"house"`
+	Subject      string                 `json:"subject"`
+	Severity     string                 `json:"severity"`
+	Summary      string                 `json:"summary"`
+	Details      map[string]interface{} `json:"details"`
+	Status       string                 `json:"status"`
+	WindowStart  time.Time              `json:"window_start"`
+	WindowEnd    time.Time              `json:"window_end"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	Acknowledged *int                   `json:"acknowledged_by,omitempty"`
+	FlockID      *int                   `json:"flock_id,omitempty"`
+}
+
+// Finding statuses
+const (
+	FindingOpen         = "open"
+	FindingAcknowledged = "acknowledged"
+)
+
+// Initialize findings table
+func InitFindingDB(db *sql.DB) error {
+	createFindingsTable := `
+    CREATE TABLE IF NOT EXISTS findings (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        kind TEXT NOT NULL,
+        house TEXT NOT NULL DEFAULT '',
+        subject TEXT NOT NULL DEFAULT '',
+        severity TEXT NOT NULL,
+        summary TEXT NOT NULL,
+        details TEXT NOT NULL DEFAULT '{}',
+        status TEXT NOT NULL DEFAULT 'open',
+        window_start TIMESTAMP NOT NULL,
+        window_end TIMESTAMP NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        acknowledged_by INTEGER,
+        FOREIGN KEY (acknowledged_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_findings_open ON findings(kind, house, subject, status);`
+
+	_, err := db.Exec(createFindingsTable)
+	if err != nil {
+		log.Println("Error creating findings table:", err)
+		return err
+	}
+	return nil
+}
+
+// Raise a finding. An open finding of the same kind for the same house and
+// subject is updated instead of duplicated.
+func UpsertFinding(db *sql.DB, f Finding) (int, error) {
+	details, err := json.Marshal(f.Details)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+
+	var id int
+	err = db.QueryRow(`
+    SELECT id FROM findings
+    WHERE kind = ? AND house = ? AND subject = ? AND status = ?`,
+		f.Kind, f.House, f.Subject, FindingOpen).Scan(&id)
+	if err == nil {
+		_, err = db.Exec(`
+    UPDATE findings
+    SET severity = ?, summary = ?, details = ?, window_end = ?, updated_at = ?
+    WHERE id = ?`,
+			f.Severity, f.Summary, string(details), f.WindowEnd.UTC(), now, id)
+		return id, err
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	result, err := db.Exec(`
+    INSERT INTO findings (kind, house, subject, severity, summary, details, status,
+        window_start, window_end, created_at, updated_at, flock_id)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Kind, f.House, f.Subject, f.Severity, f.Summary, string(details), FindingOpen,
+		f.WindowStart.UTC(), f.WindowEnd.UTC(), now, now, f.FlockID)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
+
+// List findings, newest first. Empty kind or status match everything.
+func ListFindings(db *sql.DB, kind, status string, limit int) ([]Finding, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	return listFindings(db, "(? = '' OR kind = ?) AND (? = '' OR status = ?) ORDER BY updated_at DESC LIMIT ?",
+		kind, kind, status, status, limit)
+}
+
+func listFindings(db *sql.DB, where string, args ...interface{}) ([]Finding, error) {
+	rows, err := db.Query(`
+    SELECT id, kind, house, subject, severity, summary, details, status,
+        window_start, window_end, created_at, updated_at, acknowledged_by, flock_id
+    FROM findings
+    WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	findings := []Finding{}
+	for rows.Next() {
+		var f Finding
+		var details string
+		var acknowledged, flockID sql.NullInt64
+		err := rows.Scan(&f.ID, &f.Kind, &f.House, &f.Subject, &f.Severity, &f.Summary, &details, &f.Status,
+			&f.WindowStart, &f.WindowEnd, &f.CreatedAt, &f.UpdatedAt, &acknowledged, &flockID)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(details), &f.Details); err != nil {
+			return nil, err
+		}
+		if acknowledged.Valid {
+			userID := int(acknowledged.Int64)
+			f.Acknowledged = &userID
+		}
+		if flockID.Valid {
+			id := int(flockID.Int64)
+			f.FlockID = &id
+		}
+		findings = append(findings, f)
+	}
+	return findings, rows.Err()
+}
+
+// Mark a finding as acknowledged by a user
+func AcknowledgeFinding(db *sql.DB, id, userID int) error {
+	result, err := db.Exec(`
+    UPDATE findings SET status = ?, acknowledged_by = ?, updated_at = ?
+    WHERE id = ?`, FindingAcknowledged, userID, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Acknowledge the open finding of a kind for a house and subject, when what
+// it reported was dealt with
+func AcknowledgeOpenFinding(db *sql.DB, kind, house, subject string, userID int) error {
+	_, err := db.Exec(`
+    UPDATE findings SET status = ?, acknowledged_by = ?, updated_at = ?
+    WHERE kind = ? AND house = ? AND subject = ? AND status = ?`,
+		FindingAcknowledged, userID, time.Now().UTC(), kind, house, subject, FindingOpen)
+	return err
+}
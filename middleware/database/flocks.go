@@ -0,0 +1,321 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"time"
+
+	"middleware/analysis"
+)
+
+// Flock is a group of birds raised together in a house, from placement to
+// close-out. Predictions, telemetry and findings made while a flock is active
+// in its house are linked to it, so a whole grow-out can be reviewed.
+type Flock struct {
+	ID           int        `json:"id"`
+	Name         string     `json:"name"`
+	Breed        string     `json:"breed"`
+	House        string     `json:"house"`
+	Controller   string     `json:"controller"` // Links the controller's telemetry
+	PlacedAt     time.Time  `json:"placed_at"`
+	PlacementAge int        `json:"placement_age_days"` // Age of the birds when placed, 0 for day-old chicks
+	InitialCount int        `json:"initial_count"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	Notes        string     `json:"notes"`
+	CreatedBy    int        `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	AgeDays      int        `json:"age_days"` // Derived, see AgeOn
+	Active       bool       `json:"active"`
+}
+
+// Age of the birds in days on a date. The age stops at the close-out date.
+func (f Flock) AgeOn(t time.Time) int {
+	if f.ClosedAt != nil && t.After(*f.ClosedAt) {
+		t = *f.ClosedAt
+	}
+	// Round, a day is not always 24 hours long
+	days := int(math.Round(analysis.StartOfDay(t).Sub(analysis.StartOfDay(f.PlacedAt)).Hours() / 24))
+	if days < 0 {
+		return f.PlacementAge
+	}
+	return f.PlacementAge + days
+}
+
+// FlockFilter narrows a flock query. Zero values are ignored.
+type FlockFilter struct {
+	House      string
+	ActiveOnly bool
+}
+
+// Initialize flocks table and link the recorded data to flocks
+func InitFlockDB(db *sql.DB) error {
+	createFlocksTable := `
+    CREATE TABLE IF NOT EXISTS flocks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        breed TEXT NOT NULL DEFAULT '',
+        house TEXT NOT NULL DEFAULT '',
+        controller TEXT NOT NULL DEFAULT '',
+        placed_at TIMESTAMP NOT NULL,
+        placement_age INTEGER NOT NULL DEFAULT 0,
+        initial_count INTEGER NOT NULL,
+        closed_at TIMESTAMP,
+        notes TEXT NOT NULL DEFAULT '',
+        created_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (created_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_flocks_house ON flocks(house, placed_at);`
+
+	_, err := db.Exec(createFlocksTable)
+	if err != nil {
+		log.Println("Error creating flocks table:", err)
+		return err
+	}
+
+	for _, table := range []string{"predictions", "telemetry", "findings"} {
+		if err := AddColumnIfMissing(db, table, "flock_id", "INTEGER REFERENCES flocks(id)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const flockColumns = `id, name, breed, house, controller, placed_at, placement_age, initial_count,
+        closed_at, notes, created_by, created_at, updated_at`
+
+func scanFlock(row rowScanner) (Flock, error) {
+	var f Flock
+	var closedAt sql.NullTime
+	err := row.Scan(&f.ID, &f.Name, &f.Breed, &f.House, &f.Controller, &f.PlacedAt, &f.PlacementAge,
+		&f.InitialCount, &closedAt, &f.Notes, &f.CreatedBy, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return f, err
+	}
+	if closedAt.Valid {
+		f.ClosedAt = &closedAt.Time
+	}
+	now := time.Now()
+	f.AgeDays = f.AgeOn(now)
+	f.Active = f.ClosedAt == nil && !f.PlacedAt.After(now)
+	return f, nil
+}
+
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// Create a flock and return its ID
+func CreateFlock(db *sql.DB, f Flock) (int, error) {
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+    INSERT INTO flocks (name, breed, house, controller, placed_at, placement_age, initial_count,
+        closed_at, notes, created_by, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Name, f.Breed, f.House, f.Controller, f.PlacedAt.UTC(), f.PlacementAge, f.InitialCount,
+		nullTime(f.ClosedAt), f.Notes, f.CreatedBy, now, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a flock by ID
+func GetFlock(db *sql.DB, id int) (Flock, error) {
+	return scanFlock(db.QueryRow("SELECT "+flockColumns+" FROM flocks WHERE id = ?", id))
+}
+
+// List flocks, most recently placed first
+func ListFlocks(db *sql.DB, filter FlockFilter) ([]Flock, error) {
+	query := "SELECT " + flockColumns + " FROM flocks WHERE 1 = 1"
+	var args []interface{}
+	if filter.House != "" {
+		query += " AND house = ?"
+		args = append(args, filter.House)
+	}
+	if filter.ActiveOnly {
+		query += " AND closed_at IS NULL"
+	}
+	query += " ORDER BY placed_at DESC, id DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flocks := []Flock{}
+	for rows.Next() {
+		f, err := scanFlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		flocks = append(flocks, f)
+	}
+	return flocks, rows.Err()
+}
+
+// Update the editable fields of a flock
+func UpdateFlock(db *sql.DB, f Flock) error {
+	result, err := db.Exec(`
+    UPDATE flocks SET name = ?, breed = ?, house = ?, controller = ?, placed_at = ?, placement_age = ?,
+        initial_count = ?, closed_at = ?, notes = ?, updated_at = ?
+    WHERE id = ?`,
+		f.Name, f.Breed, f.House, f.Controller, f.PlacedAt.UTC(), f.PlacementAge, f.InitialCount,
+		nullTime(f.ClosedAt), f.Notes, time.Now().UTC(), f.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a flock with its mortality, feed, weight and egg logs and its
+// treatments. The recorded data and tasks linked to it are kept and
+// unlinked.
+func DeleteFlock(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	linked := []string{"predictions", "telemetry", "findings", "device_events", "farm_tasks", "sensor_readings"}
+	for _, table := range linked {
+		if _, err := tx.Exec("UPDATE "+table+" SET flock_id = NULL WHERE flock_id = ?", id); err != nil {
+			return err
+		}
+	}
+	logs := []string{"mortality_log", "feed_deliveries", "weight_samples", "egg_collections", "flock_treatments"}
+	for _, table := range logs {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE flock_id = ?", id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM flocks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// Flock in a house, or fed by a controller, at a time. The house takes
+// precedence. Returns sql.ErrNoRows when there is none.
+func ActiveFlock(db *sql.DB, house, controller string, at time.Time) (Flock, error) {
+	return scanFlock(db.QueryRow(`
+    SELECT `+flockColumns+` FROM flocks
+    WHERE ((house != '' AND house = ?) OR (controller != '' AND controller = ?))
+        AND placed_at <= ? AND (closed_at IS NULL OR closed_at > ?)
+    ORDER BY (house != '' AND house = ?) DESC, placed_at DESC
+    LIMIT 1`, house, controller, at.UTC(), at.UTC(), house))
+}
+
+// ID of the active flock, nil when there is none
+func ActiveFlockID(db *sql.DB, house, controller string, at time.Time) *int {
+	if house == "" && controller == "" {
+		return nil
+	}
+	f, err := ActiveFlock(db, house, controller, at)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to find active flock:", err)
+		}
+		return nil
+	}
+	return &f.ID
+}
+
+// Flocks other than exclude that overlap a period in the same house. A
+// house holds one flock at a time.
+func OverlappingFlocks(db *sql.DB, house string, from time.Time, to *time.Time, exclude int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+    SELECT COUNT(*) FROM flocks
+    WHERE house = ? AND id != ?
+        AND (closed_at IS NULL OR closed_at > ?)
+        AND (? IS NULL OR placed_at < ?)`,
+		house, exclude, from.UTC(), nullTime(to), nullTime(to)).Scan(&count)
+	return count, err
+}
+
+// ====== GROW-OUT REVIEW ====== //
+
+// FlockSummary aggregates the data linked to a flock
+type FlockSummary struct {
+	Predictions     int             `json:"predictions"`
+	Uncertain       int             `json:"uncertain"`
+	DiseaseCounts   map[string]int  `json:"disease_counts"`
+	Readings        int             `json:"readings"`
+	TemperatureMin  *float64        `json:"temperature_min"`
+	TemperatureMax  *float64        `json:"temperature_max"`
+	TemperatureMean *float64        `json:"temperature_mean"`
+	HumidityMin     *float64        `json:"humidity_min"`
+	HumidityMax     *float64        `json:"humidity_max"`
+	HumidityMean    *float64        `json:"humidity_mean"`
+	Mortality       MortalityTotals `json:"mortality"`
+	LiveCount       int             `json:"live_count"`
+	Findings        []Finding       `json:"findings"`
+}
+
+// Summarize everything linked to a flock
+func SummarizeFlock(db *sql.DB, flock Flock) (FlockSummary, error) {
+	id := flock.ID
+	summary := FlockSummary{DiseaseCounts: map[string]int{}}
+
+	rows, err := db.Query(`
+    SELECT predicted_disease, is_healthy, outcome, COUNT(*) FROM predictions
+    WHERE flock_id = ? GROUP BY predicted_disease, is_healthy, outcome`, id)
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var disease, outcome string
+		var healthy bool
+		var count int
+		if err := rows.Scan(&disease, &healthy, &outcome, &count); err != nil {
+			return summary, err
+		}
+		summary.Predictions += count
+		switch {
+		case outcome == analysis.OutcomeUncertain:
+			summary.Uncertain += count
+		case !healthy:
+			summary.DiseaseCounts[disease] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+
+	err = db.QueryRow(`
+    SELECT COUNT(*), MIN(temperature), MAX(temperature), AVG(temperature),
+        MIN(humidity), MAX(humidity), AVG(humidity)
+    FROM telemetry WHERE flock_id = ?`, id).Scan(&summary.Readings,
+		&summary.TemperatureMin, &summary.TemperatureMax, &summary.TemperatureMean,
+		&summary.HumidityMin, &summary.HumidityMax, &summary.HumidityMean)
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Mortality, err = FlockMortality(db, id)
+	if err != nil {
+		return summary, err
+	}
+	summary.LiveCount = flock.InitialCount - summary.Mortality.Deaths - summary.Mortality.Culls
+
+	summary.Findings, err = listFindings(db, "flock_id = ? ORDER BY created_at", id)
+	return summary, err
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// PredictionJob is a queued disease prediction. The image is already in the
+// image store, so jobs survive a restart.
+type PredictionJob struct {
+	ID           string     `json:"id"`
+	BatchID      string     `json:"batch_id,omitempty"`
+	UserID       int        `json:"user_id"`
+	Status       string     `json:"status"`
+	Filename     string     `json:"filename"`
+	ImageHash    string     `json:"image_hash"`
+	ImagePath    string     `json:"-"`
+	ContentType  string     `json:"content_type"`
+	Controller   string     `json:"controller"`
+	House        string     `json:"house"`
+	Attempts     int        `json:"attempts"`
+	Error        string     `json:"error,omitempty"`
+	PredictionID *int       `json:"prediction_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Deadline     time.Time  `json:"deadline"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobTimedOut  = "timed_out"
+)
+
+// Initialize prediction jobs table
+func InitJobDB(db *sql.DB) error {
+	createJobsTable := `
+    CREATE TABLE IF NOT EXISTS prediction_jobs (
+        id TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        status TEXT NOT NULL,
+        filename TEXT NOT NULL DEFAULT '',
+        image_hash TEXT NOT NULL,
+        image_path TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        controller TEXT NOT NULL DEFAULT '',
+        house TEXT NOT NULL DEFAULT '',
+        attempts INTEGER NOT NULL DEFAULT 0,
+        error TEXT NOT NULL DEFAULT '',
+        prediction_id INTEGER,
+        created_at TIMESTAMP NOT NULL,
+        deadline TIMESTAMP NOT NULL,
+        started_at TIMESTAMP,
+        finished_at TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id),
+        FOREIGN KEY (prediction_id) REFERENCES predictions(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_prediction_jobs_status ON prediction_jobs(status, created_at);`
+
+	_, err := db.Exec(createJobsTable)
+	if err != nil {
+		log.Println("Error creating prediction jobs table:", err)
+		return err
+	}
+
+	// Jobs of a batch upload share a batch ID
+	if err = AddColumnIfMissing(db, "prediction_jobs", "batch_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		log.Println("Error adding batch_id column:", err)
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_prediction_jobs_batch ON prediction_jobs(batch_id)")
+	return err
+}
+
+const jobColumns = `id, batch_id, user_id, status, filename, image_hash, image_path, content_type, controller, house,
+        attempts, error, prediction_id, created_at, deadline, started_at, finished_at`
+
+func scanJob(row rowScanner) (PredictionJob, error) {
+	var j PredictionJob
+	var predictionID sql.NullInt64
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&j.ID, &j.BatchID, &j.UserID, &j.Status, &j.Filename, &j.ImageHash, &j.ImagePath, &j.ContentType,
+		&j.Controller, &j.House, &j.Attempts, &j.Error, &predictionID, &j.CreatedAt, &j.Deadline,
+		&startedAt, &finishedAt)
+	if err != nil {
+		return j, err
+	}
+	if predictionID.Valid {
+		id := int(predictionID.Int64)
+		j.PredictionID = &id
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return j, nil
+}
+
+const insertJob = `
+    INSERT INTO prediction_jobs (id, batch_id, user_id, status, filename, image_hash, image_path, content_type,
+        controller, house, created_at, deadline)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// Queue a new job
+func CreateJob(db *sql.DB, j PredictionJob) error {
+	return CreateJobs(db, []PredictionJob{j})
+}
+
+// Queue several jobs at once, either all of them or none
+func CreateJobs(db *sql.DB, jobs []PredictionJob) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(insertJob)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, j := range jobs {
+		_, err := stmt.Exec(j.ID, j.BatchID, j.UserID, JobQueued, j.Filename, j.ImageHash, j.ImagePath,
+			j.ContentType, j.Controller, j.House, j.CreatedAt.UTC(), j.Deadline.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get a job by ID
+func GetJob(db *sql.DB, id string) (PredictionJob, error) {
+	return scanJob(db.QueryRow("SELECT "+jobColumns+" FROM prediction_jobs WHERE id = ?", id))
+}
+
+// Jobs of a batch in upload order
+func ListBatchJobs(db *sql.DB, batchID string) ([]PredictionJob, error) {
+	rows, err := db.Query("SELECT "+jobColumns+" FROM prediction_jobs WHERE batch_id = ? ORDER BY created_at, rowid", batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []PredictionJob{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Count the submissions of a user that are still queued or running. A batch
+// counts as one submission.
+func CountActiveJobs(db *sql.DB, userID int) (int, error) {
+	var count int
+	err := db.QueryRow(`
+    SELECT COUNT(DISTINCT CASE WHEN batch_id = '' THEN id ELSE batch_id END) FROM prediction_jobs
+    WHERE user_id = ? AND status IN (?, ?)`, userID, JobQueued, JobRunning).Scan(&count)
+	return count, err
+}
+
+// Atomically move the oldest queued job to running. Returns sql.ErrNoRows
+// when the queue is empty.
+func ClaimNextJob(db *sql.DB) (PredictionJob, error) {
+	now := time.Now().UTC()
+	return scanJob(db.QueryRow(`
+    UPDATE prediction_jobs
+    SET status = ?, attempts = attempts + 1, started_at = ?
+    WHERE id = (
+        SELECT id FROM prediction_jobs
+        WHERE status = ?
+        ORDER BY created_at
+        LIMIT 1
+    )
+    RETURNING `+jobColumns, JobRunning, now, JobQueued))
+}
+
+// Record the outcome of a job
+func FinishJob(db *sql.DB, id, status, errMsg string, predictionID *int) error {
+	_, err := db.Exec(`
+    UPDATE prediction_jobs
+    SET status = ?, error = ?, prediction_id = ?, finished_at = ?
+    WHERE id = ?`, status, errMsg, predictionID, time.Now().UTC(), id)
+	return err
+}
+
+// Put a running job back in the queue for another attempt
+func RequeueJob(db *sql.DB, id, errMsg string) error {
+	_, err := db.Exec(`
+    UPDATE prediction_jobs SET status = ?, error = ?
+    WHERE id = ?`, JobQueued, errMsg, id)
+	return err
+}
+
+// Requeue jobs that were running when the server stopped
+func RecoverInterruptedJobs(db *sql.DB) (int, error) {
+	result, err := db.Exec(`
+    UPDATE prediction_jobs SET status = ?
+    WHERE status = ?`, JobQueued, JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Time out the queued jobs past their deadline and return their IDs
+func ExpireJobs(db *sql.DB, now time.Time) ([]string, error) {
+	rows, err := db.Query(`
+    UPDATE prediction_jobs SET status = ?, error = ?, finished_at = ?
+    WHERE status = ? AND deadline < ?
+    RETURNING id`, JobTimedOut, "AI service was unavailable", now.UTC(), JobQueued, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Number of jobs in each status that is not final
+func CountPendingJobs(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(`
+    SELECT status, COUNT(*) FROM prediction_jobs
+    WHERE status IN (?, ?) GROUP BY status`, JobQueued, JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{JobQueued: 0, JobRunning: 0}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// MortalityRecord is a number of birds found dead or culled in a flock on a
+// day. A day can have several records.
+type MortalityRecord struct {
+	ID              int       `json:"id"`
+	FlockID         int       `json:"flock_id"`
+	Day             string    `json:"day"` // YYYY-MM-DD
+	Deaths          int       `json:"deaths"`
+	Culls           int       `json:"culls"`
+	Cause           string    `json:"cause"`
+	Notes           string    `json:"notes"`
+	PredictionJobID string    `json:"prediction_job_id,omitempty"` // Photo sent for disease prediction
+	RecordedBy      int       `json:"recorded_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// MortalityTotals are the losses of a flock since placement
+type MortalityTotals struct {
+	Deaths int `json:"deaths"`
+	Culls  int `json:"culls"`
+}
+
+// Initialize mortality log table
+func InitMortalityDB(db *sql.DB) error {
+	createMortalityTable := `
+    CREATE TABLE IF NOT EXISTS mortality_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        flock_id INTEGER NOT NULL,
+        day TEXT NOT NULL,
+        deaths INTEGER NOT NULL DEFAULT 0,
+        culls INTEGER NOT NULL DEFAULT 0,
+        cause TEXT NOT NULL DEFAULT '',
+        notes TEXT NOT NULL DEFAULT '',
+        prediction_job_id TEXT NOT NULL DEFAULT '',
+        recorded_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (recorded_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_mortality_flock ON mortality_log(flock_id, day);`
+
+	_, err := db.Exec(createMortalityTable)
+	if err != nil {
+		log.Println("Error creating mortality log table:", err)
+		return err
+	}
+	return nil
+}
+
+// Store a mortality record and return its ID
+func CreateMortalityRecord(db *sql.DB, r MortalityRecord) (int, error) {
+	result, err := db.Exec(`
+    INSERT INTO mortality_log (flock_id, day, deaths, culls, cause, notes, prediction_job_id,
+        recorded_by, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.FlockID, r.Day, r.Deaths, r.Culls, r.Cause, r.Notes, r.PredictionJobID, r.RecordedBy,
+		time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Link the prediction job of a photo to a mortality record
+func SetMortalityPredictionJob(db *sql.DB, id int, jobID string) error {
+	return execOne(db, "UPDATE mortality_log SET prediction_job_id = ? WHERE id = ?", jobID, id)
+}
+
+// List the mortality records of a flock, oldest first
+func ListMortalityRecords(db *sql.DB, flockID int) ([]MortalityRecord, error) {
+	rows, err := db.Query(`
+    SELECT id, flock_id, day, deaths, culls, cause, notes, prediction_job_id, recorded_by, created_at
+    FROM mortality_log WHERE flock_id = ?
+    ORDER BY day, id`, flockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []MortalityRecord{}
+	for rows.Next() {
+		var r MortalityRecord
+		err := rows.Scan(&r.ID, &r.FlockID, &r.Day, &r.Deaths, &r.Culls, &r.Cause, &r.Notes,
+			&r.PredictionJobID, &r.RecordedBy, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Losses of a flock since placement
+func FlockMortality(db *sql.DB, flockID int) (MortalityTotals, error) {
+	var t MortalityTotals
+	err := db.QueryRow(`
+    SELECT COALESCE(SUM(deaths), 0), COALESCE(SUM(culls), 0) FROM mortality_log WHERE flock_id = ?`,
+		flockID).Scan(&t.Deaths, &t.Culls)
+	return t, err
+}
+
+// Delete a mortality record of a flock. Returns sql.ErrNoRows when it does
+// not exist.
+func DeleteMortalityRecord(db *sql.DB, flockID, id int) error {
+	result, err := db.Exec("DELETE FROM mortality_log WHERE id = ? AND flock_id = ?", id, flockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"middleware/analysis"
+)
+
+// StoredPrediction is a disease prediction kept for later review
+type StoredPrediction struct {
+	ID               int                  `json:"id"`
+	UserID           int                  `json:"user_id"`
+	Controller       string               `json:"controller"`
+	House            string               `json:"house"`
+	ImageHash        string               `json:"image_hash"`
+	ImagePath        string               `json:"-"`
+	ContentType      string               `json:"content_type"`
+	PredictedDisease string               `json:"predicted_disease"`
+	Confidence       float64              `json:"confidence"`
+	AllProbabilities map[string]float64   `json:"all_probabilities"`
+	IsHealthy        bool                 `json:"is_healthy"`
+	Recommendation   string               `json:"recommendation"`
+	ModelName        string               `json:"model_name"`
+	ModelVersion     string               `json:"model_version"` // Version of the model that made the prediction
+	Backend          string               `json:"backend"`       // Classifier backend the prediction was routed to
+	Outcome          string               `json:"outcome"`       // confident or uncertain
+	Assessment       *analysis.Assessment `json:"assessment,omitempty"`
+	FlockID          *int                 `json:"flock_id,omitempty"`
+	CreatedAt        time.Time            `json:"created_at"`
+}
+
+// PredictionFilter narrows a prediction history query. Zero values are ignored.
+type PredictionFilter struct {
+	UserID     int
+	Controller string
+	House      string
+	Disease    string
+	Model      string // Model version
+	Backend    string
+	Outcome    string
+	FlockID    int
+	Healthy    *bool
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// Initialize predictions table
+func InitPredictionDB(db *sql.DB) error {
+	createPredictionsTable := `
+    CREATE TABLE IF NOT EXISTS predictions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        controller TEXT NOT NULL DEFAULT '',
+        house TEXT NOT NULL DEFAULT '',
+        image_hash TEXT NOT NULL,
+        image_path TEXT NOT NULL,
+        content_type TEXT NOT NULL,
+        predicted_disease TEXT NOT NULL,
+        confidence REAL NOT NULL,
+        all_probabilities TEXT NOT NULL,
+        is_healthy INTEGER NOT NULL,
+        recommendation TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_predictions_created_at ON predictions(created_at);
+    CREATE INDEX IF NOT EXISTS idx_predictions_house ON predictions(house, created_at);`
+
+	_, err := db.Exec(createPredictionsTable)
+	if err != nil {
+		log.Println("Error creating predictions table:", err)
+		return err
+	}
+
+	// Predictions are tagged with the model that made them
+	if err = AddColumnIfMissing(db, "predictions", "model_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err = AddColumnIfMissing(db, "predictions", "model_version", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err = AddColumnIfMissing(db, "predictions", "backend", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Confidence assessment, predictions made before it count as confident
+	if err = AddColumnIfMissing(db, "predictions", "outcome", "TEXT NOT NULL DEFAULT 'confident'"); err != nil {
+		return err
+	}
+	return AddColumnIfMissing(db, "predictions", "assessment", "TEXT NOT NULL DEFAULT ''")
+}
+
+// Store a prediction and return its ID
+func SavePrediction(db *sql.DB, p StoredPrediction) (int, error) {
+	probabilities, err := json.Marshal(p.AllProbabilities)
+	if err != nil {
+		return 0, err
+	}
+	var assessment []byte
+	if p.Assessment != nil {
+		if assessment, err = json.Marshal(p.Assessment); err != nil {
+			return 0, err
+		}
+	}
+	if p.Outcome == "" {
+		p.Outcome = analysis.OutcomeConfident
+	}
+
+	result, err := db.Exec(`
+    INSERT INTO predictions (user_id, controller, house, image_hash, image_path, content_type,
+        predicted_disease, confidence, all_probabilities, is_healthy, recommendation, model_name,
+        model_version, backend, outcome, assessment, flock_id, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.UserID,
+		p.Controller,
+		p.House,
+		p.ImageHash,
+		p.ImagePath,
+		p.ContentType,
+		p.PredictedDisease,
+		p.Confidence,
+		string(probabilities),
+		p.IsHealthy,
+		p.Recommendation,
+		p.ModelName,
+		p.ModelVersion,
+		p.Backend,
+		p.Outcome,
+		string(assessment),
+		p.FlockID,
+		p.CreatedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+const predictionColumns = `id, user_id, controller, house, image_hash, image_path, content_type,
+        predicted_disease, confidence, all_probabilities, is_healthy, recommendation, model_name,
+        model_version, backend, outcome, assessment, flock_id, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPrediction(row rowScanner) (StoredPrediction, error) {
+	var p StoredPrediction
+	var probabilities, assessment string
+	var flockID sql.NullInt64
+	err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.Controller,
+		&p.House,
+		&p.ImageHash,
+		&p.ImagePath,
+		&p.ContentType,
+		&p.PredictedDisease,
+		&p.Confidence,
+		&probabilities,
+		&p.IsHealthy,
+		&p.Recommendation,
+		&p.ModelName,
+		&p.ModelVersion,
+		&p.Backend,
+		&p.Outcome,
+		&assessment,
+		&flockID,
+		&p.CreatedAt)
+	if err != nil {
+		return p, err
+	}
+	if err = json.Unmarshal([]byte(probabilities), &p.AllProbabilities); err != nil {
+		return p, err
+	}
+	if flockID.Valid {
+		id := int(flockID.Int64)
+		p.FlockID = &id
+	}
+	return p, decodeAssessment(&p, assessment)
+}
+
+// Predictions made before the assessment was stored have none
+func decodeAssessment(p *StoredPrediction, assessment string) error {
+	if assessment == "" {
+		return nil
+	}
+	p.Assessment = &analysis.Assessment{}
+	return json.Unmarshal([]byte(assessment), p.Assessment)
+}
+
+// Get a stored prediction by ID
+func GetPrediction(db *sql.DB, id int) (StoredPrediction, error) {
+	row := db.QueryRow("SELECT "+predictionColumns+" FROM predictions WHERE id = ?", id)
+	return scanPrediction(row)
+}
+
+// List stored predictions, newest first, with the total number of matches
+func ListPredictions(db *sql.DB, f PredictionFilter) ([]StoredPrediction, int, error) {
+	where, args := predictionWhere(f)
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM predictions"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	query := "SELECT " + predictionColumns + " FROM predictions" + where +
+		" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := db.Query(query, append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	predictions := []StoredPrediction{}
+	for rows.Next() {
+		p, err := scanPrediction(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		predictions = append(predictions, p)
+	}
+	return predictions, total, rows.Err()
+}
+
+func predictionWhere(f PredictionFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.UserID != 0 {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Controller != "" {
+		conditions = append(conditions, "controller = ?")
+		args = append(args, f.Controller)
+	}
+	if f.House != "" {
+		conditions = append(conditions, "house = ?")
+		args = append(args, f.House)
+	}
+	if f.Disease != "" {
+		conditions = append(conditions, "predicted_disease = ?")
+		args = append(args, f.Disease)
+	}
+	if f.Model != "" {
+		conditions = append(conditions, "model_version = ?")
+		args = append(args, f.Model)
+	}
+	if f.Backend != "" {
+		conditions = append(conditions, "backend = ?")
+		args = append(args, f.Backend)
+	}
+	if f.FlockID != 0 {
+		conditions = append(conditions, "flock_id = ?")
+		args = append(args, f.FlockID)
+	}
+	if f.Outcome != "" {
+		conditions = append(conditions, "outcome = ?")
+		args = append(args, f.Outcome)
+	}
+	if f.Healthy != nil {
+		conditions = append(conditions, "is_healthy = ?")
+		args = append(args, *f.Healthy)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// List every prediction made in [from, to), oldest first
+func PredictionsBetween(db *sql.DB, from, to time.Time) ([]StoredPrediction, error) {
+	rows, err := db.Query("SELECT "+predictionColumns+" FROM predictions"+
+		" WHERE created_at >= ? AND created_at < ? ORDER BY created_at", from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	predictions := []StoredPrediction{}
+	for rows.Next() {
+		p, err := scanPrediction(rows)
+		if err != nil {
+			return nil, err
+		}
+		predictions = append(predictions, p)
+	}
+	return predictions, rows.Err()
+}
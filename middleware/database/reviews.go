@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// PredictionReview is a veterinarian's verdict on a stored prediction. Label
+// is the ground truth class, which differs from the predicted disease when
+// the vet relabeled it.
+type PredictionReview struct {
+	ID           int       `json:"id"`
+	PredictionID int       `json:"prediction_id"`
+	ReviewerID   int       `json:"reviewer_id"`
+	Verdict      string    `json:"verdict"`
+	Label        string    `json:"label"`
+	Notes        string    `json:"notes"`
+	Treatment    string    `json:"treatment"`
+	ReviewedAt   time.Time `json:"reviewed_at"`
+}
+
+// Review verdicts
+const (
+	VerdictConfirmed = "confirmed"
+	VerdictRelabeled = "relabeled"
+)
+
+// LabeledPrediction is a reviewed prediction used as training data
+type LabeledPrediction struct {
+	Prediction StoredPrediction `json:"prediction"`
+	Review     PredictionReview `json:"review"`
+}
+
+// Initialize prediction reviews table
+func InitReviewDB(db *sql.DB) error {
+	createReviewsTable := `
+    CREATE TABLE IF NOT EXISTS prediction_reviews (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        prediction_id INTEGER UNIQUE NOT NULL,
+        reviewer_id INTEGER NOT NULL,
+        verdict TEXT NOT NULL,
+        label TEXT NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        treatment TEXT NOT NULL DEFAULT '',
+        reviewed_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (prediction_id) REFERENCES predictions(id),
+        FOREIGN KEY (reviewer_id) REFERENCES users(id)
+    );`
+
+	_, err := db.Exec(createReviewsTable)
+	if err != nil {
+		log.Println("Error creating prediction reviews table:", err)
+		return err
+	}
+	return nil
+}
+
+// Save a review, replacing an earlier review of the same prediction
+func UpsertReview(db *sql.DB, r PredictionReview) error {
+	query := `
+    INSERT INTO prediction_reviews (prediction_id, reviewer_id, verdict, label, notes, treatment, reviewed_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT(prediction_id) DO UPDATE SET
+        reviewer_id = excluded.reviewer_id,
+        verdict = excluded.verdict,
+        label = excluded.label,
+        notes = excluded.notes,
+        treatment = excluded.treatment,
+        reviewed_at = excluded.reviewed_at;`
+
+	_, err := db.Exec(query, r.PredictionID, r.ReviewerID, r.Verdict, r.Label, r.Notes, r.Treatment, r.ReviewedAt.UTC())
+	return err
+}
+
+// Get the review of a prediction, sql.ErrNoRows if it was not reviewed
+func GetReview(db *sql.DB, predictionID int) (PredictionReview, error) {
+	var r PredictionReview
+	err := db.QueryRow(`
+    SELECT id, prediction_id, reviewer_id, verdict, label, notes, treatment, reviewed_at
+    FROM prediction_reviews
+    WHERE prediction_id = ?`, predictionID).Scan(
+		&r.ID, &r.PredictionID, &r.ReviewerID, &r.Verdict, &r.Label, &r.Notes, &r.Treatment, &r.ReviewedAt)
+	return r, err
+}
+
+// List unreviewed predictions, least confident first, with the total count
+func ListUnreviewedPredictions(db *sql.DB, house string, limit, offset int) ([]StoredPrediction, int, error) {
+	where := ` FROM predictions p
+    WHERE NOT EXISTS (SELECT 1 FROM prediction_reviews r WHERE r.prediction_id = p.id)
+        AND (? = '' OR p.house = ?)`
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*)"+where, house, house).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT "+predictionColumns+where+
+		" ORDER BY p.confidence ASC, p.created_at DESC LIMIT ? OFFSET ?", house, house, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	predictions := []StoredPrediction{}
+	for rows.Next() {
+		p, err := scanPrediction(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		predictions = append(predictions, p)
+	}
+	return predictions, total, rows.Err()
+}
+
+// List reviewed predictions in [from, to) by review time, oldest first. Zero
+// times are not applied.
+func ListLabeledPredictions(db *sql.DB, from, to time.Time) ([]LabeledPrediction, error) {
+	query := `
+    SELECT p.id, p.user_id, p.controller, p.house, p.image_hash, p.image_path, p.content_type,
+        p.predicted_disease, p.confidence, p.all_probabilities, p.is_healthy, p.recommendation,
+        p.model_name, p.model_version, p.backend, p.outcome, p.assessment, p.created_at,
+        r.id, r.prediction_id, r.reviewer_id, r.verdict, r.label, r.notes, r.treatment, r.reviewed_at
+    FROM predictions p
+    JOIN prediction_reviews r ON r.prediction_id = p.id
+    WHERE 1 = 1`
+	var args []interface{}
+	if !from.IsZero() {
+		query += " AND r.reviewed_at >= ?"
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		query += " AND r.reviewed_at < ?"
+		args = append(args, to.UTC())
+	}
+	query += " ORDER BY r.reviewed_at"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labeled := []LabeledPrediction{}
+	for rows.Next() {
+		var l LabeledPrediction
+		var probabilities, assessment string
+		p, r := &l.Prediction, &l.Review
+		err := rows.Scan(
+			&p.ID, &p.UserID, &p.Controller, &p.House, &p.ImageHash, &p.ImagePath, &p.ContentType,
+			&p.PredictedDisease, &p.Confidence, &probabilities, &p.IsHealthy, &p.Recommendation,
+			&p.ModelName, &p.ModelVersion, &p.Backend, &p.Outcome, &assessment, &p.CreatedAt,
+			&r.ID, &r.PredictionID, &r.ReviewerID, &r.Verdict, &r.Label, &r.Notes, &r.Treatment, &r.ReviewedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(probabilities), &p.AllProbabilities); err != nil {
+			return nil, err
+		}
+		if err := decodeAssessment(p, assessment); err != nil {
+			return nil, err
+		}
+		labeled = append(labeled, l)
+	}
+	return labeled, rows.Err()
+}
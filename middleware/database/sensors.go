@@ -0,0 +1,285 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// SensorType is a kind of sensor and the units its readings can be in
+type SensorType struct {
+	Key   string   `json:"key"`
+	Name  string   `json:"name"`
+	Units []string `json:"units"` // The first is the default
+}
+
+// Sensor types the registry knows
+var SensorTypes = []SensorType{
+	{Key: "temperature", Name: "Temperature", Units: []string{"°C", "°F"}},
+	{Key: "humidity", Name: "Relative humidity", Units: []string{"%"}},
+	{Key: "water_level", Name: "Water level", Units: []string{"%", "cm"}},
+	{Key: "ammonia", Name: "Ammonia (NH3)", Units: []string{"ppm"}},
+	{Key: "co2", Name: "Carbon dioxide (CO2)", Units: []string{"ppm"}},
+	{Key: "light", Name: "Light", Units: []string{"lux"}},
+}
+
+// Look up a sensor type by key
+func GetSensorType(key string) (SensorType, bool) {
+	for _, t := range SensorTypes {
+		if t.Key == key {
+			return t, true
+		}
+	}
+	return SensorType{}, false
+}
+
+// Sensor is a registered sensor of a house. Readings are keyed by its ID,
+// which is chosen when the hardware is installed.
+type Sensor struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Unit          string     `json:"unit"`
+	HouseID       int        `json:"house_id"`
+	House         string     `json:"house"` // Derived
+	ZoneID        *int       `json:"zone_id"`
+	Zone          string     `json:"zone"`       // Derived
+	Controller    string     `json:"controller"` // Controller that polls or pushes the reading, if any
+	Field         string     `json:"field"`      // Dotted path of the reading in the controller's data
+	Active        bool       `json:"active"`
+	LastValue     *float64   `json:"last_value"`
+	LastReadingAt *time.Time `json:"last_reading_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// SensorReading is a value recorded by a sensor
+type SensorReading struct {
+	ID         int       `json:"id"`
+	SensorID   string    `json:"sensor_id"`
+	Value      float64   `json:"value"`
+	RecordedAt time.Time `json:"recorded_at"`
+	FlockID    *int      `json:"flock_id,omitempty"`
+}
+
+// SensorFilter narrows a sensor query. Zero values are ignored.
+type SensorFilter struct {
+	HouseID    int
+	ZoneID     int
+	Type       string
+	Controller string
+	ActiveOnly bool
+}
+
+// Initialize sensor and reading tables
+func InitSensorDB(db *sql.DB) error {
+	createSensorTables := `
+    CREATE TABLE IF NOT EXISTS sensors (
+        id TEXT PRIMARY KEY,
+        name TEXT NOT NULL DEFAULT '',
+        type TEXT NOT NULL,
+        unit TEXT NOT NULL,
+        house_id INTEGER NOT NULL,
+        zone_id INTEGER,
+        controller TEXT NOT NULL DEFAULT '',
+        field TEXT NOT NULL DEFAULT '',
+        active BOOLEAN NOT NULL DEFAULT 1,
+        last_value REAL,
+        last_reading_at TIMESTAMP,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (house_id) REFERENCES houses(id),
+        FOREIGN KEY (zone_id) REFERENCES zones(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_sensors_controller ON sensors(controller);
+    CREATE TABLE IF NOT EXISTS sensor_readings (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sensor_id TEXT NOT NULL,
+        value REAL NOT NULL,
+        recorded_at TIMESTAMP NOT NULL,
+        flock_id INTEGER,
+        FOREIGN KEY (sensor_id) REFERENCES sensors(id),
+        FOREIGN KEY (flock_id) REFERENCES flocks(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_sensor_readings_sensor ON sensor_readings(sensor_id, recorded_at);`
+
+	_, err := db.Exec(createSensorTables)
+	if err != nil {
+		log.Println("Error creating sensor tables:", err)
+		return err
+	}
+	return nil
+}
+
+// ====== SENSORS ====== //
+
+const sensorColumns = `s.id, s.name, s.type, s.unit, s.house_id, COALESCE(h.name, ''), s.zone_id, COALESCE(z.name, ''),
+        s.controller, s.field, s.active, s.last_value, s.last_reading_at, s.created_at, s.updated_at`
+
+const sensorFrom = ` FROM sensors s
+    LEFT JOIN houses h ON h.id = s.house_id
+    LEFT JOIN zones z ON z.id = s.zone_id`
+
+func scanSensor(row rowScanner) (Sensor, error) {
+	var s Sensor
+	var zoneID sql.NullInt64
+	var lastValue sql.NullFloat64
+	var lastReadingAt sql.NullTime
+	err := row.Scan(&s.ID, &s.Name, &s.Type, &s.Unit, &s.HouseID, &s.House, &zoneID, &s.Zone,
+		&s.Controller, &s.Field, &s.Active, &lastValue, &lastReadingAt, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+	if zoneID.Valid {
+		id := int(zoneID.Int64)
+		s.ZoneID = &id
+	}
+	if lastValue.Valid {
+		s.LastValue = &lastValue.Float64
+	}
+	if lastReadingAt.Valid {
+		s.LastReadingAt = &lastReadingAt.Time
+	}
+	return s, nil
+}
+
+// Register a sensor
+func CreateSensor(db *sql.DB, s Sensor) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+    INSERT INTO sensors (id, name, type, unit, house_id, zone_id, controller, field, active, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.ID, s.Name, s.Type, s.Unit, s.HouseID, s.ZoneID, s.Controller, s.Field, s.Active, now, now)
+	return err
+}
+
+// Get a sensor by ID
+func GetSensor(db *sql.DB, id string) (Sensor, error) {
+	return scanSensor(db.QueryRow("SELECT "+sensorColumns+sensorFrom+" WHERE s.id = ?", id))
+}
+
+// List sensors by house, zone and ID
+func ListSensors(db *sql.DB, filter SensorFilter) ([]Sensor, error) {
+	query := "SELECT " + sensorColumns + sensorFrom + " WHERE 1 = 1"
+	var args []interface{}
+	if filter.HouseID != 0 {
+		query += " AND s.house_id = ?"
+		args = append(args, filter.HouseID)
+	}
+	if filter.ZoneID != 0 {
+		query += " AND s.zone_id = ?"
+		args = append(args, filter.ZoneID)
+	}
+	if filter.Type != "" {
+		query += " AND s.type = ?"
+		args = append(args, filter.Type)
+	}
+	if filter.Controller != "" {
+		query += " AND s.controller = ?"
+		args = append(args, filter.Controller)
+	}
+	if filter.ActiveOnly {
+		query += " AND s.active = 1"
+	}
+	query += " ORDER BY h.name, z.name, s.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sensors := []Sensor{}
+	for rows.Next() {
+		s, err := scanSensor(rows)
+		if err != nil {
+			return nil, err
+		}
+		sensors = append(sensors, s)
+	}
+	return sensors, rows.Err()
+}
+
+// Update the editable fields of a sensor
+func UpdateSensor(db *sql.DB, s Sensor) error {
+	return execOne(db, `
+    UPDATE sensors SET name = ?, type = ?, unit = ?, house_id = ?, zone_id = ?, controller = ?, field = ?,
+        active = ?, updated_at = ?
+    WHERE id = ?`,
+		s.Name, s.Type, s.Unit, s.HouseID, s.ZoneID, s.Controller, s.Field, s.Active, time.Now().UTC(), s.ID)
+}
+
+// Delete a sensor with its readings
+func DeleteSensor(db *sql.DB, id string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM sensor_readings WHERE sensor_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM sensors WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ====== READINGS ====== //
+
+// Store readings together and keep the latest value of each sensor
+func SaveSensorReadings(db *sql.DB, readings []SensorReading) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range readings {
+		_, err := tx.Exec("INSERT INTO sensor_readings (sensor_id, value, recorded_at, flock_id) VALUES (?, ?, ?, ?)",
+			r.SensorID, r.Value, r.RecordedAt.UTC(), r.FlockID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+        UPDATE sensors SET last_value = ?, last_reading_at = ?
+        WHERE id = ? AND (last_reading_at IS NULL OR last_reading_at <= ?)`,
+			r.Value, r.RecordedAt.UTC(), r.SensorID, r.RecordedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List the readings of a sensor in [from, to), oldest first
+func ListSensorReadings(db *sql.DB, sensorID string, from, to time.Time) ([]SensorReading, error) {
+	rows, err := db.Query(`
+    SELECT id, sensor_id, value, recorded_at, flock_id FROM sensor_readings
+    WHERE sensor_id = ? AND recorded_at >= ? AND recorded_at < ?
+    ORDER BY recorded_at`, sensorID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readings := []SensorReading{}
+	for rows.Next() {
+		var r SensorReading
+		var flockID sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.SensorID, &r.Value, &r.RecordedAt, &flockID); err != nil {
+			return nil, err
+		}
+		if flockID.Valid {
+			id := int(flockID.Int64)
+			r.FlockID = &id
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
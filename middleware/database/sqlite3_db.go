@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	// "strings"
@@ -16,6 +17,19 @@ type User struct {
 	PhoneNumber string `json:"phone_number"`
 	Gender      string `json:"gender"`
 	Province    string `json:"province"`
+	Role        string `json:"role"`
+}
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleVet   = "vet"
+	RoleAdmin = "admin"
+)
+
+// Check whether a role name is known
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleVet || role == RoleAdmin
 }
 
 type UserProfile struct {
@@ -47,7 +61,8 @@ type UserProfile struct {
 func InitDB() *sql.DB {
 	var db *sql.DB // Database connection
 	var err error
-	db, err = sql.Open("sqlite", "users.db") // Changed from "sqlite3" to "sqlite"
+	// Wait for locks instead of failing, background jobs write concurrently
+	db, err = sql.Open("sqlite", "users.db?_pragma=busy_timeout(5000)") // Changed from "sqlite3" to "sqlite"
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -68,9 +83,19 @@ func InitDB() *sql.DB {
 		log.Fatal("Error creating users table:", err)
 	}
 
+	// Add columns introduced after the first release
+	if err = AddColumnIfMissing(db, "users", "role", "TEXT NOT NULL DEFAULT 'user'"); err != nil {
+		log.Fatal("Error migrating users table:", err)
+	}
+
 	// Initialize profiles table
 	InitProfileDB(db)
 
+	// Initialize controller trust tables
+	if err = InitControllerDB(db); err != nil {
+		log.Fatal("Error creating controller tables:", err)
+	}
+
 	// Create Schedules table
 	/* createSchedulesTable := `
 	   CREATE TABLE IF NOT EXISTS schedules (
@@ -90,10 +115,140 @@ func InitDB() *sql.DB {
 	       log.Fatal("Error creating schedules table:", err)
 	   } */
 
+	// Initialize the disease knowledge base
+	if err = InitDiseaseDB(db); err != nil {
+		log.Fatal("Error creating disease tables:", err)
+	}
+
+	// Initialize disease prediction history
+	if err = InitPredictionDB(db); err != nil {
+		log.Fatal("Error creating predictions table:", err)
+	}
+
+	// Initialize the AI model versions
+	if err = InitAIModelDB(db); err != nil {
+		log.Fatal("Error creating AI models table:", err)
+	}
+
+	// Initialize the prediction job queue
+	if err = InitJobDB(db); err != nil {
+		log.Fatal("Error creating prediction jobs table:", err)
+	}
+
+	// Initialize veterinarian reviews of predictions
+	if err = InitReviewDB(db); err != nil {
+		log.Fatal("Error creating reviews table:", err)
+	}
+
+	// Initialize telemetry history and analysis findings
+	if err = InitTelemetryDB(db); err != nil {
+		log.Fatal("Error creating telemetry table:", err)
+	}
+	if err = InitFindingDB(db); err != nil {
+		log.Fatal("Error creating findings table:", err)
+	}
+
+	// Initialize flocks, after the tables that link to them
+	if err = InitFlockDB(db); err != nil {
+		log.Fatal("Error creating flocks table:", err)
+	}
+	if err = InitMortalityDB(db); err != nil {
+		log.Fatal("Error creating mortality log table:", err)
+	}
+	if err = InitFeedDB(db); err != nil {
+		log.Fatal("Error creating feed tables:", err)
+	}
+	if err = InitWeightDB(db); err != nil {
+		log.Fatal("Error creating weight samples table:", err)
+	}
+	if err = InitBreedDB(db); err != nil {
+		log.Fatal("Error creating breed standards table:", err)
+	}
+	if err = InitEggDB(db); err != nil {
+		log.Fatal("Error creating egg collections table:", err)
+	}
+	if err = InitTreatmentDB(db); err != nil {
+		log.Fatal("Error creating treatment tables:", err)
+	}
+
+	// Initialize farm tasks
+	if err = InitTaskDB(db); err != nil {
+		log.Fatal("Error creating task tables:", err)
+	}
+
+	// Initialize the farm layout, the sensor registry and the actuator registry
+	if err = InitFarmDB(db); err != nil {
+		log.Fatal("Error creating farm tables:", err)
+	}
+	if err = InitSensorDB(db); err != nil {
+		log.Fatal("Error creating sensor tables:", err)
+	}
+	if err = InitActuatorDB(db); err != nil {
+		log.Fatal("Error creating actuator table:", err)
+	}
+
 	log.Println("Database initialized successfully")
 	return db
 }
 
+// Add a column to an existing table unless it is already there
+func AddColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Get the ID of a user by username
+func GetUserID(db *sql.DB, username string) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&id)
+	return id, err
+}
+
+// Get the role of a user by username
+func GetUserRole(db *sql.DB, username string) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE username = ?", username).Scan(&role)
+	return role, err
+}
+
+// Set the role of a user by username
+func SetUserRole(db *sql.DB, username, role string) error {
+	result, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // Initialize profiles table
 func InitProfileDB(db *sql.DB) error {
 	createProfilesTable := `
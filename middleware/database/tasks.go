@@ -0,0 +1,270 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Task is a farm chore assigned to a worker, done once or repeated by a
+// recurrence rule
+type Task struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	House       string    `json:"house"`
+	FlockID     *int      `json:"flock_id,omitempty"`
+	AssigneeID  *int      `json:"assignee_id"`
+	Assignee    string    `json:"assignee"` // Username, derived
+	DueAt       time.Time `json:"due_at"`   // First occurrence
+	RRule       string    `json:"rrule"`    // Empty for a one-off task
+	Active      bool      `json:"active"`
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TaskCompletion records who did an occurrence of a task
+type TaskCompletion struct {
+	ID              int       `json:"id"`
+	TaskID          int       `json:"task_id"`
+	DueAt           time.Time `json:"due_at"` // Occurrence completed
+	CompletedBy     int       `json:"completed_by"`
+	CompletedByName string    `json:"completed_by_name"` // Username, derived
+	CompletedAt     time.Time `json:"completed_at"`
+	Notes           string    `json:"notes"`
+	PhotoPath       string    `json:"-"`
+	PhotoType       string    `json:"-"`
+}
+
+// TaskFilter narrows a task query. Zero values are ignored.
+type TaskFilter struct {
+	AssigneeID int
+	House      string
+	ActiveOnly bool
+}
+
+// Initialize task tables
+func InitTaskDB(db *sql.DB) error {
+	createTaskTables := `
+    CREATE TABLE IF NOT EXISTS farm_tasks (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        title TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        house TEXT NOT NULL DEFAULT '',
+        flock_id INTEGER,
+        assignee_id INTEGER,
+        due_at TIMESTAMP NOT NULL,
+        rrule TEXT NOT NULL DEFAULT '',
+        active BOOLEAN NOT NULL DEFAULT 1,
+        created_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (assignee_id) REFERENCES users(id),
+        FOREIGN KEY (created_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_farm_tasks_assignee ON farm_tasks(assignee_id, active);
+    CREATE TABLE IF NOT EXISTS task_completions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        task_id INTEGER NOT NULL,
+        due_at TIMESTAMP NOT NULL,
+        completed_by INTEGER NOT NULL,
+        completed_at TIMESTAMP NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        photo_path TEXT NOT NULL DEFAULT '',
+        photo_type TEXT NOT NULL DEFAULT '',
+        FOREIGN KEY (task_id) REFERENCES farm_tasks(id),
+        FOREIGN KEY (completed_by) REFERENCES users(id),
+        UNIQUE (task_id, due_at)
+    );
+    CREATE INDEX IF NOT EXISTS idx_task_completions_completed ON task_completions(completed_at);`
+
+	_, err := db.Exec(createTaskTables)
+	if err != nil {
+		log.Println("Error creating task tables:", err)
+		return err
+	}
+	return nil
+}
+
+// ====== TASKS ====== //
+
+const taskColumns = `t.id, t.title, t.description, t.house, t.flock_id, t.assignee_id, COALESCE(u.username, ''),
+        t.due_at, t.rrule, t.active, t.created_by, t.created_at, t.updated_at`
+
+const taskFrom = " FROM farm_tasks t LEFT JOIN users u ON u.id = t.assignee_id"
+
+func scanTask(row rowScanner) (Task, error) {
+	var t Task
+	var flockID, assigneeID sql.NullInt64
+	err := row.Scan(&t.ID, &t.Title, &t.Description, &t.House, &flockID, &assigneeID, &t.Assignee,
+		&t.DueAt, &t.RRule, &t.Active, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return t, err
+	}
+	if flockID.Valid {
+		id := int(flockID.Int64)
+		t.FlockID = &id
+	}
+	if assigneeID.Valid {
+		id := int(assigneeID.Int64)
+		t.AssigneeID = &id
+	}
+	return t, nil
+}
+
+// Create a task and return its ID
+func CreateTask(db *sql.DB, t Task) (int, error) {
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+    INSERT INTO farm_tasks (title, description, house, flock_id, assignee_id, due_at, rrule, active,
+        created_by, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Title, t.Description, t.House, t.FlockID, t.AssigneeID, t.DueAt.UTC(), t.RRule, t.Active,
+		t.CreatedBy, now, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a task by ID
+func GetTask(db *sql.DB, id int) (Task, error) {
+	return scanTask(db.QueryRow("SELECT "+taskColumns+taskFrom+" WHERE t.id = ?", id))
+}
+
+// List tasks by first due time
+func ListTasks(db *sql.DB, filter TaskFilter) ([]Task, error) {
+	query := "SELECT " + taskColumns + taskFrom + " WHERE 1 = 1"
+	var args []interface{}
+	if filter.AssigneeID != 0 {
+		query += " AND t.assignee_id = ?"
+		args = append(args, filter.AssigneeID)
+	}
+	if filter.House != "" {
+		query += " AND t.house = ?"
+		args = append(args, filter.House)
+	}
+	if filter.ActiveOnly {
+		query += " AND t.active = 1"
+	}
+	query += " ORDER BY t.due_at, t.id"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// Update the editable fields of a task
+func UpdateTask(db *sql.DB, t Task) error {
+	result, err := db.Exec(`
+    UPDATE farm_tasks SET title = ?, description = ?, house = ?, flock_id = ?, assignee_id = ?, due_at = ?,
+        rrule = ?, active = ?, updated_at = ?
+    WHERE id = ?`,
+		t.Title, t.Description, t.House, t.FlockID, t.AssigneeID, t.DueAt.UTC(), t.RRule, t.Active,
+		time.Now().UTC(), t.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a task with its completions
+func DeleteTask(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM task_completions WHERE task_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM farm_tasks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ====== COMPLETIONS ====== //
+
+const completionColumns = `c.id, c.task_id, c.due_at, c.completed_by, COALESCE(u.username, ''), c.completed_at,
+        c.notes, c.photo_path, c.photo_type`
+
+const completionFrom = " FROM task_completions c LEFT JOIN users u ON u.id = c.completed_by"
+
+func scanCompletion(row rowScanner) (TaskCompletion, error) {
+	var c TaskCompletion
+	err := row.Scan(&c.ID, &c.TaskID, &c.DueAt, &c.CompletedBy, &c.CompletedByName, &c.CompletedAt,
+		&c.Notes, &c.PhotoPath, &c.PhotoType)
+	return c, err
+}
+
+// Store the completion of an occurrence and return its ID
+func CreateTaskCompletion(db *sql.DB, c TaskCompletion) (int, error) {
+	result, err := db.Exec(`
+    INSERT INTO task_completions (task_id, due_at, completed_by, completed_at, notes, photo_path, photo_type)
+    VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.TaskID, c.DueAt.UTC(), c.CompletedBy, c.CompletedAt.UTC(), c.Notes, c.PhotoPath, c.PhotoType)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// Get a completion by ID
+func GetTaskCompletion(db *sql.DB, id int) (TaskCompletion, error) {
+	return scanCompletion(db.QueryRow("SELECT "+completionColumns+completionFrom+" WHERE c.id = ?", id))
+}
+
+// Completions of a task for occurrences due in [from, to)
+func ListTaskCompletions(db *sql.DB, taskID int, from, to time.Time) ([]TaskCompletion, error) {
+	return listCompletions(db, "c.task_id = ? AND c.due_at >= ? AND c.due_at < ? ORDER BY c.due_at",
+		taskID, from.UTC(), to.UTC())
+}
+
+// Completions made in [from, to) by any user
+func ListCompletionsMade(db *sql.DB, from, to time.Time) ([]TaskCompletion, error) {
+	return listCompletions(db, "c.completed_at >= ? AND c.completed_at < ? ORDER BY c.completed_at",
+		from.UTC(), to.UTC())
+}
+
+func listCompletions(db *sql.DB, where string, args ...interface{}) ([]TaskCompletion, error) {
+	rows, err := db.Query("SELECT "+completionColumns+completionFrom+" WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	completions := []TaskCompletion{}
+	for rows.Next() {
+		c, err := scanCompletion(rows)
+		if err != nil {
+			return nil, err
+		}
+		completions = append(completions, c)
+	}
+	return completions, rows.Err()
+}
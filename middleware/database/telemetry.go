@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// TelemetryReading is a sensor reading recorded from a controller
+type TelemetryReading struct {
+	ID          int       `json:"id"`
+	Controller  string    `json:"controller"`
+	Temperature float64   `json:"temperature"`
+	Humidity    float64   `json:"humidity"`
+	RecordedAt  time.Time `json:"recorded_at"`
+	FlockID     *int      `json:"flock_id,omitempty"`
+}
+
+// Initialize telemetry table
+func InitTelemetryDB(db *sql.DB) error {
+	createTelemetryTable := `
+    CREATE TABLE IF NOT EXISTS telemetry (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        controller TEXT NOT NULL,
+        temperature REAL NOT NULL,
+        humidity REAL NOT NULL,
+        recorded_at TIMESTAMP NOT NULL
+    );
+    CREATE INDEX IF NOT EXISTS idx_telemetry_controller ON telemetry(controller, recorded_at);`
+
+	_, err := db.Exec(createTelemetryTable)
+	if err != nil {
+		log.Println("Error creating telemetry table:", err)
+		return err
+	}
+	return nil
+}
+
+// Store a telemetry reading
+func SaveTelemetry(db *sql.DB, r TelemetryReading) error {
+	_, err := db.Exec(`
+    INSERT INTO telemetry (controller, temperature, humidity, recorded_at, flock_id)
+    VALUES (?, ?, ?, ?, ?)`,
+		r.Controller, r.Temperature, r.Humidity, r.RecordedAt.UTC(), r.FlockID)
+	return err
+}
+
+// List the readings of a controller in [from, to), oldest first
+func ListTelemetry(db *sql.DB, controller string, from, to time.Time) ([]TelemetryReading, error) {
+	rows, err := db.Query(`
+    SELECT id, controller, temperature, humidity, recorded_at
+    FROM telemetry
+    WHERE controller = ? AND recorded_at >= ? AND recorded_at < ?
+    ORDER BY recorded_at`, controller, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readings := []TelemetryReading{}
+	for rows.Next() {
+		var r TelemetryReading
+		if err := rows.Scan(&r.ID, &r.Controller, &r.Temperature, &r.Humidity, &r.RecordedAt); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
+
+// List the readings linked to a flock in [from, to), oldest first
+func ListFlockTelemetry(db *sql.DB, flockID int, from, to time.Time) ([]TelemetryReading, error) {
+	rows, err := db.Query(`
+    SELECT id, controller, temperature, humidity, recorded_at
+    FROM telemetry
+    WHERE flock_id = ? AND recorded_at >= ? AND recorded_at < ?
+    ORDER BY recorded_at`, flockID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	readings := []TelemetryReading{}
+	for rows.Next() {
+		r := TelemetryReading{FlockID: &flockID}
+		if err := rows.Scan(&r.ID, &r.Controller, &r.Temperature, &r.Humidity, &r.RecordedAt); err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
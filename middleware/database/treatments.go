@@ -0,0 +1,378 @@
+package database
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// ProgramStep is a vaccine or medication given at an age of the birds
+type ProgramStep struct {
+	AgeDays        int    `json:"age_days"`
+	Kind           string `json:"kind"` // vaccine or medication
+	Product        string `json:"product"`
+	Disease        string `json:"disease,omitempty"` // Disease key it prevents or treats
+	Route          string `json:"route"`             // e.g. eye_drop, drinking_water, injection
+	Dose           string `json:"dose,omitempty"`
+	WithdrawalDays int    `json:"withdrawal_days"` // Days before the birds can be sold
+	Notes          string `json:"notes,omitempty"`
+}
+
+// HealthProgram is a vaccination or medication template keyed by bird age
+type HealthProgram struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Steps       []ProgramStep `json:"steps"`
+	CreatedBy   *int          `json:"created_by"` // Nil for seeded programs
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// Treatment is a dose due or given to a flock
+type Treatment struct {
+	ID        int  `json:"id"`
+	FlockID   int  `json:"flock_id"`
+	ProgramID *int `json:"program_id,omitempty"`
+	ProgramStep
+	DueDay         string     `json:"due_day"` // YYYY-MM-DD
+	Status         string     `json:"status"`
+	AdministeredAt *time.Time `json:"administered_at,omitempty"`
+	AdministeredBy *int       `json:"administered_by,omitempty"`
+	BatchNumber    string     `json:"batch_number"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Treatment kinds
+const (
+	TreatmentVaccine    = "vaccine"
+	TreatmentMedication = "medication"
+)
+
+// Treatment statuses
+const (
+	TreatmentPending = "pending"
+	TreatmentDone    = "done"
+	TreatmentSkipped = "skipped"
+)
+
+func ValidTreatmentKind(kind string) bool {
+	return kind == TreatmentVaccine || kind == TreatmentMedication
+}
+
+// Last day of the withdrawal period of a given dose, nil when the birds can
+// be sold
+func (t Treatment) WithdrawalUntil() *time.Time {
+	if t.Status != TreatmentDone || t.AdministeredAt == nil || t.WithdrawalDays <= 0 {
+		return nil
+	}
+	y, m, d := t.AdministeredAt.Local().Date()
+	until := time.Date(y, m, d+t.WithdrawalDays, 0, 0, 0, 0, time.Local)
+	return &until
+}
+
+// Programs the templates start with
+//
+//go:embed treatments_seed.json
+var programSeed []byte
+
+// Initialize health program and treatment tables and seed the programs on
+// first run
+func InitTreatmentDB(db *sql.DB) error {
+	createTreatmentTables := `
+    CREATE TABLE IF NOT EXISTS health_programs (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',
+        steps TEXT NOT NULL,
+        created_by INTEGER,
+        created_at TIMESTAMP NOT NULL,
+        updated_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (created_by) REFERENCES users(id)
+    );
+    CREATE TABLE IF NOT EXISTS flock_treatments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        flock_id INTEGER NOT NULL,
+        program_id INTEGER,
+        age_days INTEGER NOT NULL DEFAULT 0,
+        kind TEXT NOT NULL,
+        product TEXT NOT NULL,
+        disease TEXT NOT NULL DEFAULT '',
+        route TEXT NOT NULL DEFAULT '',
+        dose TEXT NOT NULL DEFAULT '',
+        withdrawal_days INTEGER NOT NULL DEFAULT 0,
+        notes TEXT NOT NULL DEFAULT '',
+        due_day TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        administered_at TIMESTAMP,
+        administered_by INTEGER,
+        batch_number TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (program_id) REFERENCES health_programs(id),
+        FOREIGN KEY (administered_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_flock_treatments_flock ON flock_treatments(flock_id, due_day);
+    CREATE INDEX IF NOT EXISTS idx_flock_treatments_due ON flock_treatments(status, due_day);`
+
+	_, err := db.Exec(createTreatmentTables)
+	if err != nil {
+		log.Println("Error creating treatment tables:", err)
+		return err
+	}
+
+	// Only seed an empty table, programs deleted by a vet stay deleted
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM health_programs").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	var seed []HealthProgram
+	if err := json.Unmarshal(programSeed, &seed); err != nil {
+		return err
+	}
+	for _, p := range seed {
+		if _, err := CreateHealthProgram(db, p); err != nil {
+			log.Println("Error seeding health program:", err)
+			return err
+		}
+	}
+	log.Printf("Seeded %d health programs", len(seed))
+	return nil
+}
+
+// ====== PROGRAMS ====== //
+
+const programColumns = "id, name, description, steps, created_by, created_at, updated_at"
+
+func scanHealthProgram(row rowScanner) (HealthProgram, error) {
+	var p HealthProgram
+	var steps string
+	var createdBy sql.NullInt64
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &steps, &createdBy, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return p, err
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		p.CreatedBy = &id
+	}
+	err := json.Unmarshal([]byte(steps), &p.Steps)
+	return p, err
+}
+
+// Create a health program and return its ID
+func CreateHealthProgram(db *sql.DB, p HealthProgram) (int, error) {
+	steps, err := json.Marshal(p.Steps)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	result, err := db.Exec(`
+    INSERT INTO health_programs (name, description, steps, created_by, created_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?)`,
+		p.Name, p.Description, string(steps), p.CreatedBy, now, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// List health programs by name
+func ListHealthPrograms(db *sql.DB) ([]HealthProgram, error) {
+	rows, err := db.Query("SELECT " + programColumns + " FROM health_programs ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	programs := []HealthProgram{}
+	for rows.Next() {
+		p, err := scanHealthProgram(rows)
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, p)
+	}
+	return programs, rows.Err()
+}
+
+// Get a health program by ID
+func GetHealthProgram(db *sql.DB, id int) (HealthProgram, error) {
+	return scanHealthProgram(db.QueryRow("SELECT "+programColumns+" FROM health_programs WHERE id = ?", id))
+}
+
+// Update a health program. Treatments already generated from it are kept.
+func UpdateHealthProgram(db *sql.DB, p HealthProgram) error {
+	steps, err := json.Marshal(p.Steps)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(`
+    UPDATE health_programs SET name = ?, description = ?, steps = ?, updated_at = ? WHERE id = ?`,
+		p.Name, p.Description, string(steps), time.Now().UTC(), p.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a health program, the treatments generated from it are unlinked
+func DeleteHealthProgram(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE flock_treatments SET program_id = NULL WHERE program_id = ?", id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM health_programs WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ====== TREATMENTS ====== //
+
+const treatmentColumns = `id, flock_id, program_id, age_days, kind, product, disease, route, dose, withdrawal_days, notes,
+        due_day, status, administered_at, administered_by, batch_number, created_at`
+
+func scanTreatment(row rowScanner) (Treatment, error) {
+	var t Treatment
+	var programID, administeredBy sql.NullInt64
+	var administeredAt sql.NullTime
+	err := row.Scan(&t.ID, &t.FlockID, &programID, &t.AgeDays, &t.Kind, &t.Product, &t.Disease, &t.Route,
+		&t.Dose, &t.WithdrawalDays, &t.Notes, &t.DueDay, &t.Status, &administeredAt, &administeredBy,
+		&t.BatchNumber, &t.CreatedAt)
+	if err != nil {
+		return t, err
+	}
+	if programID.Valid {
+		id := int(programID.Int64)
+		t.ProgramID = &id
+	}
+	if administeredBy.Valid {
+		id := int(administeredBy.Int64)
+		t.AdministeredBy = &id
+	}
+	if administeredAt.Valid {
+		t.AdministeredAt = &administeredAt.Time
+	}
+	return t, nil
+}
+
+// Store treatments together and return them with their IDs
+func CreateTreatments(db *sql.DB, treatments []Treatment) ([]Treatment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for i, t := range treatments {
+		result, err := tx.Exec(`
+        INSERT INTO flock_treatments (flock_id, program_id, age_days, kind, product, disease, route, dose,
+            withdrawal_days, notes, due_day, status, administered_at, administered_by, batch_number, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.FlockID, t.ProgramID, t.AgeDays, t.Kind, t.Product, t.Disease, t.Route, t.Dose, t.WithdrawalDays,
+			t.Notes, t.DueDay, t.Status, nullTime(t.AdministeredAt), t.AdministeredBy, t.BatchNumber, now)
+		if err != nil {
+			return nil, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		treatments[i].ID = int(id)
+		treatments[i].CreatedAt = now
+	}
+	return treatments, tx.Commit()
+}
+
+// List the treatments of a flock by due day
+func ListTreatments(db *sql.DB, flockID int) ([]Treatment, error) {
+	return listTreatments(db, "flock_id = ? ORDER BY due_day, id", flockID)
+}
+
+// Pending treatments of active flocks due on or before a day
+func DueTreatments(db *sql.DB, untilDay string) ([]Treatment, error) {
+	return listTreatments(db, `status = ? AND due_day <= ?
+        AND flock_id IN (SELECT id FROM flocks WHERE closed_at IS NULL)
+    ORDER BY due_day, id`, TreatmentPending, untilDay)
+}
+
+func listTreatments(db *sql.DB, where string, args ...interface{}) ([]Treatment, error) {
+	rows, err := db.Query("SELECT "+treatmentColumns+" FROM flock_treatments WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	treatments := []Treatment{}
+	for rows.Next() {
+		t, err := scanTreatment(rows)
+		if err != nil {
+			return nil, err
+		}
+		treatments = append(treatments, t)
+	}
+	return treatments, rows.Err()
+}
+
+// Get a treatment of a flock
+func GetTreatment(db *sql.DB, flockID, id int) (Treatment, error) {
+	return scanTreatment(db.QueryRow("SELECT "+treatmentColumns+" FROM flock_treatments WHERE id = ? AND flock_id = ?",
+		id, flockID))
+}
+
+// Whether a program was already applied to a flock
+func ProgramApplied(db *sql.DB, flockID, programID int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM flock_treatments WHERE flock_id = ? AND program_id = ?",
+		flockID, programID).Scan(&count)
+	return count > 0, err
+}
+
+// Record the outcome of a treatment
+func UpdateTreatmentStatus(db *sql.DB, t Treatment) error {
+	result, err := db.Exec(`
+    UPDATE flock_treatments SET status = ?, administered_at = ?, administered_by = ?, batch_number = ?,
+        dose = ?, notes = ?
+    WHERE id = ? AND flock_id = ?`,
+		t.Status, nullTime(t.AdministeredAt), t.AdministeredBy, t.BatchNumber, t.Dose, t.Notes, t.ID, t.FlockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete a treatment of a flock. Returns sql.ErrNoRows when it does not
+// exist.
+func DeleteTreatment(db *sql.DB, flockID, id int) error {
+	result, err := db.Exec("DELETE FROM flock_treatments WHERE id = ? AND flock_id = ?", id, flockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// WeightSample is the body weight of birds weighed in a flock on a day,
+// either each bird's weight or the batch average from a scale
+type WeightSample struct {
+	ID            int       `json:"id"`
+	FlockID       int       `json:"flock_id"`
+	Day           string    `json:"day"` // YYYY-MM-DD
+	AverageWeight float64   `json:"average_weight_g"`
+	SampleSize    int       `json:"sample_size"`
+	Weights       []float64 `json:"weights,omitempty"`            // Individual weights in grams
+	CV            *float64  `json:"cv_percent,omitempty"`         // Coefficient of variation
+	Uniformity    *float64  `json:"uniformity_percent,omitempty"` // Birds within 10% of the mean
+	Notes         string    `json:"notes"`
+	RecordedBy    int       `json:"recorded_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Initialize weight samples table
+func InitWeightDB(db *sql.DB) error {
+	createWeightTable := `
+    CREATE TABLE IF NOT EXISTS weight_samples (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        flock_id INTEGER NOT NULL,
+        day TEXT NOT NULL,
+        average_weight REAL NOT NULL,
+        sample_size INTEGER NOT NULL,
+        notes TEXT NOT NULL DEFAULT '',
+        recorded_by INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL,
+        FOREIGN KEY (flock_id) REFERENCES flocks(id),
+        FOREIGN KEY (recorded_by) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_weight_samples_flock ON weight_samples(flock_id, day);`
+
+	_, err := db.Exec(createWeightTable)
+	if err != nil {
+		log.Println("Error creating weight samples table:", err)
+		return err
+	}
+
+	// Individual weights and uniformity
+	if err = AddColumnIfMissing(db, "weight_samples", "weights", "TEXT NOT NULL DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err = AddColumnIfMissing(db, "weight_samples", "cv", "REAL"); err != nil {
+		return err
+	}
+	return AddColumnIfMissing(db, "weight_samples", "uniformity", "REAL")
+}
+
+// Store a weight sample and return its ID
+func CreateWeightSample(db *sql.DB, w WeightSample) (int, error) {
+	weights, err := json.Marshal(w.Weights)
+	if err != nil {
+		return 0, err
+	}
+	if w.Weights == nil {
+		weights = []byte("[]")
+	}
+	result, err := db.Exec(`
+    INSERT INTO weight_samples (flock_id, day, average_weight, sample_size, weights, cv, uniformity, notes,
+        recorded_by, created_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		w.FlockID, w.Day, w.AverageWeight, w.SampleSize, string(weights), w.CV, w.Uniformity, w.Notes,
+		w.RecordedBy, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// List the weight samples of a flock, oldest first
+func ListWeightSamples(db *sql.DB, flockID int) ([]WeightSample, error) {
+	rows, err := db.Query(`
+    SELECT id, flock_id, day, average_weight, sample_size, weights, cv, uniformity, notes, recorded_by, created_at
+    FROM weight_samples WHERE flock_id = ?
+    ORDER BY day, id`, flockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []WeightSample{}
+	for rows.Next() {
+		var w WeightSample
+		var weights string
+		err := rows.Scan(&w.ID, &w.FlockID, &w.Day, &w.AverageWeight, &w.SampleSize, &weights, &w.CV,
+			&w.Uniformity, &w.Notes, &w.RecordedBy, &w.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(weights), &w.Weights); err != nil {
+			return nil, err
+		}
+		samples = append(samples, w)
+	}
+	return samples, rows.Err()
+}
+
+// Delete a weight sample of a flock. Returns sql.ErrNoRows when it does not
+// exist.
+func DeleteWeightSample(db *sql.DB, flockID, id int) error {
+	result, err := db.Exec("DELETE FROM weight_samples WHERE id = ? AND flock_id = ?", id, flockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
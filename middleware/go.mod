@@ -12,6 +12,7 @@ require (
 
 require (
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/image v0.24.0
 	modernc.org/sqlite v1.38.2
 )
 
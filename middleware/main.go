@@ -1,18 +1,29 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"middleware/api"
 	"middleware/database"
+	"middleware/simulator"
+	"middleware/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Run the ESP32 controller simulator instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		log.Fatal(simulator.Main(os.Args[2:]))
+	}
+
 	// Load environment variables from .env file
 	// Check if we're in tokkatot directory, then look for .env in middleware subdirectory
 	currentDir, _ := os.Getwd()
@@ -58,11 +69,35 @@ func main() {
 
 	log.Println("Frontend path resolved successfully to:", frontendPath)
 
-	app := fiber.New()
+	// Batch uploads of tray photos need more than the default 4MB
+	app := fiber.New(fiber.Config{
+		BodyLimit: envInt("MAX_UPLOAD_MB", 100) * 1024 * 1024,
+	})
 
 	// Initialize database
 	api.DB = database.InitDB()
 
+	// Grant the admin role to the users listed in ADMIN_USERS
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if username = strings.TrimSpace(username); username == "" {
+			continue
+		}
+		if err := database.SetUserRole(api.DB, username, database.RoleAdmin); err != nil {
+			log.Printf("Could not grant admin role to %s: %v", username, err)
+		}
+	}
+
+	// Background jobs: telemetry history, disease outbreak detection,
+	// treatment and task reminders
+	go api.RecordTelemetry(envDuration("TELEMETRY_INTERVAL", 5*time.Minute))
+	go api.StartOutbreakMonitor(envDuration("OUTBREAK_ANALYSIS_INTERVAL", time.Hour))
+	go api.StartTreatmentReminders(envDuration("TREATMENT_REMINDER_INTERVAL", time.Hour))
+	go api.StartTaskReminders(envDuration("TASK_REMINDER_INTERVAL", 15*time.Minute))
+	if err := api.StartAISupervisor(envDuration("AI_HEALTH_INTERVAL", 30*time.Second)); err != nil {
+		log.Fatal("Invalid AI backend configuration: ", err)
+	}
+	api.StartPredictionWorkers(envInt("AI_WORKERS", 1))
+
 	// Serve static files with absolute paths
 	app.Static("/assets", filepath.Join(frontendPath, "assets"))
 	app.Static("/components", filepath.Join(frontendPath, "components"))
@@ -116,6 +151,10 @@ func main() {
 	app.Post("/register", api.RegisterHandler)
 	app.Post("/login", api.LoginHandler)
 
+	// Data pushed by controllers, sealed with their payload key instead of a session
+	deviceRoutes := app.Group("/device", api.RequireControllerEnvelope)
+	deviceRoutes.Post("/sensors/readings", api.IngestSensorReadingsHandler)
+
 	// API routes (protected by authentication)
 	apiRoutes := app.Group("/api", func(c *fiber.Ctx) error {
 		if api.ValidateCookie(c) != nil {
@@ -134,17 +173,125 @@ func main() {
 	apiRoutes.Get("/get-historical-data", api.GetHistoricalDataHandler)
 
 	// Poultry system control routes
-	apiRoutes.Get("/toggle-auto", api.ToggleAutoHandler)
-	apiRoutes.Get("/toggle-belt", api.ToggleBeltHandler)
-	apiRoutes.Get("/toggle-fan", api.ToggleFanHandler)
-	apiRoutes.Get("/toggle-bulb", api.ToggleBulbHandler)
-	apiRoutes.Get("/toggle-feeder", api.ToggleFeederHandler)
-	apiRoutes.Get("/toggle-pump", api.TogglePumpHandler)
+	apiRoutes.Get("/actuators", api.ListActuatorsHandler)
+	apiRoutes.Get("/actuators/:id", api.GetActuatorHandler)
+	apiRoutes.Post("/actuators/:id/control", api.ControlActuatorHandler)
 
 	// AI Disease Detection routes
 	apiRoutes.Get("/ai/health", api.AIHealthCheckHandler)
 	apiRoutes.Post("/ai/predict-disease", api.PredictDiseaseHandler)
 	apiRoutes.Get("/ai/disease-info", api.GetDiseaseInfoHandler)
+	apiRoutes.Post("/ai/jobs", api.CreatePredictionJobHandler)
+	apiRoutes.Get("/ai/jobs/:id", api.GetPredictionJobHandler)
+	apiRoutes.Post("/ai/batches", api.CreatePredictionBatchHandler)
+	apiRoutes.Get("/ai/batches/:id", api.GetPredictionBatchHandler)
+	apiRoutes.Get("/ai/predictions", api.ListPredictionsHandler)
+	apiRoutes.Get("/ai/predictions/:id", api.GetPredictionHandler)
+	apiRoutes.Get("/ai/predictions/:id/image", api.GetPredictionImageHandler)
+	apiRoutes.Get("/ai/trends", api.GetDiseaseTrendsHandler)
+
+	// Flock lifecycle
+	apiRoutes.Get("/flocks", api.ListFlocksHandler)
+	apiRoutes.Post("/flocks", api.CreateFlockHandler)
+	apiRoutes.Get("/flocks/:id", api.GetFlockHandler)
+	apiRoutes.Put("/flocks/:id", api.UpdateFlockHandler)
+	apiRoutes.Delete("/flocks/:id", api.DeleteFlockHandler)
+	apiRoutes.Post("/flocks/:id/close", api.CloseFlockHandler)
+	apiRoutes.Get("/flocks/:id/summary", api.GetFlockSummaryHandler)
+	apiRoutes.Get("/flocks/:id/mortality", api.GetMortalityHandler)
+	apiRoutes.Post("/flocks/:id/mortality", api.RecordMortalityHandler)
+	apiRoutes.Delete("/flocks/:id/mortality/:recordId", api.DeleteMortalityHandler)
+	apiRoutes.Get("/flocks/:id/feed", api.GetFeedHandler)
+	apiRoutes.Post("/flocks/:id/feed", api.CreateFeedDeliveryHandler)
+	apiRoutes.Delete("/flocks/:id/feed/:deliveryId", api.DeleteFeedDeliveryHandler)
+	apiRoutes.Get("/flocks/:id/weights", api.ListWeightSamplesHandler)
+	apiRoutes.Post("/flocks/:id/weights", api.CreateWeightSampleHandler)
+	apiRoutes.Delete("/flocks/:id/weights/:sampleId", api.DeleteWeightSampleHandler)
+	apiRoutes.Get("/flocks/:id/growth", api.GetGrowthHandler)
+	apiRoutes.Get("/flocks/:id/eggs", api.GetEggProductionHandler)
+	apiRoutes.Post("/flocks/:id/eggs", api.CreateEggCollectionHandler)
+	apiRoutes.Delete("/flocks/:id/eggs/:collectionId", api.DeleteEggCollectionHandler)
+
+	// Vaccination and medication
+	apiRoutes.Get("/health-programs", api.ListHealthProgramsHandler)
+	apiRoutes.Get("/health-programs/:id", api.GetHealthProgramHandler)
+	apiRoutes.Post("/flocks/:id/health-programs/:programId/apply", api.ApplyHealthProgramHandler)
+	apiRoutes.Get("/flocks/:id/treatments", api.ListTreatmentsHandler)
+	apiRoutes.Post("/flocks/:id/treatments", api.CreateTreatmentHandler)
+	apiRoutes.Post("/flocks/:id/treatments/:treatmentId/complete", api.CompleteTreatmentHandler)
+	apiRoutes.Post("/flocks/:id/treatments/:treatmentId/skip", api.SkipTreatmentHandler)
+	apiRoutes.Delete("/flocks/:id/treatments/:treatmentId", api.DeleteTreatmentHandler)
+	apiRoutes.Get("/flocks/:id/sale-status", api.GetSaleStatusHandler)
+	apiRoutes.Get("/breeds", api.ListBreedStandardsHandler)
+	apiRoutes.Get("/breeds/:key", api.GetBreedStandardHandler)
+
+	// Farm tasks
+	apiRoutes.Get("/tasks", api.ListTasksHandler)
+	apiRoutes.Post("/tasks", api.CreateTaskHandler)
+	apiRoutes.Get("/tasks/agenda", api.GetTaskAgendaHandler)
+	apiRoutes.Get("/tasks/activity", api.GetTaskActivityHandler)
+	apiRoutes.Get("/tasks/completions/:completionId/photo", api.GetTaskCompletionPhotoHandler)
+	apiRoutes.Get("/tasks/:id", api.GetTaskHandler)
+	apiRoutes.Put("/tasks/:id", api.UpdateTaskHandler)
+	apiRoutes.Delete("/tasks/:id", api.DeleteTaskHandler)
+	apiRoutes.Post("/tasks/:id/complete", api.CompleteTaskHandler)
+
+	// Farm layout and sensor registry
+	apiRoutes.Get("/farms", api.GetFarmLayoutHandler)
+	apiRoutes.Get("/sensor-types", api.ListSensorTypesHandler)
+	apiRoutes.Get("/sensors", api.ListSensorsHandler)
+	apiRoutes.Get("/sensors/:id", api.GetSensorHandler)
+	apiRoutes.Get("/sensors/:id/readings", api.GetSensorReadingsHandler)
+
+	// Analysis findings
+	apiRoutes.Get("/findings", api.ListFindingsHandler)
+	apiRoutes.Post("/findings/:id/acknowledge", api.AcknowledgeFindingHandler)
+
+	// Veterinarian review routes
+	vetRoutes := apiRoutes.Group("/vet", api.RequireRole(database.RoleVet, database.RoleAdmin))
+	vetRoutes.Get("/reviews/queue", api.GetReviewQueueHandler)
+	vetRoutes.Post("/predictions/:id/review", api.ReviewPredictionHandler)
+	vetRoutes.Get("/dataset/export", api.ExportDatasetHandler)
+	vetRoutes.Post("/health-programs", api.CreateHealthProgramHandler)
+	vetRoutes.Put("/health-programs/:id", api.UpdateHealthProgramHandler)
+	vetRoutes.Delete("/health-programs/:id", api.DeleteHealthProgramHandler)
+
+	// Administration routes
+	adminRoutes := apiRoutes.Group("/admin", api.RequireRole(database.RoleAdmin))
+	adminRoutes.Get("/controller-pins", api.ListControllerPinsHandler)
+	adminRoutes.Post("/controller-pins/repin", api.RepinControllerHandler)
+	adminRoutes.Get("/controller-keys", api.ListControllerKeysHandler)
+	adminRoutes.Post("/controller-keys/rotate", api.RotateControllerKeyHandler)
+	adminRoutes.Post("/analysis/outbreaks", api.RunOutbreakAnalysisHandler)
+	adminRoutes.Put("/users/:username/role", api.SetUserRoleHandler)
+	adminRoutes.Get("/ai/models", api.ListAIModelsHandler)
+	adminRoutes.Get("/ai/comparison", api.CompareAIModelsHandler)
+	adminRoutes.Post("/ai/restart", api.RestartAIServiceHandler)
+	adminRoutes.Get("/diseases", api.ListDiseasesHandler)
+	adminRoutes.Get("/diseases/:key", api.GetDiseaseHandler)
+	adminRoutes.Put("/diseases/:key", api.PutDiseaseHandler)
+	adminRoutes.Delete("/diseases/:key", api.DeleteDiseaseHandler)
+	adminRoutes.Put("/breeds/:key", api.PutBreedStandardHandler)
+	adminRoutes.Delete("/breeds/:key", api.DeleteBreedStandardHandler)
+	adminRoutes.Get("/controllers", api.ListControllersHandler)
+	adminRoutes.Put("/controllers/:address", api.PutControllerHandler)
+	adminRoutes.Delete("/controllers/:address", api.DeleteControllerHandler)
+	adminRoutes.Get("/controllers/:address/readings", api.GetControllerReadingsHandler)
+	adminRoutes.Post("/actuators", api.CreateActuatorHandler)
+	adminRoutes.Put("/actuators/:id", api.UpdateActuatorHandler)
+	adminRoutes.Delete("/actuators/:id", api.DeleteActuatorHandler)
+	adminRoutes.Post("/farms", api.CreateFarmHandler)
+	adminRoutes.Put("/farms/:id", api.UpdateFarmHandler)
+	adminRoutes.Delete("/farms/:id", api.DeleteFarmHandler)
+	adminRoutes.Post("/farms/:id/houses", api.CreateHouseHandler)
+	adminRoutes.Put("/houses/:id", api.UpdateHouseHandler)
+	adminRoutes.Delete("/houses/:id", api.DeleteHouseHandler)
+	adminRoutes.Post("/houses/:id/zones", api.CreateZoneHandler)
+	adminRoutes.Put("/zones/:id", api.UpdateZoneHandler)
+	adminRoutes.Delete("/zones/:id", api.DeleteZoneHandler)
+	adminRoutes.Post("/sensors", api.CreateSensorHandler)
+	adminRoutes.Put("/sensors/:id", api.UpdateSensorHandler)
+	adminRoutes.Delete("/sensors/:id", api.DeleteSensorHandler)
 
 	// Schedule management routes
 	/* apiRoutes.Post("/schedule", api.SaveScheduleHandler)      // Save schedule
@@ -159,7 +306,57 @@ func main() {
 	// Close the database connection when the application shuts down
 	defer api.DB.Close()
 
+	// Load the configured certificate or manage a self-signed one
+	certManager, err := utils.NewCertManager(os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY"), os.Getenv("TLS_CERT_DIR"))
+	if err != nil {
+		log.Fatal("Failed to set up TLS certificate: ", err)
+	}
+	go certManager.Watch(time.Hour)
+
+	// Optionally redirect plain HTTP requests to HTTPS
+	if redirectAddr := os.Getenv("HTTP_REDIRECT_ADDR"); redirectAddr != "" {
+		go func() {
+			log.Println("Redirecting HTTP on", redirectAddr, "to HTTPS")
+			log.Println("HTTP redirect listener stopped:", utils.RedirectHTTP(redirectAddr, "443"))
+		}()
+	}
+
+	ln, err := tls.Listen("tcp", ":443", &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certManager.GetCertificate,
+	})
+	if err != nil {
+		log.Fatal("Failed to listen on port 443: ", err)
+	}
+
 	// Start the server
 	log.Println("Server is running on port 443")
-	log.Fatal(app.ListenTLS(":443", os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")))
+	log.Fatal(app.Listener(ln))
+}
+
+// Read a duration such as "5m" from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
 }
@@ -0,0 +1,371 @@
+package simulator
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"middleware/utils"
+)
+
+// RelayBoard stands in for a third-party relay running Tasmota or Shelly
+// firmware, with relays, dimmable lights and a temperature and humidity
+// probe. It serves the local HTTP API of each firmware over plain HTTP, like
+// the devices do on a farm network.
+type RelayBoard struct {
+	Username string // Credentials the board asks for, if a password is set
+	Password string
+
+	mu          sync.Mutex
+	relays      []bool
+	lights      []light
+	temperature float64
+	humidity    float64
+}
+
+type light struct {
+	on         bool
+	brightness int
+}
+
+// NewRelayBoard returns a board with all outputs off
+func NewRelayBoard(relays, lights int) *RelayBoard {
+	b := &RelayBoard{
+		relays:      make([]bool, relays),
+		lights:      make([]light, lights),
+		temperature: 24.5,
+		humidity:    61,
+	}
+	for i := range b.lights {
+		b.lights[i].brightness = 100
+	}
+	return b
+}
+
+// Relay returns the state of a relay, from 0
+func (b *RelayBoard) Relay(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.relays[n]
+}
+
+// Light returns the state of a light, from 0
+func (b *RelayBoard) Light(n int) (on bool, brightness int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lights[n].on, b.lights[n].brightness
+}
+
+// SetProbe changes the readings of the probe
+func (b *RelayBoard) SetProbe(temperature, humidity float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.temperature, b.humidity = temperature, humidity
+}
+
+// Apply an on, off or toggle command to a relay. Must be called with mu held.
+func (b *RelayBoard) switchRelay(n int, turn string) bool {
+	switch strings.ToLower(turn) {
+	case "on", "1", "true":
+		b.relays[n] = true
+	case "off", "0", "false":
+		b.relays[n] = false
+	case "toggle", "2":
+		b.relays[n] = !b.relays[n]
+	}
+	return b.relays[n]
+}
+
+func (b *RelayBoard) authorized(user, password string) bool {
+	return b.Password == "" || (user == b.Username && password == b.Password)
+}
+
+// ====== TASMOTA ====== //
+
+// TasmotaHandler serves the Tasmota command API, GET /cm?cmnd=<command>.
+// Power and Dimmer channels count from 1.
+func (b *RelayBoard) TasmotaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cm", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !b.authorized(query.Get("user"), query.Get("password")) {
+			writeJSON(w, map[string]string{"WARNING": "Need user=<username>&password=<password>"})
+			return
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimSpace(query.Get("cmnd")), " ")
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		writeJSON(w, b.tasmotaCommand(strings.ToLower(command), arg))
+	})
+	return mux
+}
+
+// Must be called with mu held
+func (b *RelayBoard) tasmotaCommand(command, arg string) map[string]interface{} {
+	power := func(n int) string {
+		if b.relays[n] {
+			return "ON"
+		}
+		return "OFF"
+	}
+	// Boards with one relay answer POWER instead of POWER1
+	powerKey := func(n int) string {
+		if len(b.relays) == 1 {
+			return "POWER"
+		}
+		return fmt.Sprintf("POWER%d", n+1)
+	}
+
+	switch {
+	case command == "status" && arg == "10":
+		return map[string]interface{}{"StatusSNS": map[string]interface{}{
+			"Time":     "2025-01-01T06:00:00",
+			"AM2301":   map[string]float64{"Temperature": b.temperature, "Humidity": b.humidity},
+			"TempUnit": "C",
+		}}
+	case command == "status" && arg == "11":
+		status := map[string]interface{}{"Time": "2025-01-01T06:00:00"}
+		for n := range b.relays {
+			status[powerKey(n)] = power(n)
+		}
+		for n, l := range b.lights {
+			key := "Dimmer"
+			if n > 0 {
+				key = fmt.Sprintf("Dimmer%d", n+1)
+			}
+			status[key] = 0
+			if l.on {
+				status[key] = l.brightness
+			}
+		}
+		return map[string]interface{}{"StatusSTS": status}
+	case strings.HasPrefix(command, "power"):
+		n, err := tasmotaChannel(strings.TrimPrefix(command, "power"), len(b.relays))
+		if err != nil {
+			break
+		}
+		if arg != "" {
+			b.switchRelay(n, arg)
+		}
+		return map[string]interface{}{powerKey(n): power(n)}
+	case strings.HasPrefix(command, "dimmer"):
+		n, err := tasmotaChannel(strings.TrimPrefix(command, "dimmer"), len(b.lights))
+		if err != nil {
+			break
+		}
+		if v, err := strconv.Atoi(arg); err == nil {
+			b.lights[n].on = v > 0
+			if v > 0 {
+				b.lights[n].brightness = min(v, 100)
+			}
+		}
+		key := "Dimmer"
+		if n > 0 {
+			key = fmt.Sprintf("Dimmer%d", n+1)
+		}
+		brightness := 0
+		if b.lights[n].on {
+			brightness = b.lights[n].brightness
+		}
+		return map[string]interface{}{key: brightness}
+	}
+	return map[string]interface{}{"Command": "Unknown"}
+}
+
+// Parse a channel from 1, an empty channel is the first
+func tasmotaChannel(s string, count int) (int, error) {
+	if s == "" {
+		s = "1"
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > count {
+		return 0, fmt.Errorf("no channel %s", s)
+	}
+	return n - 1, nil
+}
+
+// ====== SHELLY ====== //
+
+// ShellyGen1Handler serves the REST API of Gen1 Shelly devices, with basic
+// authentication when a password is set
+func (b *RelayBoard) ShellyGen1Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		relays := []map[string]interface{}{}
+		for _, on := range b.relays {
+			relays = append(relays, map[string]interface{}{"ison": on, "source": "http"})
+		}
+		lights := []map[string]interface{}{}
+		for _, l := range b.lights {
+			lights = append(lights, map[string]interface{}{"ison": l.on, "brightness": l.brightness})
+		}
+		writeJSON(w, map[string]interface{}{
+			"relays":          relays,
+			"lights":          lights,
+			"ext_temperature": map[string]interface{}{"0": map[string]float64{"tC": b.temperature}},
+			"ext_humidity":    map[string]interface{}{"0": map[string]float64{"hum": b.humidity}},
+		})
+	})
+	mux.HandleFunc("/relay/", func(w http.ResponseWriter, r *http.Request) {
+		n, err := shellyChannel(strings.TrimPrefix(r.URL.Path, "/relay/"), len(b.relays))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		on := b.switchRelay(n, r.URL.Query().Get("turn"))
+		writeJSON(w, map[string]interface{}{"ison": on, "source": "http"})
+	})
+	mux.HandleFunc("/light/", func(w http.ResponseWriter, r *http.Request) {
+		n, err := shellyChannel(strings.TrimPrefix(r.URL.Path, "/light/"), len(b.lights))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		l := &b.lights[n]
+		switch query.Get("turn") {
+		case "on":
+			l.on = true
+		case "off":
+			l.on = false
+		case "toggle":
+			l.on = !l.on
+		}
+		if v, err := strconv.Atoi(query.Get("brightness")); err == nil && v >= 0 && v <= 100 {
+			l.brightness = v
+		}
+		writeJSON(w, map[string]interface{}{"ison": l.on, "brightness": l.brightness})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if !b.authorized(user, password) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// ShellyGen2Handler serves the RPC API of Gen2 Shelly devices over HTTP GET,
+// with SHA-256 digest authentication when a password is set
+func (b *RelayBoard) ShellyGen2Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.digestAuthorized(r) {
+			nonce := make([]byte, 8)
+			rand.Read(nonce)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Digest qop="auth", realm="shellyplus1-sim", nonce="%x", algorithm=SHA-256`, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]interface{}{"code": 401, "message": "Unauthorized"})
+			return
+		}
+		method := strings.TrimPrefix(r.URL.Path, "/rpc/")
+		query := r.URL.Query()
+
+		b.mu.Lock()
+		result, err := b.shellyRPC(method, query)
+		b.mu.Unlock()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]interface{}{"code": -103, "message": err.Error()})
+			return
+		}
+		writeJSON(w, result)
+	})
+}
+
+// Check the digest authorization of a request. The nonce is not tracked.
+func (b *RelayBoard) digestAuthorized(r *http.Request) bool {
+	if b.Password == "" {
+		return true
+	}
+	params, err := utils.ParseDigest(r.Header.Get("Authorization"))
+	if err != nil || params["username"] != b.Username || params["uri"] != r.URL.RequestURI() {
+		return false
+	}
+	expected, err := utils.DigestResponse(params, b.Username, b.Password, r.Method, params["uri"])
+	return err == nil && params["response"] == expected
+}
+
+// Must be called with mu held
+func (b *RelayBoard) shellyRPC(method string, query map[string][]string) (interface{}, error) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	switchStatus := func(n int) map[string]interface{} {
+		return map[string]interface{}{"id": n, "source": "HTTP", "output": b.relays[n], "temperature": map[string]float64{"tC": 41.2}}
+	}
+	lightStatus := func(n int) map[string]interface{} {
+		return map[string]interface{}{"id": n, "source": "HTTP", "output": b.lights[n].on, "brightness": b.lights[n].brightness}
+	}
+
+	switch method {
+	case "Shelly.GetStatus":
+		status := map[string]interface{}{
+			"sys":             map[string]interface{}{"uptime": 3600},
+			"temperature:100": map[string]interface{}{"id": 100, "tC": b.temperature},
+			"humidity:100":    map[string]interface{}{"id": 100, "rh": b.humidity},
+		}
+		for n := range b.relays {
+			status[fmt.Sprintf("switch:%d", n)] = switchStatus(n)
+		}
+		for n := range b.lights {
+			status[fmt.Sprintf("light:%d", n)] = lightStatus(n)
+		}
+		return status, nil
+	case "Switch.GetStatus", "Switch.Set", "Switch.Toggle":
+		n, err := shellyChannel(get("id"), len(b.relays))
+		if err != nil {
+			return nil, err
+		}
+		if method == "Switch.GetStatus" {
+			return switchStatus(n), nil
+		}
+		wasOn := b.relays[n]
+		if method == "Switch.Toggle" {
+			b.switchRelay(n, "toggle")
+		} else {
+			b.switchRelay(n, get("on"))
+		}
+		return map[string]bool{"was_on": wasOn}, nil
+	case "Light.GetStatus", "Light.Set":
+		n, err := shellyChannel(get("id"), len(b.lights))
+		if err != nil {
+			return nil, err
+		}
+		if method == "Light.Set" {
+			if on := get("on"); on != "" {
+				b.lights[n].on = on == "true"
+			}
+			if v, err := strconv.Atoi(get("brightness")); err == nil && v >= 0 && v <= 100 {
+				b.lights[n].brightness = v
+			}
+			return nil, nil
+		}
+		return lightStatus(n), nil
+	}
+	return nil, fmt.Errorf("no handler for %s", method)
+}
+
+// Parse a channel from 0
+func shellyChannel(s string, count int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n >= count {
+		return 0, fmt.Errorf("id %q not found", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,228 @@
+package simulator
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Fault kinds that can be injected into the API
+const (
+	FaultTimeout = "timeout" // Requests hang without answering
+	FaultGarbage = "garbage" // Responses are malformed JSON
+	FaultError   = "error"   // Responses are HTTP 500
+	FaultReboot  = "reboot"  // Connections are dropped, then state resets
+)
+
+type fault struct {
+	kind  string
+	until time.Time
+}
+
+// InjectFault makes the API misbehave for d of real time
+func (s *Simulator) InjectFault(kind string, d time.Duration) error {
+	switch kind {
+	case FaultTimeout, FaultGarbage, FaultError, FaultReboot:
+	default:
+		return fmt.Errorf("unknown fault %q", kind)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fault = &fault{kind: kind, until: time.Now().Add(d)}
+	log.Printf("Simulator: injecting %s fault for %s", kind, d)
+	return nil
+}
+
+// Return the active fault, clearing it once it has expired. A reboot resets
+// the controller when it ends.
+func (s *Simulator) activeFault() *fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fault != nil && time.Now().After(s.fault.until) {
+		if s.fault.kind == FaultReboot {
+			s.boot()
+			log.Println("Simulator: controller rebooted")
+		}
+		s.fault = nil
+	}
+	return s.fault
+}
+
+// Wrap a handler with fault injection
+func (s *Simulator) faulty(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := s.activeFault()
+		if f == nil {
+			next(w, r)
+			return
+		}
+
+		switch f.kind {
+		case FaultTimeout:
+			select {
+			case <-time.After(time.Until(f.until)):
+			case <-r.Context().Done():
+				return
+			}
+			next(w, r)
+		case FaultGarbage:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"timestamp": 12, "temperature": nan, "humi`))
+		case FaultError:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		case FaultReboot:
+			// Drop the connection like a controller losing power
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// ====== SCENARIOS ====== //
+
+// Duration is a time.Duration read from JSON strings such as "90s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Scenario is a scripted sequence of changes to the environment and faults.
+// Step times are simulated time since the simulator started.
+type Scenario struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is one scripted event
+type Step struct {
+	At                 Duration `json:"at"`
+	AmbientTemperature *float64 `json:"ambient_temperature,omitempty"`
+	AmbientHumidity    *float64 `json:"ambient_humidity,omitempty"`
+	Toggle             string   `json:"toggle,omitempty"` // e.g. "fan"
+	Fault              string   `json:"fault,omitempty"`
+	Duration           Duration `json:"duration,omitempty"` // Real time length of the fault
+}
+
+// LoadScenario reads a scenario from a JSON file
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", path, err)
+	}
+	sort.SliceStable(scenario.Steps, func(i, j int) bool {
+		return scenario.Steps[i].At < scenario.Steps[j].At
+	})
+	return &scenario, nil
+}
+
+type scenarioPlayer struct {
+	scenario *Scenario
+	next     int
+}
+
+func newScenarioPlayer(scenario *Scenario) *scenarioPlayer {
+	log.Printf("Simulator: playing scenario %q with %d steps", scenario.Name, len(scenario.Steps))
+	return &scenarioPlayer{scenario: scenario}
+}
+
+// Apply every step that is due at elapsed simulated time
+func (p *scenarioPlayer) advance(s *Simulator, elapsed time.Duration) {
+	for p.next < len(p.scenario.Steps) && time.Duration(p.scenario.Steps[p.next].At) <= elapsed {
+		step := p.scenario.Steps[p.next]
+		p.next++
+
+		if step.AmbientTemperature != nil || step.AmbientHumidity != nil {
+			s.SetAmbient(step.AmbientTemperature, step.AmbientHumidity)
+		}
+		if step.Toggle != "" {
+			if _, err := s.Toggle(step.Toggle); err != nil {
+				log.Println("Simulator:", err)
+			}
+		}
+		if step.Fault != "" {
+			if err := s.InjectFault(step.Fault, time.Duration(step.Duration)); err != nil {
+				log.Println("Simulator:", err)
+			}
+		}
+	}
+}
+
+// ====== COMMAND LINE ====== //
+
+// Main runs the simulator as the "simulate" subcommand of the middleware
+func Main(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	addr := fs.String("addr", ":8443", "HTTPS listen address")
+	certDir := fs.String("cert-dir", "", "directory keeping the self-signed certificate across restarts, a new one is generated on every start when empty")
+	scenarioPath := fs.String("scenario", "", "JSON scenario file to play")
+	speed := fs.Float64("speed", 1, "simulated seconds per real second")
+	ambientTemp := fs.Float64("ambient-temp", 30, "ambient temperature in °C")
+	ambientHum := fs.Float64("ambient-humidity", 70, "ambient relative humidity in %")
+	tasmotaAddr := fs.String("tasmota-addr", "", "HTTP listen address of a simulated Tasmota relay")
+	shellyAddr := fs.String("shelly-addr", "", "HTTP listen address of a simulated Shelly Gen1 relay")
+	shelly2Addr := fs.String("shelly2-addr", "", "HTTP listen address of a simulated Shelly Gen2 relay")
+	fs.Parse(args)
+
+	// Third-party relays with four relays and a dimmer each
+	relays := map[string]func(*RelayBoard) http.Handler{
+		*tasmotaAddr: (*RelayBoard).TasmotaHandler,
+		*shellyAddr:  (*RelayBoard).ShellyGen1Handler,
+		*shelly2Addr: (*RelayBoard).ShellyGen2Handler,
+	}
+	for addr, handler := range relays {
+		if addr == "" {
+			continue
+		}
+		server := &http.Server{Addr: addr, Handler: handler(NewRelayBoard(4, 1)), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+		log.Printf("Simulated relay listening on %s", addr)
+	}
+
+	opts := Options{
+		AmbientTemperature: *ambientTemp,
+		AmbientHumidity:    *ambientHum,
+		Speed:              *speed,
+	}
+	if *scenarioPath != "" {
+		scenario, err := LoadScenario(*scenarioPath)
+		if err != nil {
+			return err
+		}
+		opts.Scenario = scenario
+	}
+
+	sim := New(opts)
+	go sim.Run(make(chan struct{}))
+
+	log.Printf("Simulated controller listening on %s (set CONTROLLER_URL=https://localhost%s)", *addr, *addr)
+	return sim.ListenAndServeTLS(*addr, *certDir)
+}
@@ -0,0 +1,402 @@
+// Package simulator stands in for the ESP32 poultry controller. It serves the
+// same HTTPS API as the firmware in embedded/main/src/server_handlers.c and
+// models how the coop reacts to the bulb, fan and pump, so the middleware can
+// be developed and tested without hardware.
+package simulator
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"middleware/utils"
+)
+
+// Same values as the firmware
+const (
+	historySize     = 10 // QUEUE_SIZE in sensor_manager.h
+	sampleInterval  = 2 * time.Second
+	autoColdTemp    = 28.0
+	autoHotTemp     = 32.0
+	waterLevelFull  = 2000
+	waterLevelMax   = 2500
+	maxWaterReading = 4095
+)
+
+// Physics, per simulated second
+const (
+	ambientCoupling  = 0.01 // Fraction of the gap to ambient closed per second
+	bulbHeating      = 0.05 // °C/s with the bulb on
+	fanCooling       = 0.04 // °C/s with the fan on
+	fanCoupling      = 0.03 // Extra ambient coupling with the fan on
+	fanDrying        = 0.05 // %RH/s with the fan on
+	bulbDrying       = 0.02 // %RH/s with the bulb on
+	pumpHumidifying  = 0.03 // %RH/s with the pump on
+	pumpFillRate     = 20.0 // Water level units/s with the pump on
+	waterConsumption = 0.5  // Water level units/s drunk by the flock
+	sensorNoise      = 0.05 // Amplitude of the reading noise
+)
+
+// DeviceState mirrors device_state_t
+type DeviceState struct {
+	AutoMode bool `json:"auto_mode"`
+	Fan      bool `json:"fan"`
+	Bulb     bool `json:"bulb"`
+	Feeder   bool `json:"feeder"`
+	Pump     bool `json:"pump"`
+	Conveyer bool `json:"conveyer"`
+}
+
+// Reading mirrors sensor_data_t
+type Reading struct {
+	Timestamp   uint64  `json:"timestamp"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	WaterLevel  int     `json:"-"`
+}
+
+// Options configure a simulator
+type Options struct {
+	AmbientTemperature float64
+	AmbientHumidity    float64
+	Speed              float64 // Simulated seconds per real second
+	Scenario           *Scenario
+}
+
+// Simulator is a virtual controller
+type Simulator struct {
+	opts Options
+
+	mu          sync.Mutex
+	simTime     time.Duration // Simulated time since boot
+	state       DeviceState
+	ambientTemp float64
+	ambientHum  float64
+	temperature float64
+	humidity    float64
+	waterLevel  float64
+	history     []Reading
+	fault       *fault
+	rng         *rand.Rand
+}
+
+func New(opts Options) *Simulator {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.AmbientTemperature == 0 {
+		opts.AmbientTemperature = 30
+	}
+	if opts.AmbientHumidity == 0 {
+		opts.AmbientHumidity = 70
+	}
+
+	s := &Simulator{
+		opts:        opts,
+		ambientTemp: opts.AmbientTemperature,
+		ambientHum:  opts.AmbientHumidity,
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	s.boot()
+	return s
+}
+
+// Reset everything a power cycle would reset. Must be called with mu held or
+// before the simulator is shared.
+func (s *Simulator) boot() {
+	s.simTime = 0
+	s.state = DeviceState{}
+	s.history = nil
+	if s.temperature == 0 {
+		s.temperature = s.ambientTemp
+		s.humidity = s.ambientHum
+		s.waterLevel = waterLevelFull
+	}
+}
+
+// Step advances the physics by dt of simulated time
+func (s *Simulator) Step(dt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.step(dt)
+}
+
+func (s *Simulator) step(dt time.Duration) {
+	secs := dt.Seconds()
+	s.simTime += dt
+
+	// Heat balance: drift towards ambient, bulb heats, fan cools and mixes
+	coupling := ambientCoupling
+	if s.state.Fan {
+		coupling += fanCoupling
+	}
+	dT := coupling * (s.ambientTemp - s.temperature)
+	if s.state.Bulb {
+		dT += bulbHeating
+	}
+	if s.state.Fan {
+		dT -= fanCooling
+	}
+	s.temperature += dT * secs
+
+	// Humidity drifts to ambient, drying with airflow and heat
+	dH := ambientCoupling * (s.ambientHum - s.humidity)
+	if s.state.Fan {
+		dH -= fanDrying
+	}
+	if s.state.Bulb {
+		dH -= bulbDrying
+	}
+	if s.state.Pump {
+		dH += pumpHumidifying
+	}
+	s.humidity = clamp(s.humidity+dH*secs, 0, 100)
+
+	// Water trough
+	level := s.waterLevel - waterConsumption*secs
+	if s.state.Pump {
+		level += pumpFillRate * secs
+	}
+	s.waterLevel = clamp(level, 0, waterLevelMax)
+
+	// Same thresholds as the firmware main loop
+	if s.state.AutoMode {
+		switch {
+		case s.temperature <= autoColdTemp:
+			s.state.Bulb, s.state.Fan = true, false
+		case s.temperature >= autoHotTemp:
+			s.state.Bulb, s.state.Fan = false, true
+		default:
+			s.state.Bulb, s.state.Fan = false, false
+		}
+	}
+}
+
+// Take a sensor reading and add it to the history, like get_current_sensor_data
+func (s *Simulator) sample() Reading {
+	reading := Reading{
+		Timestamp:   uint64(s.simTime / time.Millisecond),
+		Temperature: round2(s.temperature + s.noise()),
+		Humidity:    round2(clamp(s.humidity+s.noise(), 0, 100)),
+		WaterLevel:  int(s.waterLevel) * maxWaterReading / waterLevelMax,
+	}
+
+	s.history = append(s.history, reading)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+	return reading
+}
+
+func (s *Simulator) noise() float64 {
+	return (s.rng.Float64()*2 - 1) * sensorNoise
+}
+
+// SetAmbient changes the outside conditions
+func (s *Simulator) SetAmbient(temperature, humidity *float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if temperature != nil {
+		s.ambientTemp = *temperature
+	}
+	if humidity != nil {
+		s.ambientHum = *humidity
+	}
+}
+
+// State returns the current device state
+func (s *Simulator) State() DeviceState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Run advances the simulation in real time, sampling the sensors every two
+// simulated seconds and playing the scenario, until stop is closed.
+func (s *Simulator) Run(stop <-chan struct{}) {
+	tick := time.Duration(float64(sampleInterval) / s.opts.Speed)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	started := time.Now()
+	var player *scenarioPlayer
+	if s.opts.Scenario != nil {
+		player = newScenarioPlayer(s.opts.Scenario)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			f := s.activeFault()
+			s.mu.Lock()
+			if f == nil || f.kind != FaultReboot {
+				s.step(sampleInterval)
+				s.sample()
+			}
+			s.mu.Unlock()
+
+			if player != nil {
+				elapsed := time.Duration(float64(now.Sub(started)) * s.opts.Speed)
+				player.advance(s, elapsed)
+			}
+		}
+	}
+}
+
+// ====== HTTP API ====== //
+
+// Toggle routes by device name, each returning the new state
+var toggles = map[string]func(*DeviceState) bool{
+	"auto": func(st *DeviceState) bool {
+		st.AutoMode = !st.AutoMode
+		st.Bulb, st.Fan, st.Pump, st.Conveyer = false, false, false, false
+		return st.AutoMode
+	},
+	"belt": func(st *DeviceState) bool {
+		st.Conveyer = !st.Conveyer
+		return st.Conveyer
+	},
+	"fan": func(st *DeviceState) bool {
+		st.Fan = !st.Fan
+		return st.Fan
+	},
+	"bulb": func(st *DeviceState) bool {
+		st.Bulb = !st.Bulb
+		return st.Bulb
+	},
+	"pump": func(st *DeviceState) bool {
+		st.Pump = !st.Pump
+		return st.Pump
+	},
+	"feeder": func(st *DeviceState) bool {
+		st.Feeder = !st.Feeder
+		return st.Feeder
+	},
+}
+
+// Toggle flips a device by its route name and returns the new state
+func (s *Simulator) Toggle(name string) (bool, error) {
+	flip, ok := toggles[name]
+	if !ok {
+		return false, fmt.Errorf("unknown device %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return flip(&s.state), nil
+}
+
+// Handler serves the firmware API
+func (s *Simulator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/get-initial-state", s.faulty(s.handleInitialState))
+	mux.HandleFunc("/get-current-data", s.faulty(s.handleCurrentData))
+	mux.HandleFunc("/get-historical-data", s.faulty(s.handleHistoricalData))
+
+	for name, flip := range toggles {
+		mux.HandleFunc("/toggle-"+name, s.faulty(s.toggle(flip)))
+	}
+	return mux
+}
+
+// ListenAndServeTLS serves the API over HTTPS with a self-signed certificate,
+// like the firmware's embedded cert.pem. With a certDir the key and certificate
+// are kept there so a pinned fingerprint survives restarts, otherwise a
+// throwaway certificate is generated.
+func (s *Simulator) ListenAndServeTLS(addr, certDir string) error {
+	config := &tls.Config{}
+	if certDir != "" {
+		certs, err := utils.NewCertManager("", "", certDir)
+		if err != nil {
+			return err
+		}
+		go certs.Watch(time.Hour)
+		config.GetCertificate = certs.GetCertificate
+	} else {
+		cert, err := utils.SelfSignedCertificate()
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         config,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+func (s *Simulator) handleInitialState(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	state := s.state
+	s.mu.Unlock()
+
+	// The firmware encodes booleans with cJSON_AddNumberToObject
+	writeJSON(w, map[string]int{
+		"auto_mode": btoi(state.AutoMode),
+		"fan":       btoi(state.Fan),
+		"bulb":      btoi(state.Bulb),
+		"feeder":    btoi(state.Feeder),
+		"pump":      btoi(state.Pump),
+		"conveyer":  btoi(state.Conveyer),
+	})
+}
+
+func (s *Simulator) handleCurrentData(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reading := s.sample()
+	s.mu.Unlock()
+	writeJSON(w, reading)
+}
+
+func (s *Simulator) handleHistoricalData(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	history := append([]Reading{}, s.history...)
+	s.mu.Unlock()
+	writeJSON(w, history)
+}
+
+func (s *Simulator) toggle(flip func(*DeviceState) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		on := flip(&s.state)
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain")
+		if on {
+			w.Write([]byte("true"))
+		} else {
+			w.Write([]byte("false"))
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, server *httptest.Server, path string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+// The routes and JSON shapes read by the api package, see controller-esp32.go
+// and telemetry.go
+func TestHandlerFirmwareAPI(t *testing.T) {
+	server := httptest.NewServer(New(Options{AmbientTemperature: 25, AmbientHumidity: 60}).Handler())
+	defer server.Close()
+
+	// Booleans are numbers, like cJSON_AddNumberToObject
+	_, body := get(t, server, "/get-initial-state")
+	var state map[string]float64
+	if err := json.Unmarshal(body, &state); err != nil {
+		t.Fatalf("initial state %s: %v", body, err)
+	}
+	for _, field := range []string{"auto_mode", "fan", "bulb", "feeder", "pump", "conveyer"} {
+		if v, ok := state[field]; !ok || v != 0 {
+			t.Errorf("initial state %s = %v, %v, want 0", field, v, ok)
+		}
+	}
+
+	_, body = get(t, server, "/get-current-data")
+	var reading map[string]float64
+	if err := json.Unmarshal(body, &reading); err != nil {
+		t.Fatalf("current data %s: %v", body, err)
+	}
+	if len(reading) != 3 {
+		t.Errorf("current data %s, want timestamp, temperature and humidity", body)
+	}
+	if reading["temperature"] < 24 || reading["temperature"] > 26 || reading["humidity"] < 59 || reading["humidity"] > 61 {
+		t.Errorf("current data %s, want the ambient conditions", body)
+	}
+
+	_, body = get(t, server, "/get-historical-data")
+	var history []Reading
+	if err := json.Unmarshal(body, &history); err != nil || len(history) != 1 {
+		t.Errorf("historical data %s: %v, want the sampled reading", body, err)
+	}
+
+	// Toggles answer the new state as plain text
+	for _, want := range []string{"true", "false"} {
+		if code, body := get(t, server, "/toggle-fan"); code != http.StatusOK || string(body) != want {
+			t.Errorf("toggle-fan = %d %q, want %q", code, body, want)
+		}
+	}
+	get(t, server, "/toggle-belt")
+	_, body = get(t, server, "/get-initial-state")
+	state = nil
+	json.Unmarshal(body, &state)
+	if state["conveyer"] != 1 || state["fan"] != 0 {
+		t.Errorf("state after toggling the belt = %s", body)
+	}
+
+	if code, _ := get(t, server, "/toggle-heater"); code != http.StatusNotFound {
+		t.Errorf("unknown toggle = %d, want 404", code)
+	}
+}
+
+func TestHandlerFaults(t *testing.T) {
+	sim := New(Options{})
+	server := httptest.NewServer(sim.Handler())
+	defer server.Close()
+
+	sim.InjectFault(FaultError, time.Minute)
+	if code, _ := get(t, server, "/get-current-data"); code != http.StatusInternalServerError {
+		t.Errorf("error fault = %d, want 500", code)
+	}
+
+	sim.InjectFault(FaultGarbage, time.Minute)
+	_, body := get(t, server, "/get-current-data")
+	if json.Valid(body) {
+		t.Errorf("garbage fault answered valid JSON %s", body)
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HTTP digest authentication (RFC 7616), as used by the local API of Shelly
+// Gen2 devices. A challenge and an authorization are both a list of
+// parameters after the "Digest" scheme.
+
+// ParseDigest reads the parameters of a WWW-Authenticate or Authorization
+// header, e.g. `Digest realm="shelly", nonce="60dc59c6", qop="auth"`
+func ParseDigest(header string) (map[string]string, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return nil, errors.New("not a digest authentication header")
+	}
+
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			return nil, fmt.Errorf("malformed digest parameter %q", rest)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated digest parameter %s", key)
+			}
+			params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(params[key])
+		}
+		rest = strings.TrimLeft(strings.TrimSpace(rest), ",")
+		rest = strings.TrimSpace(rest)
+	}
+	if params["realm"] == "" || params["nonce"] == "" {
+		return nil, errors.New("digest challenge without realm or nonce")
+	}
+	return params, nil
+}
+
+func digestHash(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		return md5.New, nil
+	case "SHA-256":
+		return sha256.New, nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm %q", algorithm)
+}
+
+// DigestResponse computes the response of an authorization from its
+// parameters: realm, nonce, algorithm and, with a qop, nc and cnonce
+func DigestResponse(params map[string]string, username, password, method, uri string) (string, error) {
+	newHash, err := digestHash(params["algorithm"])
+	if err != nil {
+		return "", err
+	}
+	h := func(parts ...string) string {
+		sum := newHash()
+		sum.Write([]byte(strings.Join(parts, ":")))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+
+	ha1 := h(username, params["realm"], password)
+	ha2 := h(method, uri)
+	if params["qop"] == "" {
+		return h(ha1, params["nonce"], ha2), nil
+	}
+	return h(ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], ha2), nil
+}
+
+// DigestAuthorization answers a challenge with the Authorization header of a
+// request
+func DigestAuthorization(challenge map[string]string, username, password, method, uri string) (string, error) {
+	params := map[string]string{
+		"realm":     challenge["realm"],
+		"nonce":     challenge["nonce"],
+		"algorithm": challenge["algorithm"],
+	}
+	// The challenge lists the protections it accepts, e.g. "auth,auth-int"
+	for _, qop := range strings.Split(challenge["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			cnonce := make([]byte, 8)
+			if _, err := rand.Read(cnonce); err != nil {
+				return "", err
+			}
+			params["qop"], params["nc"], params["cnonce"] = "auth", "00000001", hex.EncodeToString(cnonce)
+		}
+	}
+	if challenge["qop"] != "" && params["qop"] == "" {
+		return "", fmt.Errorf("unsupported digest qop %q", challenge["qop"])
+	}
+
+	response, err := DigestResponse(params, username, password, method, uri)
+	if err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		username, params["realm"], params["nonce"], uri, response)
+	if params["algorithm"] != "" {
+		header += ", algorithm=" + params["algorithm"]
+	}
+	if params["qop"] != "" {
+		header += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, params["qop"], params["nc"], params["cnonce"])
+	}
+	if opaque := challenge["opaque"]; opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return header, nil
+}
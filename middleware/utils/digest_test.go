@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Example of RFC 7616, section 3.9.1
+func TestDigestResponse(t *testing.T) {
+	challenge := `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=%s, ` +
+		`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	tests := []struct {
+		algorithm string
+		want      string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, tt := range tests {
+		params, err := ParseDigest(fmt.Sprintf(challenge, tt.algorithm))
+		if err != nil {
+			t.Fatal(err)
+		}
+		params["qop"], params["nc"], params["cnonce"] = "auth", "00000001", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+		got, err := DigestResponse(params, "Mufasa", "Circle of Life", "GET", "/dir/index.html")
+		if err != nil || got != tt.want {
+			t.Errorf("%s: DigestResponse() = %q, %v, want %q", tt.algorithm, got, err, tt.want)
+		}
+	}
+}
+
+// An authorization answers its challenge
+func TestDigestAuthorization(t *testing.T) {
+	challenge, err := ParseDigest(`Digest qop="auth", realm="shellyplus1-f008d1d8b8b8", nonce="60dc59c6", algorithm=SHA-256`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := DigestAuthorization(challenge, "admin", "secret", "GET", "/rpc/Switch.Set?id=0&on=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := ParseDigest(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["uri"] != "/rpc/Switch.Set?id=0&on=true" || params["qop"] != "auth" || params["username"] != "admin" {
+		t.Errorf("authorization parameters = %v", params)
+	}
+	want, _ := DigestResponse(params, "admin", "secret", "GET", params["uri"])
+	if params["response"] != want {
+		t.Errorf("response = %s, want %s", params["response"], want)
+	}
+
+	if _, err := ParseDigest(`Basic realm="x"`); err == nil {
+		t.Error("ParseDigest() accepted a basic challenge")
+	}
+}
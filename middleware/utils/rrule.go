@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence is the subset of an iCalendar RRULE (RFC 5545) used for farm
+// tasks, for example "FREQ=WEEKLY;BYDAY=MO,TH;BYHOUR=7". Occurrences are
+// anchored at a start time, which provides the day, hour and minute a rule
+// leaves out. Weeks start on Monday.
+type Recurrence struct {
+	Freq       string // HOURLY, DAILY, WEEKLY or MONTHLY
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int // Negative days count from the end of the month
+	ByHour     []int
+	ByMinute   []int
+	Count      int
+	Until      *time.Time
+}
+
+// Recurrence frequencies
+const (
+	FreqHourly  = "HOURLY"
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// ParseRecurrence reads a rule, with or without its "RRULE:" prefix
+func ParseRecurrence(rule string) (Recurrence, error) {
+	r := Recurrence{Interval: 1}
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return r, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case FreqHourly, FreqDaily, FreqWeekly, FreqMonthly:
+				r.Freq = value
+			default:
+				return r, fmt.Errorf("unsupported FREQ %s", value)
+			}
+		case "INTERVAL":
+			if r.Interval, err = strconv.Atoi(value); err != nil || r.Interval < 1 {
+				return r, fmt.Errorf("INTERVAL must be a positive number")
+			}
+		case "COUNT":
+			if r.Count, err = strconv.Atoi(value); err != nil || r.Count < 1 {
+				return r, fmt.Errorf("COUNT must be a positive number")
+			}
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return r, err
+			}
+			r.Until = &until
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := weekdays[day]
+				if !ok {
+					return r, fmt.Errorf("unsupported BYDAY %s", day)
+				}
+				r.ByDay = append(r.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			if r.ByMonthDay, err = parseList(value, -31, 31); err != nil {
+				return r, fmt.Errorf("BYMONTHDAY: %w", err)
+			}
+			for _, day := range r.ByMonthDay {
+				if day == 0 {
+					return r, fmt.Errorf("BYMONTHDAY cannot be 0")
+				}
+			}
+		case "BYHOUR":
+			if r.ByHour, err = parseList(value, 0, 23); err != nil {
+				return r, fmt.Errorf("BYHOUR: %w", err)
+			}
+		case "BYMINUTE":
+			if r.ByMinute, err = parseList(value, 0, 59); err != nil {
+				return r, fmt.Errorf("BYMINUTE: %w", err)
+			}
+		case "WKST":
+			if value != "MO" {
+				return r, fmt.Errorf("only WKST=MO is supported")
+			}
+		default:
+			return r, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && r.Until != nil {
+		return r, fmt.Errorf("COUNT and UNTIL cannot be combined")
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq != FreqMonthly {
+		return r, fmt.Errorf("BYMONTHDAY needs FREQ=MONTHLY")
+	}
+	return r, nil
+}
+
+// UNTIL is a date or a UTC timestamp
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	// A date includes the whole day
+	if t, err := time.ParseInLocation("20060102", value, time.Local); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ")
+}
+
+func parseList(value string, min, max int) ([]int, error) {
+	var list []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < min || n > max {
+			return nil, fmt.Errorf("%q is not between %d and %d", item, min, max)
+		}
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	return list, nil
+}
+
+// Between returns the occurrences of the rule from start that fall in
+// [from, to), in order. Times are in the location of start.
+func (r Recurrence) Between(start, from, to time.Time) []time.Time {
+	occurrences := []time.Time{}
+	loc := start.Location()
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	count := 0
+	// Every period starts later than the one before, so the loop ends once
+	// periods start after the window
+	for period := 0; ; period++ {
+		periodStart, candidates := r.period(start, loc, period*interval)
+		if !periodStart.Before(to) {
+			return occurrences
+		}
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			if (r.Until != nil && t.After(*r.Until)) || !t.Before(to) {
+				return occurrences
+			}
+			count++
+			if !t.Before(from) {
+				occurrences = append(occurrences, t)
+			}
+			if r.Count > 0 && count >= r.Count {
+				return occurrences
+			}
+		}
+	}
+}
+
+// Start of the nth period after the one holding start, and the candidate
+// times in it, in order
+func (r Recurrence) period(start time.Time, loc *time.Location, n int) (time.Time, []time.Time) {
+	y, m, d := start.Date()
+
+	switch r.Freq {
+	case FreqHourly:
+		// Hours are elapsed time, so a DST change does not repeat or skip one
+		hour := time.Date(y, m, d, start.Hour(), 0, 0, 0, loc).Add(time.Duration(n) * time.Hour)
+		if !r.matchesDay(hour.Weekday()) || (len(r.ByHour) > 0 && !containsInt(r.ByHour, hour.Hour())) {
+			return hour, nil
+		}
+		minutes := r.ByMinute
+		if len(minutes) == 0 {
+			minutes = []int{start.Minute()}
+		}
+		times := make([]time.Time, 0, len(minutes))
+		for _, min := range minutes {
+			times = append(times, hour.Add(time.Duration(min)*time.Minute))
+		}
+		return hour, times
+
+	case FreqDaily:
+		day := time.Date(y, m, d+n, 0, 0, 0, 0, loc)
+		if !r.matchesDay(day.Weekday()) {
+			return day, nil
+		}
+		return day, r.timesOn(day, start)
+
+	case FreqWeekly:
+		monday := d - (int(start.Weekday())+6)%7
+		week := time.Date(y, m, monday+7*n, 0, 0, 0, 0, loc)
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		offsets := make([]int, 0, len(days))
+		for _, weekday := range days {
+			offsets = append(offsets, (int(weekday)+6)%7)
+		}
+		sort.Ints(offsets)
+
+		var times []time.Time
+		for _, offset := range offsets {
+			times = append(times, r.timesOn(week.AddDate(0, 0, offset), start)...)
+		}
+		return week, times
+
+	case FreqMonthly:
+		month := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, loc)
+		last := month.AddDate(0, 1, -1).Day()
+		var days []int
+		for day := 1; day <= last; day++ {
+			if r.matchesMonthDay(day, last, start.Day()) && (len(r.ByDay) == 0 ||
+				r.matchesDay(time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, loc).Weekday())) {
+				days = append(days, day)
+			}
+		}
+
+		var times []time.Time
+		for _, day := range days {
+			times = append(times, r.timesOn(time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, loc), start)...)
+		}
+		return month, times
+	}
+	return time.Time{}, nil
+}
+
+func (r Recurrence) matchesDay(weekday time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// Without BYMONTHDAY or BYDAY a monthly rule repeats on the day of the start.
+// Months too short for it are skipped.
+func (r Recurrence) matchesMonthDay(day, last, startDay int) bool {
+	if len(r.ByMonthDay) == 0 {
+		return len(r.ByDay) > 0 || day == startDay
+	}
+	for _, d := range r.ByMonthDay {
+		if d == day || (d < 0 && last+1+d == day) {
+			return true
+		}
+	}
+	return false
+}
+
+// Times on a day from BYHOUR and BYMINUTE, or the time of day of the start
+func (r Recurrence) timesOn(day, start time.Time) []time.Time {
+	hours, minutes := r.ByHour, r.ByMinute
+	if len(hours) == 0 {
+		hours = []int{start.Hour()}
+	}
+	if len(minutes) == 0 {
+		minutes = []int{start.Minute()}
+	}
+
+	times := make([]time.Time, 0, len(hours)*len(minutes))
+	for _, h := range hours {
+		for _, min := range minutes {
+			times = append(times, time.Date(day.Year(), day.Month(), day.Day(), h, min, 0, 0, day.Location()))
+		}
+	}
+	return times
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
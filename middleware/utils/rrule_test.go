@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata" // Europe/Berlin for the DST cases
+)
+
+func TestParseRecurrence(t *testing.T) {
+	r, err := ParseRecurrence("RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TH,MO;BYHOUR=19,7;WKST=MO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Freq != FreqWeekly || r.Interval != 2 || len(r.ByDay) != 2 || r.ByHour[0] != 7 || r.ByHour[1] != 19 {
+		t.Errorf("ParseRecurrence() = %+v", r)
+	}
+
+	tests := []struct {
+		rule    string
+		wantErr string
+	}{
+		{"FREQ=DAILY;COUNT=3;UNTIL=20250110", "COUNT and UNTIL"},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", "BYMONTHDAY needs FREQ=MONTHLY"},
+		{"FREQ=DAILY;BYMONTHDAY=-1", "BYMONTHDAY needs FREQ=MONTHLY"},
+		{"FREQ=WEEKLY;WKST=SU", "WKST=MO"},
+		{"BYDAY=MO", "FREQ is required"},
+		{"FREQ=YEARLY", "unsupported FREQ"},
+		{"FREQ=DAILY;INTERVAL=0", "INTERVAL"},
+		{"FREQ=DAILY;COUNT=-1", "COUNT"},
+		{"FREQ=DAILY;UNTIL=2025-01-10", "UNTIL"},
+		{"FREQ=WEEKLY;BYDAY=XX", "BYDAY"},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", "BYMONTHDAY"},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", "BYMONTHDAY"},
+		{"FREQ=DAILY;BYHOUR=24", "BYHOUR"},
+		{"FREQ=HOURLY;BYMINUTE=60", "BYMINUTE"},
+		{"FREQ=DAILY;BYSETPOS=1", "unsupported rule part"},
+		{"FREQ=DAILY;COUNT", "invalid rule part"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseRecurrence(tt.rule); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseRecurrence(%q) error = %v, want %q", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, month time.Month, day, hour, min int) time.Time {
+		return time.Date(2025, month, day, hour, min, 0, 0, loc)
+	}
+	local := func(month time.Month, day, hour, min int) time.Time { return at(time.Local, month, day, hour, min) }
+
+	tests := []struct {
+		name     string
+		rule     string
+		start    time.Time
+		from, to time.Time
+		want     []time.Time
+	}{
+		{
+			name:  "count spent before the window",
+			rule:  "FREQ=DAILY;COUNT=5",
+			start: local(1, 1, 8, 0),
+			from:  local(1, 3, 0, 0), to: local(1, 10, 0, 0),
+			want: []time.Time{local(1, 3, 8, 0), local(1, 4, 8, 0), local(1, 5, 8, 0)},
+		},
+		{
+			name:  "count exhausted before the window",
+			rule:  "FREQ=DAILY;COUNT=5",
+			start: local(1, 1, 8, 0),
+			from:  local(1, 6, 0, 0), to: local(1, 10, 0, 0),
+			want: []time.Time{},
+		},
+		{
+			name:  "until date covers the whole day",
+			rule:  "FREQ=DAILY;BYHOUR=7,19;UNTIL=20250105",
+			start: local(1, 1, 7, 0),
+			from:  local(1, 5, 0, 0), to: local(1, 10, 0, 0),
+			want: []time.Time{local(1, 5, 7, 0), local(1, 5, 19, 0)},
+		},
+		{
+			name:  "last day of the month",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1;BYHOUR=9;BYMINUTE=0",
+			start: local(1, 15, 9, 0),
+			from:  local(1, 1, 0, 0), to: local(5, 1, 0, 0),
+			want: []time.Time{local(1, 31, 9, 0), local(2, 28, 9, 0), local(3, 31, 9, 0), local(4, 30, 9, 0)},
+		},
+		{
+			name:  "day of the start skips short months",
+			rule:  "FREQ=MONTHLY",
+			start: local(1, 31, 6, 0),
+			from:  local(1, 1, 0, 0), to: local(6, 1, 0, 0),
+			want: []time.Time{local(1, 31, 6, 0), local(3, 31, 6, 0), local(5, 31, 6, 0)},
+		},
+		{
+			// Starts on a Wednesday, the Monday of its week is before the start
+			name:  "every other week before the start weekday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+			start: local(1, 1, 10, 0),
+			from:  local(1, 1, 0, 0), to: local(2, 1, 0, 0),
+			want: []time.Time{local(1, 3, 10, 0), local(1, 13, 10, 0), local(1, 17, 10, 0), local(1, 27, 10, 0), local(1, 31, 10, 0)},
+		},
+		{
+			name:  "hourly on the minutes",
+			rule:  "FREQ=HOURLY;BYMINUTE=0,30",
+			start: local(1, 1, 8, 10),
+			from:  local(1, 1, 0, 0), to: local(1, 1, 10, 1),
+			want: []time.Time{local(1, 1, 8, 30), local(1, 1, 9, 0), local(1, 1, 9, 30), local(1, 1, 10, 0)},
+		},
+		{
+			name:  "hourly at the minute of the start",
+			rule:  "FREQ=HOURLY;INTERVAL=2",
+			start: local(1, 1, 8, 10),
+			from:  local(1, 1, 9, 0), to: local(1, 1, 14, 0),
+			want: []time.Time{local(1, 1, 10, 10), local(1, 1, 12, 10)},
+		},
+		{
+			name:  "hourly within hours and a count",
+			rule:  "FREQ=HOURLY;BYHOUR=6,7;BYMINUTE=15,45;COUNT=6",
+			start: local(1, 1, 0, 0),
+			from:  local(1, 1, 0, 0), to: local(1, 3, 0, 0),
+			want: []time.Time{local(1, 1, 6, 15), local(1, 1, 6, 45), local(1, 1, 7, 15), local(1, 1, 7, 45), local(1, 2, 6, 15), local(1, 2, 6, 45)},
+		},
+		{
+			// Clocks go from 02:00 to 03:00 on March 30
+			name:  "daily keeps the wall clock over DST",
+			rule:  "FREQ=DAILY",
+			start: at(berlin, 3, 29, 8, 0),
+			from:  at(berlin, 3, 29, 0, 0), to: at(berlin, 4, 1, 0, 0),
+			want: []time.Time{at(berlin, 3, 29, 8, 0), at(berlin, 3, 30, 8, 0), at(berlin, 3, 31, 8, 0)},
+		},
+		{
+			name:  "hourly counts elapsed hours over DST",
+			rule:  "FREQ=HOURLY",
+			start: at(berlin, 3, 30, 0, 0),
+			from:  at(berlin, 3, 30, 0, 0), to: at(berlin, 3, 30, 4, 0),
+			want: []time.Time{at(berlin, 3, 30, 0, 0), at(berlin, 3, 30, 1, 0), at(berlin, 3, 30, 3, 0)},
+		},
+		{
+			// Clocks go from 03:00 back to 02:00 on October 26, 02:30 happens twice
+			name:  "hourly repeats the hour when clocks go back",
+			rule:  "FREQ=HOURLY;BYMINUTE=30",
+			start: at(berlin, 10, 26, 1, 30),
+			from:  at(berlin, 10, 26, 0, 0), to: at(berlin, 10, 26, 4, 0),
+			want: []time.Time{
+				at(berlin, 10, 26, 1, 30),
+				time.Date(2025, 10, 26, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+				time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), // 02:30 CET
+				at(berlin, 10, 26, 3, 30),
+			},
+		},
+	}
+	for _, tt := range tests {
+		r, err := ParseRecurrence(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := r.Between(tt.start, tt.from, tt.to)
+		if !sameTimes(got, tt.want) {
+			t.Errorf("%s: Between() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	selfSignedValidity = 365 * 24 * time.Hour
+	renewBefore        = 30 * 24 * time.Hour
+)
+
+// CertManager serves the TLS certificate for the web server. When a
+// certificate is configured it is reloaded whenever the files change on disk,
+// otherwise a self-signed certificate is generated and renewed before expiry.
+type CertManager struct {
+	certFile string
+	keyFile  string
+	managed  bool // true when the certificate is self-signed by us
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertManager loads the configured certificate or, when certFile and
+// keyFile are empty, manages a self-signed certificate inside certDir.
+func NewCertManager(certFile, keyFile, certDir string) (*CertManager, error) {
+	m := &CertManager{certFile: certFile, keyFile: keyFile}
+
+	if certFile == "" || keyFile == "" {
+		if certDir == "" {
+			certDir = "certs"
+		}
+		m.certFile = filepath.Join(certDir, "cert.pem")
+		m.keyFile = filepath.Join(certDir, "key.pem")
+		m.managed = true
+	}
+
+	if err := m.refresh(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetCertificate is meant to be used as tls.Config.GetCertificate so rotated
+// certificates are picked up without restarting the server.
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// Watch periodically reloads changed certificate files and renews the
+// self-signed certificate. It never returns.
+func (m *CertManager) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := m.refresh(); err != nil {
+			log.Println("Certificate refresh failed:", err)
+		}
+	}
+}
+
+func (m *CertManager) refresh() error {
+	if m.managed && m.needsRenewal() {
+		if err := generateSelfSigned(m.certFile, m.keyFile); err != nil {
+			return fmt.Errorf("Failed to generate certificate: %v", err)
+		}
+		log.Println("Generated self-signed certificate at", m.certFile)
+	}
+
+	info, err := os.Stat(m.certFile)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	unchanged := m.cert != nil && info.ModTime().Equal(m.modTime)
+	m.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load certificate: %v", err)
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.modTime = info.ModTime()
+	m.mu.Unlock()
+
+	log.Println("Loaded TLS certificate from", m.certFile)
+	return nil
+}
+
+// needsRenewal reports whether the managed certificate is missing, unreadable
+// or about to expire.
+func (m *CertManager) needsRenewal() bool {
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil || len(cert.Certificate) == 0 {
+		return true
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+	return time.Until(leaf.NotAfter) < renewBefore
+}
+
+// SelfSignedCertificate creates an in-memory self-signed certificate, used by
+// development tools that need TLS without files on disk.
+func SelfSignedCertificate() (tls.Certificate, error) {
+	der, keyDER, err := newSelfSigned()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func generateSelfSigned(certFile, keyFile string) error {
+	der, keyDER, err := newSelfSigned()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		return err
+	}
+
+	// Write the key first so the certificate mtime marks a complete pair
+	if err := writePEM(keyFile, "EC PRIVATE KEY", keyDER, 0o600); err != nil {
+		return err
+	}
+	return writePEM(certFile, "CERTIFICATE", der, 0o644)
+}
+
+// Create a self-signed certificate and return it with its key, both DER encoded
+func newSelfSigned() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:      []string{"KH"},
+			Province:     []string{"PhnomPenh"},
+			Locality:     []string{"PhnomPenh"},
+			Organization: []string{"Tokkatot"},
+			CommonName:   "Tokkatot",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           localIPs(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return der, keyDER, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// localIPs lists the addresses of this machine so browsers on the farm network
+// connecting by IP see a matching certificate.
+func localIPs() []net.IP {
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips
+}
+
+// ====== HTTP REDIRECT ====== //
+// RedirectHTTP listens for plain HTTP on addr and redirects every request to
+// the same host and path over HTTPS on httpsPort.
+func RedirectHTTP(addr, httpsPort string) error {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -3,34 +3,47 @@ package utils
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 )
 
+// Envelope is the JSON form of an AES-GCM message exchanged with the
+// controller. KeyID and Counter travel in the clear and are authenticated as
+// part of the associated data.
+type Envelope struct {
+	Data    string `json:"data"`
+	IV      string `json:"iv"`
+	Tag     string `json:"tag"`
+	KeyID   string `json:"kid,omitempty"`
+	Counter uint64 `json:"ctr,omitempty"`
+}
+
 func DecryptAESGCM(jsonResponse string, key []byte) ([]byte, error) {
 	// Define structure to parse JSON
-	var resp struct {
-		Data string `json:"data"`
-		IV   string `json:"iv"`
-		Tag  string `json:"tag"`
-	}
+	var resp Envelope
 
 	if err := json.Unmarshal([]byte(jsonResponse), &resp); err != nil {
 		return nil, fmt.Errorf("Failed to parse JSON: %v", err)
 	}
 
-	ciphertext, err := base64.StdEncoding.DecodeString(resp.Data)
+	return OpenAESGCM(resp, key, nil)
+}
+
+// Decrypt an envelope and verify it against the associated data
+func OpenAESGCM(env Envelope, key, aad []byte) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Data)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to decode data: %v", err)
 	}
 
-	iv, err := base64.StdEncoding.DecodeString(resp.IV)
+	iv, err := base64.StdEncoding.DecodeString(env.IV)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to decode IV: %v", err)
 	}
 
-	tag, err := base64.StdEncoding.DecodeString(resp.Tag)
+	tag, err := base64.StdEncoding.DecodeString(env.Tag)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to decode tag: %v", err)
 	}
@@ -55,10 +68,38 @@ func DecryptAESGCM(jsonResponse string, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	plaintext, err := gcm.Open(nil, iv, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, iv, ciphertext, aad)
 	if err != nil {
 		return nil, err
 	}
 
 	return plaintext, nil
 }
+
+// Encrypt plaintext into an envelope with a random IV. The caller fills in
+// KeyID and Counter, which must match what was used to build aad.
+func EncryptAESGCM(plaintext, key, aad []byte) (Envelope, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return Envelope{}, err
+	}
+
+	sealed := gcm.Seal(nil, iv, plaintext, aad)
+	tagStart := len(sealed) - gcm.Overhead()
+
+	return Envelope{
+		Data: base64.StdEncoding.EncodeToString(sealed[:tagStart]),
+		IV:   base64.StdEncoding.EncodeToString(iv),
+		Tag:  base64.StdEncoding.EncodeToString(sealed[tagStart:]),
+	}, nil
+}